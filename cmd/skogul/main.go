@@ -34,10 +34,12 @@ import (
 	"net/http"
 	_ "net/http/pprof"
 	"os"
+	"os/signal"
 	"plugin"
 	"runtime"
 	"strings"
 	"sync"
+	"syscall"
 	"time"
 
 	"github.com/telenornms/skogul"
@@ -205,10 +207,33 @@ func main() {
 
 	go startStats(c)
 
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		sig := <-sigs
+		log.WithField("signal", sig).Info("Shutting down")
+		shutdown(c)
+		os.Exit(0)
+	}()
+
 	wg.Wait()
+	shutdown(c)
 	os.Exit(exitInt)
 }
 
+// shutdown closes all senders that need to tidy up before exiting, e.g.
+// by flushing buffers.
+func shutdown(c *config.Config) {
+	log := skogul.Logger("cmd", "main")
+	for name, s := range c.Senders {
+		if cl, ok := s.Sender.(skogul.Closer); ok {
+			if err := cl.Close(); err != nil {
+				log.WithError(err).WithField("sender", name).Error("Failed to close sender")
+			}
+		}
+	}
+}
+
 // startStats starts a forever-running loop which fetches
 // stats from each module at the configured interval.
 func startStats(c *config.Config) {
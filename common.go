@@ -30,6 +30,7 @@ import (
 	"math"
 	"os"
 	"runtime"
+	"strings"
 
 	"github.com/sirupsen/logrus"
 )
@@ -180,6 +181,33 @@ type Stats interface {
 	GetStats() *Metric
 }
 
+/*
+Closer is an optional interface for modules that need to tidy up before
+Skogul exits, typically to flush buffered data. Close() is called once,
+when Skogul is shutting down. Receivers are not stopped first, so the
+module may still be used afterwards, and should then fail rather than
+block.
+*/
+type Closer interface {
+	Close() error
+}
+
+/*
+HeaderEncoder is an optional interface for encoders that write a header
+before the encoded data, such as the column names of CSV. Senders that
+know where an output starts, like the file sender for each new file,
+call SeparateHeader once, before encoding anything, and then write the
+result of OutputHeader at the start of each output.
+*/
+type HeaderEncoder interface {
+	// SeparateHeader stops the encoder from adding the header to
+	// encoded data itself.
+	SeparateHeader()
+	// OutputHeader returns the header, or nil if there is none, e.g.
+	// because it depends on data that hasn't been encoded yet.
+	OutputHeader() []byte
+}
+
 /*
 SenderRef is a reference to a named sender. This is required to allow
 references to be resolved after all senders are loaded. Wherever a
@@ -384,6 +412,34 @@ func MissingArgument(field string) error {
 	return fmt.Errorf("missing required configuration option `%s'", field)
 }
 
+// ExpandMetadata replaces ${metadata.KEY} in template with the value of
+// the metadata field KEY, e.g. for building topics or routing keys from a
+// metric. Returns an error if a field is missing or empty, since the
+// result would usually end up in the wrong place.
+func ExpandMetadata(template string, metadata map[string]interface{}) (string, error) {
+	var out strings.Builder
+	for {
+		start := strings.Index(template, "${metadata.")
+		if start < 0 {
+			out.WriteString(template)
+			return out.String(), nil
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			out.WriteString(template)
+			return out.String(), nil
+		}
+		key := template[start+len("${metadata.") : start+end]
+		val, ok := metadata[key]
+		if !ok || val == nil || fmt.Sprint(val) == "" {
+			return "", fmt.Errorf("metadata field %s missing", key)
+		}
+		out.WriteString(template[:start])
+		out.WriteString(fmt.Sprint(val))
+		template = template[start+end+1:]
+	}
+}
+
 // GetCertPool accepts a path to a directory of certificate authorities to trust. Pass in the empty string to use system defaults
 func GetCertPool(path string) (*x509.CertPool, error) {
 	// this means "use system default"
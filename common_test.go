@@ -236,3 +236,14 @@ func TestSecretIsExposed(t *testing.T) {
 		t.Errorf("Expected secret to be 'hunter2', but got %s", secret.Expose())
 	}
 }
+
+func TestExpandMetadata(t *testing.T) {
+	md := map[string]interface{}{"site": "oslo", "id": 5}
+	got, err := skogul.ExpandMetadata("sites/${metadata.site}/${metadata.id}/data", md)
+	if err != nil || got != "sites/oslo/5/data" {
+		t.Errorf("ExpandMetadata() = %s, %v", got, err)
+	}
+	if _, err := skogul.ExpandMetadata("sites/${metadata.missing}", md); err == nil {
+		t.Errorf("ExpandMetadata() with missing metadata did not fail")
+	}
+}
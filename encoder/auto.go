@@ -69,6 +69,50 @@ func init() {
 	Auto.Add(skogul.Module{
 		Name:  "avro",
 		Alloc: func() interface{} { return &AVRO{} },
-		Help:  "Encodes the avro format.",
+		Help:  "Encodes the avro format. The schema is read from a file, derived from a metric layout or fetched from a Confluent Schema Registry. With a registry, the Confluent wire format with a schema ID header is used, and the schema can be registered automatically.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "protobuf_dynamic",
+		Aliases: []string{"protobuf-dynamic"},
+		Alloc:   func() interface{} { return &ProtobufDynamic{} },
+		Help:    "Encodes arbitrary protobuf messages, using a FileDescriptorSet or .proto files loaded at run time. The counterpart of the protobuf_dynamic parser.",
+	})
+	Auto.Add(skogul.Module{
+		Name:     "graphite",
+		Alloc:    func() interface{} { return &Graphite{} },
+		Help:     "Encodes the Graphite plaintext protocol, one line per numeric data field, with the path built from metadata. The counterpart of the graphite parser.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "csv",
+		Alloc:    func() interface{} { return &CSV{} },
+		Help:     "Encodes metrics as comma separated values, one row per metric. The counterpart of the csv parser.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "logfmt",
+		Alloc:    func() interface{} { return &Logfmt{} },
+		Help:     "Encodes metrics as logfmt, one line per metric. The counterpart of the logfmt parser.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "kv",
+		Aliases:  []string{"keyvalue"},
+		Alloc:    func() interface{} { return &KV{} },
+		Help:     "Encodes metrics as key=value pairs with configurable separators, one line per metric. The counterpart of the kv parser.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "msgpack",
+		Aliases:  []string{"messagepack"},
+		Alloc:    func() interface{} { return &MsgPack{} },
+		Help:     "Encodes the Skogul container as MessagePack, a compact binary format with the same layout as Skogul JSON. Useful for inter-Skogul communication, also with non-Go peers.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "cbor",
+		Alloc:    func() interface{} { return &CBOR{} },
+		Help:     "Encodes the Skogul container as CBOR (RFC 8949), a compact binary format with the same layout as Skogul JSON.",
+		AutoMake: true,
 	})
 }
@@ -24,13 +24,19 @@
 package encoder
 
 import (
+	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"regexp"
+	"sort"
 	"sync"
 	"time"
 
 	avro "github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/registry"
 	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/internal/schemareg"
 )
 
 type AvroContainer struct {
@@ -44,27 +50,277 @@ type AvroMetric struct {
 	Data     map[string]interface{}
 }
 
+/*
+AVRO encodes containers using an Avro schema, which is either read from
+a file, derived from Layout or, with a registry, the latest version
+registered for the subject.
+
+If Registry is set, messages use the Confluent wire format: a zero byte
+and the 4 byte big endian schema ID, followed by the Avro data. The ID is
+looked up the first time something is encoded, and if Register is set,
+the schema is registered first if it isn't already. Failed lookups are
+retried on the next message.
+*/
 type AVRO struct {
-	Schema string
-	s      avro.Schema
-	err    error
-	once   sync.Once
+	Schema          string          `doc:"Path to a file with the Avro schema."`
+	Layout          *AvroLayout     `doc:"Generate the schema from a metric layout instead of reading it from a file. Fields not in the layout are dropped, and missing fields are encoded as null."`
+	Registry        string          `doc:"URL of a Confluent Schema Registry. Enables the Confluent wire format. If neither Schema nor Layout is set, the latest schema registered for the subject is used."`
+	Username        string          `doc:"Username for basic authentication against the registry."`
+	Password        skogul.Secret   `doc:"Password for basic authentication against the registry."`
+	Subject         string          `doc:"Registry subject. Overrides SubjectStrategy."`
+	SubjectStrategy string          `doc:"How the subject is named if Subject isn't set: topic (Topic with a -value or -key suffix), record (the full name of the schema) or topic_record (Topic, a dash and the full name of the schema). Default: topic"`
+	Topic           string          `doc:"Kafka topic used to name the subject."`
+	Key             bool            `doc:"Name the subject for message keys instead of values, when using the topic strategy."`
+	Register        bool            `doc:"Register the schema under the subject if it isn't already. Otherwise the schema must already be registered."`
+	Timeout         skogul.Duration `doc:"Timeout for registry requests. Default: 10s"`
+	s               avro.Schema
+	id              int
+	client          *registry.Client
+	lock            sync.Mutex
+	err             error
+	once            sync.Once
 }
 
-func (x *AVRO) Encode(c *skogul.Container) ([]byte, error) {
-	x.once.Do(func() {
+// AvroLayout describes the metrics to encode, used to derive a schema.
+// Field names must be valid Avro names.
+type AvroLayout struct {
+	Name     string            `doc:"Full name of the generated schema. Default: skogul.Container"`
+	Metadata map[string]string `doc:"Metadata fields and their types: string, int, long, float, double or boolean."`
+	Data     map[string]string `doc:"Data fields and their types, as for Metadata."`
+}
+
+var avroName = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+func (l *AvroLayout) verify() error {
+	for _, fields := range []map[string]string{l.Metadata, l.Data} {
+		for k, t := range fields {
+			if !avroName.MatchString(k) {
+				return fmt.Errorf("%q is not a valid Avro field name", k)
+			}
+			switch t {
+			case "string", "int", "long", "float", "double", "boolean":
+			default:
+				return fmt.Errorf("unsupported type %q for field %s", t, k)
+			}
+		}
+	}
+	return nil
+}
+
+// schema generates the Avro schema for the layout. Fields are sorted so
+// the same layout always gives the same schema, and all fields are
+// nullable.
+func (l *AvroLayout) schema() string {
+	name := l.Name
+	if name == "" {
+		name = "skogul.Container"
+	}
+	record := func(name string, fields map[string]string) map[string]interface{} {
+		keys := make([]string, 0, len(fields))
+		for k := range fields {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		list := make([]interface{}, 0, len(fields))
+		for _, k := range keys {
+			list = append(list, map[string]interface{}{"name": k, "type": []string{"null", fields[k]}, "default": nil})
+		}
+		return map[string]interface{}{"type": "record", "name": name, "fields": list}
+	}
+	metric := map[string]interface{}{
+		"type": "record",
+		"name": "Metric",
+		"fields": []interface{}{
+			map[string]interface{}{"name": "Time", "type": map[string]string{"type": "long", "logicalType": "timestamp-micros"}},
+			map[string]interface{}{"name": "Metadata", "type": record("Metadata", l.Metadata)},
+			map[string]interface{}{"name": "Data", "type": record("Data", l.Data)},
+		},
+	}
+	s := map[string]interface{}{
+		"type":   "record",
+		"name":   name,
+		"fields": []interface{}{map[string]interface{}{"name": "Metrics", "type": map[string]interface{}{"type": "array", "items": metric}}},
+	}
+	b, _ := json.Marshal(s)
+	return string(b)
+}
+
+// convert picks the fields of the layout from values, converted to the
+// Go type matching the Avro type.
+func (l *AvroLayout) convert(fields map[string]string, values map[string]interface{}) (map[string]interface{}, error) {
+	ret := make(map[string]interface{}, len(fields))
+	for k, t := range fields {
+		v, ok := values[k]
+		if !ok || v == nil {
+			continue
+		}
+		var err error
+		if ret[k], err = avroValue(t, v); err != nil {
+			return nil, fmt.Errorf("field %s: %w", k, err)
+		}
+	}
+	return ret, nil
+}
+
+func avroValue(t string, v interface{}) (interface{}, error) {
+	switch t {
+	case "string":
+		if s, ok := v.(string); ok {
+			return s, nil
+		}
+		return fmt.Sprint(v), nil
+	case "boolean":
+		if b, ok := v.(bool); ok {
+			return b, nil
+		}
+		return nil, fmt.Errorf("%v (%T) is not a boolean", v, v)
+	}
+	var f float64
+	var i int64
+	switch n := v.(type) {
+	case int:
+		i, f = int64(n), float64(n)
+	case int32:
+		i, f = int64(n), float64(n)
+	case int64:
+		i, f = n, float64(n)
+	case uint32:
+		i, f = int64(n), float64(n)
+	case uint64:
+		i, f = int64(n), float64(n)
+	case float32:
+		i, f = int64(n), float64(n)
+	case float64:
+		i, f = int64(n), n
+	case json.Number:
+		var err error
+		if f, err = n.Float64(); err != nil {
+			return nil, err
+		}
+		i = int64(f)
+		if ni, err := n.Int64(); err == nil {
+			i = ni
+		}
+	default:
+		return nil, fmt.Errorf("%v (%T) is not a number", v, v)
+	}
+	switch t {
+	case "int":
+		return int32(i), nil
+	case "long":
+		return i, nil
+	case "float":
+		return float32(f), nil
+	}
+	return f, nil
+}
+
+// Verify checks that a schema can be found and that the registry options
+// make sense.
+func (x *AVRO) Verify() error {
+	if x.Schema != "" && x.Layout != nil {
+		return fmt.Errorf("Schema and Layout are mutually exclusive")
+	}
+	if x.Layout != nil {
+		if err := x.Layout.verify(); err != nil {
+			return fmt.Errorf("invalid Layout: %w", err)
+		}
+	}
+	if x.Registry == "" {
+		if x.Schema == "" && x.Layout == nil {
+			return skogul.MissingArgument("Schema")
+		}
+		return nil
+	}
+	if err := schemareg.VerifyStrategy(x.SubjectStrategy); err != nil {
+		return err
+	}
+	if x.Subject == "" && x.Topic == "" && x.SubjectStrategy != schemareg.StrategyRecord {
+		return skogul.MissingArgument("Topic")
+	}
+	if x.Subject == "" && x.Schema == "" && x.Layout == nil && x.SubjectStrategy != "" && x.SubjectStrategy != schemareg.StrategyTopic {
+		return fmt.Errorf("the %s subject naming strategy needs a Schema or Layout, or an explicit Subject", x.SubjectStrategy)
+	}
+	if x.Register && x.Schema == "" && x.Layout == nil {
+		return fmt.Errorf("Register requires a Schema or Layout")
+	}
+	return nil
+}
+
+func (x *AVRO) init() {
+	if x.Layout != nil {
+		x.s, x.err = avro.Parse(x.Layout.schema())
+	} else if x.Schema != "" {
 		b, err := os.ReadFile(x.Schema)
 		x.err = err
 		if x.err == nil {
-			x.s = avro.MustParse(string(b))
-			if x.s == nil {
-				x.err = fmt.Errorf("parsed schema is nil")
-			}
+			x.s, x.err = avro.Parse(string(b))
+		}
+	}
+	if x.err == nil && x.Registry != "" {
+		if x.Timeout.Duration == 0 {
+			x.Timeout.Duration = 10 * time.Second
+		}
+		x.client, x.err = schemareg.NewClient(x.Registry, x.Username, x.Password.Expose(), x.Timeout.Duration)
+	}
+}
+
+// schemaID looks up the registry ID of the schema, registering it if
+// configured to do so, or fetches the latest schema for the subject if
+// there is no local schema. The result is cached.
+func (x *AVRO) schemaID() (avro.Schema, int, error) {
+	x.lock.Lock()
+	defer x.lock.Unlock()
+	if x.id != 0 {
+		return x.s, x.id, nil
+	}
+	subject := x.Subject
+	if subject == "" {
+		record := ""
+		if n, ok := x.s.(avro.NamedSchema); ok {
+			record = n.FullName()
 		}
-	})
+		var err error
+		if subject, err = schemareg.Subject(x.SubjectStrategy, x.Topic, record, x.Key); err != nil {
+			return nil, 0, err
+		}
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), x.Timeout.Duration)
+	defer cancel()
+	var err error
+	var id int
+	switch {
+	case x.s == nil:
+		var info registry.SchemaInfo
+		info, err = x.client.GetLatestSchemaInfo(ctx, subject)
+		if err == nil {
+			x.s, id = info.Schema, info.ID
+		}
+	case x.Register:
+		id, _, err = x.client.CreateSchema(ctx, subject, x.s.String())
+	default:
+		id, _, err = x.client.IsRegistered(ctx, subject, x.s.String())
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("schema registry lookup for subject %s failed: %w", subject, err)
+	}
+	x.id = id
+	return x.s, x.id, nil
+}
+
+func (x *AVRO) Encode(c *skogul.Container) ([]byte, error) {
+	x.once.Do(x.init)
 	if x.err != nil {
 		return nil, x.err
 	} // same as before
+	s := x.s
+	id := 0
+	if x.client != nil {
+		var err error
+		if s, id, err = x.schemaID(); err != nil {
+			return nil, err
+		}
+	}
 	tmpContainer := AvroContainer{}
 	// This allocates the Metrics array with a length of 0 but a
 	// *capacity* (size) that matches the original container.
@@ -78,10 +334,23 @@ func (x *AVRO) Encode(c *skogul.Container) ([]byte, error) {
 		tmpMetric.Metadata = c.Metrics[m].Metadata
 		tmpMetric.Data = c.Metrics[m].Data
 		tmpMetric.Time = *c.Metrics[m].Time
+		if x.Layout != nil {
+			var err error
+			if tmpMetric.Metadata, err = x.Layout.convert(x.Layout.Metadata, c.Metrics[m].Metadata); err != nil {
+				return nil, fmt.Errorf("unable to encode metadata: %w", err)
+			}
+			if tmpMetric.Data, err = x.Layout.convert(x.Layout.Data, c.Metrics[m].Data); err != nil {
+				return nil, fmt.Errorf("unable to encode data: %w", err)
+			}
+		}
 		tmpContainer.Metrics = append(tmpContainer.Metrics, &tmpMetric)
 	}
 
-	return avro.Marshal(x.s, &tmpContainer)
+	b, err := avro.Marshal(s, &tmpContainer)
+	if err != nil || x.client == nil {
+		return b, err
+	}
+	return schemareg.Frame(id, b), nil
 }
 func (x *AVRO) EncodeMetric(m *skogul.Metric) ([]byte, error) {
 	return nil, fmt.Errorf("not supported")
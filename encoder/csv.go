@@ -0,0 +1,259 @@
+/*
+ * skogul, CSV encoder
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package encoder
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"unicode/utf8"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+CSV encodes metrics as comma separated values, one row per metric. It is
+the counterpart of the csv parser.
+
+Values for each column are taken from metadata, then data. Without
+Columns, the columns are the time column followed by the sorted metadata
+and data keys of the first container with metrics, and stay the same
+after that. Nested values are encoded as JSON.
+
+With Header once, the file sender writes the header at the start of
+every file it writes to, including templated and rotated files, instead
+of the encoder adding it to the first container.
+
+Rows are separated by newlines, without a trailing newline, since the
+file sender adds one after each container.
+*/
+type CSV struct {
+	Columns    []string `doc:"Columns to write. Defaults to the time column followed by all metadata and data keys of the first container."`
+	Delimiter  string   `doc:"Field delimiter, a single character. Default ','."`
+	Quote      string   `doc:"Quote character, a single character. Default '\"'."`
+	Header     string   `doc:"When to write a header row: once (default) before the first container, or at the start of each file with the file sender, always before every container, or never."`
+	TimeColumn string   `doc:"Name of the column for the timestamp. Default 'time'."`
+	TimeFormat string   `doc:"Format of the timestamp, as for the timestamp transformer: rfc3339 (default) or a Go time layout, or unix, unix_ms, unix_us or unix_ns for epoch values."`
+	once       sync.Once
+	colLock    sync.Mutex
+	columns    []string
+	delim      rune
+	quote      rune
+	headerDone uint32
+	separate   uint32
+}
+
+func (x *CSV) init() {
+	x.delim = ','
+	if x.Delimiter != "" {
+		x.delim, _ = utf8.DecodeRuneInString(x.Delimiter)
+	}
+	x.quote = '"'
+	if x.Quote != "" {
+		x.quote, _ = utf8.DecodeRuneInString(x.Quote)
+	}
+	if x.TimeColumn == "" {
+		x.TimeColumn = "time"
+	}
+	if x.Header == "" {
+		x.Header = "once"
+	}
+}
+
+// getColumns returns the columns, picking them from the first metrics
+// unless they are configured. Returns nil until there are metrics to pick
+// them from.
+func (x *CSV) getColumns(metrics []*skogul.Metric) []string {
+	x.colLock.Lock()
+	defer x.colLock.Unlock()
+	if x.columns == nil {
+		x.initColumns(metrics)
+	}
+	return x.columns
+}
+
+func (x *CSV) initColumns(metrics []*skogul.Metric) {
+	if len(x.Columns) > 0 {
+		x.columns = x.Columns
+		return
+	}
+	if len(metrics) == 0 {
+		return
+	}
+	md := make(map[string]bool)
+	data := make(map[string]bool)
+	for _, m := range metrics {
+		for k := range m.Metadata {
+			md[k] = true
+		}
+		for k := range m.Data {
+			if !md[k] {
+				data[k] = true
+			}
+		}
+	}
+	x.columns = []string{x.TimeColumn}
+	x.columns = append(x.columns, sortedKeys(md)...)
+	x.columns = append(x.columns, sortedKeys(data)...)
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// textValue formats a value for text formats: strings as they are,
+// anything else as JSON.
+func textValue(v interface{}) string {
+	switch x := v.(type) {
+	case nil:
+		return ""
+	case string:
+		return x
+	case []byte:
+		return string(x)
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(b)
+}
+
+func (x *CSV) field(b *strings.Builder, s string) {
+	if !strings.ContainsRune(s, x.delim) && !strings.ContainsRune(s, x.quote) && !strings.ContainsAny(s, "\r\n") {
+		b.WriteString(s)
+		return
+	}
+	q := string(x.quote)
+	b.WriteString(q)
+	b.WriteString(strings.ReplaceAll(s, q, q+q))
+	b.WriteString(q)
+}
+
+func (x *CSV) row(b *strings.Builder, values []string) {
+	for i, v := range values {
+		if i > 0 {
+			b.WriteRune(x.delim)
+		}
+		x.field(b, v)
+	}
+}
+
+func (x *CSV) encode(b *strings.Builder, columns []string, m *skogul.Metric) {
+	values := make([]string, len(columns))
+	for i, c := range columns {
+		if c == x.TimeColumn {
+			if m.Time != nil {
+				values[i] = skogul.FormatTime(x.TimeFormat, *m.Time)
+			}
+			continue
+		}
+		if v, ok := m.Metadata[c]; ok {
+			values[i] = textValue(v)
+		} else {
+			values[i] = textValue(m.Data[c])
+		}
+	}
+	x.row(b, values)
+}
+
+// Encode encodes all metrics of the container, with a header row
+// according to Header.
+func (x *CSV) Encode(c *skogul.Container) ([]byte, error) {
+	x.once.Do(x.init)
+	columns := x.getColumns(c.Metrics)
+	if columns == nil {
+		return []byte{}, nil
+	}
+	var b strings.Builder
+	if x.Header == "always" || (x.Header == "once" && atomic.LoadUint32(&x.separate) == 0 && atomic.CompareAndSwapUint32(&x.headerDone, 0, 1)) {
+		x.row(&b, columns)
+		if len(c.Metrics) > 0 {
+			b.WriteByte('\n')
+		}
+	}
+	for i, m := range c.Metrics {
+		if i > 0 {
+			b.WriteByte('\n')
+		}
+		x.encode(&b, columns, m)
+	}
+	return []byte(b.String()), nil
+}
+
+// SeparateHeader stops Encode from adding the header with Header once,
+// since the caller adds it to each output.
+func (x *CSV) SeparateHeader() {
+	atomic.StoreUint32(&x.separate, 1)
+}
+
+// OutputHeader returns the header row with Header once, after the
+// columns are known.
+func (x *CSV) OutputHeader() []byte {
+	x.once.Do(x.init)
+	if x.Header != "once" {
+		return nil
+	}
+	x.colLock.Lock()
+	defer x.colLock.Unlock()
+	if x.columns == nil {
+		return nil
+	}
+	var b strings.Builder
+	x.row(&b, x.columns)
+	return []byte(b.String())
+}
+
+// EncodeMetric encodes a single metric as a row, without header.
+func (x *CSV) EncodeMetric(m *skogul.Metric) ([]byte, error) {
+	x.once.Do(x.init)
+	columns := x.getColumns([]*skogul.Metric{m})
+	var b strings.Builder
+	x.encode(&b, columns, m)
+	return []byte(b.String()), nil
+}
+
+// Verify checks the delimiter, quote and header options.
+func (x *CSV) Verify() error {
+	if utf8.RuneCountInString(x.Delimiter) > 1 {
+		return fmt.Errorf("Delimiter must be a single character")
+	}
+	if utf8.RuneCountInString(x.Quote) > 1 {
+		return fmt.Errorf("Quote must be a single character")
+	}
+	switch x.Header {
+	case "", "once", "always", "never":
+	default:
+		return fmt.Errorf("invalid Header %s, must be once, always or never", x.Header)
+	}
+	return nil
+}
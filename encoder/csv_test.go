@@ -0,0 +1,132 @@
+/*
+ * skogul, CSV, logfmt and key=value encoder tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package encoder_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/encoder"
+	"github.com/telenornms/skogul/parser"
+)
+
+func textContainer() *skogul.Container {
+	now := time.Unix(1700000000, 0).UTC()
+	return &skogul.Container{Metrics: []*skogul.Metric{
+		{
+			Time:     &now,
+			Metadata: map[string]interface{}{"host": "gw1"},
+			Data:     map[string]interface{}{"in": 1234, "descr": `uplink, "primary"`, "up": true},
+		},
+		{
+			Time:     &now,
+			Metadata: map[string]interface{}{"host": "gw2"},
+			Data:     map[string]interface{}{"in": 0.5, "descr": "multi\nline", "nested": map[string]interface{}{"a": 1}},
+		},
+	}}
+}
+
+func TestCSV(t *testing.T) {
+	e := encoder.CSV{}
+	b, err := e.Encode(textContainer())
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	want := "time,host,descr,in,nested,up\n" +
+		"2023-11-14T22:13:20Z,gw1,\"uplink, \"\"primary\"\"\",1234,,true\n" +
+		"2023-11-14T22:13:20Z,gw2,\"multi\nline\",0.5,\"{\"\"a\"\":1}\","
+	if string(b) != want {
+		t.Errorf("Encode() = %q, want %q", b, want)
+	}
+	b, _ = e.Encode(textContainer())
+	if string(b[:5]) == "time," {
+		t.Errorf("header written twice with Header once")
+	}
+
+	// Columns aren't picked from an empty container.
+	e = encoder.CSV{}
+	if b, err := e.Encode(&skogul.Container{}); err != nil || len(b) != 0 {
+		t.Errorf("Encode() of empty container = %q, %v", b, err)
+	}
+	b, _ = e.Encode(textContainer())
+	if !strings.HasPrefix(string(b), "time,host,descr,in,nested,up\n2") {
+		t.Errorf("unexpected CSV after empty container %q", b)
+	}
+
+	e = encoder.CSV{Columns: []string{"host", "in", "time"}, Delimiter: ";", TimeFormat: "unix", Header: "never"}
+	b, err = e.Encode(textContainer())
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if string(b) != "gw1;1234;1700000000\ngw2;0.5;1700000000" {
+		t.Errorf("unexpected CSV %q", b)
+	}
+
+	// Round trip through the parser
+	e = encoder.CSV{Columns: []string{"time", "host", "in", "descr"}}
+	b, _ = e.Encode(textContainer())
+	p := parser.CSV{Metadata: []string{"host"}, TimeColumn: "time"}
+	c, err := p.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse() of encoded CSV failed: %v", err)
+	}
+	if len(c.Metrics) != 2 || c.Metrics[1].Data["descr"] != "multi\nline" || c.Metrics[0].Metadata["host"] != "gw1" || !c.Metrics[0].Time.Equal(time.Unix(1700000000, 0)) {
+		t.Errorf("round trip mismatch: %v", c.Metrics)
+	}
+}
+
+func TestLogfmt(t *testing.T) {
+	e := encoder.Logfmt{}
+	b, err := e.Encode(textContainer())
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	want := `time=2023-11-14T22:13:20Z host=gw1 descr="uplink, \"primary\"" in=1234 up=true` + "\n" +
+		`time=2023-11-14T22:13:20Z host=gw2 descr="multi\nline" in=0.5 nested="{\"a\":1}"`
+	if string(b) != want {
+		t.Errorf("Encode() = %q, want %q", b, want)
+	}
+	p := parser.Logfmt{Metadata: []string{"host"}, TimeField: "time"}
+	c, err := p.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse() of encoded logfmt failed: %v", err)
+	}
+	if c.Metrics[0].Data["descr"] != `uplink, "primary"` || c.Metrics[1].Data["descr"] != "multi\nline" {
+		t.Errorf("round trip mismatch: %v", c.Metrics)
+	}
+}
+
+func TestKV(t *testing.T) {
+	e := encoder.KV{Separator: ", ", Assign: ":", Quote: "'", TimeField: "ts", TimeFormat: "unix_ms"}
+	b, err := e.EncodeMetric(textContainer().Metrics[0])
+	if err != nil {
+		t.Fatalf("EncodeMetric() failed: %v", err)
+	}
+	want := `ts:1700000000000, host:gw1, descr:'uplink, "primary"', in:1234, up:true`
+	if string(b) != want {
+		t.Errorf("EncodeMetric() = %q, want %q", b, want)
+	}
+}
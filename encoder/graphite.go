@@ -0,0 +1,152 @@
+/*
+ * skogul, graphite plaintext encoder
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package encoder
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+Graphite encodes metrics in the Graphite plaintext protocol, one "path
+value timestamp" line per numeric data field. It is the counterpart of
+the graphite parser.
+
+The path is built from Template, where each dot-separated segment is
+either "field" for the name of the data field, or the name of a metadata
+field. Segments for missing metadata are left out, as is the field
+segment for the data field "value", which is what the graphite parser
+uses when the path has no field. Dots and whitespace in the values are
+replaced with underscores, so they don't change the structure of the
+path.
+
+Booleans are sent as 0 and 1, other non-numeric data is skipped.
+*/
+type Graphite struct {
+	Template string `doc:"Template for the path. Defaults to measurement.field." example:"host.measurement.field"`
+	Prefix   string `doc:"Prefix added to every path, e.g. skogul. Dots are kept."`
+	parts    []string
+	once     sync.Once
+}
+
+var graphiteReplacer = strings.NewReplacer(".", "_", " ", "_", "\t", "_", "\n", "_")
+
+func (x *Graphite) init() {
+	t := x.Template
+	if t == "" {
+		t = "measurement.field"
+	}
+	x.parts = strings.Split(t, ".")
+}
+
+// Encode all metrics of the container.
+func (x *Graphite) Encode(c *skogul.Container) ([]byte, error) {
+	var b bytes.Buffer
+	for _, m := range c.Metrics {
+		x.encode(&b, m)
+	}
+	return b.Bytes(), nil
+}
+
+// EncodeMetric encodes a single metric.
+func (x *Graphite) EncodeMetric(m *skogul.Metric) ([]byte, error) {
+	var b bytes.Buffer
+	x.encode(&b, m)
+	return b.Bytes(), nil
+}
+
+func (x *Graphite) encode(b *bytes.Buffer, m *skogul.Metric) {
+	x.once.Do(x.init)
+	ts := skogul.Now().Unix()
+	if m.Time != nil {
+		ts = m.Time.Unix()
+	}
+	fields := make([]string, 0, len(m.Data))
+	for k := range m.Data {
+		fields = append(fields, k)
+	}
+	sort.Strings(fields)
+	for _, field := range fields {
+		value, ok := graphiteValue(m.Data[field])
+		if !ok {
+			continue
+		}
+		segments := make([]string, 0, len(x.parts)+1)
+		if x.Prefix != "" {
+			segments = append(segments, x.Prefix)
+		}
+		for _, p := range x.parts {
+			if p == "field" {
+				if field != "value" {
+					segments = append(segments, graphiteReplacer.Replace(field))
+				}
+				continue
+			}
+			if v, ok := m.Metadata[p]; ok && v != nil && fmt.Sprint(v) != "" {
+				segments = append(segments, graphiteReplacer.Replace(fmt.Sprint(v)))
+			}
+		}
+		if len(segments) == 0 {
+			segments = append(segments, graphiteReplacer.Replace(field))
+		}
+		fmt.Fprintf(b, "%s %s %d\n", strings.Join(segments, "."), value, ts)
+	}
+}
+
+// graphiteValue formats a numeric value.
+func graphiteValue(v interface{}) (string, bool) {
+	switch x := v.(type) {
+	case bool:
+		if x {
+			return "1", true
+		}
+		return "0", true
+	case float64:
+		if math.IsNaN(x) || math.IsInf(x, 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(x, 'f', -1, 64), true
+	case float32:
+		if math.IsNaN(float64(x)) || math.IsInf(float64(x), 0) {
+			return "", false
+		}
+		return strconv.FormatFloat(float64(x), 'f', -1, 32), true
+	case json.Number:
+		if _, err := x.Float64(); err != nil {
+			return "", false
+		}
+		return x.String(), true
+	case int, int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64:
+		return fmt.Sprint(x), true
+	}
+	return "", false
+}
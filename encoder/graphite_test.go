@@ -0,0 +1,71 @@
+/*
+ * skogul, graphite encoder tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package encoder_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/encoder"
+	"github.com/telenornms/skogul/parser"
+)
+
+func TestGraphite(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	c := skogul.Container{Metrics: []*skogul.Metric{
+		{
+			Time:     &now,
+			Metadata: map[string]interface{}{"host": "web.01", "measurement": "cpu"},
+			Data:     map[string]interface{}{"idle": 98.5, "up": true, "name": "skipped"},
+		},
+		{
+			Time:     &now,
+			Metadata: map[string]interface{}{"measurement": "load"},
+			Data:     map[string]interface{}{"value": 3},
+		},
+	}}
+	e := encoder.Graphite{Template: "host.measurement.field", Prefix: "skogul"}
+	b, err := e.Encode(&c)
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	want := `skogul.web_01.cpu.idle 98.5 1700000000
+skogul.web_01.cpu.up 1 1700000000
+skogul.load 3 1700000000
+`
+	if string(b) != want {
+		t.Errorf("Encode() = %q, want %q", b, want)
+	}
+
+	p := parser.Graphite{Templates: []string{"skogul.host.measurement.field"}}
+	parsed, err := p.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	m := parsed.Metrics[0]
+	if m.Metadata["host"] != "web_01" || m.Metadata["measurement"] != "cpu" || m.Data["idle"] != 98.5 {
+		t.Errorf("unexpected round trip %v %v", m.Metadata, m.Data)
+	}
+}
@@ -0,0 +1,192 @@
+/*
+ * skogul, logfmt and key=value encoders
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package encoder
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+KV encodes metrics as key=value pairs, one line per metric: the
+timestamp first, then sorted metadata and sorted data. It is the
+counterpart of the kv parser. Values containing the separators, quotes
+or whitespace are quoted, escaping quotes and backslashes with a
+backslash. Nested values are encoded as JSON.
+
+Lines are separated by newlines, without a trailing newline, since the
+file sender adds one after each container.
+*/
+type KV struct {
+	Separator  string `doc:"Separator between pairs. Default ' '."`
+	Assign     string `doc:"Separator between key and value. Default '='."`
+	Quote      string `doc:"Quote character, a single character. Default '\"'."`
+	TimeField  string `doc:"Key for the timestamp. Default 'time'."`
+	TimeFormat string `doc:"Format of the timestamp, as for the timestamp transformer: rfc3339 (default) or a Go time layout, or unix, unix_ms, unix_us or unix_ns for epoch values."`
+	once       sync.Once
+	quote      rune
+}
+
+/*
+Logfmt encodes metrics as logfmt, e.g.:
+
+	time=2024-05-01T12:00:00Z host=foo msg="hello world" value=1
+
+It is the counterpart of the logfmt parser, and uses Go quoting for
+values.
+*/
+type Logfmt struct {
+	TimeField  string `doc:"Key for the timestamp. Default 'time'."`
+	TimeFormat string `doc:"Format of the timestamp, as for the timestamp transformer: rfc3339 (default) or a Go time layout, or unix, unix_ms, unix_us or unix_ns for epoch values."`
+	once       sync.Once
+	kv         KV
+}
+
+func (x *KV) init() {
+	if x.Separator == "" {
+		x.Separator = " "
+	}
+	if x.Assign == "" {
+		x.Assign = "="
+	}
+	x.quote = '"'
+	if x.Quote != "" {
+		x.quote, _ = utf8.DecodeRuneInString(x.Quote)
+	}
+	if x.TimeField == "" {
+		x.TimeField = "time"
+	}
+}
+
+// value writes a value, quoted if needed.
+func (x *KV) value(b *strings.Builder, s string) {
+	needQuote := s == "" || strings.Contains(s, x.Separator) || strings.Contains(s, x.Assign) || strings.ContainsRune(s, x.quote) || strings.IndexFunc(s, func(r rune) bool {
+		return unicode.IsSpace(r) || !unicode.IsPrint(r) || r == '\\'
+	}) >= 0
+	if !needQuote {
+		b.WriteString(s)
+		return
+	}
+	if x.quote == '"' {
+		b.WriteString(strconv.Quote(s))
+		return
+	}
+	b.WriteRune(x.quote)
+	for _, r := range s {
+		switch r {
+		case x.quote, '\\':
+			b.WriteByte('\\')
+		case '\n':
+			b.WriteString(`\n`)
+			continue
+		case '\t':
+			b.WriteString(`\t`)
+			continue
+		case '\r':
+			b.WriteString(`\r`)
+			continue
+		}
+		b.WriteRune(r)
+	}
+	b.WriteRune(x.quote)
+}
+
+func (x *KV) pair(b *strings.Builder, k string, v interface{}) {
+	if b.Len() > 0 {
+		b.WriteString(x.Separator)
+	}
+	b.WriteString(k)
+	b.WriteString(x.Assign)
+	x.value(b, textValue(v))
+}
+
+func (x *KV) encode(m *skogul.Metric) string {
+	x.once.Do(x.init)
+	var b strings.Builder
+	if m.Time != nil {
+		x.pair(&b, x.TimeField, skogul.FormatTime(x.TimeFormat, *m.Time))
+	}
+	md := make(map[string]bool, len(m.Metadata))
+	for k := range m.Metadata {
+		md[k] = true
+	}
+	for _, k := range sortedKeys(md) {
+		x.pair(&b, k, m.Metadata[k])
+	}
+	data := make(map[string]bool, len(m.Data))
+	for k := range m.Data {
+		data[k] = true
+	}
+	for _, k := range sortedKeys(data) {
+		x.pair(&b, k, m.Data[k])
+	}
+	return b.String()
+}
+
+// Encode encodes all metrics of the container, one per line.
+func (x *KV) Encode(c *skogul.Container) ([]byte, error) {
+	lines := make([]string, 0, len(c.Metrics))
+	for _, m := range c.Metrics {
+		lines = append(lines, x.encode(m))
+	}
+	return []byte(strings.Join(lines, "\n")), nil
+}
+
+// EncodeMetric encodes a single metric.
+func (x *KV) EncodeMetric(m *skogul.Metric) ([]byte, error) {
+	return []byte(x.encode(m)), nil
+}
+
+// Verify checks that the separators are usable.
+func (x *KV) Verify() error {
+	if utf8.RuneCountInString(x.Quote) > 1 {
+		return fmt.Errorf("Quote must be a single character")
+	}
+	if x.Separator != "" && x.Separator == x.Assign {
+		return fmt.Errorf("Separator and Assign can not be the same")
+	}
+	return nil
+}
+
+func (x *Logfmt) init() {
+	x.kv = KV{TimeField: x.TimeField, TimeFormat: x.TimeFormat}
+}
+
+// Encode encodes all metrics of the container, one per line.
+func (x *Logfmt) Encode(c *skogul.Container) ([]byte, error) {
+	x.once.Do(x.init)
+	return x.kv.Encode(c)
+}
+
+// EncodeMetric encodes a single metric.
+func (x *Logfmt) EncodeMetric(m *skogul.Metric) ([]byte, error) {
+	x.once.Do(x.init)
+	return x.kv.EncodeMetric(m)
+}
@@ -0,0 +1,85 @@
+/*
+ * skogul, MessagePack and CBOR encoders
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package encoder
+
+import (
+	"bytes"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/telenornms/skogul"
+)
+
+// MsgPack encodes containers or metrics as MessagePack, with the same
+// layout as the Skogul JSON format. Timestamps use the MessagePack
+// timestamp extension type, keeping nanoseconds, and integers and floats
+// keep their types.
+type MsgPack struct{}
+
+func msgpackEncode(v interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := msgpack.NewEncoder(&buf)
+	enc.SetCustomStructTag("json")
+	enc.SetOmitEmpty(true)
+	err := enc.Encode(v)
+	return buf.Bytes(), err
+}
+
+// Encode a container.
+func (x MsgPack) Encode(c *skogul.Container) ([]byte, error) {
+	return msgpackEncode(c)
+}
+
+// EncodeMetric encodes a single metric.
+func (x MsgPack) EncodeMetric(m *skogul.Metric) ([]byte, error) {
+	return msgpackEncode(m)
+}
+
+// CBOR encodes containers or metrics as CBOR (RFC 8949), with the same
+// layout as the Skogul JSON format. Timestamps are encoded as tag 0
+// RFC3339 strings with nanoseconds, and integers and floats keep their
+// types.
+type CBOR struct{}
+
+var cborEncMode cbor.EncMode
+
+func init() {
+	var err error
+	cborEncMode, err = cbor.EncOptions{
+		Time:    cbor.TimeRFC3339Nano,
+		TimeTag: cbor.EncTagRequired,
+	}.EncMode()
+	skogul.Assert(err == nil, err)
+}
+
+// Encode a container.
+func (x CBOR) Encode(c *skogul.Container) ([]byte, error) {
+	return cborEncMode.Marshal(c)
+}
+
+// EncodeMetric encodes a single metric.
+func (x CBOR) EncodeMetric(m *skogul.Metric) ([]byte, error) {
+	return cborEncMode.Marshal(m)
+}
@@ -0,0 +1,89 @@
+/*
+ * skogul, MessagePack and CBOR encoder benchmarks
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package encoder_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/encoder"
+)
+
+func benchContainer() *skogul.Container {
+	now := time.Now()
+	c := skogul.Container{}
+	for i := 0; i < 100; i++ {
+		c.Metrics = append(c.Metrics, &skogul.Metric{
+			Time:     &now,
+			Metadata: map[string]interface{}{"host": fmt.Sprintf("host%d", i), "ifname": "xe-0/0/0"},
+			Data:     map[string]interface{}{"in_octets": int64(1234567890123), "util": 0.25, "up": true, "descr": "uplink"},
+		})
+	}
+	return &c
+}
+
+// TestBinarySize checks that the binary formats are actually more compact
+// than JSON, and logs the sizes for comparison with GOB.
+func TestBinarySize(t *testing.T) {
+	c := benchContainer()
+	sizes := make(map[string]int)
+	for name, e := range map[string]skogul.Encoder{"json": encoder.JSON{}, "gob": encoder.GOB{}, "msgpack": encoder.MsgPack{}, "cbor": encoder.CBOR{}} {
+		b, err := e.Encode(c)
+		if err != nil {
+			t.Fatalf("%s: Encode() failed: %v", name, err)
+		}
+		sizes[name] = len(b)
+	}
+	t.Logf("encoded sizes of 100 metrics: %v", sizes)
+	if sizes["msgpack"] >= sizes["json"] || sizes["cbor"] >= sizes["json"] {
+		t.Errorf("binary encodings are not smaller than JSON: %v", sizes)
+	}
+}
+
+func benchmarkEncode(b *testing.B, e skogul.Encoder) {
+	c := benchContainer()
+	for i := 0; i < b.N; i++ {
+		if _, err := e.Encode(c); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBinaryEncode_json(b *testing.B) {
+	benchmarkEncode(b, encoder.JSON{})
+}
+
+func BenchmarkBinaryEncode_gob(b *testing.B) {
+	benchmarkEncode(b, encoder.GOB{})
+}
+
+func BenchmarkBinaryEncode_msgpack(b *testing.B) {
+	benchmarkEncode(b, encoder.MsgPack{})
+}
+
+func BenchmarkBinaryEncode_cbor(b *testing.B) {
+	benchmarkEncode(b, encoder.CBOR{})
+}
@@ -0,0 +1,168 @@
+/*
+ * skogul, schema-driven protobuf encoder
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package encoder
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/internal/protodyn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+ProtobufDynamic encodes metrics as arbitrary protobuf messages, using
+message definitions loaded at run time. It is the counterpart of the
+protobuf_dynamic parser, and uses the same options.
+*/
+type ProtobufDynamic struct {
+	DescriptorSet string            `doc:"Path to a compiled FileDescriptorSet, as written by protoc --descriptor_set_out."`
+	Proto         []string          `doc:"Paths to .proto files to compile, as an alternative to DescriptorSet. Relative to ImportPaths, if set."`
+	ImportPaths   []string          `doc:"Directories to search for .proto files and their imports. The well-known types (google/protobuf/*.proto) are always available."`
+	Message       string            `doc:"Fully qualified name of the message type to encode, e.g. example.telemetry.Measurement."`
+	Split         string            `doc:"Path to a repeated message field, e.g. \"points\". If set, a container is encoded as a single message with one element per metric, and the paths for Metadata, Data and Timestamp are relative to the element."`
+	Metadata      map[string]string `doc:"Map of metadata keys to the path of the protobuf field to store them in, with dots between nested fields, e.g. {\"host\": \"header.hostname\"}." example:"{\"host\": \"header.hostname\", \"interface\": \"if_name\"}"`
+	Data          map[string]string `doc:"Map of data keys to field paths, like Metadata. If left empty, data keys are matched against the fields of the message by name, and keys without a matching field are ignored."`
+	Timestamp     string            `doc:"Path to the field to store the time of the metric in. Can be a google.protobuf.Timestamp, a string (RFC3339) or a number, see TimestampUnit."`
+	TimestampUnit string            `doc:"Unit of numeric timestamps: s, ms, us or ns. Defaults to s."`
+	Delimited     bool              `doc:"Prefix each message with its length as a varint, as read by parseDelimitedFrom() in Java and similar. Without Split, this is required to encode containers with more than one metric."`
+	once          sync.Once
+	desc          protoreflect.MessageDescriptor
+	err           error
+}
+
+// Verify loads the schema to catch errors early, without keeping it.
+func (x *ProtobufDynamic) Verify() error {
+	if x.Message == "" {
+		return skogul.MissingArgument("Message")
+	}
+	if x.DescriptorSet == "" && len(x.Proto) == 0 {
+		return skogul.MissingArgument("DescriptorSet or Proto")
+	}
+	if err := protodyn.VerifyUnit(x.TimestampUnit); err != nil {
+		return err
+	}
+	_, err := protodyn.Load(x.DescriptorSet, x.Proto, x.ImportPaths, x.Message)
+	return err
+}
+
+func (x *ProtobufDynamic) load() error {
+	x.once.Do(func() {
+		x.desc, x.err = protodyn.Load(x.DescriptorSet, x.Proto, x.ImportPaths, x.Message)
+	})
+	if x.err != nil {
+		return fmt.Errorf("unable to load schema: %w", x.err)
+	}
+	return nil
+}
+
+// Encode encodes the container as a single message if Split is set,
+// otherwise as one message per metric.
+func (x *ProtobufDynamic) Encode(c *skogul.Container) ([]byte, error) {
+	if err := x.load(); err != nil {
+		return nil, err
+	}
+	if x.Split != "" {
+		m := protodyn.New(x.desc)
+		l, _, err := protodyn.Mutable(m, protodyn.Path(x.Split))
+		if err != nil {
+			return nil, err
+		}
+		for _, metric := range c.Metrics {
+			e := l.NewElement()
+			if err := x.fill(e.Message(), metric); err != nil {
+				return nil, err
+			}
+			l.Append(e)
+		}
+		return x.marshal(nil, m)
+	}
+	if len(c.Metrics) != 1 && !x.Delimited {
+		return nil, fmt.Errorf("container has %d metrics, but only one message can be encoded without Split or Delimited", len(c.Metrics))
+	}
+	var ret []byte
+	for _, metric := range c.Metrics {
+		m := protodyn.New(x.desc)
+		if err := x.fill(m, metric); err != nil {
+			return nil, err
+		}
+		var err error
+		if ret, err = x.marshal(ret, m); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// EncodeMetric encodes a single metric as a message. If Split is set, the
+// message has a single element.
+func (x *ProtobufDynamic) EncodeMetric(metric *skogul.Metric) ([]byte, error) {
+	return x.Encode(&skogul.Container{Metrics: []*skogul.Metric{metric}})
+}
+
+// marshal appends the encoded message to dst, with a length prefix if
+// Delimited is set.
+func (x *ProtobufDynamic) marshal(dst []byte, m protoreflect.Message) ([]byte, error) {
+	b, err := proto.Marshal(m.Interface())
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode %s: %w", x.Message, err)
+	}
+	if x.Delimited {
+		return protodyn.AppendDelimited(dst, b), nil
+	}
+	return append(dst, b...), nil
+}
+
+// fill populates m from the metric
+func (x *ProtobufDynamic) fill(m protoreflect.Message, metric *skogul.Metric) error {
+	if x.Timestamp != "" && metric.Time != nil {
+		if err := protodyn.SetTime(m, protodyn.Path(x.Timestamp), *metric.Time, x.TimestampUnit); err != nil {
+			return err
+		}
+	}
+	for key, path := range x.Metadata {
+		v, ok := metric.Metadata[key]
+		if !ok || v == nil {
+			continue
+		}
+		if err := protodyn.Set(m, protodyn.Path(path), v); err != nil {
+			return fmt.Errorf("metadata %s: %w", key, err)
+		}
+	}
+	if len(x.Data) == 0 {
+		return protodyn.FromMap(m, metric.Data)
+	}
+	for key, path := range x.Data {
+		v, ok := metric.Data[key]
+		if !ok || v == nil {
+			continue
+		}
+		if err := protodyn.Set(m, protodyn.Path(path), v); err != nil {
+			return fmt.Errorf("data %s: %w", key, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,66 @@
+/*
+ * skogul, test dynamic protobuf encoder
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package encoder_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/encoder"
+)
+
+func TestProtobufDynamicEncode(t *testing.T) {
+	now := time.Unix(1700000000, 0)
+	m := skogul.Metric{
+		Time:     &now,
+		Metadata: map[string]interface{}{"host": "router1"},
+		Data:     map[string]interface{}{"if_name": "xe-0/0/0", "in_octets": 1.0, "unknown": "ignored"},
+	}
+	x := encoder.ProtobufDynamic{
+		Proto:       []string{"measurement.proto"},
+		ImportPaths: []string{"../docs/examples/protobuf_dynamic"},
+		Message:     "example.telemetry.Point",
+		Timestamp:   "time",
+	}
+	b, err := x.EncodeMetric(&m)
+	if err != nil {
+		t.Fatalf("EncodeMetric() failed: %v", err)
+	}
+	// time (1) is a 6 byte message, if_name (2) is 8 bytes and
+	// in_octets (3) is a single byte varint.
+	if len(b) != 8+10+2 {
+		t.Errorf("Expected 20 bytes, got %d: %x", len(b), b)
+	}
+
+	c := skogul.Container{Metrics: []*skogul.Metric{&m, &m}}
+	if _, err := x.Encode(&c); err == nil {
+		t.Errorf("Encode() of two metrics without Split or Delimited succeeded")
+	}
+
+	m.Data["in_octets"] = "many"
+	if _, err := x.EncodeMetric(&m); err == nil {
+		t.Errorf("EncodeMetric() with a string for an integer field succeeded")
+	}
+}
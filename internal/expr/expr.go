@@ -0,0 +1,807 @@
+/*
+ * skogul, arithmetic expressions
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+/*
+Package expr implements the small expression language used by the expr
+transformer: arithmetic, comparisons, logical operators, conditionals and
+a handful of math functions over metric fields.
+
+Expressions are compiled and type-checked once, and evaluated against a
+metric through a Lookup function. Identifiers refer to data fields,
+unless prefixed with "metadata.". A "data." prefix is also accepted.
+Field names with characters outside [A-Za-z0-9_.] can be quoted with
+backticks, e.g. `in-octets`.
+
+	util = (in_octets*8)/speed*100
+	temp_f = temp_c*1.8+32
+	state = metadata.type == "ge" && speed > 1e9 ? "fast" : "slow"
+
+Supported operators, by increasing precedence: ?:, ||, &&,
+== != < <= > >=, + -, * / %, unary - and !. Functions: abs, ceil, exp,
+floor, if, ln, log, log10, log2, max, min, pow, round and sqrt.
+*/
+package expr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// ErrOperand is returned (wrapped) by Eval when an operand is missing or
+// has a type that can't be used, e.g. a non-numeric string in
+// arithmetic.
+var ErrOperand = errors.New("missing or invalid operand")
+
+// Lookup returns the value of a field, with metadata set for metadata
+// fields, and false if it isn't present.
+type Lookup func(metadata bool, name string) (interface{}, bool)
+
+// kind is the static type of an expression.
+type kind int
+
+const (
+	kAny kind = iota
+	kNum
+	kStr
+	kBool
+)
+
+func (k kind) String() string {
+	switch k {
+	case kNum:
+		return "number"
+	case kStr:
+		return "string"
+	case kBool:
+		return "boolean"
+	}
+	return "field"
+}
+
+type node interface {
+	eval(l Lookup) (interface{}, error)
+	kind() kind
+}
+
+// Expr is a compiled expression.
+type Expr struct {
+	src  string
+	root node
+}
+
+// String returns the source of the expression.
+func (e *Expr) String() string {
+	return e.src
+}
+
+// Eval evaluates the expression. The result is a float64, string or
+// bool.
+func (e *Expr) Eval(l Lookup) (interface{}, error) {
+	return e.root.eval(l)
+}
+
+// Compile parses and type-checks an expression.
+func Compile(src string) (*Expr, error) {
+	p := parser{src: src}
+	if err := p.lex(); err != nil {
+		return nil, err
+	}
+	root, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if p.peek().typ != tEOF {
+		return nil, p.errorf("unexpected %s", p.peek())
+	}
+	return &Expr{src: src, root: root}, nil
+}
+
+// Lexer
+
+type tokType int
+
+const (
+	tEOF tokType = iota
+	tNum
+	tStr
+	tIdent
+	tOp
+)
+
+type token struct {
+	typ tokType
+	pos int
+	s   string
+	num float64
+}
+
+func (t token) String() string {
+	if t.typ == tEOF {
+		return "end of expression"
+	}
+	return fmt.Sprintf("'%s'", t.s)
+}
+
+type parser struct {
+	src  string
+	toks []token
+	pos  int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	pos := len(p.src)
+	if p.pos < len(p.toks) {
+		pos = p.toks[p.pos].pos
+	}
+	return fmt.Errorf("expression `%s' at position %d: %s", p.src, pos+1, fmt.Sprintf(format, args...))
+}
+
+func isIdent(c byte, first bool) bool {
+	if c == '_' || (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+		return true
+	}
+	return !first && (c == '.' || (c >= '0' && c <= '9'))
+}
+
+var operators = []string{"==", "!=", "<=", ">=", "&&", "||", "+", "-", "*", "/", "%", "(", ")", ",", "?", ":", "<", ">", "!"}
+
+func (p *parser) lex() error {
+	s := p.src
+	for i := 0; i < len(s); {
+		c := s[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case (c >= '0' && c <= '9') || (c == '.' && i+1 < len(s) && s[i+1] >= '0' && s[i+1] <= '9'):
+			start := i
+			for i < len(s) && ((s[i] >= '0' && s[i] <= '9') || s[i] == '.') {
+				i++
+			}
+			if i < len(s) && (s[i] == 'e' || s[i] == 'E') {
+				i++
+				if i < len(s) && (s[i] == '+' || s[i] == '-') {
+					i++
+				}
+				for i < len(s) && s[i] >= '0' && s[i] <= '9' {
+					i++
+				}
+			}
+			n, err := strconv.ParseFloat(s[start:i], 64)
+			if err != nil {
+				return fmt.Errorf("expression `%s' at position %d: invalid number %s", s, start+1, s[start:i])
+			}
+			p.toks = append(p.toks, token{typ: tNum, pos: start, s: s[start:i], num: n})
+		case c == '"' || c == '\'':
+			start := i
+			i++
+			for i < len(s) && s[i] != c {
+				if s[i] == '\\' {
+					i++
+				}
+				i++
+			}
+			if i >= len(s) {
+				return fmt.Errorf("expression `%s' at position %d: unterminated string", s, start+1)
+			}
+			i++
+			raw := s[start:i]
+			if c == '\'' {
+				raw = `"` + strings.ReplaceAll(raw[1:len(raw)-1], `"`, `\"`) + `"`
+			}
+			str, err := strconv.Unquote(raw)
+			if err != nil {
+				return fmt.Errorf("expression `%s' at position %d: invalid string %s", s, start+1, s[start:i])
+			}
+			p.toks = append(p.toks, token{typ: tStr, pos: start, s: str})
+		case isIdent(c, true) || c == '`':
+			start := i
+			var name strings.Builder
+			for i < len(s) && (isIdent(s[i], i == start) || s[i] == '`') {
+				if s[i] == '`' {
+					end := strings.IndexByte(s[i+1:], '`')
+					if end == -1 {
+						return fmt.Errorf("expression `%s' at position %d: unterminated quoted field", s, i+1)
+					}
+					name.WriteString(s[i+1 : i+1+end])
+					i += end + 2
+					continue
+				}
+				name.WriteByte(s[i])
+				i++
+			}
+			p.toks = append(p.toks, token{typ: tIdent, pos: start, s: name.String()})
+		default:
+			found := false
+			for _, op := range operators {
+				if strings.HasPrefix(s[i:], op) {
+					p.toks = append(p.toks, token{typ: tOp, pos: i, s: op})
+					i += len(op)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return fmt.Errorf("expression `%s' at position %d: unexpected character '%c'", s, i+1, c)
+			}
+		}
+	}
+	p.toks = append(p.toks, token{typ: tEOF, pos: len(s)})
+	return nil
+}
+
+// Parser
+
+func (p *parser) peek() token {
+	return p.toks[p.pos]
+}
+
+func (p *parser) next() token {
+	t := p.toks[p.pos]
+	if t.typ != tEOF {
+		p.pos++
+	}
+	return t
+}
+
+func (p *parser) accept(ops ...string) (string, bool) {
+	t := p.peek()
+	if t.typ != tOp {
+		return "", false
+	}
+	for _, op := range ops {
+		if t.s == op {
+			p.pos++
+			return op, true
+		}
+	}
+	return "", false
+}
+
+func (p *parser) expect(op string) error {
+	if _, ok := p.accept(op); !ok {
+		return p.errorf("expected '%s', got %s", op, p.peek())
+	}
+	return nil
+}
+
+// check verifies that n can be used where k is expected.
+func (p *parser) check(n node, k kind, what string) error {
+	if n.kind() != kAny && n.kind() != k {
+		return p.errorf("%s needs a %s, got a %s", what, k, n.kind())
+	}
+	return nil
+}
+
+func (p *parser) parseExpr() (node, error) {
+	c, err := p.parseOr()
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := p.accept("?"); !ok {
+		return c, nil
+	}
+	if err := p.check(c, kBool, "condition"); err != nil {
+		return nil, err
+	}
+	a, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	b, err := p.parseExpr()
+	if err != nil {
+		return nil, err
+	}
+	return newCond(p, c, a, b)
+}
+
+func newCond(p *parser, c, a, b node) (node, error) {
+	k := a.kind()
+	if a.kind() != b.kind() {
+		if a.kind() != kAny && b.kind() != kAny {
+			return nil, p.errorf("conditional branches have different types: %s and %s", a.kind(), b.kind())
+		}
+		k = kAny
+	}
+	return &condNode{c: c, a: a, b: b, k: k}, nil
+}
+
+func (p *parser) parseOr() (node, error) {
+	return p.parseLogical("||", p.parseAnd)
+}
+
+func (p *parser) parseAnd() (node, error) {
+	return p.parseLogical("&&", p.parseCmp)
+}
+
+func (p *parser) parseLogical(op string, sub func() (node, error)) (node, error) {
+	l, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		if _, ok := p.accept(op); !ok {
+			return l, nil
+		}
+		r, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.check(l, kBool, op); err != nil {
+			return nil, err
+		}
+		if err := p.check(r, kBool, op); err != nil {
+			return nil, err
+		}
+		l = &logicalNode{op: op, l: l, r: r}
+	}
+}
+
+func (p *parser) parseCmp() (node, error) {
+	l, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	op, ok := p.accept("==", "!=", "<=", ">=", "<", ">")
+	if !ok {
+		return l, nil
+	}
+	r, err := p.parseAdd()
+	if err != nil {
+		return nil, err
+	}
+	if op == "==" || op == "!=" {
+		if l.kind() != kAny && r.kind() != kAny && l.kind() != r.kind() {
+			return nil, p.errorf("can't compare %s with %s", l.kind(), r.kind())
+		}
+		return &eqNode{not: op == "!=", l: l, r: r}, nil
+	}
+	if err := p.check(l, kNum, op); err != nil {
+		return nil, err
+	}
+	if err := p.check(r, kNum, op); err != nil {
+		return nil, err
+	}
+	return &cmpNode{op: op, l: l, r: r}, nil
+}
+
+func (p *parser) parseArith(sub func() (node, error), ops ...string) (node, error) {
+	l, err := sub()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		op, ok := p.accept(ops...)
+		if !ok {
+			return l, nil
+		}
+		r, err := sub()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.check(l, kNum, op); err != nil {
+			return nil, err
+		}
+		if err := p.check(r, kNum, op); err != nil {
+			return nil, err
+		}
+		l = &arithNode{op: op[0], l: l, r: r}
+	}
+}
+
+func (p *parser) parseAdd() (node, error) {
+	return p.parseArith(p.parseMul, "+", "-")
+}
+
+func (p *parser) parseMul() (node, error) {
+	return p.parseArith(p.parseUnary, "*", "/", "%")
+}
+
+func (p *parser) parseUnary() (node, error) {
+	op, ok := p.accept("-", "!")
+	if !ok {
+		return p.parsePrimary()
+	}
+	x, err := p.parseUnary()
+	if err != nil {
+		return nil, err
+	}
+	if op == "-" {
+		if err := p.check(x, kNum, "unary -"); err != nil {
+			return nil, err
+		}
+		return &negNode{x: x}, nil
+	}
+	if err := p.check(x, kBool, "!"); err != nil {
+		return nil, err
+	}
+	return &notNode{x: x}, nil
+}
+
+func (p *parser) parsePrimary() (node, error) {
+	t := p.next()
+	switch t.typ {
+	case tNum:
+		return &litNode{v: t.num, k: kNum}, nil
+	case tStr:
+		return &litNode{v: t.s, k: kStr}, nil
+	case tIdent:
+		if _, ok := p.accept("("); ok {
+			return p.parseCall(t)
+		}
+		switch t.s {
+		case "true":
+			return &litNode{v: true, k: kBool}, nil
+		case "false":
+			return &litNode{v: false, k: kBool}, nil
+		}
+		if name, ok := strings.CutPrefix(t.s, "metadata."); ok {
+			return &fieldNode{metadata: true, name: name}, nil
+		}
+		name, _ := strings.CutPrefix(t.s, "data.")
+		return &fieldNode{name: name}, nil
+	case tOp:
+		if t.s == "(" {
+			n, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			if err := p.expect(")"); err != nil {
+				return nil, err
+			}
+			return n, nil
+		}
+	}
+	if t.typ != tEOF {
+		p.pos--
+	}
+	return nil, p.errorf("unexpected %s", t)
+}
+
+func (p *parser) parseCall(name token) (node, error) {
+	var args []node
+	if _, ok := p.accept(")"); !ok {
+		for {
+			a, err := p.parseExpr()
+			if err != nil {
+				return nil, err
+			}
+			args = append(args, a)
+			if _, ok := p.accept(")"); ok {
+				break
+			}
+			if err := p.expect(","); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if name.s == "if" {
+		if len(args) != 3 {
+			return nil, p.errorf("if() takes 3 arguments, got %d", len(args))
+		}
+		if err := p.check(args[0], kBool, "if()"); err != nil {
+			return nil, err
+		}
+		return newCond(p, args[0], args[1], args[2])
+	}
+	f, ok := functions[name.s]
+	if !ok {
+		return nil, p.errorf("unknown function %s()", name.s)
+	}
+	if len(args) < f.min || (f.max >= 0 && len(args) > f.max) {
+		return nil, p.errorf("wrong number of arguments to %s(): %d", name.s, len(args))
+	}
+	for _, a := range args {
+		if err := p.check(a, kNum, name.s+"()"); err != nil {
+			return nil, err
+		}
+	}
+	return &callNode{name: name.s, fn: f.fn, args: args}, nil
+}
+
+type function struct {
+	min int
+	max int
+	fn  func(args []float64) float64
+}
+
+func math1(f func(float64) float64) function {
+	return function{1, 1, func(a []float64) float64 { return f(a[0]) }}
+}
+
+var functions = map[string]function{
+	"abs":   math1(math.Abs),
+	"ceil":  math1(math.Ceil),
+	"exp":   math1(math.Exp),
+	"floor": math1(math.Floor),
+	"ln":    math1(math.Log),
+	"log":   math1(math.Log),
+	"log10": math1(math.Log10),
+	"log2":  math1(math.Log2),
+	"round": math1(math.Round),
+	"sqrt":  math1(math.Sqrt),
+	"pow":   {2, 2, func(a []float64) float64 { return math.Pow(a[0], a[1]) }},
+	"min": {1, -1, func(a []float64) float64 {
+		r := a[0]
+		for _, v := range a[1:] {
+			r = math.Min(r, v)
+		}
+		return r
+	}},
+	"max": {1, -1, func(a []float64) float64 {
+		r := a[0]
+		for _, v := range a[1:] {
+			r = math.Max(r, v)
+		}
+		return r
+	}},
+}
+
+// Evaluation
+
+// toNum converts a value to a number, parsing numeric strings.
+func toNum(v interface{}) (float64, error) {
+	switch n := v.(type) {
+	case float64:
+		return n, nil
+	case string:
+		f, err := strconv.ParseFloat(strings.TrimSpace(n), 64)
+		if err != nil {
+			return 0, fmt.Errorf("%w: %q is not a number", ErrOperand, n)
+		}
+		return f, nil
+	}
+	return 0, fmt.Errorf("%w: %v is not a number", ErrOperand, v)
+}
+
+func toBool(v interface{}) (bool, error) {
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("%w: %v is not a boolean", ErrOperand, v)
+	}
+	return b, nil
+}
+
+func evalNum(n node, l Lookup) (float64, error) {
+	v, err := n.eval(l)
+	if err != nil {
+		return 0, err
+	}
+	return toNum(v)
+}
+
+func evalBool(n node, l Lookup) (bool, error) {
+	v, err := n.eval(l)
+	if err != nil {
+		return false, err
+	}
+	return toBool(v)
+}
+
+type litNode struct {
+	v interface{}
+	k kind
+}
+
+func (n *litNode) eval(Lookup) (interface{}, error) { return n.v, nil }
+func (n *litNode) kind() kind                       { return n.k }
+
+type fieldNode struct {
+	metadata bool
+	name     string
+}
+
+func (n *fieldNode) kind() kind { return kAny }
+func (n *fieldNode) eval(l Lookup) (interface{}, error) {
+	v, ok := l(n.metadata, n.name)
+	if !ok || v == nil {
+		return nil, fmt.Errorf("%w: %s is missing", ErrOperand, n.name)
+	}
+	switch x := v.(type) {
+	case float64, string, bool:
+		return x, nil
+	case float32:
+		return float64(x), nil
+	case int:
+		return float64(x), nil
+	case int8:
+		return float64(x), nil
+	case int16:
+		return float64(x), nil
+	case int32:
+		return float64(x), nil
+	case int64:
+		return float64(x), nil
+	case uint:
+		return float64(x), nil
+	case uint8:
+		return float64(x), nil
+	case uint16:
+		return float64(x), nil
+	case uint32:
+		return float64(x), nil
+	case uint64:
+		return float64(x), nil
+	case json.Number:
+		return toNum(string(x))
+	}
+	return nil, fmt.Errorf("%w: %s has unsupported type %T", ErrOperand, n.name, v)
+}
+
+type negNode struct{ x node }
+
+func (n *negNode) kind() kind { return kNum }
+func (n *negNode) eval(l Lookup) (interface{}, error) {
+	v, err := evalNum(n.x, l)
+	return -v, err
+}
+
+type notNode struct{ x node }
+
+func (n *notNode) kind() kind { return kBool }
+func (n *notNode) eval(l Lookup) (interface{}, error) {
+	v, err := evalBool(n.x, l)
+	return !v, err
+}
+
+type arithNode struct {
+	op   byte
+	l, r node
+}
+
+func (n *arithNode) kind() kind { return kNum }
+func (n *arithNode) eval(l Lookup) (interface{}, error) {
+	a, err := evalNum(n.l, l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := evalNum(n.r, l)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case '+':
+		return a + b, nil
+	case '-':
+		return a - b, nil
+	case '*':
+		return a * b, nil
+	case '/':
+		return a / b, nil
+	}
+	return math.Mod(a, b), nil
+}
+
+type cmpNode struct {
+	op   string
+	l, r node
+}
+
+func (n *cmpNode) kind() kind { return kBool }
+func (n *cmpNode) eval(l Lookup) (interface{}, error) {
+	a, err := evalNum(n.l, l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := evalNum(n.r, l)
+	if err != nil {
+		return nil, err
+	}
+	switch n.op {
+	case "<":
+		return a < b, nil
+	case "<=":
+		return a <= b, nil
+	case ">":
+		return a > b, nil
+	}
+	return a >= b, nil
+}
+
+type eqNode struct {
+	not  bool
+	l, r node
+}
+
+func (n *eqNode) kind() kind { return kBool }
+
+// eval compares numerically if either side is a number and the other
+// can be converted, e.g. a string field "80" equals 80.
+func (n *eqNode) eval(l Lookup) (interface{}, error) {
+	a, err := n.l.eval(l)
+	if err != nil {
+		return nil, err
+	}
+	b, err := n.r.eval(l)
+	if err != nil {
+		return nil, err
+	}
+	eq := a == b
+	_, an := a.(float64)
+	_, bn := b.(float64)
+	if an || bn {
+		x, errx := toNum(a)
+		y, erry := toNum(b)
+		eq = errx == nil && erry == nil && x == y
+	}
+	return eq != n.not, nil
+}
+
+type logicalNode struct {
+	op   string
+	l, r node
+}
+
+func (n *logicalNode) kind() kind { return kBool }
+func (n *logicalNode) eval(l Lookup) (interface{}, error) {
+	a, err := evalBool(n.l, l)
+	if err != nil {
+		return nil, err
+	}
+	if (n.op == "&&" && !a) || (n.op == "||" && a) {
+		return a, nil
+	}
+	return evalBool(n.r, l)
+}
+
+type condNode struct {
+	c, a, b node
+	k       kind
+}
+
+func (n *condNode) kind() kind { return n.k }
+func (n *condNode) eval(l Lookup) (interface{}, error) {
+	c, err := evalBool(n.c, l)
+	if err != nil {
+		return nil, err
+	}
+	if c {
+		return n.a.eval(l)
+	}
+	return n.b.eval(l)
+}
+
+type callNode struct {
+	name string
+	fn   func([]float64) float64
+	args []node
+}
+
+func (n *callNode) kind() kind { return kNum }
+func (n *callNode) eval(l Lookup) (interface{}, error) {
+	args := make([]float64, len(n.args))
+	for i, a := range n.args {
+		v, err := evalNum(a, l)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	return n.fn(args), nil
+}
@@ -0,0 +1,164 @@
+/*
+ * skogul, arithmetic expression tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package expr_test
+
+import (
+	"errors"
+	"math"
+	"testing"
+
+	"github.com/telenornms/skogul/internal/expr"
+)
+
+var testData = map[string]interface{}{
+	"in_octets": uint64(1000),
+	"speed":     int64(8000),
+	"temp_c":    100.0,
+	"neg":       -4,
+	"str":       "12.5",
+	"word":      "hello",
+	"in-octets": 10,
+	"flag":      true,
+}
+
+var testMetadata = map[string]interface{}{
+	"type":   "ge",
+	"port":   "80",
+	"if-idx": 3,
+}
+
+func lookup(metadata bool, name string) (interface{}, bool) {
+	if metadata {
+		v, ok := testMetadata[name]
+		return v, ok
+	}
+	v, ok := testData[name]
+	return v, ok
+}
+
+func TestEval(t *testing.T) {
+	cases := []struct {
+		src  string
+		want interface{}
+	}{
+		{"(in_octets*8)/speed*100", 100.0},
+		{"temp_c*1.8+32", 212.0},
+		{"1 + 2 * 3 - 4 / 2", 5.0},
+		{"-(1 + 2) * -2", 6.0},
+		{"7 % 4", 3.0},
+		{"1e3 + .5", 1000.5},
+		{"abs(neg)", 4.0},
+		{"log10(in_octets)", 3.0},
+		{"min(3, neg, 10)", -4.0},
+		{"max(3, neg, 10)", 10.0},
+		{"pow(2, 10)", 1024.0},
+		{"round(2.5) + floor(1.9) + ceil(1.1)", 6.0},
+		{"str * 2", 25.0},
+		{"data.temp_c", 100.0},
+		{"`in-octets` + 1", 11.0},
+		{"metadata.`if-idx` * 2", 6.0},
+		{`metadata.type == "ge"`, true},
+		{`metadata.type != 'ge'`, false},
+		{"metadata.port == 80", true},
+		{"speed > 1000 && !(speed >= 10000)", true},
+		{"flag || missing > 0", true},
+		{"speed < 1000 && missing > 0", false},
+		{`speed > 1000 ? "fast" : "slow"`, "fast"},
+		{`speed > 10000 ? "fast" : speed > 1000 ? "medium" : "slow"`, "medium"},
+		{"if(temp_c > 50, 1, missing)", 1.0},
+		{"word", "hello"},
+	}
+	for _, c := range cases {
+		e, err := expr.Compile(c.src)
+		if err != nil {
+			t.Errorf("Compile(%s) failed: %v", c.src, err)
+			continue
+		}
+		got, err := e.Eval(lookup)
+		if err != nil {
+			t.Errorf("Eval(%s) failed: %v", c.src, err)
+			continue
+		}
+		if f, ok := got.(float64); ok {
+			if w, ok := c.want.(float64); ok && math.Abs(f-w) < 1e-9 {
+				continue
+			}
+		}
+		if got != c.want {
+			t.Errorf("Eval(%s) = %v (%T), want %v", c.src, got, got, c.want)
+		}
+	}
+}
+
+func TestEvalOperandErrors(t *testing.T) {
+	for _, src := range []string{"missing * 2", "word + 1", "flag * 2", "metadata.in_octets", "!speed", "max(1, missing)"} {
+		e, err := expr.Compile(src)
+		if err != nil {
+			t.Errorf("Compile(%s) failed: %v", src, err)
+			continue
+		}
+		_, err = e.Eval(lookup)
+		if !errors.Is(err, expr.ErrOperand) {
+			t.Errorf("Eval(%s): expected ErrOperand, got %v", src, err)
+		}
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		"",
+		"1 +",
+		"(1 + 2",
+		"1 2",
+		"foo(1)",
+		"log10()",
+		"pow(1)",
+		`"a" * 2`,
+		`1 == "a"`,
+		"true + 1",
+		"1 && true",
+		`true ? 1 : "a"`,
+		"1 ? 2 : 3",
+		"-\"a\"",
+		"!1",
+		`"unterminated`,
+		"`unterminated",
+		"a # b",
+		"if(1, 2)",
+	} {
+		if _, err := expr.Compile(src); err == nil {
+			t.Errorf("Compile(%s) succeeded, expected error", src)
+		}
+	}
+}
+
+func BenchmarkEval(b *testing.B) {
+	e, err := expr.Compile("(in_octets*8)/speed*100")
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < b.N; i++ {
+		e.Eval(lookup)
+	}
+}
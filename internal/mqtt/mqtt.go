@@ -31,41 +31,84 @@ the MQTT sender and receiver instead.
 package mqtt
 
 import (
+	"context"
+	"crypto/tls"
 	"fmt"
 	"math/rand"
+	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"github.com/eclipse/paho.golang/autopaho"
+	"github.com/eclipse/paho.golang/paho"
 	mqtt "github.com/eclipse/paho.mqtt.golang"
 	"github.com/telenornms/skogul"
 )
 
 var mqttLog = skogul.Logger("sender", "mqtt")
 
-// MQTT contains an MQTT client, its options and its configuration for handling messages
+// DefaultConnectTimeout is used if ConnectTimeout isn't set.
+const DefaultConnectTimeout = 10 * time.Second
+
+/*
+MQTT contains an MQTT client, its options and its configuration for
+handling messages. Both MQTT 3.1.1 and MQTT 5 are supported, using
+different client libraries. Set the exported options before calling
+Init.
+*/
 type MQTT struct {
-	Client        mqtt.Client
-	RenewClientID bool
-	MQTTLogs      bool
-	opts          *mqtt.ClientOptions
-	topics        map[string]*MessageHandler
+	Client         mqtt.Client // The MQTT 3.1.1 client, nil for MQTT 5
+	RenewClientID  bool
+	MQTTLogs       bool
+	Version        int           // 3 (default) for MQTT 3.1.1, or 5
+	QoS            byte          // QoS for subscriptions and publishing
+	TLS            *tls.Config   // TLS configuration, nil to use the defaults
+	ConnectTimeout time.Duration // How long to wait for a connection
+	opts           *mqtt.ClientOptions
+	v5             *autopaho.ConnectionManager
+	v5cfg          autopaho.ClientConfig
+	topics         map[string]*MessageHandler
+	lock           sync.Mutex
+}
+
+// Message is a received message, independent of MQTT version.
+type Message struct {
+	Topic         string
+	Payload       []byte
+	ResponseTopic string // Only available with MQTT 5
 }
 
 // MessageHandler is used to establish a callback when a message is
 // received.
-type MessageHandler func(Message mqtt.Message)
+type MessageHandler func(Message Message)
 
 // Subscribe to a topic. callback is called whenever a message is received.
 // This also deals with re-subscribing when a reconnect takes place.
 func (handler *MQTT) Subscribe(topic string, callback MessageHandler) {
 	mqttLog.WithField("topic", topic).Debug("MQTT subscribed")
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
 	if handler.topics == nil {
 		handler.topics = make(map[string]*MessageHandler)
 	}
 	handler.topics[topic] = &callback
 }
 
+func (handler *MQTT) timeout() time.Duration {
+	if handler.ConnectTimeout > 0 {
+		return handler.ConnectTimeout
+	}
+	return DefaultConnectTimeout
+}
+
 // Connect to the broker and subscribe to the relevant topics, if any.
+// Gives up after ConnectTimeout. With MQTT 5, the client keeps trying to
+// connect in the background after that.
 func (handler *MQTT) Connect() error {
+	if handler.Version == 5 {
+		return handler.connectV5()
+	}
 	if handler.Client.IsConnected() || handler.Client.IsConnectionOpen() {
 		mqttLog.Trace("Disconnecting client before (re)connecting")
 		handler.Client.Disconnect(100)
@@ -79,21 +122,130 @@ func (handler *MQTT) Connect() error {
 
 	mqttLog.Debugf("Connecting to MQTT broker as '%s'", handler.opts.ClientID)
 	token := handler.Client.Connect()
-	// Should probably be configurable, or at least not infinite.
-	for !token.WaitTimeout(3 * time.Second) {
+	if !token.WaitTimeout(handler.timeout()) {
+		err := fmt.Errorf("timed out after %v", handler.timeout())
+		mqttLog.WithError(err).Error("Failed to connect to MQTT broker")
+		return err
 	}
 	if err := token.Error(); err != nil {
 		mqttLog.WithError(err).Error("Failed to connect to MQTT broker")
 		return err
 	}
+	handler.lock.Lock()
+	defer handler.lock.Unlock()
 	for i, messageHandler := range handler.topics {
-		handler.Client.Subscribe(i, 0, func(_ mqtt.Client, msg mqtt.Message) { (*messageHandler)(msg) })
+		messageHandler := messageHandler
+		handler.Client.Subscribe(i, handler.QoS, func(_ mqtt.Client, msg mqtt.Message) {
+			(*messageHandler)(Message{Topic: msg.Topic(), Payload: msg.Payload()})
+		})
 	}
 	return nil
 }
 
+// connectV5 sets up the MQTT 5 connection manager, which deals with
+// reconnecting and re-subscribing on its own.
+func (handler *MQTT) connectV5() error {
+	if handler.v5 == nil {
+		handler.v5cfg.OnConnectionUp = handler.subscribeV5
+		handler.v5cfg.OnConnectError = func(err error) {
+			mqttLog.WithError(err).Warn("Failed to connect to MQTT broker")
+		}
+		handler.v5cfg.OnPublishReceived = []func(paho.PublishReceived) (bool, error){handler.receiveV5}
+		cm, err := autopaho.NewConnection(context.Background(), handler.v5cfg)
+		if err != nil {
+			return err
+		}
+		handler.v5 = cm
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handler.timeout())
+	defer cancel()
+	if err := handler.v5.AwaitConnection(ctx); err != nil {
+		mqttLog.WithError(err).Error("Failed to connect to MQTT broker")
+		return err
+	}
+	return nil
+}
+
+func (handler *MQTT) subscribeV5(cm *autopaho.ConnectionManager, _ *paho.Connack) {
+	handler.lock.Lock()
+	sub := paho.Subscribe{}
+	for topic := range handler.topics {
+		sub.Subscriptions = append(sub.Subscriptions, paho.SubscribeOptions{Topic: topic, QoS: handler.QoS})
+	}
+	handler.lock.Unlock()
+	if len(sub.Subscriptions) == 0 {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), handler.timeout())
+	defer cancel()
+	if _, err := cm.Subscribe(ctx, &sub); err != nil {
+		mqttLog.WithError(err).Error("Failed to subscribe")
+	}
+}
+
+// receiveV5 dispatches a message to the callbacks of all matching
+// subscriptions.
+func (handler *MQTT) receiveV5(pr paho.PublishReceived) (bool, error) {
+	msg := Message{Topic: pr.Packet.Topic, Payload: pr.Packet.Payload}
+	if pr.Packet.Properties != nil {
+		msg.ResponseTopic = pr.Packet.Properties.ResponseTopic
+	}
+	handler.lock.Lock()
+	var callbacks []*MessageHandler
+	for filter, cb := range handler.topics {
+		if Match(filter, msg.Topic) {
+			callbacks = append(callbacks, cb)
+		}
+	}
+	handler.lock.Unlock()
+	for _, cb := range callbacks {
+		(*cb)(msg)
+	}
+	return len(callbacks) > 0, nil
+}
+
+// Publish a message, waiting up to ConnectTimeout for it to be sent. With
+// MQTT 3.1.1, a lost connection is re-established first.
+func (handler *MQTT) Publish(topic string, retain bool, payload []byte) error {
+	if handler.Version == 5 {
+		ctx, cancel := context.WithTimeout(context.Background(), handler.timeout())
+		defer cancel()
+		_, err := handler.v5.Publish(ctx, &paho.Publish{
+			Topic:   topic,
+			QoS:     handler.QoS,
+			Retain:  retain,
+			Payload: payload,
+		})
+		return err
+	}
+	if !handler.Client.IsConnectionOpen() {
+		if err := handler.Connect(); err != nil {
+			return err
+		}
+	}
+	token := handler.Client.Publish(topic, handler.QoS, retain, payload)
+	if !token.WaitTimeout(handler.timeout()) {
+		return fmt.Errorf("timed out publishing to %s", topic)
+	}
+	return token.Error()
+}
+
 // Init sets up the MQTT client
 func (handler *MQTT) Init(address, username, password, clientID string) error {
+	if handler.QoS > 2 {
+		return fmt.Errorf("invalid QoS %d, must be 0, 1 or 2", handler.QoS)
+	}
+	if clientID == "" {
+		clientID = fmt.Sprintf("skogul-%d-%d", rand.Uint32(), rand.Uint32())
+	}
+	switch handler.Version {
+	case 0, 3:
+		handler.Version = 3
+	case 5:
+		return handler.initV5(address, username, password, clientID)
+	default:
+		return fmt.Errorf("unsupported MQTT version %d, must be 3 or 5", handler.Version)
+	}
 	if handler.MQTTLogs {
 		mqtt.ERROR = mqttLog
 		mqtt.CRITICAL = mqttLog
@@ -106,6 +258,34 @@ func (handler *MQTT) Init(address, username, password, clientID string) error {
 	return nil
 }
 
+func (handler *MQTT) initV5(address, username, password, clientID string) error {
+	if !strings.Contains(address, "://") {
+		address = "mqtt://" + address
+	}
+	u, err := url.Parse(address)
+	if err != nil {
+		return fmt.Errorf("invalid broker address %s: %w", address, err)
+	}
+	handler.v5cfg = autopaho.ClientConfig{
+		ServerUrls:                    []*url.URL{u},
+		TlsCfg:                        handler.TLS,
+		KeepAlive:                     30,
+		CleanStartOnInitialConnection: true,
+		ConnectTimeout:                handler.timeout(),
+		ConnectRetryDelay:             5 * time.Second,
+		ClientConfig: paho.ClientConfig{
+			ClientID: clientID,
+		},
+	}
+	if username != "" {
+		handler.v5cfg.ConnectUsername = username
+	}
+	if password != "" {
+		handler.v5cfg.ConnectPassword = []byte(password)
+	}
+	return nil
+}
+
 // connLostHandler handles reconnects if the connection drops.
 func (handler *MQTT) connLostHandler(client mqtt.Client, e error) {
 	mqttLog.WithError(e).Debug("Connection lost... Auto-reconnecting and re-subscribing.")
@@ -131,12 +311,108 @@ func (handler *MQTT) createClientOptions(address, username, password, clientID s
 	if password != "" {
 		handler.opts.SetPassword(password)
 	}
-	if clientID == "" {
-		clientID = fmt.Sprintf("skogul-%d-%d", rand.Uint32(), rand.Uint32())
+	if handler.TLS != nil {
+		handler.opts.SetTLSConfig(handler.TLS)
 	}
 	handler.opts.SetClientID(clientID)
 	handler.opts.SetAutoReconnect(false)
+	handler.opts.SetConnectTimeout(handler.timeout())
 	handler.opts.SetPingTimeout(time.Duration(40 * time.Second))
 	handler.opts.SetConnectionLostHandler(handler.connLostHandler)
 	return nil
 }
+
+// TLSConfig builds the TLS configuration from file names. Returns nil if
+// none of the options are set, so the defaults are used.
+func TLSConfig(ca, cert, key string, insecure bool) (*tls.Config, error) {
+	if ca == "" && cert == "" && !insecure {
+		return nil, nil
+	}
+	conf := tls.Config{InsecureSkipVerify: insecure}
+	if ca != "" {
+		pool, err := skogul.GetCertPool(ca)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load CA %s: %w", ca, err)
+		}
+		conf.RootCAs = pool
+	}
+	if cert != "" {
+		c, err := tls.LoadX509KeyPair(cert, key)
+		if err != nil {
+			return nil, fmt.Errorf("unable to load client certificate: %w", err)
+		}
+		conf.Certificates = []tls.Certificate{c}
+	}
+	return &conf, nil
+}
+
+// Match checks if topic matches the subscription filter, which may
+// contain the + and # wildcards.
+func Match(filter, topic string) bool {
+	_, ok := NewPattern(filter).Match(topic)
+	return ok
+}
+
+/*
+Pattern is a topic filter where wildcards may be named, for extracting
+parts of the topic. A named single-level wildcard is written as +name,
+and a named multi-level wildcard as #name. E.g.:
+sites/+site/devices/+device/# matches sites/oslo/devices/r1/cpu with
+site=oslo and device=r1.
+*/
+type Pattern struct {
+	segments []string
+	names    []string
+}
+
+// NewPattern parses a pattern
+func NewPattern(p string) Pattern {
+	segs := strings.Split(p, "/")
+	ret := Pattern{segments: make([]string, len(segs)), names: make([]string, len(segs))}
+	for i, seg := range segs {
+		if len(seg) > 0 && (seg[0] == '+' || seg[0] == '#') {
+			ret.segments[i] = seg[:1]
+			ret.names[i] = seg[1:]
+			continue
+		}
+		ret.segments[i] = seg
+	}
+	return ret
+}
+
+// Filter returns the pattern as a plain topic filter, without names, for
+// subscribing.
+func (p Pattern) Filter() string {
+	return strings.Join(p.segments, "/")
+}
+
+// Match checks if topic matches the pattern, and returns the values of
+// the named wildcards if it does.
+func (p Pattern) Match(topic string) (map[string]string, bool) {
+	segs := strings.Split(topic, "/")
+	vals := make(map[string]string)
+	for i, seg := range p.segments {
+		if seg == "#" {
+			if p.names[i] != "" {
+				vals[p.names[i]] = strings.Join(segs[i:], "/")
+			}
+			return vals, true
+		}
+		if i >= len(segs) {
+			return nil, false
+		}
+		if seg == "+" {
+			if p.names[i] != "" {
+				vals[p.names[i]] = segs[i]
+			}
+			continue
+		}
+		if seg != segs[i] {
+			return nil, false
+		}
+	}
+	if len(segs) != len(p.segments) {
+		return nil, false
+	}
+	return vals, true
+}
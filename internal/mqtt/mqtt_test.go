@@ -0,0 +1,101 @@
+/*
+ * skogul, test mqtt topic handling
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package mqtt_test
+
+import (
+	"testing"
+
+	"github.com/telenornms/skogul/internal/mqtt"
+)
+
+func TestPattern(t *testing.T) {
+	p := mqtt.NewPattern("sites/+site/devices/+device/#")
+	if got := p.Filter(); got != "sites/+/devices/+/#" {
+		t.Errorf("Filter() = %s", got)
+	}
+	fields, ok := p.Match("sites/oslo/devices/r1/cpu/load")
+	if !ok {
+		t.Fatalf("pattern did not match")
+	}
+	if fields["site"] != "oslo" || fields["device"] != "r1" || len(fields) != 2 {
+		t.Errorf("unexpected fields %v", fields)
+	}
+	if _, ok := p.Match("sites/oslo/routers/r1/cpu"); ok {
+		t.Errorf("pattern matched wrong topic")
+	}
+	if _, ok := p.Match("sites/oslo"); ok {
+		t.Errorf("pattern matched short topic")
+	}
+
+	p = mqtt.NewPattern("a/+x/#rest")
+	fields, ok = p.Match("a/b/c/d")
+	if !ok || fields["x"] != "b" || fields["rest"] != "c/d" {
+		t.Errorf("unexpected match %v %v", ok, fields)
+	}
+}
+
+func TestMatch(t *testing.T) {
+	cases := []struct {
+		filter, topic string
+		match         bool
+	}{
+		{"a/b", "a/b", true},
+		{"a/b", "a/b/c", false},
+		{"a/+", "a/b", true},
+		{"a/+", "a/b/c", false},
+		{"a/#", "a/b/c", true},
+		{"#", "a", true},
+		{"+/b", "a/c", false},
+	}
+	for _, c := range cases {
+		if got := mqtt.Match(c.filter, c.topic); got != c.match {
+			t.Errorf("Match(%s, %s) = %v, want %v", c.filter, c.topic, got, c.match)
+		}
+	}
+}
+
+func TestTLSConfig(t *testing.T) {
+	conf, err := mqtt.TLSConfig("", "", "", false)
+	if conf != nil || err != nil {
+		t.Errorf("TLSConfig() without options = %v, %v", conf, err)
+	}
+	conf, err = mqtt.TLSConfig("", "", "", true)
+	if err != nil || conf == nil || !conf.InsecureSkipVerify {
+		t.Errorf("TLSConfig() with insecure = %v, %v", conf, err)
+	}
+	if _, err := mqtt.TLSConfig("/nonexistent", "", "", false); err == nil {
+		t.Errorf("TLSConfig() with missing CA did not fail")
+	}
+}
+
+func TestInit(t *testing.T) {
+	m := mqtt.MQTT{Version: 4}
+	if err := m.Init("localhost:1883", "", "", ""); err == nil {
+		t.Errorf("Init() with version 4 did not fail")
+	}
+	m = mqtt.MQTT{Version: 5}
+	if err := m.Init("localhost:1883", "", "", ""); err != nil {
+		t.Errorf("Init() with version 5 failed: %v", err)
+	}
+}
@@ -0,0 +1,638 @@
+/*
+ * skogul, dynamic protobuf common functions
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+/*
+Package protodyn provides the glue shared by the protobuf_dynamic parser
+and encoder: loading message descriptors at run time, converting between
+dynamic messages and the map[string]interface{} structures of
+skogul.Metric, and length-delimited framing. Use the parser and encoder
+instead of including this directly.
+*/
+package protodyn
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// timestampName is the full name of google.protobuf.Timestamp, which is
+// converted to and from time.Time.
+const timestampName = "google.protobuf.Timestamp"
+
+/*
+Load finds the message descriptor named message, either from a compiled
+FileDescriptorSet (as written by protoc --descriptor_set_out) or by
+compiling a set of .proto files. Exactly one of descriptorSet and protos
+should be set. The well-known types (google/protobuf/*.proto) are always
+available, even if the descriptor set was built without
+--include_imports.
+*/
+func Load(descriptorSet string, protos []string, importPaths []string, message string) (protoreflect.MessageDescriptor, error) {
+	var resolver interface {
+		FindDescriptorByName(protoreflect.FullName) (protoreflect.Descriptor, error)
+	}
+	switch {
+	case descriptorSet != "" && len(protos) > 0:
+		return nil, fmt.Errorf("both a descriptor set and .proto files are specified, use only one")
+	case descriptorSet != "":
+		b, err := os.ReadFile(descriptorSet)
+		if err != nil {
+			return nil, fmt.Errorf("unable to read descriptor set: %w", err)
+		}
+		set := descriptorpb.FileDescriptorSet{}
+		if err := proto.Unmarshal(b, &set); err != nil {
+			return nil, fmt.Errorf("unable to parse descriptor set %s: %w", descriptorSet, err)
+		}
+		addWellKnown(&set)
+		files, err := protodesc.NewFiles(&set)
+		if err != nil {
+			return nil, fmt.Errorf("invalid descriptor set %s: %w", descriptorSet, err)
+		}
+		resolver = files
+	case len(protos) > 0:
+		compiler := protocompile.Compiler{
+			Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: importPaths}),
+		}
+		files, err := compiler.Compile(context.Background(), protos...)
+		if err != nil {
+			return nil, fmt.Errorf("unable to compile .proto files: %w", err)
+		}
+		resolver = files.AsResolver()
+	default:
+		return nil, fmt.Errorf("neither a descriptor set nor .proto files are specified")
+	}
+	if message == "" {
+		return nil, fmt.Errorf("no message type specified")
+	}
+	d, err := resolver.FindDescriptorByName(protoreflect.FullName(message))
+	if err != nil {
+		return nil, fmt.Errorf("unable to find message type %s: %w", message, err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a message type", message)
+	}
+	return md, nil
+}
+
+// addWellKnown adds the files from the global registry, i.e. the
+// well-known types, that the set depends on but doesn't include.
+func addWellKnown(set *descriptorpb.FileDescriptorSet) {
+	have := make(map[string]bool)
+	for _, f := range set.File {
+		have[f.GetName()] = true
+	}
+	for i := 0; i < len(set.File); i++ {
+		for _, dep := range set.File[i].Dependency {
+			if have[dep] {
+				continue
+			}
+			fd, err := protoregistry.GlobalFiles.FindFileByPath(dep)
+			if err != nil {
+				continue
+			}
+			have[dep] = true
+			set.File = append(set.File, protodesc.ToFileDescriptorProto(fd))
+		}
+	}
+}
+
+// New returns a new, empty message of the given type.
+func New(md protoreflect.MessageDescriptor) protoreflect.Message {
+	return dynamicpb.NewMessage(md)
+}
+
+// Path splits a dotted field path
+func Path(p string) []string {
+	if p == "" {
+		return nil
+	}
+	return strings.Split(p, ".")
+}
+
+// field looks up a field by its protobuf name or its JSON name.
+func field(md protoreflect.MessageDescriptor, name string) protoreflect.FieldDescriptor {
+	fields := md.Fields()
+	if fd := fields.ByName(protoreflect.Name(name)); fd != nil {
+		return fd
+	}
+	return fields.ByJSONName(name)
+}
+
+// Get returns the value at path and the field it belongs to. Returns
+// false if the path doesn't exist or isn't populated.
+func Get(m protoreflect.Message, path []string) (protoreflect.Value, protoreflect.FieldDescriptor, bool) {
+	for i, name := range path {
+		fd := field(m.Descriptor(), name)
+		if fd == nil || !m.Has(fd) {
+			return protoreflect.Value{}, nil, false
+		}
+		if i == len(path)-1 {
+			return m.Get(fd), fd, true
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return protoreflect.Value{}, nil, false
+		}
+		m = m.Get(fd).Message()
+	}
+	return protoreflect.Value{}, nil, false
+}
+
+// Set stores v at path, creating intermediate messages as needed.
+func Set(m protoreflect.Message, path []string, v interface{}) error {
+	for i, name := range path {
+		fd := field(m.Descriptor(), name)
+		if fd == nil {
+			return fmt.Errorf("no field %s in %s", name, m.Descriptor().FullName())
+		}
+		if i == len(path)-1 {
+			return setField(m, fd, v)
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return fmt.Errorf("field %s of %s is not a message", name, m.Descriptor().FullName())
+		}
+		m = m.Mutable(fd).Message()
+	}
+	return fmt.Errorf("empty path")
+}
+
+// Mutable returns the list at path, creating intermediate messages as
+// needed, for building messages with a repeated message field.
+func Mutable(m protoreflect.Message, path []string) (protoreflect.List, protoreflect.FieldDescriptor, error) {
+	for i, name := range path {
+		fd := field(m.Descriptor(), name)
+		if fd == nil {
+			return nil, nil, fmt.Errorf("no field %s in %s", name, m.Descriptor().FullName())
+		}
+		if i == len(path)-1 {
+			if !fd.IsList() || fd.Message() == nil {
+				return nil, nil, fmt.Errorf("field %s of %s is not a repeated message", name, m.Descriptor().FullName())
+			}
+			return m.Mutable(fd).List(), fd, nil
+		}
+		if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+			return nil, nil, fmt.Errorf("field %s of %s is not a message", name, m.Descriptor().FullName())
+		}
+		m = m.Mutable(fd).Message()
+	}
+	return nil, nil, fmt.Errorf("empty path")
+}
+
+/*
+ToMap converts a message to a map, using the protobuf field names as keys.
+Only populated fields are included. Nested messages become nested maps,
+repeated fields become []interface{}, enums become the name of the value
+and google.protobuf.Timestamp becomes time.Time. Integers keep their
+type as int64 or uint64. NaN and infinite values are dropped, since they
+can't be represented in most output formats.
+*/
+func ToMap(m protoreflect.Message) map[string]interface{} {
+	ret := make(map[string]interface{})
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		if val, ok := Value(fd, v); ok {
+			ret[string(fd.Name())] = val
+		}
+		return true
+	})
+	return ret
+}
+
+// Value converts the value v of the field fd, see ToMap. Returns false if
+// the value should be omitted.
+func Value(fd protoreflect.FieldDescriptor, v protoreflect.Value) (interface{}, bool) {
+	switch {
+	case fd.IsList():
+		l := v.List()
+		ret := make([]interface{}, 0, l.Len())
+		for i := 0; i < l.Len(); i++ {
+			if val, ok := single(fd, l.Get(i)); ok {
+				ret = append(ret, val)
+			}
+		}
+		return ret, true
+	case fd.IsMap():
+		ret := make(map[string]interface{})
+		v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			if val, ok := single(fd.MapValue(), mv); ok {
+				ret[k.String()] = val
+			}
+			return true
+		})
+		return ret, true
+	}
+	return single(fd, v)
+}
+
+// single converts a non-repeated value
+func single(fd protoreflect.FieldDescriptor, v protoreflect.Value) (interface{}, bool) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		if fd.Message().FullName() == timestampName {
+			return timestamp(v.Message()), true
+		}
+		return ToMap(v.Message()), true
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByNumber(v.Enum()); ev != nil {
+			return string(ev.Name()), true
+		}
+		return int64(v.Enum()), true
+	case protoreflect.BoolKind:
+		return v.Bool(), true
+	case protoreflect.StringKind:
+		return v.String(), true
+	case protoreflect.BytesKind:
+		return v.Bytes(), true
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return v.Int(), true
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return v.Uint(), true
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			return nil, false
+		}
+		if fd.Kind() == protoreflect.FloatKind {
+			f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', -1, 32), 64)
+		}
+		return f, true
+	}
+	return nil, false
+}
+
+// timestamp converts a google.protobuf.Timestamp to time.Time
+func timestamp(m protoreflect.Message) time.Time {
+	fields := m.Descriptor().Fields()
+	secs := m.Get(fields.ByName("seconds")).Int()
+	nanos := m.Get(fields.ByName("nanos")).Int()
+	return time.Unix(secs, nanos)
+}
+
+/*
+Time converts the value of fd to a time. Timestamp messages are used
+as-is, numbers are interpreted using unit, which is one of "s", "ms",
+"us" or "ns", and strings are parsed as RFC3339.
+*/
+func Time(fd protoreflect.FieldDescriptor, v protoreflect.Value, unit string) (time.Time, error) {
+	if fd.IsList() || fd.IsMap() {
+		return time.Time{}, fmt.Errorf("timestamp field %s is repeated", fd.Name())
+	}
+	switch fd.Kind() {
+	case protoreflect.MessageKind:
+		if fd.Message().FullName() == timestampName {
+			return timestamp(v.Message()), nil
+		}
+	case protoreflect.StringKind:
+		return time.Parse(time.RFC3339Nano, v.String())
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		return fromUnit(v.Float(), unit)
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		return fromUnit(float64(v.Int()), unit)
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind, protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		return fromUnit(float64(v.Uint()), unit)
+	}
+	return time.Time{}, fmt.Errorf("timestamp field %s has unsupported type %s", fd.Name(), fd.Kind())
+}
+
+// unitScale returns the number of nanoseconds per unit
+func unitScale(unit string) (float64, error) {
+	switch unit {
+	case "", "s":
+		return 1e9, nil
+	case "ms":
+		return 1e6, nil
+	case "us":
+		return 1e3, nil
+	case "ns":
+		return 1, nil
+	}
+	return 0, fmt.Errorf("invalid timestamp unit %s, must be s, ms, us or ns", unit)
+}
+
+// VerifyUnit checks that unit is a valid timestamp unit
+func VerifyUnit(unit string) error {
+	_, err := unitScale(unit)
+	return err
+}
+
+func fromUnit(f float64, unit string) (time.Time, error) {
+	scale, err := unitScale(unit)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return time.Unix(0, int64(f*scale)), nil
+}
+
+// SetTime stores t at path, using unit for numeric fields, the reverse of
+// Time.
+func SetTime(m protoreflect.Message, path []string, t time.Time, unit string) error {
+	for i, name := range path {
+		fd := field(m.Descriptor(), name)
+		if fd == nil {
+			return fmt.Errorf("no field %s in %s", name, m.Descriptor().FullName())
+		}
+		if i < len(path)-1 {
+			if fd.Message() == nil || fd.IsList() || fd.IsMap() {
+				return fmt.Errorf("field %s of %s is not a message", name, m.Descriptor().FullName())
+			}
+			m = m.Mutable(fd).Message()
+			continue
+		}
+		switch fd.Kind() {
+		case protoreflect.MessageKind, protoreflect.StringKind:
+			return setField(m, fd, t)
+		}
+		scale, err := unitScale(unit)
+		if err != nil {
+			return err
+		}
+		if fd.Kind() == protoreflect.FloatKind || fd.Kind() == protoreflect.DoubleKind {
+			return setField(m, fd, float64(t.UnixNano())/scale)
+		}
+		return setField(m, fd, t.UnixNano()/int64(scale))
+	}
+	return fmt.Errorf("empty path")
+}
+
+/*
+FromMap sets the fields of m from the map, the reverse of ToMap. Keys
+are matched against both protobuf and JSON field names, and keys that
+don't match a field are ignored.
+*/
+func FromMap(m protoreflect.Message, data map[string]interface{}) error {
+	md := m.Descriptor()
+	for k, v := range data {
+		fd := field(md, k)
+		if fd == nil || v == nil {
+			continue
+		}
+		if err := setField(m, fd, v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setField sets a single field, including repeated and map fields
+func setField(m protoreflect.Message, fd protoreflect.FieldDescriptor, v interface{}) error {
+	switch {
+	case fd.IsList():
+		vals, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("field %s is repeated, got %T", fd.Name(), v)
+		}
+		l := m.Mutable(fd).List()
+		for _, e := range vals {
+			if fd.Message() != nil {
+				elem := l.NewElement()
+				if err := setMessage(elem.Message(), e); err != nil {
+					return fmt.Errorf("field %s: %w", fd.Name(), err)
+				}
+				l.Append(elem)
+				continue
+			}
+			pv, err := scalar(fd, e)
+			if err != nil {
+				return err
+			}
+			l.Append(pv)
+		}
+		return nil
+	case fd.IsMap():
+		vals, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("field %s is a map, got %T", fd.Name(), v)
+		}
+		mp := m.Mutable(fd).Map()
+		for k, e := range vals {
+			key, err := scalar(fd.MapKey(), k)
+			if err != nil {
+				return err
+			}
+			if fd.MapValue().Message() != nil {
+				elem := mp.NewValue()
+				if err := setMessage(elem.Message(), e); err != nil {
+					return fmt.Errorf("field %s: %w", fd.Name(), err)
+				}
+				mp.Set(key.MapKey(), elem)
+				continue
+			}
+			pv, err := scalar(fd.MapValue(), e)
+			if err != nil {
+				return err
+			}
+			mp.Set(key.MapKey(), pv)
+		}
+		return nil
+	case fd.Message() != nil:
+		return setMessage(m.Mutable(fd).Message(), v)
+	}
+	pv, err := scalar(fd, v)
+	if err != nil {
+		return err
+	}
+	m.Set(fd, pv)
+	return nil
+}
+
+// setMessage fills in a message from a map, or from a time if it is a
+// google.protobuf.Timestamp.
+func setMessage(m protoreflect.Message, v interface{}) error {
+	if m.Descriptor().FullName() == timestampName {
+		var t time.Time
+		switch v := v.(type) {
+		case time.Time:
+			t = v
+		case *time.Time:
+			t = *v
+		case string:
+			var err error
+			if t, err = time.Parse(time.RFC3339Nano, v); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("can't use %T as a timestamp", v)
+		}
+		fields := m.Descriptor().Fields()
+		m.Set(fields.ByName("seconds"), protoreflect.ValueOfInt64(t.Unix()))
+		m.Set(fields.ByName("nanos"), protoreflect.ValueOfInt32(int32(t.Nanosecond())))
+		return nil
+	}
+	mv, ok := v.(map[string]interface{})
+	if !ok {
+		return fmt.Errorf("%s is a message, got %T", m.Descriptor().FullName(), v)
+	}
+	return FromMap(m, mv)
+}
+
+// scalar converts v to a protobuf value of the kind of fd
+func scalar(fd protoreflect.FieldDescriptor, v interface{}) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.BoolKind:
+		if b, ok := v.(bool); ok {
+			return protoreflect.ValueOfBool(b), nil
+		}
+	case protoreflect.StringKind:
+		switch s := v.(type) {
+		case string:
+			return protoreflect.ValueOfString(s), nil
+		case time.Time:
+			return protoreflect.ValueOfString(s.Format(time.RFC3339Nano)), nil
+		default:
+			return protoreflect.ValueOfString(fmt.Sprint(v)), nil
+		}
+	case protoreflect.BytesKind:
+		switch b := v.(type) {
+		case []byte:
+			return protoreflect.ValueOfBytes(b), nil
+		case string:
+			dec, err := base64.StdEncoding.DecodeString(b)
+			if err != nil {
+				return protoreflect.Value{}, fmt.Errorf("field %s: %w", fd.Name(), err)
+			}
+			return protoreflect.ValueOfBytes(dec), nil
+		}
+	case protoreflect.EnumKind:
+		if s, ok := v.(string); ok {
+			ev := fd.Enum().Values().ByName(protoreflect.Name(s))
+			if ev == nil {
+				return protoreflect.Value{}, fmt.Errorf("field %s: no enum value %s", fd.Name(), s)
+			}
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		if f, ok := number(v); ok {
+			return protoreflect.ValueOfEnum(protoreflect.EnumNumber(f)), nil
+		}
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		if f, ok := number(v); ok {
+			return protoreflect.ValueOfInt32(int32(f)), nil
+		}
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if i, ok := v.(int64); ok {
+			return protoreflect.ValueOfInt64(i), nil
+		}
+		if f, ok := number(v); ok {
+			return protoreflect.ValueOfInt64(int64(f)), nil
+		}
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		if f, ok := number(v); ok {
+			return protoreflect.ValueOfUint32(uint32(f)), nil
+		}
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if u, ok := v.(uint64); ok {
+			return protoreflect.ValueOfUint64(u), nil
+		}
+		if f, ok := number(v); ok {
+			return protoreflect.ValueOfUint64(uint64(f)), nil
+		}
+	case protoreflect.FloatKind:
+		if f, ok := number(v); ok {
+			return protoreflect.ValueOfFloat32(float32(f)), nil
+		}
+	case protoreflect.DoubleKind:
+		if f, ok := number(v); ok {
+			return protoreflect.ValueOfFloat64(f), nil
+		}
+	}
+	return protoreflect.Value{}, fmt.Errorf("field %s: can't use %T as %s", fd.Name(), v, fd.Kind())
+}
+
+// number converts any numeric type, or a numeric string, to float64
+func number(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int8:
+		return float64(n), true
+	case int16:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint8:
+		return float64(n), true
+	case uint16:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	case json.Number:
+		f, err := n.Float64()
+		return f, err == nil
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// SplitDelimited splits a stream of messages, each prefixed with its
+// length as a varint.
+func SplitDelimited(b []byte) ([][]byte, error) {
+	var ret [][]byte
+	for len(b) > 0 {
+		l, n := protowire.ConsumeVarint(b)
+		if n < 0 {
+			return nil, fmt.Errorf("invalid length prefix: %w", protowire.ParseError(n))
+		}
+		b = b[n:]
+		if uint64(len(b)) < l {
+			return nil, fmt.Errorf("message length %d exceeds the %d remaining bytes", l, len(b))
+		}
+		ret = append(ret, b[:l])
+		b = b[l:]
+	}
+	return ret, nil
+}
+
+// AppendDelimited appends msg to dst, prefixed with its length as a
+// varint.
+func AppendDelimited(dst []byte, msg []byte) []byte {
+	dst = protowire.AppendVarint(dst, uint64(len(msg)))
+	return append(dst, msg...)
+}
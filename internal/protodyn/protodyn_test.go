@@ -0,0 +1,179 @@
+/*
+ * skogul, protodyn tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package protodyn_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/telenornms/skogul/internal/protodyn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const protoDir = "../../docs/examples/protobuf_dynamic"
+
+func loadBatch(t *testing.T) protoreflect.MessageDescriptor {
+	t.Helper()
+	md, err := protodyn.Load("", []string{"measurement.proto"}, []string{protoDir}, "example.telemetry.Batch")
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	return md
+}
+
+func TestLoad(t *testing.T) {
+	md := loadBatch(t)
+	if md.FullName() != "example.telemetry.Batch" {
+		t.Errorf("Expected example.telemetry.Batch, got %s", md.FullName())
+	}
+
+	// A descriptor set built without --include_imports, so the
+	// well-known Timestamp type has to come from the global registry.
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: []string{protoDir}}),
+	}
+	files, err := compiler.Compile(context.Background(), "measurement.proto")
+	if err != nil {
+		t.Fatalf("unable to compile measurement.proto: %v", err)
+	}
+	set := descriptorpb.FileDescriptorSet{File: []*descriptorpb.FileDescriptorProto{protodesc.ToFileDescriptorProto(files[0])}}
+	b, err := proto.Marshal(&set)
+	if err != nil {
+		t.Fatalf("unable to marshal descriptor set: %v", err)
+	}
+	dir := t.TempDir()
+	setFile := filepath.Join(dir, "measurement.pb")
+	if err := os.WriteFile(setFile, b, 0644); err != nil {
+		t.Fatalf("unable to write descriptor set: %v", err)
+	}
+	md, err = protodyn.Load(setFile, nil, nil, "example.telemetry.Point")
+	if err != nil {
+		t.Fatalf("Load() from descriptor set failed: %v", err)
+	}
+	if fd := md.Fields().ByName("time"); fd == nil || fd.Message().FullName() != "google.protobuf.Timestamp" {
+		t.Errorf("Expected time to be a google.protobuf.Timestamp, got %v", fd)
+	}
+
+	bad := []struct {
+		set     string
+		protos  []string
+		message string
+	}{
+		{setFile, []string{"measurement.proto"}, "example.telemetry.Point"},
+		{"", nil, "example.telemetry.Point"},
+		{setFile, nil, ""},
+		{setFile, nil, "example.telemetry.Missing"},
+		{setFile, nil, "example.telemetry.Status"},
+		{filepath.Join(dir, "missing.pb"), nil, "example.telemetry.Point"},
+		{"", []string{"missing.proto"}, "example.telemetry.Point"},
+	}
+	for i, c := range bad {
+		if _, err := protodyn.Load(c.set, c.protos, []string{protoDir}, c.message); err == nil {
+			t.Errorf("Expected Load() to fail for case %d", i)
+		}
+	}
+}
+
+func TestPath(t *testing.T) {
+	if p := protodyn.Path(""); p != nil {
+		t.Errorf("Expected nil path, got %v", p)
+	}
+	if p := protodyn.Path("header.hostname"); !reflect.DeepEqual(p, []string{"header", "hostname"}) {
+		t.Errorf("Expected [header hostname], got %v", p)
+	}
+}
+
+func TestGetSet(t *testing.T) {
+	m := protodyn.New(loadBatch(t))
+	if _, _, ok := protodyn.Get(m, protodyn.Path("header.hostname")); ok {
+		t.Errorf("Get() found a value in an empty message")
+	}
+	if err := protodyn.Set(m, protodyn.Path("header.hostname"), "router1"); err != nil {
+		t.Fatalf("Set() failed: %v", err)
+	}
+	v, fd, ok := protodyn.Get(m, protodyn.Path("header.hostname"))
+	if !ok || v.String() != "router1" || fd.Name() != "hostname" {
+		t.Errorf("Get() returned %v, %v, %v", v, fd, ok)
+	}
+	if _, _, ok := protodyn.Get(m, protodyn.Path("header.hostname.nope")); ok {
+		t.Errorf("Get() found a path through a string")
+	}
+	if _, _, ok := protodyn.Get(m, protodyn.Path("header.missing")); ok {
+		t.Errorf("Get() found an unknown field")
+	}
+	if err := protodyn.Set(m, protodyn.Path("header.missing"), "x"); err == nil {
+		t.Errorf("Set() of unknown field succeeded")
+	}
+	if err := protodyn.Set(m, protodyn.Path("header.hostname.nope"), "x"); err == nil {
+		t.Errorf("Set() through a string succeeded")
+	}
+
+	points, fd, err := protodyn.Mutable(m, protodyn.Path("points"))
+	if err != nil {
+		t.Fatalf("Mutable() failed: %v", err)
+	}
+	if fd.Name() != "points" {
+		t.Errorf("Expected field points, got %s", fd.Name())
+	}
+	p := points.NewElement()
+	// JSON names work as well as protobuf names
+	if err := protodyn.Set(p.Message(), protodyn.Path("ifName"), "xe-0/0/0"); err != nil {
+		t.Fatalf("Set() with JSON name failed: %v", err)
+	}
+	points.Append(p)
+	if _, _, err := protodyn.Mutable(m, protodyn.Path("header")); err == nil {
+		t.Errorf("Mutable() of a non-repeated field succeeded")
+	}
+	want := map[string]interface{}{
+		"header": map[string]interface{}{"hostname": "router1"},
+		"points": []interface{}{map[string]interface{}{"if_name": "xe-0/0/0"}},
+	}
+	if got := protodyn.ToMap(m); !reflect.DeepEqual(got, want) {
+		t.Errorf("ToMap() returned %v, expected %v", got, want)
+	}
+}
+
+func TestDelimited(t *testing.T) {
+	var b []byte
+	b = protodyn.AppendDelimited(b, []byte("foo"))
+	b = protodyn.AppendDelimited(b, []byte{})
+	b = protodyn.AppendDelimited(b, []byte("barbaz"))
+	msgs, err := protodyn.SplitDelimited(b)
+	if err != nil {
+		t.Fatalf("SplitDelimited() failed: %v", err)
+	}
+	if len(msgs) != 3 || string(msgs[0]) != "foo" || len(msgs[1]) != 0 || string(msgs[2]) != "barbaz" {
+		t.Errorf("Unexpected messages: %q", msgs)
+	}
+	if _, err := protodyn.SplitDelimited(b[:len(b)-1]); err == nil {
+		t.Errorf("SplitDelimited() of truncated data succeeded")
+	}
+}
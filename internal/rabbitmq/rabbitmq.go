@@ -0,0 +1,170 @@
+/*
+ * skogul, rabbitmq connection handling
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+/*
+Package rabbitmq provides the connection handling shared by Skogul's
+RabbitMQ sender and receiver: building the URL, TLS, and re-establishing
+the connection and channel after failures.
+*/
+package rabbitmq
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"sync"
+	"time"
+
+	amqp "github.com/rabbitmq/amqp091-go"
+	"github.com/telenornms/skogul"
+)
+
+// Conn is a lazily established connection and channel to a RabbitMQ
+// broker. Set the exported fields before calling Channel.
+type Conn struct {
+	Username string
+	Password string
+	Host     string // Default localhost
+	Port     string // Default 5672, or 5671 with TLS
+	Vhost    string // Default is the default vhost, "/"
+	TLS      bool   // Use amqps
+	CA       string // CA used to verify the broker, if not the system pool
+	Insecure bool   // Skip verification of the broker certificate
+
+	// Setup is called with each new channel, to set QoS and declare
+	// queues, exchanges and bindings.
+	Setup func(ch *amqp.Channel) error
+
+	conn    *amqp.Connection
+	ch      *amqp.Channel
+	lastErr error
+	lastTry time.Time
+	lock    sync.Mutex
+}
+
+// retryDelay is the minimum time between connection attempts, to avoid
+// hammering a broker that is down for every message sent.
+const retryDelay = time.Second
+
+// URL returns the AMQP URL of the broker, including credentials.
+func (c *Conn) URL() string {
+	u := url.URL{
+		Scheme: "amqp",
+		User:   url.UserPassword(c.Username, c.Password),
+		Host:   net.JoinHostPort(c.host(), c.port()),
+		Path:   "/",
+	}
+	if c.TLS {
+		u.Scheme = "amqps"
+	}
+	if c.Vhost != "" && c.Vhost != "/" {
+		u.Path = "/" + c.Vhost
+		u.RawPath = "/" + url.PathEscape(c.Vhost)
+	}
+	return u.String()
+}
+
+func (c *Conn) host() string {
+	if c.Host == "" {
+		return "localhost"
+	}
+	return c.Host
+}
+
+func (c *Conn) port() string {
+	if c.Port != "" {
+		return c.Port
+	}
+	if c.TLS {
+		return "5671"
+	}
+	return "5672"
+}
+
+// Channel returns the current channel, connecting and running Setup
+// first if there is no open channel. If the previous attempt failed less
+// than a second ago, its error is returned instead of trying again.
+func (c *Conn) Channel() (*amqp.Channel, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.ch != nil && !c.ch.IsClosed() {
+		return c.ch, nil
+	}
+	c.close()
+	if c.lastErr != nil && time.Since(c.lastTry) < retryDelay {
+		return nil, c.lastErr
+	}
+	c.lastTry = time.Now()
+	ch, err := c.connect()
+	c.lastErr = err
+	return ch, err
+}
+
+func (c *Conn) connect() (*amqp.Channel, error) {
+	conf := amqp.Config{Heartbeat: 10 * time.Second}
+	if c.TLS {
+		pool, err := skogul.GetCertPool(c.CA)
+		if err != nil {
+			return nil, err
+		}
+		conf.TLSClientConfig = &tls.Config{
+			RootCAs:            pool,
+			ServerName:         c.host(),
+			InsecureSkipVerify: c.Insecure,
+		}
+	}
+	conn, err := amqp.DialConfig(c.URL(), conf)
+	if err != nil {
+		return nil, fmt.Errorf("unable to connect to broker: %w", err)
+	}
+	ch, err := conn.Channel()
+	if err != nil {
+		conn.Close()
+		return nil, fmt.Errorf("unable to open channel: %w", err)
+	}
+	if c.Setup != nil {
+		if err := c.Setup(ch); err != nil {
+			conn.Close()
+			return nil, err
+		}
+	}
+	c.conn = conn
+	c.ch = ch
+	return ch, nil
+}
+
+// Close the connection, if any. The next call to Channel reconnects.
+func (c *Conn) Close() {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.close()
+}
+
+func (c *Conn) close() {
+	if c.conn != nil {
+		c.conn.Close()
+	}
+	c.conn = nil
+	c.ch = nil
+}
@@ -0,0 +1,125 @@
+/*
+ * skogul, Confluent Schema Registry glue
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+/*
+Package schemareg provides the parts of the Confluent Schema Registry
+conventions shared by the avro parser and encoder: the wire format
+framing, subject naming strategies and setting up a registry client.
+Use the parser and encoder instead of including this directly.
+*/
+package schemareg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/hamba/avro/v2/registry"
+)
+
+// magic is the first byte of the Confluent wire format. Any other value
+// is a message that is not framed, or framed in some future format.
+const magic = 0
+
+// HeaderSize is the size of the wire format header: the magic byte and
+// the 4 byte schema ID.
+const HeaderSize = 5
+
+// Subject naming strategies, named after the Confluent serializer
+// settings TopicNameStrategy, RecordNameStrategy and
+// TopicRecordNameStrategy.
+const (
+	StrategyTopic       = "topic"
+	StrategyRecord      = "record"
+	StrategyTopicRecord = "topic_record"
+)
+
+// Frame prepends the wire format header for schema id to payload.
+func Frame(id int, payload []byte) []byte {
+	b := make([]byte, HeaderSize, HeaderSize+len(payload))
+	b[0] = magic
+	binary.BigEndian.PutUint32(b[1:], uint32(id))
+	return append(b, payload...)
+}
+
+// Unframe splits a message in the wire format into the schema ID and the
+// payload following the header.
+func Unframe(b []byte) (int, []byte, error) {
+	if len(b) < HeaderSize {
+		return 0, nil, fmt.Errorf("message too short for schema registry framing (%d bytes)", len(b))
+	}
+	if b[0] != magic {
+		return 0, nil, fmt.Errorf("invalid magic byte %#x in schema registry framing", b[0])
+	}
+	return int(binary.BigEndian.Uint32(b[1:HeaderSize])), b[HeaderSize:], nil
+}
+
+// VerifyStrategy checks that strategy is a known subject naming
+// strategy. The empty string is the default, StrategyTopic.
+func VerifyStrategy(strategy string) error {
+	switch strategy {
+	case "", StrategyTopic, StrategyRecord, StrategyTopicRecord:
+		return nil
+	}
+	return fmt.Errorf("unknown subject naming strategy %q, must be %s, %s or %s", strategy, StrategyTopic, StrategyRecord, StrategyTopicRecord)
+}
+
+// Subject returns the subject name for a schema with the full name
+// record, used for topic, using strategy. The topic strategy uses the
+// -key or -value suffix, depending on key.
+func Subject(strategy string, topic string, record string, key bool) (string, error) {
+	suffix := "-value"
+	if key {
+		suffix = "-key"
+	}
+	switch strategy {
+	case "", StrategyTopic:
+		if topic == "" {
+			return "", fmt.Errorf("the %s subject naming strategy requires a topic", StrategyTopic)
+		}
+		return topic + suffix, nil
+	case StrategyRecord:
+		if record == "" {
+			return "", fmt.Errorf("the %s subject naming strategy requires a named schema", StrategyRecord)
+		}
+		return record, nil
+	case StrategyTopicRecord:
+		if topic == "" || record == "" {
+			return "", fmt.Errorf("the %s subject naming strategy requires a topic and a named schema", StrategyTopicRecord)
+		}
+		return topic + "-" + record, nil
+	}
+	return "", VerifyStrategy(strategy)
+}
+
+// NewClient returns a registry client for url, using basic
+// authentication if either username or password is set. Schemas looked
+// up by ID are cached by the client.
+func NewClient(url string, username string, password string, timeout time.Duration) (*registry.Client, error) {
+	opts := []registry.ClientFunc{registry.WithHTTPClient(&http.Client{Timeout: timeout})}
+	if username != "" || password != "" {
+		opts = append(opts, registry.WithBasicAuth(username, password))
+	}
+	return registry.NewClient(url, opts...)
+}
@@ -64,7 +64,7 @@ func init() {
 		Name:     "protobuf",
 		Aliases:  []string{"telemetry", "juniper"},
 		Alloc:    func() interface{} { return &ProtoBuf{} },
-		Help:     "Parse Juniper telemetry in the form of protocol buffers. Typicially combined with the UDP receiver.",
+		Help:     "Parse Juniper telemetry in the form of protocol buffers. Typicially combined with the UDP receiver. By default, each packet becomes a single nested metric. Enable Split to get one flat metric per interface, LSP, queue, etc instead.",
 		AutoMake: true,
 	})
 	Auto.Add(skogul.Module{
@@ -102,6 +102,11 @@ func init() {
 		Help:     "Parse a single GOB-encoded Skogul Metric",
 		AutoMake: true,
 	})
+	Auto.Add(skogul.Module{
+		Name:  "avro",
+		Alloc: func() interface{} { return &AVRO{} },
+		Help:  "Parse Avro-encoded containers, using a schema from a file or from a Confluent Schema Registry, in which case the Confluent wire format with a schema ID header is expected.",
+	})
 	Auto.Add(skogul.Module{
 		Name:    "dummystore",
 		Aliases: []string{"dstore"},
@@ -112,7 +117,7 @@ func init() {
 		Name:     "prometheus",
 		Aliases:  []string{},
 		Alloc:    func() interface{} { return &Prometheus{} },
-		Help:     "Parse a prometheus formatted document into a skogul container, one metric per line.",
+		Help:     "Parse a prometheus or OpenMetrics formatted document into a skogul container, one metric per sample. Histograms and summaries are expanded into one metric per bucket/quantile.",
 		AutoMake: true,
 	})
 	Auto.Add(skogul.Module{
@@ -122,4 +127,73 @@ func init() {
 		Help:     "Store unparsed data as a byte string in a Skogul metric. Can be used to parse arbitrary data from A to B without implementing support for it. See the blob encoder for the oposite end.",
 		AutoMake: true,
 	})
+	Auto.Add(skogul.Module{
+		Name:     "sflow",
+		Aliases:  []string{},
+		Alloc:    func() interface{} { return &SFlow{} },
+		Help:     "Parse sFlow v5 datagrams, one metric per flow or counter sample. Typically combined with the UDP receiver.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:    "protobuf_dynamic",
+		Aliases: []string{"protobuf-dynamic"},
+		Alloc:   func() interface{} { return &ProtobufDynamic{} },
+		Help:    "Parse arbitrary protobuf messages, using a FileDescriptorSet or .proto files loaded at run time instead of generated code. Fields are mapped to metadata and data by path.",
+	})
+	Auto.Add(skogul.Module{
+		Name:     "graphite",
+		Alloc:    func() interface{} { return &Graphite{} },
+		Help:     "Parse the Graphite plaintext protocol, one metric per line. Templates map segments of the path to metadata.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "grok",
+		Alloc:    func() interface{} { return &Grok{} },
+		Help:     "Parse unstructured log lines with grok patterns, one metric per line. Named captures are stored as data or metadata, optionally converted to numbers or used as the timestamp.",
+		AutoMake: false,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "csv",
+		Alloc:    func() interface{} { return &CSV{} },
+		Help:     "Parse comma separated values, one metric per row, with column names from a header row or configuration.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "logfmt",
+		Alloc:    func() interface{} { return &Logfmt{} },
+		Help:     "Parse logfmt lines, e.g. level=info msg=\"hello world\", one metric per line.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "kv",
+		Aliases:  []string{"keyvalue"},
+		Alloc:    func() interface{} { return &KV{} },
+		Help:     "Parse lines of key=value pairs with configurable separators and quoting, one metric per line.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "msgpack",
+		Aliases:  []string{"messagepack"},
+		Alloc:    func() interface{} { return &MsgPack{} },
+		Help:     "Parse a MessagePack-encoded Skogul Container. Unlike JSON, integers and floats are kept apart. Useful for inter-Skogul communication, also with non-Go peers.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "msgpackmetric",
+		Alloc:    func() interface{} { return &MsgPackMetric{} },
+		Help:     "Parse a single MessagePack-encoded Skogul Metric.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "cbor",
+		Alloc:    func() interface{} { return &CBOR{} },
+		Help:     "Parse a CBOR-encoded Skogul Container. Unlike JSON, integers and floats are kept apart.",
+		AutoMake: true,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "cbormetric",
+		Alloc:    func() interface{} { return &CBORMetric{} },
+		Help:     "Parse a single CBOR-encoded Skogul Metric.",
+		AutoMake: true,
+	})
 }
@@ -23,13 +23,16 @@
 package parser
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"sync"
 	"time"
 
 	avro "github.com/hamba/avro/v2"
+	"github.com/hamba/avro/v2/registry"
 	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/internal/schemareg"
 )
 
 type AvroContainer struct {
@@ -43,30 +46,72 @@ type AvroMetric struct {
 	Data     map[string]interface{}
 }
 
+/*
+AVRO parses Avro-encoded containers, using either a schema read from a
+file or, if Registry is set, messages in the Confluent wire format: a
+zero byte and the 4 byte schema ID, followed by the Avro data. Schemas
+are fetched from the registry the first time an ID is seen, and cached.
+*/
 type AVRO struct {
-	Schema string
-	s      avro.Schema
-	err    error
-	once   sync.Once
+	Schema   string          `doc:"Path to a file with the Avro schema. Not used with a Registry."`
+	Registry string          `doc:"URL of a Confluent Schema Registry. Enables the Confluent wire format."`
+	Username string          `doc:"Username for basic authentication against the registry."`
+	Password skogul.Secret   `doc:"Password for basic authentication against the registry."`
+	Timeout  skogul.Duration `doc:"Timeout for registry requests. Default: 10s"`
+	s        avro.Schema
+	client   *registry.Client
+	err      error
+	once     sync.Once
 }
 
-func (x *AVRO) Parse(b []byte) (*skogul.Container, error) {
-	x.once.Do(func() {
-		s, err := os.ReadFile(x.Schema)
-		x.err = err
-		if x.err == nil {
-			x.s = avro.MustParse(string(s))
-			if x.s == nil {
-				x.err = fmt.Errorf("parsed schema is nil")
-			}
+// Verify checks that exactly one of Schema and Registry is set.
+func (x *AVRO) Verify() error {
+	if x.Schema == "" && x.Registry == "" {
+		return skogul.MissingArgument("Schema")
+	}
+	if x.Schema != "" && x.Registry != "" {
+		return fmt.Errorf("Schema and Registry are mutually exclusive")
+	}
+	return nil
+}
+
+func (x *AVRO) init() {
+	if x.Registry != "" {
+		if x.Timeout.Duration == 0 {
+			x.Timeout.Duration = 10 * time.Second
 		}
-	})
+		x.client, x.err = schemareg.NewClient(x.Registry, x.Username, x.Password.Expose(), x.Timeout.Duration)
+		return
+	}
+	s, err := os.ReadFile(x.Schema)
+	x.err = err
+	if x.err == nil {
+		x.s, x.err = avro.Parse(string(s))
+	}
+}
+
+func (x *AVRO) Parse(b []byte) (*skogul.Container, error) {
+	x.once.Do(x.init)
 	if x.err != nil {
 		return nil, fmt.Errorf("unable to load schema: %w", x.err)
 	}
 
+	schema := x.s
+	if x.client != nil {
+		id, payload, err := schemareg.Unframe(b)
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), x.Timeout.Duration)
+		defer cancel()
+		if schema, err = x.client.GetSchema(ctx, id); err != nil {
+			return nil, fmt.Errorf("unable to fetch schema %d from registry: %w", id, err)
+		}
+		b = payload
+	}
+
 	tmpContainer := AvroContainer{}
-	err := avro.Unmarshal(x.s, b, &tmpContainer)
+	err := avro.Unmarshal(schema, b, &tmpContainer)
 	if err != nil {
 		return nil, fmt.Errorf("unable to unmarshal avro into container %w", err)
 	}
@@ -74,16 +119,80 @@ func (x *AVRO) Parse(b []byte) (*skogul.Container, error) {
 	container := skogul.Container{}
 	container.Metrics = make([]*skogul.Metric, 0, len(tmpContainer.Metrics))
 
+	layout := avroLayout(schema)
 	for m := range tmpContainer.Metrics {
 		var tmpMetric skogul.Metric
 		tmpMetric.Metadata = tmpContainer.Metrics[m].Metadata
 		tmpMetric.Data = tmpContainer.Metrics[m].Data
+		if layout {
+			tmpMetric.Metadata = plainFields(tmpMetric.Metadata)
+			tmpMetric.Data = plainFields(tmpMetric.Data)
+		}
 		tmpMetric.Time = &tmpContainer.Metrics[m].Time
 		container.Metrics = append(container.Metrics, &tmpMetric)
 	}
 
 	return &container, err
 }
+
+// avroLayout reports whether the schema looks like one generated by the
+// avro encoder from a layout: Metadata and Data are records where every
+// field is a nullable primitive.
+func avroLayout(s avro.Schema) bool {
+	r, ok := s.(*avro.RecordSchema)
+	if !ok || len(r.Fields()) != 1 || r.Fields()[0].Name() != "Metrics" {
+		return false
+	}
+	a, ok := r.Fields()[0].Type().(*avro.ArraySchema)
+	if !ok {
+		return false
+	}
+	metric, ok := a.Items().(*avro.RecordSchema)
+	if !ok {
+		return false
+	}
+	records := 0
+	for _, f := range metric.Fields() {
+		if f.Name() != "Metadata" && f.Name() != "Data" {
+			continue
+		}
+		rec, ok := f.Type().(*avro.RecordSchema)
+		if !ok {
+			return false
+		}
+		for _, field := range rec.Fields() {
+			u, ok := field.Type().(*avro.UnionSchema)
+			if !ok || !u.Nullable() {
+				return false
+			}
+		}
+		records++
+	}
+	return records == 2
+}
+
+// plainFields tidies up records with nullable fields, as generated by
+// the avro encoder from a layout: unions of primitive types are decoded
+// as {"type": value}, which is replaced by just the value, and null
+// values are removed.
+func plainFields(m map[string]interface{}) map[string]interface{} {
+	for k, v := range m {
+		if u, ok := v.(map[string]interface{}); ok && len(u) == 1 {
+			for t, uv := range u {
+				switch t {
+				case "boolean", "int", "long", "float", "double", "bytes", "string":
+					v = uv
+					m[k] = v
+				}
+			}
+		}
+		if v == nil {
+			delete(m, k)
+		}
+	}
+	return m
+}
+
 func (x *AVRO) ParseMetric(m *skogul.Metric) ([]byte, error) {
 	return nil, fmt.Errorf("not supported")
 }
@@ -23,8 +23,18 @@
 package parser_test
 
 import (
+	"bytes"
 	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/encoder"
@@ -76,3 +86,179 @@ func parseAVRO(t *testing.T, by []byte) {
 		t.FailNow()
 	}
 }
+
+// TestAVROStaticSchema checks that values decoded with a static schema are
+// left alone, even if they look like the unions of a layout.
+func TestAVROStaticSchema(t *testing.T) {
+	schema := filepath.Join(t.TempDir(), "schema")
+	err := os.WriteFile(schema, []byte(`{"type": "record", "name": "c", "fields": [{"name": "Metrics", "type": {"type": "array", "items": {
+		"type": "record", "name": "m", "fields": [
+			{"name": "Time", "type": {"type": "long", "logicalType": "timestamp-micros"}},
+			{"name": "Metadata", "type": {"type": "map", "values": ["null", "string"]}},
+			{"name": "Data", "type": {"type": "map", "values": {"type": "map", "values": "long"}}}]}}}]}`), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	c := skogul.Container{Metrics: []*skogul.Metric{{
+		Time:     &now,
+		Metadata: map[string]interface{}{"host": "a", "empty": nil},
+		Data:     map[string]interface{}{"nested": map[string]interface{}{"long": int64(5)}},
+	}}}
+	b, err := (&encoder.AVRO{Schema: schema}).Encode(&c)
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	parsed, err := (&parser.AVRO{Schema: schema}).Parse(b)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	m := parsed.Metrics[0]
+	if v, ok := m.Metadata["empty"]; !ok || v != nil || m.Metadata["host"] != "a" {
+		t.Errorf("unexpected metadata %#v", m.Metadata)
+	}
+	if n, ok := m.Data["nested"].(map[string]interface{}); !ok || n["long"] != int64(5) {
+		t.Errorf("unexpected data %#v", m.Data)
+	}
+}
+
+// fakeRegistry is a minimal Confluent Schema Registry, enough for the
+// avro encoder and parser.
+type fakeRegistry struct {
+	lock     sync.Mutex
+	schemas  []string
+	subjects map[string]int
+}
+
+func (f *fakeRegistry) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	if u, p, _ := r.BasicAuth(); u != "user" || p != "secret" {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"error_code":401,"message":"Unauthorized"}`)
+		return
+	}
+	var req struct{ Schema string }
+	if r.Method == http.MethodPost {
+		json.NewDecoder(r.Body).Decode(&req)
+	}
+	path := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	reply := func(id int) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"id": id, "version": 1, "schema": f.schemas[id-1]})
+	}
+	switch {
+	case r.Method == http.MethodPost && len(path) == 3 && path[2] == "versions":
+		id, ok := f.subjects[path[1]]
+		if !ok {
+			f.schemas = append(f.schemas, req.Schema)
+			id = len(f.schemas)
+			f.subjects[path[1]] = id
+		}
+		reply(id)
+		return
+	case r.Method == http.MethodPost && len(path) == 2:
+		if id, ok := f.subjects[path[1]]; ok && f.schemas[id-1] == req.Schema {
+			reply(id)
+			return
+		}
+	case r.Method == http.MethodGet && len(path) == 4 && path[3] == "latest":
+		if id, ok := f.subjects[path[1]]; ok {
+			reply(id)
+			return
+		}
+	case r.Method == http.MethodGet && len(path) == 3 && path[0] == "schemas":
+		id, _ := strconv.Atoi(path[2])
+		if id > 0 && id <= len(f.schemas) {
+			reply(id)
+			return
+		}
+	}
+	w.WriteHeader(http.StatusNotFound)
+	fmt.Fprint(w, `{"error_code":40403,"message":"Schema not found"}`)
+}
+
+func TestAVRORegistry(t *testing.T) {
+	reg := &fakeRegistry{subjects: make(map[string]int)}
+	srv := httptest.NewServer(reg)
+	defer srv.Close()
+
+	layout := &encoder.AvroLayout{
+		Metadata: map[string]string{"host": "string", "port": "int"},
+		Data:     map[string]string{"in": "long", "util": "double", "up": "boolean", "missing": "float"},
+	}
+	e := encoder.AVRO{Layout: layout, Registry: srv.URL, Username: "user", Password: "secret", Topic: "metrics", Register: true}
+	if err := e.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 12, 0, 0, 123456000, time.UTC)
+	c := skogul.Container{Metrics: []*skogul.Metric{{
+		Time:     &now,
+		Metadata: map[string]interface{}{"host": "a", "port": 1.0, "extra": "dropped"},
+		Data:     map[string]interface{}{"in": 1234567890123.0, "util": 0.5, "up": true},
+	}}}
+	b, err := e.Encode(&c)
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	if b[0] != 0 || b[4] != 1 || reg.subjects["metrics-value"] != 1 {
+		t.Fatalf("unexpected framing %v or subjects %v", b[:5], reg.subjects)
+	}
+
+	p := parser.AVRO{Registry: srv.URL, Username: "user", Password: "secret"}
+	if err := p.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	parsed, err := p.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	m := parsed.Metrics[0]
+	if !m.Time.Equal(now) {
+		t.Errorf("time %v, want %v", m.Time, now)
+	}
+	if m.Metadata["host"] != "a" || m.Metadata["port"] != 1 || m.Metadata["extra"] != nil {
+		t.Errorf("unexpected metadata %#v", m.Metadata)
+	}
+	if m.Data["in"] != int64(1234567890123) || m.Data["util"] != 0.5 || m.Data["up"] != true {
+		t.Errorf("unexpected data %#v", m.Data)
+	}
+	if _, ok := m.Data["missing"]; ok {
+		t.Errorf("null field not dropped: %#v", m.Data)
+	}
+	if _, err := p.Parse(b[5:]); err == nil {
+		t.Errorf("Parse() of unframed data did not fail")
+	}
+
+	// Without a local schema, the latest one for the subject is used,
+	// but values are no longer converted to the types of the layout.
+	latest := encoder.AVRO{Registry: srv.URL, Username: "user", Password: "secret", Topic: "metrics"}
+	c.Metrics[0].Metadata = map[string]interface{}{"host": "a", "port": int32(1)}
+	c.Metrics[0].Data = map[string]interface{}{"in": int64(1234567890123), "util": 0.5, "up": true}
+	if b2, err := latest.Encode(&c); err != nil || !bytes.Equal(b, b2) {
+		t.Errorf("Encode() using latest schema gave %v, %v", b2, err)
+	}
+
+	// The schema isn't registered under the record name subject.
+	unregistered := encoder.AVRO{Layout: layout, Registry: srv.URL, Username: "user", Password: "secret", SubjectStrategy: "record"}
+	if _, err := unregistered.Encode(&c); err == nil {
+		t.Errorf("Encode() with unregistered schema did not fail")
+	}
+	unauth := parser.AVRO{Registry: srv.URL}
+	if _, err := unauth.Parse(b); err == nil {
+		t.Errorf("Parse() without credentials did not fail")
+	}
+
+	for _, bad := range []*encoder.AVRO{
+		{},
+		{Registry: srv.URL},
+		{Registry: srv.URL, Topic: "x", SubjectStrategy: "nope"},
+		{Registry: srv.URL, Topic: "x", Register: true},
+		{Registry: srv.URL, SubjectStrategy: "record"},
+		{Layout: &encoder.AvroLayout{Data: map[string]string{"in-octets": "long"}}},
+		{Layout: &encoder.AvroLayout{Data: map[string]string{"in": "bytes"}}},
+	} {
+		if err := bad.Verify(); err == nil {
+			t.Errorf("Verify() of %+v did not fail", bad)
+		}
+	}
+}
@@ -0,0 +1,259 @@
+/*
+ * skogul, CSV parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+CSV parses comma separated values, one metric per row. Column names are
+read from the first row, unless Columns is set. Columns listed in
+Metadata are stored as metadata, TimeColumn is used as the timestamp and
+the rest are stored as data. Empty cells are left out.
+
+Unless Strings is set, integers, floats and true/false are converted to
+numbers and booleans.
+*/
+type CSV struct {
+	Columns    []string `doc:"Column names. If not set, the first row (after SkipRows) is used as header."`
+	SkipRows   int      `doc:"Number of rows to skip before the header or data, e.g. to ignore a header row when Columns is set."`
+	Delimiter  string   `doc:"Field delimiter, a single character. Default ','."`
+	Quote      string   `doc:"Quote character, a single character. Quotes inside quoted fields are escaped by doubling them. Default '\"'."`
+	Comment    string   `doc:"Rows starting with this character are skipped."`
+	Metadata   []string `doc:"Columns to store as metadata."`
+	TimeColumn string   `doc:"Column holding the timestamp. If not set, or empty, the current time is used."`
+	TimeFormat string   `doc:"Format of TimeColumn, as for the timestamp transformer: rfc3339 (default) or a Go time layout, or unix, unix_ms, unix_us or unix_ns for epoch values."`
+	Strings    bool     `doc:"Keep all values as strings instead of inferring numbers and booleans."`
+	once       sync.Once
+	delim      rune
+	quote      rune
+	metadata   map[string]bool
+}
+
+func (x *CSV) init() {
+	x.delim = ','
+	if x.Delimiter != "" {
+		x.delim, _ = utf8.DecodeRuneInString(x.Delimiter)
+	}
+	x.quote = '"'
+	if x.Quote != "" {
+		x.quote, _ = utf8.DecodeRuneInString(x.Quote)
+	}
+	x.metadata = make(map[string]bool)
+	for _, k := range x.Metadata {
+		x.metadata[k] = true
+	}
+}
+
+// csvRecords splits data into records and fields. Newlines are allowed
+// in quoted fields.
+func csvRecords(data string, delim, quote rune) ([][]string, error) {
+	var records [][]string
+	var record []string
+	var field strings.Builder
+	inQuote, quoted, empty := false, false, true
+	line := 1
+	endField := func() {
+		record = append(record, field.String())
+		field.Reset()
+		quoted = false
+	}
+	for i, w := 0, 0; i < len(data); i += w {
+		r, width := utf8.DecodeRuneInString(data[i:])
+		w = width
+		if inQuote {
+			if r == quote {
+				if next, _ := utf8.DecodeRuneInString(data[i+w:]); next == quote && i+w < len(data) {
+					field.WriteRune(quote)
+					w += utf8.RuneLen(quote)
+					continue
+				}
+				inQuote = false
+				continue
+			}
+			if r == '\n' {
+				line++
+			}
+			field.WriteRune(r)
+			continue
+		}
+		switch {
+		case r == quote && field.Len() == 0 && !quoted:
+			inQuote, quoted, empty = true, true, false
+		case r == delim:
+			endField()
+			empty = false
+		case r == '\n':
+			line++
+			if !empty || field.Len() > 0 || len(record) > 0 {
+				endField()
+				records = append(records, record)
+			}
+			record, empty = nil, true
+		case r == '\r' && strings.HasPrefix(data[i+w:], "\n"):
+		default:
+			if quoted {
+				return nil, fmt.Errorf("unexpected %q after quoted field on line %d", r, line)
+			}
+			field.WriteRune(r)
+			empty = false
+		}
+	}
+	if inQuote {
+		return nil, fmt.Errorf("unterminated quoted field on line %d", line)
+	}
+	if !empty || field.Len() > 0 || len(record) > 0 {
+		endField()
+		records = append(records, record)
+	}
+	return records, nil
+}
+
+// inferValue converts s to an int64, float64 or bool if possible.
+func inferValue(s string) interface{} {
+	if s == "true" || s == "false" {
+		return s == "true"
+	}
+	if s == "" || !strings.ContainsAny(s, "0123456789") {
+		return s
+	}
+	if i, err := strconv.ParseInt(s, 10, 64); err == nil {
+		return i
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// Parse parses CSV data. Rows with the wrong number of columns or an
+// invalid timestamp are skipped, and reported as an error along with the
+// metrics of the valid rows.
+func (x *CSV) Parse(b []byte) (*skogul.Container, error) {
+	x.once.Do(x.init)
+	records, err := csvRecords(string(b), x.delim, x.quote)
+	if err != nil {
+		return nil, err
+	}
+	if x.Comment != "" {
+		kept := records[:0]
+		for _, r := range records {
+			if len(r) == 0 || !strings.HasPrefix(r[0], x.Comment) {
+				kept = append(kept, r)
+			}
+		}
+		records = kept
+	}
+	if x.SkipRows >= len(records) {
+		records = nil
+	} else {
+		records = records[x.SkipRows:]
+	}
+	columns := x.Columns
+	if len(columns) == 0 {
+		if len(records) == 0 {
+			return nil, fmt.Errorf("no CSV header row")
+		}
+		columns = records[0]
+		records = records[1:]
+	}
+	container := skogul.Container{Metrics: make([]*skogul.Metric, 0, len(records))}
+	now := skogul.Now()
+	failed := 0
+	var lastErr error
+	for idx, r := range records {
+		m, err := x.row(columns, r, now)
+		if err != nil {
+			failed++
+			lastErr = fmt.Errorf("row %d: %w", idx+1, err)
+			continue
+		}
+		container.Metrics = append(container.Metrics, m)
+	}
+	if failed > 0 {
+		return &container, fmt.Errorf("failed to parse %d CSV rows, last error: %w", failed, lastErr)
+	}
+	return &container, nil
+}
+
+// row converts a single record to a metric.
+func (x *CSV) row(columns []string, r []string, now time.Time) (*skogul.Metric, error) {
+	if len(r) != len(columns) {
+		return nil, fmt.Errorf("%d columns, expected %d", len(r), len(columns))
+	}
+	m := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	for i, name := range columns {
+		v := r[i]
+		if v == "" {
+			continue
+		}
+		if name == x.TimeColumn {
+			t, err := skogul.ParseTime(x.TimeFormat, v)
+			if err != nil {
+				return nil, err
+			}
+			m.Time = &t
+			continue
+		}
+		var value interface{} = v
+		if !x.Strings {
+			value = inferValue(v)
+		}
+		if x.metadata[name] {
+			m.Metadata[name] = value
+		} else {
+			m.Data[name] = value
+		}
+	}
+	return &m, nil
+}
+
+// Verify checks that the delimiter and quote are single characters.
+func (x *CSV) Verify() error {
+	if utf8.RuneCountInString(x.Delimiter) > 1 {
+		return fmt.Errorf("Delimiter must be a single character")
+	}
+	if utf8.RuneCountInString(x.Quote) > 1 {
+		return fmt.Errorf("Quote must be a single character")
+	}
+	if x.Delimiter != "" && x.Delimiter == x.Quote {
+		return fmt.Errorf("Delimiter and Quote can not be the same")
+	}
+	if x.SkipRows < 0 {
+		return fmt.Errorf("SkipRows can not be negative")
+	}
+	return nil
+}
@@ -0,0 +1,94 @@
+/*
+ * skogul, CSV parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/telenornms/skogul/parser"
+)
+
+func TestCSV(t *testing.T) {
+	p := parser.CSV{
+		Metadata:   []string{"host", "ifname"},
+		TimeColumn: "ts",
+		TimeFormat: "unix",
+	}
+	if err := p.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	c, err := p.Parse([]byte("ts,host,ifname,in,util,up,descr\r\n" +
+		"1700000000,gw1,eth0,1234,0.5,true,\"uplink, \"\"primary\"\"\"\r\n" +
+		"\n" +
+		"1700000060,gw1,eth1,12,,false,\"multi\nline\"\n"))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Time.Unix() != 1700000000 || m.Metadata["host"] != "gw1" || m.Metadata["ifname"] != "eth0" {
+		t.Errorf("unexpected metric %v %v", m.Time, m.Metadata)
+	}
+	if m.Data["in"] != int64(1234) || m.Data["util"] != 0.5 || m.Data["up"] != true || m.Data["descr"] != `uplink, "primary"` {
+		t.Errorf("unexpected data %v", m.Data)
+	}
+	m = c.Metrics[1]
+	if _, ok := m.Data["util"]; ok {
+		t.Errorf("empty cell was stored: %v", m.Data)
+	}
+	if m.Data["descr"] != "multi\nline" || m.Data["up"] != false {
+		t.Errorf("unexpected data %v", m.Data)
+	}
+}
+
+func TestCSVColumns(t *testing.T) {
+	p := parser.CSV{
+		Columns:   []string{"name", "value"},
+		SkipRows:  1,
+		Delimiter: ";",
+		Quote:     "'",
+		Comment:   "#",
+		Strings:   true,
+	}
+	c, err := p.Parse([]byte("# exported\nkey;val\n'a;b';1\nc;2;3\n"))
+	if err == nil {
+		t.Errorf("Parse() with wrong number of columns did not fail")
+	}
+	if c == nil || len(c.Metrics) != 1 {
+		t.Fatalf("expected 1 valid metric, got %v", c)
+	}
+	if c.Metrics[0].Data["name"] != "a;b" || c.Metrics[0].Data["value"] != "1" {
+		t.Errorf("unexpected data %v", c.Metrics[0].Data)
+	}
+
+	p = parser.CSV{}
+	if _, err := p.Parse([]byte("a,\"b\n")); err == nil {
+		t.Errorf("Parse() of unterminated quote did not fail")
+	}
+	if err := (&parser.CSV{Delimiter: "ab"}).Verify(); err == nil {
+		t.Errorf("Verify() of long delimiter did not fail")
+	}
+}
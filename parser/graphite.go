@@ -0,0 +1,257 @@
+/*
+ * skogul, graphite plaintext parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"math"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+Graphite parses the Graphite plaintext protocol, one "path value
+timestamp" per line, into one metric per line. The timestamp is in
+seconds, and is optional. A timestamp of -1 means "now".
+
+Templates map the dotted path into metadata, like the templates of
+Telegraf. Each template is an optional filter, the template itself, and
+optional extra metadata, separated by spaces, e.g.:
+
+	servers.* .host.measurement.field region=eu
+
+The filter is matched against the path, segment by segment, with glob
+patterns. The first template with a matching filter is used, and a
+template without a filter matches everything. In the template, each
+segment names what the corresponding path segment is: "measurement" and
+"field" are appended to the measurement and field names, any other name
+becomes a metadata field, and an empty segment is skipped. A trailing *,
+e.g. "measurement*", consumes the rest of the path.
+
+The measurement is stored in the "measurement" metadata field, joined
+with a dot, and the value as data with the field as the key, defaulting
+to "value". Without a matching template, the entire path is the
+measurement.
+*/
+type Graphite struct {
+	Templates []string `doc:"Templates mapping path segments to metadata, in the form [filter] template [key=value,...]. The first matching template is used." example:"[\"servers.* .host.measurement.field\", \"measurement*\"]"`
+	templates []graphiteTemplate
+	err       error
+	once      sync.Once
+}
+
+type graphiteTemplate struct {
+	filter []string
+	parts  []string
+	extra  map[string]string
+}
+
+func parseGraphiteTemplate(s string) (graphiteTemplate, error) {
+	t := graphiteTemplate{}
+	words := strings.Fields(s)
+	if len(words) > 1 && strings.Contains(words[len(words)-1], "=") {
+		t.extra = make(map[string]string)
+		for _, kv := range strings.Split(words[len(words)-1], ",") {
+			k, v, ok := strings.Cut(kv, "=")
+			if !ok || k == "" {
+				return t, fmt.Errorf("invalid extra metadata %q in template %q", kv, s)
+			}
+			t.extra[k] = v
+		}
+		words = words[:len(words)-1]
+	}
+	switch len(words) {
+	case 1:
+		t.parts = strings.Split(words[0], ".")
+	case 2:
+		t.filter = strings.Split(words[0], ".")
+		t.parts = strings.Split(words[1], ".")
+	default:
+		return t, fmt.Errorf("invalid template %q", s)
+	}
+	for _, f := range t.filter {
+		if _, err := path.Match(f, ""); err != nil {
+			return t, fmt.Errorf("invalid filter in template %q: %w", s, err)
+		}
+	}
+	return t, nil
+}
+
+// match checks if the filter matches the path segments.
+func (t *graphiteTemplate) match(segments []string) bool {
+	if t.filter == nil {
+		return true
+	}
+	if len(segments) < len(t.filter) {
+		return false
+	}
+	for i, f := range t.filter {
+		if ok, _ := path.Match(f, segments[i]); !ok {
+			return false
+		}
+	}
+	return true
+}
+
+// apply maps the segments to measurement, field and metadata.
+func (t *graphiteTemplate) apply(segments []string, md map[string]interface{}) (measurement, field string) {
+	var m, f []string
+	tags := make(map[string][]string)
+	for i, p := range t.parts {
+		if i >= len(segments) {
+			break
+		}
+		rest := strings.HasSuffix(p, "*")
+		name := strings.TrimSuffix(p, "*")
+		values := segments[i : i+1]
+		if rest {
+			values = segments[i:]
+		}
+		switch name {
+		case "":
+		case "measurement":
+			m = append(m, values...)
+		case "field":
+			f = append(f, values...)
+		default:
+			tags[name] = append(tags[name], values...)
+		}
+		if rest {
+			break
+		}
+	}
+	for k, v := range t.extra {
+		md[k] = v
+	}
+	for k, v := range tags {
+		md[k] = strings.Join(v, ".")
+	}
+	return strings.Join(m, "."), strings.Join(f, ".")
+}
+
+func (g *Graphite) init() {
+	for _, s := range g.Templates {
+		t, err := parseGraphiteTemplate(s)
+		if err != nil {
+			g.err = err
+			return
+		}
+		g.templates = append(g.templates, t)
+	}
+}
+
+// Parse a block of graphite lines. Invalid lines are skipped, and
+// reported as an error along with the metrics of the valid lines.
+func (g *Graphite) Parse(b []byte) (*skogul.Container, error) {
+	g.once.Do(g.init)
+	if g.err != nil {
+		return nil, g.err
+	}
+	container := skogul.Container{Metrics: make([]*skogul.Metric, 0)}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	failed := 0
+	var lastErr error
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		m, err := g.parseLine(line)
+		if err != nil {
+			failed++
+			lastErr = err
+			continue
+		}
+		container.Metrics = append(container.Metrics, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if failed > 0 {
+		return &container, fmt.Errorf("failed to parse %d graphite lines, last error: %w", failed, lastErr)
+	}
+	return &container, nil
+}
+
+func (g *Graphite) parseLine(line string) (*skogul.Metric, error) {
+	words := strings.Fields(line)
+	if len(words) < 2 || len(words) > 3 {
+		return nil, fmt.Errorf("expected \"path value [timestamp]\", got %q", line)
+	}
+	value, err := strconv.ParseFloat(words[1], 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid value in %q: %w", line, err)
+	}
+	if math.IsNaN(value) || math.IsInf(value, 0) {
+		return nil, fmt.Errorf("non-finite value in %q", line)
+	}
+	t := skogul.Now()
+	if len(words) == 3 && words[2] != "-1" {
+		ts, err := strconv.ParseFloat(words[2], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid timestamp in %q: %w", line, err)
+		}
+		sec, frac := math.Modf(ts)
+		t = time.Unix(int64(sec), int64(frac*1e9))
+	}
+	m := skogul.Metric{
+		Time:     &t,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	segments := strings.Split(words[0], ".")
+	measurement, field := "", ""
+	for i := range g.templates {
+		if g.templates[i].match(segments) {
+			measurement, field = g.templates[i].apply(segments, m.Metadata)
+			break
+		}
+	}
+	if measurement == "" {
+		measurement = words[0]
+	}
+	if field == "" {
+		field = "value"
+	}
+	m.Metadata["measurement"] = measurement
+	m.Data[field] = value
+	return &m, nil
+}
+
+// Verify checks that the templates are valid.
+func (g *Graphite) Verify() error {
+	for _, s := range g.Templates {
+		if _, err := parseGraphiteTemplate(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
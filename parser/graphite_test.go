@@ -0,0 +1,95 @@
+/*
+ * skogul, graphite parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/telenornms/skogul/parser"
+)
+
+func TestGraphite(t *testing.T) {
+	p := parser.Graphite{
+		Templates: []string{
+			"servers.* .host.measurement.field region=eu,dc=osl",
+			"apps.*.*.latency .app.measurement*",
+			"measurement*",
+		},
+	}
+	if err := p.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	c, err := p.Parse([]byte(`servers.web01.cpu.idle 98.5 1700000000
+apps.shop.prod.latency.p99 12 1700000000.5
+
+plain.old.path 1 -1
+`))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 3 {
+		t.Fatalf("expected 3 metrics, got %d", len(c.Metrics))
+	}
+
+	m := c.Metrics[0]
+	if m.Metadata["host"] != "web01" || m.Metadata["measurement"] != "cpu" || m.Metadata["region"] != "eu" || m.Metadata["dc"] != "osl" {
+		t.Errorf("unexpected metadata %v", m.Metadata)
+	}
+	if m.Data["idle"] != 98.5 || m.Time.Unix() != 1700000000 {
+		t.Errorf("unexpected data %v at %v", m.Data, m.Time)
+	}
+
+	m = c.Metrics[1]
+	if m.Metadata["app"] != "shop" || m.Metadata["measurement"] != "prod.latency.p99" || m.Data["value"] != 12.0 {
+		t.Errorf("unexpected metric %v %v", m.Metadata, m.Data)
+	}
+	if m.Time.UnixMilli() != 1700000000500 {
+		t.Errorf("unexpected time %v", m.Time)
+	}
+
+	m = c.Metrics[2]
+	if m.Metadata["measurement"] != "plain.old.path" || m.Data["value"] != 1.0 {
+		t.Errorf("unexpected metric %v %v", m.Metadata, m.Data)
+	}
+}
+
+func TestGraphiteInvalid(t *testing.T) {
+	p := parser.Graphite{}
+	c, err := p.Parse([]byte("ok.path 1 1700000000\nbroken.path x\nalso broken\n"))
+	if err == nil {
+		t.Errorf("Parse() of invalid lines did not fail")
+	}
+	if c == nil || len(c.Metrics) != 1 {
+		t.Errorf("expected the valid line to be parsed, got %v", c)
+	}
+
+	p = parser.Graphite{Templates: []string{"a b c d"}}
+	if err := p.Verify(); err == nil {
+		t.Errorf("Verify() of invalid template did not fail")
+	}
+	p = parser.Graphite{Templates: []string{"a.[ measurement"}}
+	if err := p.Verify(); err == nil {
+		t.Errorf("Verify() of invalid filter did not fail")
+	}
+}
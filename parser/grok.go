@@ -0,0 +1,333 @@
+/*
+ * skogul, grok parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+Grok parses unstructured text, one metric per line, by matching each line
+against grok patterns. A grok pattern is a regular expression where
+%{NAME} refers to a named pattern from the standard library or
+CustomPatterns, %{NAME:field} stores what it matched as field, and
+%{NAME:field:type} also converts it to type int, float, string or
+timestamp. Regular named groups, (?P<field>...), also work.
+
+Patterns are tried in order and the first match is used. Captures listed
+in Metadata are stored as metadata, the rest as data. A capture typed
+timestamp sets the time of the metric instead of being stored, and
+defaults to the current time.
+*/
+type Grok struct {
+	Patterns         []string          `doc:"Grok patterns to match, tried in order." example:"[\"%{SYSLOGBASE} %{GREEDYDATA:message}\", \"%{COMMONAPACHELOG}\"]"`
+	CustomPatterns   map[string]string `doc:"Additional named patterns that can be referenced from Patterns, or override standard patterns." example:"{\"QUEUEID\": \"[0-9A-F]{10,11}\"}"`
+	Metadata         []string          `doc:"Captures to store as metadata. The remaining captures are stored as data."`
+	Types            map[string]string `doc:"Type of captures, as an alternative to %{NAME:field:type}: int, float, string or timestamp."`
+	TimestampFormats []string          `doc:"Go time layouts to try for timestamp captures, or unix, unix_ms, unix_us or unix_ns for epoch values. Defaults to RFC3339, syslog (Jan _2 15:04:05), HTTP (02/Jan/2006:15:04:05 -0700) and 2006-01-02 15:04:05."`
+	Unmatched        string            `doc:"What to do with lines matching no pattern: error (default) skips the line and returns an error along with the other metrics, raw keeps the line as a metric with the line stored in RawField."`
+	RawField         string            `doc:"Data field for the line when Unmatched is raw. Default 'message'."`
+	once             sync.Once
+	err              error
+	compiled         []*grokRegexp
+	metadata         map[string]bool
+}
+
+// grokCapture is a named capture in a compiled pattern.
+type grokCapture struct {
+	group int
+	name  string
+	typ   string
+}
+
+type grokRegexp struct {
+	re       *regexp.Regexp
+	captures []grokCapture
+}
+
+var grokRef = regexp.MustCompile(`%\{(\w+)(?::([\w.\[\]@-]+))?(?::(\w+))?\}`)
+
+// grokCompile expands a grok pattern and compiles it.
+func grokCompile(pattern string, library map[string]string, types map[string]string) (*grokRegexp, error) {
+	var names []grokCapture
+	var expand func(p string, depth int) (string, error)
+	expand = func(p string, depth int) (string, error) {
+		if depth > 32 {
+			return "", fmt.Errorf("pattern recursion too deep in %q", pattern)
+		}
+		var err error
+		out := grokRef.ReplaceAllStringFunc(p, func(ref string) string {
+			if err != nil {
+				return ""
+			}
+			m := grokRef.FindStringSubmatch(ref)
+			sub, ok := library[m[1]]
+			if !ok {
+				err = fmt.Errorf("unknown pattern %%{%s} in %q", m[1], pattern)
+				return ""
+			}
+			sub, err = expand(sub, depth+1)
+			if err != nil {
+				return ""
+			}
+			if m[2] == "" {
+				return "(?:" + sub + ")"
+			}
+			typ := m[3]
+			if typ == "" {
+				typ = types[m[2]]
+			}
+			names = append(names, grokCapture{name: m[2], typ: typ})
+			return fmt.Sprintf("(?P<_grok%d>%s)", len(names)-1, sub)
+		})
+		return out, err
+	}
+	expanded, err := expand(pattern, 0)
+	if err != nil {
+		return nil, err
+	}
+	re, err := regexp.Compile(expanded)
+	if err != nil {
+		return nil, fmt.Errorf("pattern %q doesn't compile: %w", pattern, err)
+	}
+	g := &grokRegexp{re: re}
+	for idx, group := range re.SubexpNames() {
+		if group == "" {
+			continue
+		}
+		if n, ok := strings.CutPrefix(group, "_grok"); ok {
+			if i, err := strconv.Atoi(n); err == nil && i < len(names) {
+				c := names[i]
+				c.group = idx
+				g.captures = append(g.captures, c)
+				continue
+			}
+		}
+		g.captures = append(g.captures, grokCapture{group: idx, name: group, typ: types[group]})
+	}
+	for _, c := range g.captures {
+		switch c.typ {
+		case "", "string", "int", "float", "timestamp":
+		default:
+			return nil, fmt.Errorf("unknown type %s for %s in %q", c.typ, c.name, pattern)
+		}
+	}
+	return g, nil
+}
+
+// library returns the standard patterns merged with CustomPatterns.
+func (g *Grok) library() map[string]string {
+	library := make(map[string]string, len(grokPatterns)+len(g.CustomPatterns))
+	for k, v := range grokPatterns {
+		library[k] = v
+	}
+	for k, v := range g.CustomPatterns {
+		library[k] = v
+	}
+	return library
+}
+
+func (g *Grok) init() {
+	library := g.library()
+	for _, p := range g.Patterns {
+		re, err := grokCompile(p, library, g.Types)
+		if err != nil {
+			g.err = err
+			return
+		}
+		g.compiled = append(g.compiled, re)
+	}
+	g.metadata = make(map[string]bool)
+	for _, k := range g.Metadata {
+		g.metadata[k] = true
+	}
+	if len(g.TimestampFormats) == 0 {
+		g.TimestampFormats = []string{time.RFC3339Nano, time.Stamp, "02/Jan/2006:15:04:05 -0700", "2006-01-02 15:04:05"}
+	}
+	if g.RawField == "" {
+		g.RawField = "message"
+	}
+}
+
+// parseTimestamp tries the configured formats in order. Formats without
+// a year, e.g. syslog, get the current year, or the previous if the
+// result would be more than a day into the future.
+func (g *Grok) parseTimestamp(s string) (time.Time, error) {
+	for _, format := range g.TimestampFormats {
+		var unit time.Duration
+		switch format {
+		case "unix":
+			unit = time.Second
+		case "unix_ms":
+			unit = time.Millisecond
+		case "unix_us":
+			unit = time.Microsecond
+		case "unix_ns":
+			unit = time.Nanosecond
+		}
+		if unit != 0 {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+			return time.Unix(0, int64(f*float64(unit))), nil
+		}
+		t, err := time.Parse(format, s)
+		if err != nil {
+			continue
+		}
+		if t.Year() == 0 {
+			now := time.Now()
+			t = t.AddDate(now.Year(), 0, 0)
+			if t.After(now.Add(24 * time.Hour)) {
+				t = t.AddDate(-1, 0, 0)
+			}
+		}
+		return t, nil
+	}
+	return time.Time{}, fmt.Errorf("timestamp %q matches none of the formats", s)
+}
+
+// match tries a single pattern against a line. It returns nil if the
+// pattern doesn't match, or a capture can't be converted to its type.
+func (g *Grok) match(re *grokRegexp, line string) *skogul.Metric {
+	idx := re.re.FindStringSubmatchIndex(line)
+	if idx == nil {
+		return nil
+	}
+	m := skogul.Metric{
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	for _, c := range re.captures {
+		start, end := idx[2*c.group], idx[2*c.group+1]
+		if start < 0 {
+			continue
+		}
+		s := line[start:end]
+		var v interface{} = s
+		switch c.typ {
+		case "int":
+			i, err := strconv.ParseInt(s, 10, 64)
+			if err != nil {
+				return nil
+			}
+			v = i
+		case "float":
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return nil
+			}
+			v = f
+		case "timestamp":
+			t, err := g.parseTimestamp(s)
+			if err != nil {
+				return nil
+			}
+			m.Time = &t
+			continue
+		}
+		if g.metadata[c.name] {
+			m.Metadata[c.name] = v
+		} else {
+			m.Data[c.name] = v
+		}
+	}
+	return &m
+}
+
+// Parse parses a block of lines. Lines matching no pattern are handled
+// according to Unmatched.
+func (g *Grok) Parse(b []byte) (*skogul.Container, error) {
+	g.once.Do(g.init)
+	if g.err != nil {
+		return nil, g.err
+	}
+	container := skogul.Container{Metrics: make([]*skogul.Metric, 0)}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	now := skogul.Now()
+	failed := 0
+	var lastLine string
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		var m *skogul.Metric
+		for _, re := range g.compiled {
+			if m = g.match(re, line); m != nil {
+				break
+			}
+		}
+		if m == nil {
+			if g.Unmatched != "raw" {
+				failed++
+				lastLine = line
+				continue
+			}
+			m = &skogul.Metric{
+				Metadata: make(map[string]interface{}),
+				Data:     map[string]interface{}{g.RawField: line},
+			}
+		}
+		if m.Time == nil {
+			m.Time = &now
+		}
+		container.Metrics = append(container.Metrics, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if failed > 0 {
+		return &container, fmt.Errorf("%d lines matched no grok pattern, last: %q", failed, lastLine)
+	}
+	return &container, nil
+}
+
+// Verify checks that the patterns compile.
+func (g *Grok) Verify() error {
+	if len(g.Patterns) == 0 {
+		return skogul.MissingArgument("Patterns")
+	}
+	if g.Unmatched != "" && g.Unmatched != "error" && g.Unmatched != "raw" {
+		return fmt.Errorf("invalid Unmatched %s, must be error or raw", g.Unmatched)
+	}
+	library := g.library()
+	for _, p := range g.Patterns {
+		if _, err := grokCompile(p, library, g.Types); err != nil {
+			return err
+		}
+	}
+	return nil
+}
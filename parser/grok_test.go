@@ -0,0 +1,140 @@
+/*
+ * skogul, grok parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul/parser"
+)
+
+func TestGrok(t *testing.T) {
+	p := parser.Grok{
+		Patterns: []string{
+			`%{SYSLOGBASE} %{GREEDYDATA:message}`,
+			`%{COMMONAPACHELOG}`,
+			`^%{TIMESTAMP_ISO8601:time:timestamp} %{LOGLEVEL:level} queue=%{QUEUEID:queue} took %{NUMBER:ms:float}ms`,
+		},
+		CustomPatterns: map[string]string{"QUEUEID": `[0-9A-F]{10,11}`},
+		Metadata:       []string{"logsource", "program", "clientip", "level"},
+		Types:          map[string]string{"timestamp": "timestamp", "response": "int", "bytes": "int"},
+	}
+	if err := p.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	c, err := p.Parse([]byte(`Mar  7 10:01:02 gw1 sshd[4242]: Accepted publickey for root
+10.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /apache_pb.gif HTTP/1.0" 200 2326
+2024-05-01T12:00:00Z INFO queue=0123456789A took 12.5ms
+`))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 3 {
+		t.Fatalf("expected 3 metrics, got %d", len(c.Metrics))
+	}
+
+	m := c.Metrics[0]
+	if m.Metadata["logsource"] != "gw1" || m.Metadata["program"] != "sshd" || m.Data["pid"] != "4242" {
+		t.Errorf("unexpected syslog metric %v %v", m.Metadata, m.Data)
+	}
+	if m.Data["message"] != "Accepted publickey for root" {
+		t.Errorf("unexpected message %q", m.Data["message"])
+	}
+	if m.Time.Month() != time.March || m.Time.Day() != 7 || m.Time.Year() < 2024 {
+		t.Errorf("unexpected syslog time %v", m.Time)
+	}
+
+	m = c.Metrics[1]
+	if m.Metadata["clientip"] != "10.0.0.1" || m.Data["response"] != int64(200) || m.Data["bytes"] != int64(2326) || m.Data["verb"] != "GET" {
+		t.Errorf("unexpected apache metric %v %v", m.Metadata, m.Data)
+	}
+	if m.Time.Unix() != 971211336 {
+		t.Errorf("unexpected apache time %v", m.Time)
+	}
+
+	m = c.Metrics[2]
+	if m.Metadata["level"] != "INFO" || m.Data["queue"] != "0123456789A" || m.Data["ms"] != 12.5 {
+		t.Errorf("unexpected custom metric %v %v", m.Metadata, m.Data)
+	}
+	if _, ok := m.Data["time"]; ok || m.Time.Unix() != 1714564800 {
+		t.Errorf("timestamp capture not used as time: %v", m.Time)
+	}
+}
+
+func TestGrokUnmatched(t *testing.T) {
+	p := parser.Grok{Patterns: []string{`^(?P<key>\w+)=%{INT:value:int}$`}}
+	c, err := p.Parse([]byte("a=1\nnot a match\nb=x\n"))
+	if err == nil {
+		t.Errorf("Parse() of unmatched lines did not fail")
+	}
+	if c == nil || len(c.Metrics) != 1 || c.Metrics[0].Data["key"] != "a" || c.Metrics[0].Data["value"] != int64(1) {
+		t.Errorf("expected the matching line to be parsed, got %v", c)
+	}
+
+	p = parser.Grok{Patterns: []string{`^%{WORD:key}=%{INT:value:int}$`}, Unmatched: "raw", RawField: "line"}
+	c, err = p.Parse([]byte("a=1\nnot a match\n"))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 2 || c.Metrics[1].Data["line"] != "not a match" {
+		t.Errorf("expected unmatched line kept raw, got %v", c.Metrics)
+	}
+}
+
+func TestGrokVerify(t *testing.T) {
+	for _, p := range []*parser.Grok{
+		{},
+		{Patterns: []string{"%{NOSUCHPATTERN:x}"}},
+		{Patterns: []string{"%{WORD:x:bool}"}},
+		{Patterns: []string{"%{LOOP}"}, CustomPatterns: map[string]string{"LOOP": "%{LOOP}"}},
+		{Patterns: []string{"(unbalanced"}},
+		{Patterns: []string{"%{WORD}"}, Unmatched: "drop"},
+	} {
+		if err := p.Verify(); err == nil {
+			t.Errorf("Verify() of %v did not fail", p.Patterns)
+		}
+	}
+}
+
+func TestGrokStandardPatterns(t *testing.T) {
+	cases := map[string]string{
+		"IPV4":              "192.168.1.254",
+		"IPV6":              "2001:db8::ff00:42:8329",
+		"MAC":               "00:1b:44:11:3a:b7",
+		"UUID":              "123e4567-e89b-12d3-a456-426614174000",
+		"URI":               "https://user@example.com:8080/path?x=1",
+		"EMAILADDRESS":      "kly@example.com",
+		"TIMESTAMP_ISO8601": "2024-05-01T12:00:00.123+02:00",
+		"HTTPDATE":          "10/Oct/2000:13:55:36 -0700",
+		"QUOTEDSTRING":      `"hello \"world\""`,
+	}
+	for name, input := range cases {
+		p := parser.Grok{Patterns: []string{"^%{" + name + ":v}$"}}
+		c, err := p.Parse([]byte(input))
+		if err != nil || len(c.Metrics) != 1 || c.Metrics[0].Data["v"] != input {
+			t.Errorf("%s did not match %q: %v", name, input, err)
+		}
+	}
+}
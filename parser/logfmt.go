@@ -0,0 +1,299 @@
+/*
+ * skogul, logfmt and key=value parsers
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+KV parses lines of key=value pairs, one metric per line. Pairs are
+separated by Separator, or whitespace by default, and values can be
+quoted with Quote, using backslash to escape the quote character or a
+backslash. A key without a value is set to true.
+
+Keys listed in Metadata are stored as metadata, TimeField is used as the
+timestamp and the rest are stored as data. Unless Strings is set,
+unquoted integers, floats and true/false are converted to numbers and
+booleans.
+*/
+type KV struct {
+	Separator  string   `doc:"Separator between pairs. Default is any whitespace." example:","`
+	Assign     string   `doc:"Separator between key and value. Default '='."`
+	Quote      string   `doc:"Quote character for values, a single character. Default '\"'."`
+	Metadata   []string `doc:"Keys to store as metadata."`
+	TimeField  string   `doc:"Key holding the timestamp. If not set, or missing, the current time is used."`
+	TimeFormat string   `doc:"Format of TimeField, as for the timestamp transformer: rfc3339 (default) or a Go time layout, or unix, unix_ms, unix_us or unix_ns for epoch values."`
+	Strings    bool     `doc:"Keep all values as strings instead of inferring numbers and booleans."`
+	once       sync.Once
+	quote      rune
+	metadata   map[string]bool
+}
+
+/*
+Logfmt parses logfmt lines, e.g.:
+
+	time=2024-05-01T12:00:00Z level=info msg="request done" duration=0.12 ok
+
+It is the KV parser with logfmt defaults: pairs separated by whitespace,
+= between key and value and Go-style double quoted values.
+*/
+type Logfmt struct {
+	Metadata   []string `doc:"Keys to store as metadata."`
+	TimeField  string   `doc:"Key holding the timestamp. If not set, or missing, the current time is used."`
+	TimeFormat string   `doc:"Format of TimeField, as for the timestamp transformer: rfc3339 (default) or a Go time layout, or unix, unix_ms, unix_us or unix_ns for epoch values."`
+	Strings    bool     `doc:"Keep all values as strings instead of inferring numbers and booleans."`
+	once       sync.Once
+	kv         KV
+}
+
+type kvPair struct {
+	key    string
+	value  string
+	quoted bool
+	bare   bool
+}
+
+func (x *KV) init() {
+	if x.Assign == "" {
+		x.Assign = "="
+	}
+	x.quote = '"'
+	if x.Quote != "" {
+		x.quote, _ = utf8.DecodeRuneInString(x.Quote)
+	}
+	x.metadata = make(map[string]bool)
+	for _, k := range x.Metadata {
+		x.metadata[k] = true
+	}
+}
+
+// separator returns the length of the separator at the start of s, or 0.
+func (x *KV) separator(s string) int {
+	if x.Separator == "" {
+		r, w := utf8.DecodeRuneInString(s)
+		if unicode.IsSpace(r) {
+			return w
+		}
+		return 0
+	}
+	if strings.HasPrefix(s, x.Separator) {
+		return len(x.Separator)
+	}
+	return 0
+}
+
+// pairs splits a line into key/value pairs.
+func (x *KV) pairs(line string) ([]kvPair, error) {
+	var pairs []kvPair
+	i := 0
+	for i < len(line) {
+		if w := x.separator(line[i:]); w > 0 {
+			i += w
+			continue
+		}
+		if x.Separator != "" && strings.TrimSpace(line[i:i+1]) == "" {
+			i++
+			continue
+		}
+		start := i
+		for i < len(line) && x.separator(line[i:]) == 0 && !strings.HasPrefix(line[i:], x.Assign) {
+			i++
+		}
+		p := kvPair{key: strings.TrimSpace(line[start:i])}
+		if p.key == "" {
+			return nil, fmt.Errorf("missing key at position %d", start+1)
+		}
+		if !strings.HasPrefix(line[i:], x.Assign) {
+			p.bare = true
+			pairs = append(pairs, p)
+			continue
+		}
+		i += len(x.Assign)
+		if r, w := utf8.DecodeRuneInString(line[i:]); i < len(line) && r == x.quote {
+			i += w
+			start = i
+			closed := false
+			for i < len(line) {
+				r, w := utf8.DecodeRuneInString(line[i:])
+				if r == '\\' {
+					i += w
+					_, w = utf8.DecodeRuneInString(line[i:])
+				} else if r == x.quote {
+					closed = true
+					break
+				}
+				i += w
+			}
+			if !closed {
+				return nil, fmt.Errorf("unterminated quoted value for %s", p.key)
+			}
+			v, err := x.unquote(line[start:i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid quoted value for %s: %w", p.key, err)
+			}
+			i += utf8.RuneLen(x.quote)
+			p.value = v
+			p.quoted = true
+		} else {
+			start = i
+			for i < len(line) && x.separator(line[i:]) == 0 {
+				i++
+			}
+			p.value = strings.TrimSpace(line[start:i])
+		}
+		pairs = append(pairs, p)
+	}
+	return pairs, nil
+}
+
+// unquote unescapes a quoted value. Double quoted values use Go
+// escaping, other quotes only backslash escapes.
+func (x *KV) unquote(raw string) (string, error) {
+	if x.quote == '"' {
+		return strconv.Unquote(`"` + raw + `"`)
+	}
+	var v strings.Builder
+	escaped := false
+	for _, r := range raw {
+		if !escaped && r == '\\' {
+			escaped = true
+			continue
+		}
+		if escaped {
+			switch r {
+			case 'n':
+				r = '\n'
+			case 't':
+				r = '\t'
+			case 'r':
+				r = '\r'
+			}
+			escaped = false
+		}
+		v.WriteRune(r)
+	}
+	return v.String(), nil
+}
+
+// line converts a single line to a metric.
+func (x *KV) line(line string) (*skogul.Metric, error) {
+	pairs, err := x.pairs(line)
+	if err != nil {
+		return nil, err
+	}
+	now := skogul.Now()
+	m := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	for _, p := range pairs {
+		if p.key == x.TimeField {
+			t, err := skogul.ParseTime(x.TimeFormat, p.value)
+			if err != nil {
+				return nil, err
+			}
+			m.Time = &t
+			continue
+		}
+		var value interface{} = p.value
+		switch {
+		case p.bare:
+			value = true
+		case !x.Strings && !p.quoted:
+			value = inferValue(p.value)
+		}
+		if x.metadata[p.key] {
+			m.Metadata[p.key] = value
+		} else {
+			m.Data[p.key] = value
+		}
+	}
+	return &m, nil
+}
+
+// Parse parses a block of lines. Invalid lines are skipped, and reported
+// as an error along with the metrics of the valid lines.
+func (x *KV) Parse(b []byte) (*skogul.Container, error) {
+	x.once.Do(x.init)
+	container := skogul.Container{Metrics: make([]*skogul.Metric, 0)}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	failed := 0
+	var lastErr error
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		m, err := x.line(line)
+		if err != nil {
+			failed++
+			lastErr = fmt.Errorf("line %d: %w", n, err)
+			continue
+		}
+		container.Metrics = append(container.Metrics, m)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if failed > 0 {
+		return &container, fmt.Errorf("failed to parse %d lines, last error: %w", failed, lastErr)
+	}
+	return &container, nil
+}
+
+// Verify checks that the separators are usable.
+func (x *KV) Verify() error {
+	if utf8.RuneCountInString(x.Quote) > 1 {
+		return fmt.Errorf("Quote must be a single character")
+	}
+	if x.Separator != "" && x.Separator == x.Assign {
+		return fmt.Errorf("Separator and Assign can not be the same")
+	}
+	return nil
+}
+
+// Parse parses a block of logfmt lines.
+func (x *Logfmt) Parse(b []byte) (*skogul.Container, error) {
+	x.once.Do(func() {
+		x.kv = KV{
+			Metadata:   x.Metadata,
+			TimeField:  x.TimeField,
+			TimeFormat: x.TimeFormat,
+			Strings:    x.Strings,
+		}
+	})
+	return x.kv.Parse(b)
+}
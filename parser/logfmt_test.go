@@ -0,0 +1,79 @@
+/*
+ * skogul, logfmt and key=value parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"testing"
+
+	"github.com/telenornms/skogul/parser"
+)
+
+func TestLogfmt(t *testing.T) {
+	p := parser.Logfmt{Metadata: []string{"level", "host"}, TimeField: "time"}
+	c, err := p.Parse([]byte(`time=2024-05-01T12:00:00Z level=info host=web1 msg="request done: \"ok\"\t" duration=0.12 status=200 cached
+level=warn msg=""
+`))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Time.Unix() != 1714564800 || m.Metadata["level"] != "info" || m.Metadata["host"] != "web1" {
+		t.Errorf("unexpected metric %v %v", m.Time, m.Metadata)
+	}
+	if m.Data["msg"] != "request done: \"ok\"\t" || m.Data["duration"] != 0.12 || m.Data["status"] != int64(200) || m.Data["cached"] != true {
+		t.Errorf("unexpected data %v", m.Data)
+	}
+	if _, ok := m.Data["time"]; ok {
+		t.Errorf("time stored as data")
+	}
+	if c.Metrics[1].Data["msg"] != "" {
+		t.Errorf("expected empty msg, got %v", c.Metrics[1].Data)
+	}
+
+	_, err = p.Parse([]byte("ok=1\nmsg=\"unterminated\n"))
+	if err == nil {
+		t.Errorf("Parse() of unterminated quote did not fail")
+	}
+}
+
+func TestKV(t *testing.T) {
+	p := parser.KV{Separator: ",", Assign: ":", Quote: "'", Metadata: []string{"id"}}
+	if err := p.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	c, err := p.Parse([]byte(`id:7, name:'it\'s, here', temp:21.5, version:'1.0'`))
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	m := c.Metrics[0]
+	if m.Metadata["id"] != int64(7) || m.Data["name"] != "it's, here" || m.Data["temp"] != 21.5 || m.Data["version"] != "1.0" {
+		t.Errorf("unexpected metric %v %v", m.Metadata, m.Data)
+	}
+	if err := (&parser.KV{Separator: "=", Assign: "="}).Verify(); err == nil {
+		t.Errorf("Verify() with equal separators did not fail")
+	}
+}
@@ -0,0 +1,217 @@
+/*
+ * skogul, MessagePack and CBOR parsers
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"reflect"
+
+	"github.com/fxamacker/cbor/v2"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+MsgPack parses a MessagePack-encoded Skogul container, as written by the
+msgpack encoder. The layout is the same as the Skogul JSON format: a map
+with "metrics", holding maps with "timestamp", "metadata" and "data".
+Timestamps use the MessagePack timestamp extension type. Unlike JSON,
+integers decode as int64 (or uint64 if too large), and floats as float64.
+*/
+type MsgPack struct{}
+
+// MsgPackMetric parses a single MessagePack-encoded Skogul metric, and
+// wraps it in a container.
+type MsgPackMetric struct{}
+
+// msgpackMaxDepth limits how deeply arrays and maps can nest.
+const msgpackMaxDepth = 1000
+
+var errMsgpackShort = errors.New("msgpack: declared length exceeds input")
+
+/*
+msgpackCheck walks the encoded bytes without decoding them, and fails if
+any array, map, string or binary header declares more than the remaining
+input can hold. The decoder allocates based on the declared length, so a
+five byte array32 header could otherwise ask for billions of elements.
+
+Every pending element needs at least one byte, so the check is done on
+the total number of elements still expected, not just per header.
+*/
+func msgpackCheck(b []byte) error {
+	pos := 0
+	pending := 1
+	stack := []int{1}
+	for len(stack) > 0 {
+		top := len(stack) - 1
+		if stack[top] == 0 {
+			stack = stack[:top]
+			continue
+		}
+		stack[top]--
+		pending--
+		if pos >= len(b) {
+			return errMsgpackShort
+		}
+		c := b[pos]
+		pos++
+		var skip, elems, lenBytes int
+		switch {
+		case c <= 0x7f || c >= 0xe0, c == 0xc0, c == 0xc2, c == 0xc3:
+		case c >= 0x80 && c <= 0x8f:
+			elems = 2 * int(c&0x0f)
+		case c >= 0x90 && c <= 0x9f:
+			elems = int(c & 0x0f)
+		case c >= 0xa0 && c <= 0xbf:
+			skip = int(c & 0x1f)
+		case c == 0xc4 || c == 0xd9:
+			lenBytes = 1
+		case c == 0xc5 || c == 0xda:
+			lenBytes = 2
+		case c == 0xc6 || c == 0xdb:
+			lenBytes = 4
+		case c == 0xc7:
+			lenBytes, skip = 1, 1
+		case c == 0xc8:
+			lenBytes, skip = 2, 1
+		case c == 0xc9:
+			lenBytes, skip = 4, 1
+		case c == 0xca, c == 0xce, c == 0xd2:
+			skip = 4
+		case c == 0xcb, c == 0xcf, c == 0xd3:
+			skip = 8
+		case c == 0xcc, c == 0xd0:
+			skip = 1
+		case c == 0xcd, c == 0xd1:
+			skip = 2
+		case c >= 0xd4 && c <= 0xd8:
+			skip = 1 + 1<<(c-0xd4)
+		case c == 0xdc || c == 0xde:
+			lenBytes = 2
+		case c == 0xdd || c == 0xdf:
+			lenBytes = 4
+		default:
+			return fmt.Errorf("msgpack: invalid code 0x%x", c)
+		}
+		if lenBytes > 0 {
+			if len(b)-pos < lenBytes {
+				return errMsgpackShort
+			}
+			var n uint64
+			for _, x := range b[pos : pos+lenBytes] {
+				n = n<<8 | uint64(x)
+			}
+			pos += lenBytes
+			if n > uint64(len(b)) {
+				return errMsgpackShort
+			}
+			switch c {
+			case 0xdc, 0xdd:
+				elems = int(n)
+			case 0xde, 0xdf:
+				elems = 2 * int(n)
+			default:
+				skip += int(n)
+			}
+		}
+		if skip > len(b)-pos {
+			return errMsgpackShort
+		}
+		pos += skip
+		if elems > 0 {
+			pending += elems
+			if pending > len(b)-pos {
+				return errMsgpackShort
+			}
+			if len(stack) >= msgpackMaxDepth {
+				return fmt.Errorf("msgpack: nesting deeper than %d", msgpackMaxDepth)
+			}
+			stack = append(stack, elems)
+		}
+	}
+	return nil
+}
+
+func msgpackDecode(b []byte, v interface{}) error {
+	if err := msgpackCheck(b); err != nil {
+		return err
+	}
+	dec := msgpack.NewDecoder(bytes.NewReader(b))
+	dec.SetCustomStructTag("json")
+	dec.UseLooseInterfaceDecoding(true)
+	return dec.Decode(v)
+}
+
+// Parse a MessagePack-encoded container.
+func (x MsgPack) Parse(b []byte) (*skogul.Container, error) {
+	container := skogul.Container{}
+	err := msgpackDecode(b, &container)
+	return &container, err
+}
+
+// Parse a MessagePack-encoded metric.
+func (x MsgPackMetric) Parse(b []byte) (*skogul.Container, error) {
+	metric := skogul.Metric{}
+	err := msgpackDecode(b, &metric)
+	return &skogul.Container{Metrics: []*skogul.Metric{&metric}}, err
+}
+
+/*
+CBOR parses a CBOR-encoded Skogul container, as written by the cbor
+encoder. The layout is the same as the Skogul JSON format. Timestamps
+can be tag 0 (RFC3339) or tag 1 (epoch). Positive integers decode as
+uint64, negative as int64, and floats as float64.
+*/
+type CBOR struct{}
+
+// CBORMetric parses a single CBOR-encoded Skogul metric, and wraps it in
+// a container.
+type CBORMetric struct{}
+
+var cborDecMode cbor.DecMode
+
+func init() {
+	var err error
+	cborDecMode, err = cbor.DecOptions{
+		DefaultMapType: reflect.TypeOf(map[string]interface{}(nil)),
+	}.DecMode()
+	skogul.Assert(err == nil, err)
+}
+
+// Parse a CBOR-encoded container.
+func (x CBOR) Parse(b []byte) (*skogul.Container, error) {
+	container := skogul.Container{}
+	err := cborDecMode.Unmarshal(b, &container)
+	return &container, err
+}
+
+// Parse a CBOR-encoded metric.
+func (x CBORMetric) Parse(b []byte) (*skogul.Container, error) {
+	metric := skogul.Metric{}
+	err := cborDecMode.Unmarshal(b, &metric)
+	return &skogul.Container{Metrics: []*skogul.Metric{&metric}}, err
+}
@@ -0,0 +1,182 @@
+/*
+ * skogul, MessagePack and CBOR parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/encoder"
+	"github.com/telenornms/skogul/parser"
+)
+
+// binaryContainer returns n metrics with a mix of value types. Nested
+// data is left out if nested is false, since GOB can't decode nested
+// interface maps without registration.
+func binaryContainer(n int, nested bool) *skogul.Container {
+	now := time.Date(2024, 5, 1, 12, 0, 0, 123456789, time.UTC)
+	c := skogul.Container{}
+	for i := 0; i < n; i++ {
+		c.Metrics = append(c.Metrics, &skogul.Metric{
+			Time:     &now,
+			Metadata: map[string]interface{}{"host": fmt.Sprintf("host%d", i), "ifname": "xe-0/0/0"},
+			Data: map[string]interface{}{
+				"in_octets":  int64(1234567890123),
+				"out_octets": int64(-42),
+				"util":       0.25,
+				"whole":      2.0,
+				"up":         true,
+				"descr":      "uplink",
+			},
+		})
+		if nested {
+			c.Metrics[i].Data["nested"] = map[string]interface{}{"a": int64(1), "b": []interface{}{"x", 1.5}}
+		}
+	}
+	return &c
+}
+
+func TestBinaryRoundTrip(t *testing.T) {
+	type codec struct {
+		e  skogul.Encoder
+		p  skogul.Parser
+		pm skogul.Parser
+	}
+	for name, x := range map[string]codec{
+		"msgpack": {encoder.MsgPack{}, parser.MsgPack{}, parser.MsgPackMetric{}},
+		"cbor":    {encoder.CBOR{}, parser.CBOR{}, parser.CBORMetric{}},
+	} {
+		orig := binaryContainer(2, true)
+		b, err := x.e.Encode(orig)
+		if err != nil {
+			t.Fatalf("%s: Encode() failed: %v", name, err)
+		}
+		c, err := x.p.Parse(b)
+		if err != nil {
+			t.Fatalf("%s: Parse() failed: %v", name, err)
+		}
+		if len(c.Metrics) != 2 {
+			t.Fatalf("%s: expected 2 metrics, got %d", name, len(c.Metrics))
+		}
+		m := c.Metrics[1]
+		if !m.Time.Equal(*orig.Metrics[1].Time) {
+			t.Errorf("%s: time %v, want %v", name, m.Time, orig.Metrics[1].Time)
+		}
+		if m.Metadata["host"] != "host1" {
+			t.Errorf("%s: unexpected metadata %v", name, m.Metadata)
+		}
+		switch v := m.Data["in_octets"].(type) {
+		case int64:
+			if v != 1234567890123 {
+				t.Errorf("%s: in_octets %v", name, v)
+			}
+		case uint64:
+			if v != 1234567890123 {
+				t.Errorf("%s: in_octets %v", name, v)
+			}
+		default:
+			t.Errorf("%s: in_octets is %T, want an integer", name, v)
+		}
+		if m.Data["out_octets"] != int64(-42) {
+			t.Errorf("%s: out_octets %v (%T)", name, m.Data["out_octets"], m.Data["out_octets"])
+		}
+		if m.Data["whole"] != 2.0 || m.Data["util"] != 0.25 {
+			t.Errorf("%s: floats not kept as float64: %T %T", name, m.Data["whole"], m.Data["util"])
+		}
+		if m.Data["up"] != true || m.Data["descr"] != "uplink" {
+			t.Errorf("%s: unexpected data %v", name, m.Data)
+		}
+		nested, ok := m.Data["nested"].(map[string]interface{})
+		if !ok || len(nested["b"].([]interface{})) != 2 {
+			t.Errorf("%s: nested data not preserved: %#v", name, m.Data["nested"])
+		}
+		if err := c.Validate(false); err != nil {
+			t.Errorf("%s: parsed container doesn't validate: %v", name, err)
+		}
+
+		b, err = x.e.EncodeMetric(orig.Metrics[0])
+		if err != nil {
+			t.Fatalf("%s: EncodeMetric() failed: %v", name, err)
+		}
+		c, err = x.pm.Parse(b)
+		if err != nil || len(c.Metrics) != 1 || c.Metrics[0].Metadata["host"] != "host0" {
+			t.Errorf("%s: metric round trip failed: %v %v", name, err, c)
+		}
+
+		if _, err := x.p.Parse([]byte("garbage")); err == nil {
+			t.Errorf("%s: Parse() of garbage did not fail", name)
+		}
+	}
+}
+
+// TestMsgPackHugeLength checks that length headers larger than the input
+// are rejected, instead of making the decoder allocate for them.
+func TestMsgPackHugeLength(t *testing.T) {
+	cases := map[string][]byte{
+		"array32": append([]byte{0x81, 0xa7}, append([]byte("metrics"), 0xdd, 0xff, 0xff, 0xff, 0xf0)...),
+		"map32":   {0xdf, 0xff, 0xff, 0xff, 0xf0},
+		"str32":   {0x81, 0xdb, 0xff, 0xff, 0xff, 0xf0},
+		"nested":  {0x91, 0x91, 0x91, 0xdc, 0x00, 0x10, 0xc0, 0xc0},
+	}
+	for name, b := range cases {
+		if _, err := (parser.MsgPack{}).Parse(b); err == nil {
+			t.Errorf("%s: Parse() did not fail", name)
+		}
+		if _, err := (parser.MsgPackMetric{}).Parse(b); err == nil {
+			t.Errorf("%s: metric Parse() did not fail", name)
+		}
+	}
+}
+
+func benchmarkParse(b *testing.B, e skogul.Encoder, p skogul.Parser) {
+	data, err := e.Encode(binaryContainer(100, false))
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.SetBytes(int64(len(data)))
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := p.Parse(data); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkBinaryParse_json(b *testing.B) {
+	benchmarkParse(b, encoder.JSON{}, parser.SkogulJSON{})
+}
+
+func BenchmarkBinaryParse_gob(b *testing.B) {
+	benchmarkParse(b, encoder.GOB{}, parser.GOB{})
+}
+
+func BenchmarkBinaryParse_msgpack(b *testing.B) {
+	benchmarkParse(b, encoder.MsgPack{}, parser.MsgPack{})
+}
+
+func BenchmarkBinaryParse_cbor(b *testing.B) {
+	benchmarkParse(b, encoder.CBOR{}, parser.CBOR{})
+}
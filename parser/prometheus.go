@@ -24,62 +24,381 @@ package parser
 
 import (
 	"bytes"
-	"encoding/json"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"time"
 
+	dto "github.com/prometheus/client_model/go"
 	"github.com/prometheus/common/expfmt"
 	"github.com/telenornms/skogul"
 )
 
+// Metadata keys used for the family type and help text. Label names
+// starting with "__" are reserved by Prometheus, so these will never
+// collide with a real label.
+const (
+	PrometheusTypeKey = "__type__"
+	PrometheusHelpKey = "__help__"
+)
+
+/*
+Prometheus parses the Prometheus text exposition format, and the
+OpenMetrics text format, into a container with one metric per sample.
+
+Counters, gauges and untyped samples are stored with the family name as
+the data key. Histograms are expanded into one metric per bucket, with
+the upper bound as the "le" metadata field and the cumulative count as
+<name>_bucket, and one metric holding <name>_sum and <name>_count.
+Summaries are expanded the same way, with one metric per quantile and the
+"quantile" metadata field.
+
+All labels are stored as metadata, along with the family type and
+non-empty help text (see PrometheusTypeKey and PrometheusHelpKey). The
+type is the one declared in the document, so OpenMetrics types such as
+unknown, info, stateset and gaugehistogram are kept. Gauge histograms
+are expanded like histograms, with <name>_gsum and <name>_gcount. The
+OpenMetrics _created sample of a counter, histogram or summary is added
+to the metric with the counter value, or sum and count.
+
+Exemplars are accepted but discarded.
+*/
 type Prometheus struct{}
 
 func (data Prometheus) Parse(b []byte) (*skogul.Container, error) {
-	reader := bytes.NewBuffer(b)
 	var parser expfmt.TextParser
-	// parse prometheus metrics
-	mf, err := parser.TextToMetricFamilies(reader)
+	doc := promFromOpenMetrics(b)
+	mf, err := parser.TextToMetricFamilies(bytes.NewReader(doc.text))
+	if err != nil {
+		return nil, err
+	}
+	created, err := doc.parseCreated()
 	if err != nil {
 		return nil, err
 	}
 	container := skogul.Container{
 		Metrics: make([]*skogul.Metric, 0, len(mf)),
 	}
-	tmpMetric := make([]skogul.Metric, len(mf))
-	metadataDict := make(map[string]interface{})
-	dataDict := make(map[string]interface{})
-	var tm time.Time
-	indexCounter := 0
-	for k, v := range mf {
-		for _, i := range v.GetMetric() {
-			for _, l := range i.GetLabel() {
-				metadataDict[l.GetName()] = l.GetValue()
+	now := skogul.Now()
+	for name, family := range mf {
+		ftype := strings.ToLower(family.GetType().String())
+		if t, ok := doc.types[name]; ok {
+			ftype = t
+		}
+		for _, i := range family.GetMetric() {
+			tm := now
+			if i.TimestampMs != nil {
+				tm = time.UnixMilli(i.GetTimestampMs())
 			}
-			// convert int64 timestamp to time.Time
-			tm = time.UnixMilli(i.GetTimestampMs())
-			if !tm.IsZero() {
-				tmpMetric[indexCounter].Time = &tm
-			} else {
-				tm = skogul.Now()
-				tmpMetric[indexCounter].Time = &tm
+			base := func() *skogul.Metric {
+				m := skogul.Metric{
+					Time:     &tm,
+					Metadata: make(map[string]interface{}, len(i.GetLabel())+2),
+					Data:     make(map[string]interface{}),
+				}
+				for _, l := range i.GetLabel() {
+					m.Metadata[l.GetName()] = l.GetValue()
+				}
+				m.Metadata[PrometheusTypeKey] = ftype
+				if family.GetHelp() != "" {
+					m.Metadata[PrometheusHelpKey] = family.GetHelp()
+				}
+				return &m
 			}
-			Metadatastr, _ := json.Marshal(metadataDict)
-			err := json.Unmarshal(Metadatastr, &tmpMetric[indexCounter].Metadata)
-			if err != nil {
-				return nil, err
+			// addCreated adds the _created sample of OpenMetrics
+			// counters, histograms and summaries, if any.
+			addCreated := func(m *skogul.Metric) {
+				if c, ok := created[name][promLabelKey(i.GetLabel())]; ok {
+					m.Data[c.name] = c.value
+				}
 			}
-			// we do not need tmp to iteratate to get to the value. The library offers GetUntyped().Value call to directly get the value.
-			dataDict[k] = i.GetUntyped().Value
-			dataDictstr, _ := json.Marshal(dataDict)
-			err1 := json.Unmarshal(dataDictstr, &tmpMetric[indexCounter].Data)
-			if err1 != nil {
-				return nil, err
+			switch family.GetType() {
+			case dto.MetricType_COUNTER:
+				m := base()
+				m.Data[name] = i.GetCounter().GetValue()
+				addCreated(m)
+				container.Metrics = append(container.Metrics, m)
+			case dto.MetricType_GAUGE:
+				m := base()
+				m.Data[name] = i.GetGauge().GetValue()
+				container.Metrics = append(container.Metrics, m)
+			case dto.MetricType_UNTYPED:
+				m := base()
+				m.Data[name] = i.GetUntyped().GetValue()
+				container.Metrics = append(container.Metrics, m)
+			case dto.MetricType_HISTOGRAM:
+				h := i.GetHistogram()
+				for _, bucket := range h.GetBucket() {
+					m := base()
+					m.Metadata["le"] = promFormatFloat(bucket.GetUpperBound())
+					m.Data[name+"_bucket"] = bucket.GetCumulativeCount()
+					container.Metrics = append(container.Metrics, m)
+				}
+				// Gauge histograms are parsed as histograms, but
+				// keep their own names for sum and count.
+				sum, count := "_sum", "_count"
+				if ftype == "gaugehistogram" {
+					sum, count = "_gsum", "_gcount"
+				}
+				m := base()
+				m.Data[name+sum] = h.GetSampleSum()
+				m.Data[name+count] = h.GetSampleCount()
+				addCreated(m)
+				container.Metrics = append(container.Metrics, m)
+			case dto.MetricType_SUMMARY:
+				s := i.GetSummary()
+				for _, q := range s.GetQuantile() {
+					m := base()
+					m.Metadata["quantile"] = promFormatFloat(q.GetQuantile())
+					m.Data[name] = q.GetValue()
+					container.Metrics = append(container.Metrics, m)
+				}
+				m := base()
+				m.Data[name+"_sum"] = s.GetSampleSum()
+				m.Data[name+"_count"] = s.GetSampleCount()
+				addCreated(m)
+				container.Metrics = append(container.Metrics, m)
 			}
-			container.Metrics = append(container.Metrics, &tmpMetric[indexCounter])
-			// clean up the old values of the dictionary so that they don't get carried to the next iteration.
-			metadataDict = make(map[string]interface{})
-			dataDict = make(map[string]interface{})
-			indexCounter++
 		}
 	}
-	return &container, err
+	return &container, nil
+}
+
+// promFormatFloat formats le and quantile labels the way Prometheus does,
+// e.g. "0.5" and "+Inf".
+func promFormatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// promDoc is a document rewritten by promFromOpenMetrics.
+type promDoc struct {
+	text    []byte            // The document, for the text parser
+	created []byte            // The _created samples, taken out of text
+	types   map[string]string // Declared type of families, by their name in text
+	owners  map[string]string // The family each _created sample belongs to
+}
+
+// promCreated is the _created sample of a family.
+type promCreated struct {
+	name  string
+	value float64
+}
+
+/*
+promFromOpenMetrics rewrites the OpenMetrics specific parts of a document
+into something the Prometheus text parser accepts. Plain Prometheus text is
+returned more or less untouched.
+
+Specifically, it:
+
+  - Stops at "# EOF".
+  - Strips exemplars from sample lines.
+  - Maps the OpenMetrics-only types to their closest text-format
+    equivalent: unknown to untyped, gaugehistogram to histogram (with
+    _gcount and _gsum renamed to _count and _sum) and info/stateset to
+    gauge. The declared types are kept in types, so the original type
+    ends up in the metadata.
+  - Renames counter and info families to <name>_total and <name>_info if
+    that is what the samples are called, since the text parser requires
+    an exact match.
+  - Moves the _created samples of counters, histograms and summaries to a
+    separate document, so they can be added to their family instead of
+    showing up as separate untyped metrics.
+  - Converts timestamps from seconds to milliseconds, but only if the
+    document has "# EOF" and is thus known to be OpenMetrics.
+*/
+func promFromOpenMetrics(b []byte) promDoc {
+	lines := bytes.Split(b, []byte("\n"))
+	openMetrics := false
+	samples := make(map[string]bool)
+	declared := make(map[string]string)
+	for idx, line := range lines {
+		line = bytes.TrimSpace(line)
+		if string(line) == "# EOF" {
+			openMetrics = true
+			lines = lines[:idx]
+			break
+		}
+		if len(line) == 0 {
+			continue
+		}
+		if line[0] != '#' {
+			samples[string(promSampleName(line))] = true
+			continue
+		}
+		if fields := strings.Fields(string(line)); len(fields) == 4 && fields[1] == "TYPE" {
+			declared[fields[2]] = fields[3]
+		}
+	}
+
+	doc := promDoc{types: make(map[string]string), owners: make(map[string]string)}
+	renames := make(map[string]string)
+	samplesRenames := make(map[string]string)
+	for name, t := range declared {
+		text := name
+		suffix := map[string]string{"counter": "_total", "info": "_info"}[t]
+		if suffix != "" && !samples[name] && samples[name+suffix] {
+			text = name + suffix
+			renames[name] = text
+		}
+		doc.types[text] = t
+		switch t {
+		case "counter", "histogram", "summary":
+			doc.owners[strings.TrimSuffix(name, "_total")+"_created"] = text
+		case "gaugehistogram":
+			samplesRenames[name+"_gcount"] = name + "_count"
+			samplesRenames[name+"_gsum"] = name + "_sum"
+		}
+	}
+
+	out := make([]byte, 0, len(b))
+	for _, line := range lines {
+		trimmed := bytes.TrimSpace(line)
+		if len(trimmed) == 0 {
+			continue
+		}
+		if trimmed[0] == '#' {
+			out = append(out, promRewriteComment(trimmed, renames)...)
+			out = append(out, '\n')
+			continue
+		}
+		name := string(promSampleName(trimmed))
+		if _, ok := doc.owners[name]; ok {
+			doc.created = append(doc.created, promRewriteSample(trimmed, openMetrics)...)
+			doc.created = append(doc.created, '\n')
+			continue
+		}
+		if rename, ok := samplesRenames[name]; ok {
+			trimmed = append([]byte(rename), trimmed[len(name):]...)
+		}
+		out = append(out, promRewriteSample(trimmed, openMetrics)...)
+		out = append(out, '\n')
+	}
+	doc.text = out
+	return doc
+}
+
+// parseCreated parses the _created samples, returning them by the family
+// they belong to and the labels of the sample.
+func (doc promDoc) parseCreated() (map[string]map[string]promCreated, error) {
+	if len(doc.created) == 0 {
+		return nil, nil
+	}
+	var parser expfmt.TextParser
+	mf, err := parser.TextToMetricFamilies(bytes.NewReader(doc.created))
+	if err != nil {
+		return nil, err
+	}
+	created := make(map[string]map[string]promCreated)
+	for name, family := range mf {
+		owner := doc.owners[name]
+		if created[owner] == nil {
+			created[owner] = make(map[string]promCreated)
+		}
+		for _, i := range family.GetMetric() {
+			created[owner][promLabelKey(i.GetLabel())] = promCreated{name, i.GetUntyped().GetValue()}
+		}
+	}
+	return created, nil
+}
+
+// promLabelKey returns a string identifying a label set.
+func promLabelKey(labels []*dto.LabelPair) string {
+	pairs := make([]string, 0, len(labels))
+	for _, l := range labels {
+		pairs = append(pairs, l.GetName()+"\xff"+l.GetValue())
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, "\xfe")
+}
+
+// promSampleName returns the metric name of a sample line.
+func promSampleName(line []byte) []byte {
+	end := bytes.IndexAny(line, "{ \t")
+	if end == -1 {
+		return line
+	}
+	return line[:end]
+}
+
+// promRewriteComment rewrites HELP and TYPE lines, see promFromOpenMetrics.
+func promRewriteComment(line []byte, renames map[string]string) []byte {
+	fields := strings.Fields(string(line))
+	if len(fields) < 3 || (fields[1] != "HELP" && fields[1] != "TYPE") {
+		return line
+	}
+	name := fields[2]
+	if rename, ok := renames[name]; ok {
+		fields[2] = rename
+	}
+	if fields[1] == "TYPE" && len(fields) == 4 {
+		switch fields[3] {
+		case "unknown":
+			fields[3] = "untyped"
+		case "gaugehistogram":
+			fields[3] = "histogram"
+		case "info", "stateset":
+			fields[3] = "gauge"
+		}
+		return []byte(strings.Join(fields, " "))
+	}
+	if fields[2] == name {
+		return line
+	}
+	// HELP text, keep the original spacing of the text itself.
+	idx := bytes.Index(line, []byte(name))
+	ret := make([]byte, 0, len(line)+6)
+	ret = append(ret, line[:idx]...)
+	ret = append(ret, fields[2]...)
+	return append(ret, line[idx+len(name):]...)
+}
+
+// promRewriteSample strips exemplars and, for OpenMetrics, converts the
+// timestamp to milliseconds.
+func promRewriteSample(line []byte, openMetrics bool) []byte {
+	// Skip past the label set, if any, since label values can contain
+	// anything, including " # ".
+	start := 0
+	if brace := bytes.IndexByte(line, '{'); brace != -1 && brace < bytes.IndexAny(line, " \t") {
+		quoted := false
+		escaped := false
+		for i := brace; i < len(line); i++ {
+			c := line[i]
+			if escaped {
+				escaped = false
+				continue
+			}
+			if c == '\\' {
+				escaped = true
+			} else if c == '"' {
+				quoted = !quoted
+			} else if c == '}' && !quoted {
+				start = i + 1
+				break
+			}
+		}
+	}
+	if ex := bytes.Index(line[start:], []byte(" # ")); ex != -1 {
+		line = bytes.TrimSpace(line[:start+ex])
+	}
+	if !openMetrics {
+		return line
+	}
+	rest := strings.Fields(string(line[start:]))
+	if start == 0 {
+		rest = rest[1:]
+	}
+	if len(rest) != 2 {
+		return line
+	}
+	ts, err := strconv.ParseFloat(rest[1], 64)
+	if err != nil {
+		return line
+	}
+	ms := strconv.FormatInt(int64(math.Round(ts*1000)), 10)
+	ret := make([]byte, 0, len(line))
+	ret = append(ret, bytes.TrimSpace(line[:bytes.LastIndex(line, []byte(rest[1]))])...)
+	ret = append(ret, ' ')
+	return append(ret, ms...)
 }
@@ -116,7 +116,10 @@ func TestPrometheus(t *testing.T) {
 		t.Logf("Expected parsed prometheus to return a data field value %v", m3.Data[data3Key])
 		t.FailNow()
 	}
-	if len(m1.Metadata) != 3 || len(m2.Metadata) != 0 || len(m3.Metadata) != 3 {
+	if m1.Metadata[parser.PrometheusTypeKey] != "untyped" {
+		t.Errorf("Expected type untyped, got %v", m1.Metadata[parser.PrometheusTypeKey])
+	}
+	if len(m1.Metadata) != 4 || len(m2.Metadata) != 1 || len(m3.Metadata) != 4 {
 		t.Logf("container: %s", container.Describe())
 		t.Logf("Length of the container Metrics Metadata fields are not correct %v, %v, %v", len(m1.Metadata), len(m2.Metadata), len(m3.Metadata))
 		t.FailNow()
@@ -127,3 +130,176 @@ func TestPrometheus(t *testing.T) {
 		t.FailNow()
 	}
 }
+
+// findProm returns the first metric with the data key and matching
+// metadata, or nil.
+func findProm(c *skogul.Container, key string, md map[string]string) *skogul.Metric {
+outer:
+	for _, m := range c.Metrics {
+		if m.Data[key] == nil {
+			continue
+		}
+		for k, v := range md {
+			if m.Metadata[k] != v {
+				continue outer
+			}
+		}
+		return m
+	}
+	return nil
+}
+
+func TestPrometheusTypes(t *testing.T) {
+	b, err := os.ReadFile("./testdata/prometheus_types")
+	if err != nil {
+		t.Fatalf("Failed to read test data file: %v", err)
+	}
+	p := parser.Prometheus{}
+	c, err := p.Parse(b)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	// 2 counters, 1 gauge, 3 buckets + sum/count, 2 quantiles + sum/count
+	if len(c.Metrics) != 10 {
+		t.Errorf("Expected 10 metrics, got %d", len(c.Metrics))
+	}
+	m := findProm(c, "http_requests_total", map[string]string{"code": "400"})
+	if m == nil {
+		t.Fatalf("Missing counter metric")
+	}
+	if m.Data["http_requests_total"] != float64(3) {
+		t.Errorf("Expected counter value 3, got %v", m.Data["http_requests_total"])
+	}
+	if m.Metadata[parser.PrometheusTypeKey] != "counter" {
+		t.Errorf("Expected type counter, got %v", m.Metadata[parser.PrometheusTypeKey])
+	}
+	if m.Metadata[parser.PrometheusHelpKey] != "The total number of HTTP requests." {
+		t.Errorf("Unexpected help text %v", m.Metadata[parser.PrometheusHelpKey])
+	}
+	if m.Time.UnixMilli() != 1395066363000 {
+		t.Errorf("Unexpected timestamp %v", m.Time)
+	}
+	m = findProm(c, "temperature_celsius", map[string]string{"sensor": "cpu", parser.PrometheusTypeKey: "gauge"})
+	if m == nil || m.Data["temperature_celsius"] != 42.5 {
+		t.Errorf("Missing or wrong gauge metric: %v", m)
+	}
+	m = findProm(c, "http_request_duration_seconds_bucket", map[string]string{"le": "+Inf"})
+	if m == nil || m.Data["http_request_duration_seconds_bucket"] != uint64(144320) {
+		t.Errorf("Missing or wrong +Inf bucket: %v", m)
+	}
+	m = findProm(c, "http_request_duration_seconds_bucket", map[string]string{"le": "0.05"})
+	if m == nil || m.Data["http_request_duration_seconds_bucket"] != uint64(24054) {
+		t.Errorf("Missing or wrong 0.05 bucket: %v", m)
+	}
+	m = findProm(c, "http_request_duration_seconds_sum", nil)
+	if m == nil || m.Data["http_request_duration_seconds_count"] != uint64(144320) || m.Data["http_request_duration_seconds_sum"] != float64(53423) {
+		t.Errorf("Missing or wrong histogram sum/count: %v", m)
+	}
+	if m != nil && m.Metadata["le"] != nil {
+		t.Errorf("Histogram sum/count should not have an le label")
+	}
+	m = findProm(c, "rpc_duration_seconds", map[string]string{"quantile": "0.99", parser.PrometheusTypeKey: "summary"})
+	if m == nil || m.Data["rpc_duration_seconds"] != float64(76656) {
+		t.Errorf("Missing or wrong quantile: %v", m)
+	}
+	m = findProm(c, "rpc_duration_seconds_count", nil)
+	if m == nil || m.Data["rpc_duration_seconds_count"] != uint64(2693) {
+		t.Errorf("Missing or wrong summary count: %v", m)
+	}
+}
+
+func TestPrometheusOpenMetrics(t *testing.T) {
+	b, err := os.ReadFile("./testdata/openmetrics_testdata")
+	if err != nil {
+		t.Fatalf("Failed to read test data file: %v", err)
+	}
+	p := parser.Prometheus{}
+	c, err := p.Parse(b)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	m := findProm(c, "go_goroutines", map[string]string{parser.PrometheusTypeKey: "unknown"})
+	if m == nil || m.Data["go_goroutines"] != float64(69) {
+		t.Errorf("Missing or wrong unknown-typed metric: %v", m)
+	}
+	m = findProm(c, "process_cpu_seconds_total", map[string]string{parser.PrometheusTypeKey: "counter"})
+	if m == nil {
+		t.Fatalf("Missing counter")
+	}
+	if m.Time.UnixMilli() != 1520879607789 {
+		t.Errorf("Expected timestamp in seconds to be converted, got %v", m.Time.UnixMilli())
+	}
+	if m.Metadata[parser.PrometheusHelpKey] != "Total user and system CPU time spent in seconds." {
+		t.Errorf("Unexpected help text %v", m.Metadata[parser.PrometheusHelpKey])
+	}
+	m = findProm(c, "foo_bucket", map[string]string{"le": "0.1", "id": "a # b"})
+	if m == nil || m.Data["foo_bucket"] != uint64(8) {
+		t.Errorf("Missing or wrong bucket with exemplar: %v", m)
+	}
+	m = findProm(c, "acme_http_router_request_seconds_count", map[string]string{"method": "GET"})
+	if m == nil || m.Data["acme_http_router_request_seconds_count"] != uint64(807283) {
+		t.Errorf("Missing or wrong summary count: %v", m)
+	}
+}
+
+func TestPrometheusOpenMetricsTypes(t *testing.T) {
+	b := []byte(`# TYPE queue gaugehistogram
+queue_bucket{le="1.0"} 2
+queue_bucket{le="+Inf"} 5
+queue_gcount 5
+queue_gsum 7.5
+# TYPE build info
+build_info{version="1.2"} 1
+# TYPE state stateset
+state{state="up"} 1
+state{state="down"} 0
+# TYPE requests counter
+requests_total{code="200"} 10
+requests_created{code="200"} 1520430000.123
+requests_total{code="500"} 1
+# TYPE latency histogram
+latency_bucket{le="+Inf"} 3
+latency_count 3
+latency_sum 1.5
+latency_created 1520430000
+# EOF
+`)
+	c, err := parser.Prometheus{}.Parse(b)
+	if err != nil {
+		t.Fatalf("Failed to parse: %v", err)
+	}
+	m := findProm(c, "queue_gcount", map[string]string{parser.PrometheusTypeKey: "gaugehistogram"})
+	if m == nil || m.Data["queue_gcount"] != uint64(5) || m.Data["queue_gsum"] != 7.5 || len(m.Data) != 2 {
+		t.Errorf("Missing or wrong gauge histogram: %v", m)
+	}
+	if m := findProm(c, "queue_bucket", map[string]string{"le": "1", parser.PrometheusTypeKey: "gaugehistogram"}); m == nil || m.Data["queue_bucket"] != uint64(2) {
+		t.Errorf("Missing or wrong gauge histogram bucket: %v", m)
+	}
+	if m := findProm(c, "build_info", map[string]string{"version": "1.2", parser.PrometheusTypeKey: "info"}); m == nil || m.Data["build_info"] != float64(1) {
+		t.Errorf("Missing or wrong info metric: %v", m)
+	}
+	if m := findProm(c, "state", map[string]string{"state": "down", parser.PrometheusTypeKey: "stateset"}); m == nil {
+		t.Errorf("Missing stateset metric")
+	}
+	m = findProm(c, "requests_total", map[string]string{"code": "200", parser.PrometheusTypeKey: "counter"})
+	if m == nil || m.Data["requests_created"] != 1520430000.123 {
+		t.Errorf("Missing or wrong counter with created time: %v", m)
+	}
+	m = findProm(c, "requests_total", map[string]string{"code": "500"})
+	if m == nil || m.Data["requests_created"] != nil {
+		t.Errorf("Counter without created time got one: %v", m)
+	}
+	if m := findProm(c, "latency_count", nil); m == nil || m.Data["latency_created"] != float64(1520430000) {
+		t.Errorf("Missing or wrong histogram created time: %v", m)
+	}
+	for _, m := range c.Metrics {
+		for _, key := range []string{"queue_gcount", "queue_gsum", "requests_created", "latency_created"} {
+			if _, ok := m.Data[key]; ok && m.Metadata[parser.PrometheusTypeKey] == "untyped" {
+				t.Errorf("%s parsed as a separate untyped metric: %v", key, m)
+			}
+		}
+	}
+	if len(c.Metrics) != 10 {
+		t.Errorf("Expected 10 metrics, got %d", len(c.Metrics))
+	}
+}
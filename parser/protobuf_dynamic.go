@@ -0,0 +1,181 @@
+/*
+ * skogul, schema-driven protobuf parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/internal/protodyn"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+ProtobufDynamic parses arbitrary protobuf messages, using message
+definitions loaded at run time instead of generated code. The schema is
+either a compiled FileDescriptorSet, e.g. from
+"protoc --include_imports --descriptor_set_out=x.pb x.proto", or the
+.proto files themselves.
+*/
+type ProtobufDynamic struct {
+	DescriptorSet string            `doc:"Path to a compiled FileDescriptorSet, as written by protoc --descriptor_set_out."`
+	Proto         []string          `doc:"Paths to .proto files to compile, as an alternative to DescriptorSet. Relative to ImportPaths, if set."`
+	ImportPaths   []string          `doc:"Directories to search for .proto files and their imports. The well-known types (google/protobuf/*.proto) are always available."`
+	Message       string            `doc:"Fully qualified name of the message type to decode, e.g. example.telemetry.Measurement."`
+	Split         string            `doc:"Path to a repeated message field, e.g. \"points\". If set, each element becomes a separate metric, and the paths for Metadata, Data and Timestamp are relative to the element."`
+	Metadata      map[string]string `doc:"Map of metadata keys to the path of the protobuf field to use, with dots between nested fields, e.g. {\"host\": \"header.hostname\"}." example:"{\"host\": \"header.hostname\", \"interface\": \"if_name\"}"`
+	Data          map[string]string `doc:"Map of data keys to field paths, like Metadata. If left empty, all fields are used as data, except those used for metadata and the timestamp, keeping the nested structure."`
+	Timestamp     string            `doc:"Path to the field holding the time of the metric. Can be a google.protobuf.Timestamp, a RFC3339 string or a number, see TimestampUnit. If unset or not present, the current time is used."`
+	TimestampUnit string            `doc:"Unit of numeric timestamps: s, ms, us or ns. Defaults to s."`
+	Delimited     bool              `doc:"Treat the input as a stream of messages, each prefixed by its length as a varint, as written by writeDelimitedTo() in Java and similar."`
+	once          sync.Once
+	desc          protoreflect.MessageDescriptor
+	err           error
+}
+
+// Verify loads the schema to catch errors early, without keeping it.
+func (x *ProtobufDynamic) Verify() error {
+	if x.Message == "" {
+		return skogul.MissingArgument("Message")
+	}
+	if x.DescriptorSet == "" && len(x.Proto) == 0 {
+		return skogul.MissingArgument("DescriptorSet or Proto")
+	}
+	if err := protodyn.VerifyUnit(x.TimestampUnit); err != nil {
+		return err
+	}
+	_, err := protodyn.Load(x.DescriptorSet, x.Proto, x.ImportPaths, x.Message)
+	return err
+}
+
+// Parse decodes one message, or a stream of them if Delimited is set.
+func (x *ProtobufDynamic) Parse(b []byte) (*skogul.Container, error) {
+	x.once.Do(func() {
+		x.desc, x.err = protodyn.Load(x.DescriptorSet, x.Proto, x.ImportPaths, x.Message)
+	})
+	if x.err != nil {
+		return nil, fmt.Errorf("unable to load schema: %w", x.err)
+	}
+	msgs := [][]byte{b}
+	if x.Delimited {
+		var err error
+		if msgs, err = protodyn.SplitDelimited(b); err != nil {
+			return nil, err
+		}
+	}
+	c := skogul.Container{}
+	for _, mb := range msgs {
+		m := protodyn.New(x.desc)
+		if err := proto.Unmarshal(mb, m.Interface()); err != nil {
+			return nil, fmt.Errorf("unable to decode %s: %w", x.Message, err)
+		}
+		if x.Split == "" {
+			metric, err := x.metric(m)
+			if err != nil {
+				return nil, err
+			}
+			c.Metrics = append(c.Metrics, metric)
+			continue
+		}
+		v, fd, ok := protodyn.Get(m, protodyn.Path(x.Split))
+		if !ok {
+			continue
+		}
+		if !fd.IsList() || fd.Message() == nil {
+			return nil, fmt.Errorf("split field %s is not a repeated message", x.Split)
+		}
+		l := v.List()
+		for i := 0; i < l.Len(); i++ {
+			metric, err := x.metric(l.Get(i).Message())
+			if err != nil {
+				return nil, err
+			}
+			c.Metrics = append(c.Metrics, metric)
+		}
+	}
+	return &c, nil
+}
+
+// metric converts a single message to a metric
+func (x *ProtobufDynamic) metric(m protoreflect.Message) (*skogul.Metric, error) {
+	metric := skogul.Metric{
+		Metadata: make(map[string]interface{}),
+	}
+	if x.Timestamp != "" {
+		if v, fd, ok := protodyn.Get(m, protodyn.Path(x.Timestamp)); ok {
+			t, err := protodyn.Time(fd, v, x.TimestampUnit)
+			if err != nil {
+				return nil, err
+			}
+			metric.Time = &t
+		}
+	}
+	if metric.Time == nil {
+		now := skogul.Now()
+		metric.Time = &now
+	}
+	for key, path := range x.Metadata {
+		if v, fd, ok := protodyn.Get(m, protodyn.Path(path)); ok {
+			if val, ok := protodyn.Value(fd, v); ok {
+				metric.Metadata[key] = val
+			}
+		}
+	}
+	if len(x.Data) > 0 {
+		metric.Data = make(map[string]interface{})
+		for key, path := range x.Data {
+			if v, fd, ok := protodyn.Get(m, protodyn.Path(path)); ok {
+				if val, ok := protodyn.Value(fd, v); ok {
+					metric.Data[key] = val
+				}
+			}
+		}
+		return &metric, nil
+	}
+	metric.Data = protodyn.ToMap(m)
+	for _, path := range x.Metadata {
+		removePath(metric.Data, protodyn.Path(path))
+	}
+	if x.Timestamp != "" {
+		removePath(metric.Data, protodyn.Path(x.Timestamp))
+	}
+	return &metric, nil
+}
+
+// removePath deletes a nested key from data, if present.
+func removePath(data map[string]interface{}, path []string) {
+	for i, key := range path {
+		if i == len(path)-1 {
+			delete(data, key)
+			return
+		}
+		next, ok := data[key].(map[string]interface{})
+		if !ok {
+			return
+		}
+		data = next
+	}
+}
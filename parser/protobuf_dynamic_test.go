@@ -0,0 +1,203 @@
+/*
+ * skogul, test dynamic protobuf parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/bufbuild/protocompile"
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/encoder"
+	"github.com/telenornms/skogul/parser"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+const dynProtoDir = "../docs/examples/protobuf_dynamic"
+
+func dynTestContainer() *skogul.Container {
+	now := time.Date(2024, 6, 1, 12, 0, 0, 500, time.UTC)
+	c := skogul.Container{}
+	for _, name := range []string{"xe-0/0/0", "xe-0/0/1"} {
+		c.Metrics = append(c.Metrics, &skogul.Metric{
+			Time:     &now,
+			Metadata: map[string]interface{}{"interface": name, "host": "router1"},
+			Data: map[string]interface{}{
+				"in_octets":   float64(1000),
+				"out_octets":  uint64(1 << 60),
+				"utilization": 0.5,
+				"status":      "UP",
+				"tags":        []interface{}{"core", "uplink"},
+			},
+		})
+	}
+	return &c
+}
+
+func TestProtobufDynamic(t *testing.T) {
+	enc := encoder.ProtobufDynamic{
+		Proto:       []string{"measurement.proto"},
+		ImportPaths: []string{dynProtoDir},
+		Message:     "example.telemetry.Point",
+		Timestamp:   "time",
+		Metadata:    map[string]string{"interface": "if_name"},
+		Delimited:   true,
+	}
+	if err := enc.Verify(); err != nil {
+		t.Fatalf("encoder.Verify() failed: %v", err)
+	}
+	b, err := enc.Encode(dynTestContainer())
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	p := parser.ProtobufDynamic{
+		Proto:       []string{"measurement.proto"},
+		ImportPaths: []string{dynProtoDir},
+		Message:     "example.telemetry.Point",
+		Timestamp:   "time",
+		Metadata:    map[string]string{"interface": "if_name"},
+		Delimited:   true,
+	}
+	if err := p.Verify(); err != nil {
+		t.Fatalf("parser.Verify() failed: %v", err)
+	}
+	c, err := p.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[1]
+	if m.Metadata["interface"] != "xe-0/0/1" {
+		t.Errorf("Expected interface xe-0/0/1, got %v", m.Metadata)
+	}
+	if _, ok := m.Metadata["host"]; ok {
+		t.Errorf("Unmapped metadata host was encoded: %v", m.Metadata)
+	}
+	if !m.Time.Equal(*dynTestContainer().Metrics[0].Time) {
+		t.Errorf("Expected time %v, got %v", dynTestContainer().Metrics[0].Time, m.Time)
+	}
+	want := map[string]interface{}{
+		"in_octets":   uint64(1000),
+		"out_octets":  uint64(1 << 60),
+		"utilization": 0.5,
+		"status":      "UP",
+	}
+	for k, v := range want {
+		if m.Data[k] != v {
+			t.Errorf("Expected data %s to be %T(%v), got %T(%v)", k, v, v, m.Data[k], m.Data[k])
+		}
+	}
+	if tags, ok := m.Data["tags"].([]interface{}); !ok || len(tags) != 2 || tags[1] != "uplink" {
+		t.Errorf("Expected tags [core uplink], got %v", m.Data["tags"])
+	}
+	if _, ok := m.Data["if_name"]; ok {
+		t.Errorf("Metadata field if_name also present in data: %v", m.Data)
+	}
+	if _, ok := m.Data["time"]; ok {
+		t.Errorf("Timestamp field present in data: %v", m.Data)
+	}
+}
+
+// writeDescriptorSet compiles the example schema to a FileDescriptorSet,
+// like protoc --descriptor_set_out without --include_imports would.
+func writeDescriptorSet(t *testing.T) string {
+	t.Helper()
+	compiler := protocompile.Compiler{
+		Resolver: protocompile.WithStandardImports(&protocompile.SourceResolver{ImportPaths: []string{dynProtoDir}}),
+	}
+	files, err := compiler.Compile(context.Background(), "measurement.proto")
+	if err != nil {
+		t.Fatalf("compiling schema failed: %v", err)
+	}
+	set := descriptorpb.FileDescriptorSet{}
+	for _, f := range files {
+		set.File = append(set.File, protodesc.ToFileDescriptorProto(f))
+	}
+	b, err := proto.Marshal(&set)
+	if err != nil {
+		t.Fatalf("marshalling descriptor set failed: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "measurement.pb")
+	if err := os.WriteFile(path, b, 0644); err != nil {
+		t.Fatalf("writing descriptor set failed: %v", err)
+	}
+	return path
+}
+
+func TestProtobufDynamic_split(t *testing.T) {
+	set := writeDescriptorSet(t)
+	enc := encoder.ProtobufDynamic{
+		DescriptorSet: set,
+		Message:       "example.telemetry.Batch",
+		Split:         "points",
+		Timestamp:     "time",
+		Metadata:      map[string]string{"interface": "if_name"},
+		Data:          map[string]string{"in_octets": "in_octets", "status": "status"},
+	}
+	b, err := enc.Encode(dynTestContainer())
+	if err != nil {
+		t.Fatalf("Encode() failed: %v", err)
+	}
+	p := parser.ProtobufDynamic{
+		DescriptorSet: set,
+		Message:       "example.telemetry.Batch",
+		Split:         "points",
+		Metadata:      map[string]string{"interface": "if_name"},
+		Data:          map[string]string{"octets": "in_octets"},
+		Timestamp:     "time",
+	}
+	c, err := p.Parse(b)
+	if err != nil {
+		t.Fatalf("Parse() failed: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Metadata["interface"] != "xe-0/0/0" || m.Data["octets"] != uint64(1000) || len(m.Data) != 1 {
+		t.Errorf("Unexpected metric %v", m)
+	}
+}
+
+func TestProtobufDynamic_verify(t *testing.T) {
+	bad := []*parser.ProtobufDynamic{
+		{Proto: []string{"measurement.proto"}, ImportPaths: []string{dynProtoDir}},
+		{Message: "example.telemetry.Point"},
+		{Proto: []string{"measurement.proto"}, ImportPaths: []string{dynProtoDir}, Message: "example.telemetry.Nope"},
+		{Proto: []string{"nope.proto"}, Message: "example.telemetry.Point"},
+		{Proto: []string{"measurement.proto"}, ImportPaths: []string{dynProtoDir}, Message: "example.telemetry.Point", TimestampUnit: "days"},
+	}
+	for i, p := range bad {
+		if err := p.Verify(); err == nil {
+			t.Errorf("Verify() of bad config %d succeeded", i)
+		}
+	}
+}
@@ -24,9 +24,7 @@
 package parser
 
 import (
-	"encoding/json"
 	"fmt"
-	"math"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -36,15 +34,19 @@ import (
 
 	"github.com/telenornms/skogul"
 	pb "github.com/telenornms/skogul/gen/junos/telemetry"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/reflect/protoreflect"
 )
 
 var pbLog = skogul.Logger("parser", "protobuf")
 
 // ProtoBuf parses a byte string-representation of a Container
 type ProtoBuf struct {
-	Debug bool `doc:"Logs the entire protobuf-packet if marshaling fails"`
-	once  sync.Once
-	stats *protobufStats
+	Debug       bool `doc:"Logs the entire protobuf-packet if decoding fails"`
+	NativeTypes bool `doc:"Keep integers as integers. By default, all numbers are converted to float64 for compatibility with older versions of Skogul, which loses precision for very large counters."`
+	Split       bool `doc:"Split the sensor data into one flat metric per entity, e.g. per interface or per LSP, using the key fields of the Junos telemetry descriptors as metadata. Nested messages are flattened with an underscore between field names, and the path to the entity is stored in the entity metadata field."`
+	once        sync.Once
+	stats       *protobufStats
 }
 
 type protobufStats struct {
@@ -52,8 +54,7 @@ type protobufStats struct {
 	ParseErrors                  uint64 // Failure to parse the bytes using the protobuf definitions provided
 	MissingExtension             uint64 // Missing Protobuf extension
 	FailedToCastToJuniperMessage uint64 // We assumed it was a Juniper TelemetryStream message, but it failed to cast to it.
-	FailedToJsonMarshal          uint64 // Failed to marshal protobuf data to json (this might fail if the data is not representable in JSON, such as the value '-Inf' as float64)
-	FailedToJsonUnmarshal        uint64 // Failed to marshal JSON data back into skogul.Metric
+	FailedToDecode               uint64 // The extension was not a valid message
 	Parsed                       uint64 // Successful parses
 }
 
@@ -63,8 +64,7 @@ func (x *ProtoBuf) initStats() {
 		ParseErrors:                  0,
 		MissingExtension:             0,
 		FailedToCastToJuniperMessage: 0,
-		FailedToJsonMarshal:          0,
-		FailedToJsonUnmarshal:        0,
+		FailedToDecode:               0,
 		Parsed:                       0,
 	}
 }
@@ -91,17 +91,24 @@ func (x *ProtoBuf) Parse(b []byte) (*skogul.Container, error) {
 		sensorName := parsedProtoBuf.GetSensorName()
 		return nil, fmt.Errorf("unable to extract metadata from protobuf packet. SystemID: %v SensorName: %v: %w", systemID, sensorName, err)
 	}
-	metric.Data, err = x.createData(parsedProtoBuf)
+	container := skogul.Container{}
+	if x.Split {
+		var ext protoreflect.Message
+		ext, err = x.extension(parsedProtoBuf)
+		if err == nil {
+			splitter := junosSplitter{walker: pbWalker{native: x.NativeTypes}, base: metric}
+			container.Metrics = splitter.split(ext)
+		}
+	} else {
+		metric.Data, err = x.createData(parsedProtoBuf)
+		container.Metrics = []*skogul.Metric{&metric}
+	}
 	if err != nil {
 		systemID := parsedProtoBuf.GetSystemId()
 		sensorName := parsedProtoBuf.GetSensorName()
 		return nil, fmt.Errorf("unable to extract data from protobuf packet. SystemID: %v SensorName: %v: %w", systemID, sensorName, err)
 	}
 
-	container := skogul.Container{}
-	container.Metrics = make([]*skogul.Metric, 1)
-	container.Metrics[0] = &metric
-
 	atomic.AddUint64(&x.stats.Parsed, 1)
 	return &container, err
 }
@@ -133,62 +140,28 @@ func (x *ProtoBuf) createMetadata(telemetry *pb.TelemetryStream) (map[string]int
 }
 
 /*
-applyJuniperHaxx adjusts incoming telemetry packets to make them JSON-compatible, sometimes "mangling" the data.
-
-So far, it's mainly about fixing -Inf.
+createData creates a string-interface map of skogul.Metric type Data by
+walking the Juniper extension message with protobuf reflection. See
+pbWalker for details on how values are represented.
 */
-func applyJuniperHaxx(messageOnly proto.Message) {
-	var foo float32
-	foo = -40.0
-	optics, ok := messageOnly.(*pb.Optics)
-	if !ok {
-		return
-	}
-	for _, odiags := range optics.OpticsDiag {
-		if odiags.OpticsDiagStats == nil {
-			continue
-		}
-		if odiags.OpticsDiagStats.LaserOutputPowerHighAlarmThresholdDbm != nil {
-			if math.IsInf(*odiags.OpticsDiagStats.LaserOutputPowerHighAlarmThresholdDbm, 0) {
-				odiags.OpticsDiagStats.LaserOutputPowerHighAlarmThresholdDbm = nil
-			}
-		}
-		if odiags.OpticsDiagStats.LaserOutputPowerLowAlarmThresholdDbm != nil {
-			if math.IsInf(*odiags.OpticsDiagStats.LaserOutputPowerLowAlarmThresholdDbm, 0) {
-				odiags.OpticsDiagStats.LaserOutputPowerLowAlarmThresholdDbm = nil
-			}
-		}
-		if odiags.OpticsDiagStats.LaserOutputPowerHighWarningThresholdDbm != nil {
-			if math.IsInf(*odiags.OpticsDiagStats.LaserOutputPowerHighWarningThresholdDbm, 0) {
-				odiags.OpticsDiagStats.LaserOutputPowerHighWarningThresholdDbm = nil
-			}
-		}
-		if odiags.OpticsDiagStats.LaserOutputPowerLowWarningThresholdDbm != nil {
-			if math.IsInf(*odiags.OpticsDiagStats.LaserOutputPowerLowWarningThresholdDbm, 0) {
-				odiags.OpticsDiagStats.LaserOutputPowerLowWarningThresholdDbm = nil
-			}
-		}
-		for _, lane := range odiags.OpticsDiagStats.OpticsLaneDiagStats {
-			if lane.LaneLaserReceiverPowerDbm != nil {
-				if skogul.IsInf(*lane.LaneLaserReceiverPowerDbm, -1) {
-					lane.LaneLaserReceiverPowerDbm = &foo
-				}
-			}
-			if lane.LaneLaserOutputPowerDbm != nil {
-				if skogul.IsInf(*lane.LaneLaserOutputPowerDbm, -1) {
-					lane.LaneLaserOutputPowerDbm = &foo
-				}
-			}
-		}
+func (x *ProtoBuf) createData(telemetry *pb.TelemetryStream) (map[string]interface{}, error) {
+	ext, err := x.extension(telemetry)
+	if err != nil {
+		return nil, err
 	}
+	metrics := pbWalker{native: x.NativeTypes}.message(ext)
+
+	delete(metrics, "timestamp")
+	delete(metrics, "sensorName")
+	delete(metrics, "componentId")
+	delete(metrics, "subComponentId")
+
+	return metrics, nil
 }
 
-/*
-createData creates a string-interface map of skogul.Metric type Data
-by first marshalling the protobuf message into json and then parsing
-it back in to a string-interface map.
-*/
-func (x *ProtoBuf) createData(telemetry *pb.TelemetryStream) (map[string]interface{}, error) {
+// extension finds the single Juniper extension message in the telemetry
+// stream and returns it as a protobuf reflection message.
+func (x *ProtoBuf) extension(telemetry *pb.TelemetryStream) (protoreflect.Message, error) {
 	extension, err := proto.GetExtension(telemetry.GetEnterprise(), pb.E_JuniperNetworks)
 	if err != nil {
 		atomic.AddUint64(&x.stats.MissingExtension, 1)
@@ -213,14 +186,13 @@ func (x *ProtoBuf) createData(telemetry *pb.TelemetryStream) (map[string]interfa
 		return nil, err
 	}
 
-	var jsonMessage []byte
-	found := false
+	var ret protoreflect.Message
 	for _, ext := range availableExtensions {
 		if ext == nil {
 			continue
 		}
 
-		if found {
+		if ret != nil {
 			return nil, fmt.Errorf("multiple protobuf extensions found, don't know what to do!")
 		}
 
@@ -228,52 +200,25 @@ func (x *ProtoBuf) createData(telemetry *pb.TelemetryStream) (map[string]interfa
 		if !ok {
 			return nil, fmt.Errorf("failed to cast to message: %v", ext)
 		}
-		applyJuniperHaxx(messageOnly)
-
-		jsonMessage, err = json.Marshal(messageOnly)
-		if err != nil {
+		m := protoadapt.MessageV2Of(messageOnly).ProtoReflect()
+		if !m.IsValid() {
+			atomic.AddUint64(&x.stats.FailedToDecode, 1)
 			if x.Debug {
-				pbLog.WithError(err).Infof("failed to marshal protobuf. data: %v", messageOnly)
+				pbLog.Infof("invalid extension message %T. data: %v", messageOnly, messageOnly)
 			}
-			atomic.AddUint64(&x.stats.FailedToJsonMarshal, 1)
-			return nil, err
+			return nil, fmt.Errorf("invalid extension message %T", messageOnly)
 		}
-
-		found = true
+		ret = m
 	}
 
-	if !found {
+	if ret == nil {
 		if x.Debug {
 			pbLog.Infof("no valid extensions found. availableExtensions: %v, registered: %v, extensions: %v, telemetry: %v, regextensions: %v", availableExtensions, registeredExtensions, extension, telemetry, regextensions)
 		}
 		return nil, fmt.Errorf("found no valid extensions")
 	}
 
-	var metrics map[string]interface{}
-	if err = json.Unmarshal(jsonMessage, &metrics); err != nil {
-		atomic.AddUint64(&x.stats.FailedToJsonUnmarshal, 1)
-		target := 500
-		data := ""
-		if len(jsonMessage) < 500 {
-			target = len(jsonMessage) - 1
-		}
-		if len(jsonMessage) > 0 {
-			data = string(jsonMessage[:target])
-		} else {
-			target = 0
-			data = ""
-		}
-
-		return nil, fmt.Errorf("unmarshalling %d bytes of JSON data to string/interface map failed: %w. First %d bytes: %s", len(jsonMessage), err, target, data)
-	}
-
-	delete(metrics, "timestamp")
-	delete(metrics, "sensorName")
-	delete(metrics, "componentId")
-	delete(metrics, "subComponentId")
-
-	atomic.AddUint64(&x.stats.Parsed, 1)
-	return metrics, nil
+	return ret, nil
 }
 
 // GetStats prepares a skogul metric with stats
@@ -296,8 +241,11 @@ func (x *ProtoBuf) GetStats() *skogul.Metric {
 	metric.Data["parse_errors"] = x.stats.ParseErrors
 	metric.Data["missing_protobuf_extension"] = x.stats.MissingExtension
 	metric.Data["failed_to_cast_to_juniper_message"] = x.stats.FailedToCastToJuniperMessage
-	metric.Data["failed_to_json_marshal"] = x.stats.FailedToJsonMarshal
-	metric.Data["failed_to_json_unmarshal"] = x.stats.FailedToJsonUnmarshal
+	metric.Data["failed_to_decode"] = x.stats.FailedToDecode
+	// Deprecated: the parser no longer goes through JSON, so these are
+	// always 0. Kept so existing dashboards and alerts don't break.
+	metric.Data["failed_to_json_marshal"] = uint64(0)
+	metric.Data["failed_to_json_unmarshal"] = uint64(0)
 	metric.Data["parsed"] = x.stats.Parsed
 	return &metric
 }
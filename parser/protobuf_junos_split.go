@@ -0,0 +1,240 @@
+/*
+ * skogul, sensor-aware splitting of junos telemetry
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/telenornms/skogul"
+	pb "github.com/telenornms/skogul/gen/junos/telemetry"
+	"google.golang.org/protobuf/encoding/protowire"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+/*
+junosSplitter turns a Junos extension message into one flat metric per
+entity, instead of a single deeply nested metric.
+
+Every element of a repeated message field is an entity. Fields the Junos
+telemetry descriptors mark as keys (telemetry_options.is_key), such as
+if_name or name, are added to the metadata of the entity and all its
+children. If a key has the same name as a key of a parent entity, it is
+prefixed with the name of the field holding it, e.g. a property name
+below a component name becomes property_name. Other scalar fields become
+data. Singular sub-messages are
+flattened into the entity they belong to, with the name of the field and
+an underscore as prefix, e.g. ingress_stats_if_octets. Repeated scalar
+fields are kept as arrays.
+
+If an entity has no key fields, its index in the repeated field is used
+instead, stored as "<field>_index". The "entity" metadata field holds the
+path of repeated fields leading to the entity, e.g.
+"Optics_diag.optics_diag_stats.optics_lane_diag_stats", so different
+entities with the same keys can be told apart.
+*/
+type junosSplitter struct {
+	walker  pbWalker
+	base    skogul.Metric
+	metrics []*skogul.Metric
+}
+
+// split converts the extension message to metrics
+func (s *junosSplitter) split(m protoreflect.Message) []*skogul.Metric {
+	root := make(map[string]interface{})
+	s.entity(m, s.base.Metadata, root, "", "")
+	delete(root, "timestamp")
+	delete(root, "sensorName")
+	delete(root, "componentId")
+	delete(root, "subComponentId")
+	if len(root) > 0 {
+		s.emit(s.base.Metadata, root)
+	}
+	return s.metrics
+}
+
+// emit adds a metric with a copy of the metadata
+func (s *junosSplitter) emit(meta map[string]interface{}, data map[string]interface{}) {
+	m := skogul.Metric{
+		Time:     s.base.Time,
+		Metadata: make(map[string]interface{}, len(meta)),
+		Data:     data,
+	}
+	for k, v := range meta {
+		m.Metadata[k] = v
+	}
+	s.metrics = append(s.metrics, &m)
+}
+
+// entity walks the message m, storing keys in meta, data in data with the
+// given prefix and emitting new metrics for repeated message fields.
+// path is the path of fields leading to m. All keys are collected before
+// any children are emitted, since fields are visited in field number
+// order and a key can come after a repeated field.
+func (s *junosSplitter) entity(m protoreflect.Message, meta map[string]interface{}, data map[string]interface{}, prefix string, path string) {
+	s.keys(m, meta, prefix, path)
+	s.values(m, meta, data, prefix, path)
+}
+
+// keys adds the key fields of m and its singular sub-messages to meta.
+func (s *junosSplitter) keys(m protoreflect.Message, meta map[string]interface{}, prefix string, path string) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(fd.Name())
+		switch {
+		case junosIsKeyField(fd):
+			if val, ok := s.walker.field(fd, v); ok {
+				if _, exists := meta[name]; exists {
+					name = junosQualify(path, prefix, name)
+				}
+				meta[name] = val
+			}
+		case fd.Message() != nil && !fd.IsMap() && !fd.IsList():
+			s.keys(v.Message(), meta, prefix+name+"_", junosPath(path, name))
+		}
+		return true
+	})
+}
+
+// values adds the data fields of m and its singular sub-messages to data,
+// and emits metrics for repeated message fields, with a copy of meta.
+func (s *junosSplitter) values(m protoreflect.Message, meta map[string]interface{}, data map[string]interface{}, prefix string, path string) {
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(fd.Name())
+		isMessage := fd.Message() != nil && !fd.IsMap()
+		switch {
+		case junosIsKeyField(fd):
+		case isMessage && !fd.IsList():
+			s.values(v.Message(), meta, data, prefix+name+"_", junosPath(path, name))
+		case isMessage:
+			epath := junosPath(path, name)
+			l := v.List()
+			for i := 0; i < l.Len(); i++ {
+				e := l.Get(i).Message()
+				emeta := make(map[string]interface{}, len(meta)+2)
+				for k, v := range meta {
+					emeta[k] = v
+				}
+				emeta["entity"] = epath
+				if !junosHasKeys(e.Descriptor()) {
+					emeta[name+"_index"] = i
+				}
+				edata := make(map[string]interface{})
+				s.entity(e, emeta, edata, "", epath)
+				if len(edata) > 0 {
+					s.emit(emeta, edata)
+				}
+			}
+		default:
+			if val, ok := s.walker.field(fd, v); ok {
+				data[prefix+name] = val
+			}
+		}
+		return true
+	})
+}
+
+// junosQualify disambiguates a key that collides with a key of a parent
+// entity, using the prefix of the flattened sub-message if there is one,
+// and otherwise the name of the repeated field holding the entity, e.g.
+// egress_queue_info_name.
+func junosQualify(path string, prefix string, name string) string {
+	if prefix != "" {
+		return prefix + name
+	}
+	if i := strings.LastIndexByte(path, '.'); i >= 0 {
+		path = path[i+1:]
+	}
+	return path + "_" + name
+}
+
+// junosPath appends name to path
+func junosPath(path string, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+// junosKeyCache maps protoreflect.FieldDescriptor to whether the field is
+// a key.
+var junosKeyCache sync.Map
+
+// junosIsKeyField checks if the field is marked as a key in the Junos
+// telemetry descriptors.
+func junosIsKeyField(fd protoreflect.FieldDescriptor) bool {
+	if k, ok := junosKeyCache.Load(fd); ok {
+		return k.(bool)
+	}
+	opts, _ := fd.Options().(*descriptorpb.FieldOptions)
+	key := junosIsKey(opts)
+	junosKeyCache.Store(fd, key)
+	return key
+}
+
+// junosHasKeys checks if any field of the message is a key.
+func junosHasKeys(md protoreflect.MessageDescriptor) bool {
+	fields := md.Fields()
+	for i := 0; i < fields.Len(); i++ {
+		if junosIsKeyField(fields.Get(i)) {
+			return true
+		}
+	}
+	return false
+}
+
+// junosIsKey checks the telemetry_options extension of the field options.
+// The extension isn't registered with the descriptor package, so it is
+// found among the unknown fields.
+func junosIsKey(opts *descriptorpb.FieldOptions) bool {
+	if opts == nil {
+		return false
+	}
+	b := opts.ProtoReflect().GetUnknown()
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+		if num == protowire.Number(pb.E_TelemetryOptions.Field) && typ == protowire.BytesType {
+			v, n := protowire.ConsumeBytes(b)
+			if n < 0 {
+				return false
+			}
+			to := pb.TelemetryFieldOptions{}
+			if err := proto.Unmarshal(v, &to); err != nil {
+				return false
+			}
+			return to.GetIsKey()
+		}
+		n = protowire.ConsumeFieldValue(num, typ, b)
+		if n < 0 {
+			return false
+		}
+		b = b[n:]
+	}
+	return false
+}
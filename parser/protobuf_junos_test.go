@@ -24,9 +24,11 @@
 package parser_test
 
 import (
+	"encoding/json"
 	"fmt"
 	"math"
 	"os"
+	"reflect"
 	"testing"
 	"time"
 
@@ -88,6 +90,94 @@ func BenchmarkProtoBufParse(b *testing.B) {
 	}
 }
 
+// jsonRoundTrip decodes the Juniper extension the way the protobuf
+// parser used to: by marshalling it to JSON and back.
+func jsonRoundTrip(t failer, b []byte) map[string]interface{} {
+	t.Helper()
+	telemetry := junos_protobuf_telemetry.TelemetryStream{}
+	if err := proto.Unmarshal(b, &telemetry); err != nil {
+		t.Fatalf("unable to unmarshal protobuf: %v", err)
+	}
+	ext, err := proto.GetExtension(telemetry.GetEnterprise(), junos_protobuf_telemetry.E_JuniperNetworks)
+	if err != nil {
+		t.Fatalf("unable to get extension: %v", err)
+	}
+	msg := ext.(proto.Message)
+	var descs []*proto.ExtensionDesc
+	for _, desc := range proto.RegisteredExtensions(msg) {
+		descs = append(descs, desc)
+	}
+	exts, err := proto.GetExtensions(msg, descs)
+	if err != nil {
+		t.Fatalf("unable to get extensions: %v", err)
+	}
+	for _, e := range exts {
+		if e == nil {
+			continue
+		}
+		js, err := json.Marshal(e)
+		if err != nil {
+			t.Fatalf("unable to marshal to json: %v", err)
+		}
+		var ret map[string]interface{}
+		if err := json.Unmarshal(js, &ret); err != nil {
+			t.Fatalf("unable to unmarshal json: %v", err)
+		}
+		return ret
+	}
+	t.Fatalf("no extension found")
+	return nil
+}
+
+func TestProtoBufCompatible(t *testing.T) {
+	b := readProtobufFile(t, "testdata/protobuf-packet.bin")
+	x := parser.ProtoBuf{}
+	c, err := x.Parse(b)
+	if err != nil {
+		t.Fatalf("ProtoBuf.Parse(b) failed: %s", err)
+	}
+	want := jsonRoundTrip(t, b)
+	if !reflect.DeepEqual(c.Metrics[0].Data, want) {
+		t.Errorf("ProtoBuf.Parse(b) differs from JSON round trip.\nGot:  %v\nWant: %v", c.Metrics[0].Data, want)
+	}
+}
+
+func TestProtoBufNativeTypes(t *testing.T) {
+	b := readProtobufFile(t, "testdata/protobuf-packet.bin")
+	x := parser.ProtoBuf{NativeTypes: true}
+	c, err := x.Parse(b)
+	if err != nil {
+		t.Fatalf("ProtoBuf.Parse(b) failed: %s", err)
+	}
+	var walk func(v interface{})
+	floats := 0
+	walk = func(v interface{}) {
+		switch v := v.(type) {
+		case map[string]interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		case []interface{}:
+			for _, e := range v {
+				walk(e)
+			}
+		case float64:
+			floats++
+		}
+	}
+	walk(c.Metrics[0].Data)
+	if floats != 0 {
+		t.Errorf("ProtoBuf.Parse(b) with NativeTypes returned %d float64 values, expected none", floats)
+	}
+}
+
+func BenchmarkProtoBufParse_jsonRoundTrip(b *testing.B) {
+	by := readProtobufFile(b, "testdata/protobuf-packet.bin")
+	for i := 0; i < b.N; i++ {
+		jsonRoundTrip(b, by)
+	}
+}
+
 func generateJunosTelemetryStream(sensorName string, eps junos_protobuf_telemetry.EnterpriseSensors) junos_protobuf_telemetry.TelemetryStream {
 	systemId := "localhost"
 	now := uint64(time.Now().Unix())
@@ -208,3 +298,252 @@ func TestParseJunosProtobufTelemetryStreamOpticsNegativeInf(t *testing.T) {
 		return
 	}
 }
+
+func TestParseJunosProtobufTelemetryStreamOpticsNonFinite(t *testing.T) {
+	telemetry := generateOpticsDiag(-3)
+	eps := telemetry.GetEnterprise()
+	jnpr, err := proto.GetExtension(eps, junos_protobuf_telemetry.E_JuniperNetworks)
+	if err != nil {
+		t.Fatalf("Failed to get extension: %v", err)
+	}
+	ext, err := proto.GetExtension(jnpr.(proto.Message), junos_protobuf_telemetry.E_JnprOpticsExt)
+	if err != nil {
+		t.Fatalf("Failed to get optics extension: %v", err)
+	}
+	optics := ext.(*junos_protobuf_telemetry.Optics)
+	nan := math.NaN()
+	inf := math.Inf(1)
+	neginf := math.Inf(-1)
+	optics.OpticsDiag[0].OpticsDiagStats.ModuleTemp = &nan
+	optics.OpticsDiag[0].OpticsDiagStats.ModuleTempHighAlarmThreshold = &inf
+	optics.OpticsDiag[0].OpticsDiagStats.LaserRxPowerLowAlarmThresholdDbm = &neginf
+
+	bytes, err := proto.Marshal(&telemetry)
+	if err != nil {
+		t.Fatalf("Failed to marshal protobuf message to bytes: %v", err)
+	}
+	x := parser.ProtoBuf{}
+	c, err := x.Parse(bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse protobuf data: %v", err)
+	}
+	stats := c.Metrics[0].Data["Optics_diag"].([]interface{})[0].(map[string]interface{})["optics_diag_stats"].(map[string]interface{})
+	if _, ok := stats["module_temp"]; ok {
+		t.Errorf("Expected NaN module_temp to be omitted, got %v", stats["module_temp"])
+	}
+	if _, ok := stats["module_temp_high_alarm_threshold"]; ok {
+		t.Errorf("Expected +Inf module_temp_high_alarm_threshold to be omitted, got %v", stats["module_temp_high_alarm_threshold"])
+	}
+	if _, ok := stats["laser_rx_power_low_alarm_threshold_dbm"]; ok {
+		t.Errorf("Expected -Inf laser_rx_power_low_alarm_threshold_dbm to be omitted, got %v", stats["laser_rx_power_low_alarm_threshold_dbm"])
+	}
+	if got := parseDiagStatsResp(c.Metrics[0].Data, "lane_laser_receiver_power_dbm"); got != float64(-3) {
+		t.Errorf("Expected lane_laser_receiver_power_dbm to be -3, got %T(%v)", got, got)
+	}
+
+	telemetry = generateOpticsDiag(float32(math.Inf(-1)))
+	bytes, err = proto.Marshal(&telemetry)
+	if err != nil {
+		t.Fatalf("Failed to marshal protobuf message to bytes: %v", err)
+	}
+	c, err = x.Parse(bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse protobuf data: %v", err)
+	}
+	if got := parseDiagStatsResp(c.Metrics[0].Data, "lane_laser_receiver_power_dbm"); got != float64(-40) {
+		t.Errorf("Expected -Inf lane_laser_receiver_power_dbm to be -40, got %T(%v)", got, got)
+	}
+}
+
+func TestProtoBufSplit(t *testing.T) {
+	eps := junos_protobuf_telemetry.EnterpriseSensors{}
+	jnpr := junos_protobuf_telemetry.JuniperNetworksSensors{}
+	if err := proto.SetExtension(&eps, junos_protobuf_telemetry.E_JuniperNetworks, &jnpr); err != nil {
+		t.Fatalf("Failed to set juniperNetworks extension: %v", err)
+	}
+	ifName := "xe-0/0/0"
+	pkts := uint64(42)
+	queue := uint32(3)
+	packets := uint64(7)
+	port := junos_protobuf_telemetry.Port{
+		InterfaceStats: []*junos_protobuf_telemetry.InterfaceInfos{
+			{
+				IfName:       &ifName,
+				IngressStats: &junos_protobuf_telemetry.InterfaceStats{IfPkts: &pkts},
+				EgressQueueInfo: []*junos_protobuf_telemetry.QueueStats{
+					{QueueNumber: &queue, Packets: &packets},
+				},
+			},
+		},
+	}
+	if err := proto.SetExtension(&jnpr, junos_protobuf_telemetry.E_JnprInterfaceExt, &port); err != nil {
+		t.Fatalf("Failed to set interface extension: %v", err)
+	}
+	telemetry := generateJunosTelemetryStream("foo", eps)
+	bytes, err := proto.Marshal(&telemetry)
+	if err != nil {
+		t.Fatalf("Failed to marshal protobuf message to bytes: %v", err)
+	}
+
+	x := parser.ProtoBuf{Split: true}
+	c, err := x.Parse(bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse protobuf data: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(c.Metrics))
+	}
+	var intf, q *skogul.Metric
+	for _, m := range c.Metrics {
+		switch m.Metadata["entity"] {
+		case "interface_stats":
+			intf = m
+		case "interface_stats.egress_queue_info":
+			q = m
+		}
+	}
+	if intf == nil || q == nil {
+		t.Fatalf("Missing interface or queue metric: %v %v", c.Metrics[0], c.Metrics[1])
+	}
+	if intf.Metadata["if_name"] != ifName || intf.Metadata["systemId"] != "localhost" {
+		t.Errorf("Unexpected interface metadata: %v", intf.Metadata)
+	}
+	if intf.Data["ingress_stats_if_pkts"] != float64(42) {
+		t.Errorf("Expected ingress_stats_if_pkts 42, got %v", intf.Data)
+	}
+	if q.Metadata["if_name"] != ifName || q.Metadata["queue_number"] != float64(3) {
+		t.Errorf("Unexpected queue metadata: %v", q.Metadata)
+	}
+	if q.Data["packets"] != float64(7) || q.Data["queue_number"] != nil {
+		t.Errorf("Unexpected queue data: %v", q.Data)
+	}
+
+	telemetry = generateOpticsDiag(-3)
+	bytes, err = proto.Marshal(&telemetry)
+	if err != nil {
+		t.Fatalf("Failed to marshal protobuf message to bytes: %v", err)
+	}
+	c, err = x.Parse(bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse protobuf data: %v", err)
+	}
+	if len(c.Metrics) != 1 {
+		t.Fatalf("Expected 1 metric, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Metadata["entity"] != "Optics_diag.optics_diag_stats.optics_lane_diag_stats" || m.Metadata["if_name"] != "ge-1/0/1" {
+		t.Errorf("Unexpected optics metadata: %v", m.Metadata)
+	}
+	if m.Data["lane_laser_receiver_power_dbm"] != float64(-3) {
+		t.Errorf("Unexpected optics data: %v", m.Data)
+	}
+}
+
+func BenchmarkProtoBufParse_split(b *testing.B) {
+	by := readProtobufFile(b, "testdata/protobuf-packet.bin")
+	x := parser.ProtoBuf{Split: true}
+	for i := 0; i < b.N; i++ {
+		x.Parse(by)
+	}
+}
+
+func TestProtoBufSplitKeyCollision(t *testing.T) {
+	eps := junos_protobuf_telemetry.EnterpriseSensors{}
+	jnpr := junos_protobuf_telemetry.JuniperNetworksSensors{}
+	if err := proto.SetExtension(&eps, junos_protobuf_telemetry.E_JuniperNetworks, &jnpr); err != nil {
+		t.Fatalf("Failed to set juniperNetworks extension: %v", err)
+	}
+	component := "FPC0"
+	property := "temperature-unit"
+	value := "celsius"
+	components := junos_protobuf_telemetry.Components{
+		Component: []*junos_protobuf_telemetry.ComponentsComponentList{
+			{
+				Name: &component,
+				Properties: &junos_protobuf_telemetry.ComponentsComponentListPropertiesType{
+					Property: []*junos_protobuf_telemetry.ComponentsComponentListPropertiesTypePropertyList{
+						{
+							Name:  &property,
+							State: &junos_protobuf_telemetry.ComponentsComponentListPropertiesTypePropertyListStateType{Value: &value},
+						},
+					},
+				},
+			},
+		},
+	}
+	if err := proto.SetExtension(&jnpr, junos_protobuf_telemetry.E_JnprComponentsExt, &components); err != nil {
+		t.Fatalf("Failed to set components extension: %v", err)
+	}
+	telemetry := generateJunosTelemetryStream("foo", eps)
+	bytes, err := proto.Marshal(&telemetry)
+	if err != nil {
+		t.Fatalf("Failed to marshal protobuf message to bytes: %v", err)
+	}
+
+	x := parser.ProtoBuf{Split: true}
+	c, err := x.Parse(bytes)
+	if err != nil {
+		t.Fatalf("Failed to parse protobuf data: %v", err)
+	}
+	if len(c.Metrics) != 1 {
+		t.Fatalf("Expected 1 metric, got %d", len(c.Metrics))
+	}
+	m := c.Metrics[0]
+	if m.Metadata["name"] != component || m.Metadata["property_name"] != property {
+		t.Errorf("Unexpected property metadata: %v", m.Metadata)
+	}
+	if m.Data["state_value"] != value {
+		t.Errorf("Unexpected property data: %v", m.Data)
+	}
+}
+
+// TestProtoBufSplitLateKey checks that keys with a higher field number
+// than a repeated field, like the location of fabric_message, still end up
+// in the metadata of its entities.
+func TestProtoBufSplitLateKey(t *testing.T) {
+	locations := make(map[string]bool)
+	for _, location := range []junos_protobuf_telemetry.FabricMessageSensorLocation{junos_protobuf_telemetry.FabricMessage_Linecard, junos_protobuf_telemetry.FabricMessage_Switch_Fabric} {
+		eps := junos_protobuf_telemetry.EnterpriseSensors{}
+		jnpr := junos_protobuf_telemetry.JuniperNetworksSensors{}
+		if err := proto.SetExtension(&eps, junos_protobuf_telemetry.E_JuniperNetworks, &jnpr); err != nil {
+			t.Fatalf("Failed to set juniperNetworks extension: %v", err)
+		}
+		slot := uint32(3)
+		priority := "0"
+		packets := uint64(42)
+		fabric := junos_protobuf_telemetry.FabricMessage{
+			Location: &location,
+			Edges: []*junos_protobuf_telemetry.EdgeStats{{
+				SourceSlot: &slot,
+				ClassStats: []*junos_protobuf_telemetry.ClassStats{{
+					Priority:       &priority,
+					TransmitCounts: &junos_protobuf_telemetry.Counters{Packets: &packets},
+				}},
+			}},
+		}
+		if err := proto.SetExtension(&jnpr, junos_protobuf_telemetry.E_FabricMessageExt, &fabric); err != nil {
+			t.Fatalf("Failed to set fabric extension: %v", err)
+		}
+		telemetry := generateJunosTelemetryStream("foo", eps)
+		bytes, err := proto.Marshal(&telemetry)
+		if err != nil {
+			t.Fatalf("Failed to marshal protobuf message to bytes: %v", err)
+		}
+		x := parser.ProtoBuf{Split: true}
+		c, err := x.Parse(bytes)
+		if err != nil {
+			t.Fatalf("Failed to parse protobuf data: %v", err)
+		}
+		if len(c.Metrics) != 1 {
+			t.Fatalf("Expected 1 metric, got %d", len(c.Metrics))
+		}
+		m := c.Metrics[0]
+		if m.Metadata["location"] == nil || m.Metadata["source_slot"] == nil || m.Metadata["priority"] != priority {
+			t.Errorf("Unexpected metadata for location %v: %v", location, m.Metadata)
+		}
+		locations[fmt.Sprint(m.Metadata["location"])] = true
+	}
+	if len(locations) != 2 {
+		t.Errorf("Expected 2 different locations, got %v", locations)
+	}
+}
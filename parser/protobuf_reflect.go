@@ -0,0 +1,147 @@
+/*
+ * skogul, reflection based protobuf decoding
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"encoding/base64"
+	"math"
+	"strconv"
+	"strings"
+
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+/*
+pbWalker converts protobuf messages into the map[string]interface{}
+representation used for skogul.Metric data, walking the message with
+protobuf reflection.
+
+The output is identical to what marshalling the generated message to JSON
+with encoding/json and back would give, which is what the protobuf parser
+used to do: fields are named by their name in the .proto file, fields that
+aren't set are omitted, bytes become a base64 string, enums are numbers
+and all numbers become float64.
+
+The only difference is how non-finite floating point values are handled,
+since JSON can't represent them. They are dropped, with one exception:
+Juniper uses -Inf dBm to signal "no light", so -Inf in a power reading,
+i.e. a field ending in "_dbm" that isn't a threshold, is replaced with -40,
+the conventional floor for optics. This matches what the parser used to
+do for optics lane powers, while thresholds of -Inf are left out as
+before.
+
+If native is set, integers are kept as int64/uint64 instead of being
+converted to float64, avoiding loss of precision for large counters.
+*/
+type pbWalker struct {
+	native bool
+}
+
+// message converts a message to a map.
+func (w pbWalker) message(m protoreflect.Message) map[string]interface{} {
+	ret := make(map[string]interface{})
+	m.Range(func(fd protoreflect.FieldDescriptor, v protoreflect.Value) bool {
+		name := string(fd.Name())
+		if val, ok := w.field(fd, v); ok {
+			ret[name] = val
+		}
+		return true
+	})
+	return ret
+}
+
+// field converts the value of a field, which may be a list or a map.
+// Returns false if the value should be omitted.
+func (w pbWalker) field(fd protoreflect.FieldDescriptor, v protoreflect.Value) (interface{}, bool) {
+	name := string(fd.Name())
+	switch {
+	case fd.IsList():
+		l := v.List()
+		ret := make([]interface{}, 0, l.Len())
+		for i := 0; i < l.Len(); i++ {
+			if val, ok := w.value(fd, l.Get(i), name); ok {
+				ret = append(ret, val)
+			}
+		}
+		return ret, true
+	case fd.IsMap():
+		ret := make(map[string]interface{}, v.Map().Len())
+		v.Map().Range(func(k protoreflect.MapKey, mv protoreflect.Value) bool {
+			key := k.String()
+			if val, ok := w.value(fd.MapValue(), mv, key); ok {
+				ret[key] = val
+			}
+			return true
+		})
+		return ret, true
+	}
+	return w.value(fd, v, name)
+}
+
+// value converts a single value of the kind described by fd. The name is
+// the name of the field it belongs to, used for non-finite floats.
+// Returns false if the value should be omitted.
+func (w pbWalker) value(fd protoreflect.FieldDescriptor, v protoreflect.Value, name string) (interface{}, bool) {
+	switch fd.Kind() {
+	case protoreflect.MessageKind, protoreflect.GroupKind:
+		return w.message(v.Message()), true
+	case protoreflect.StringKind:
+		return v.String(), true
+	case protoreflect.BytesKind:
+		return base64.StdEncoding.EncodeToString(v.Bytes()), true
+	case protoreflect.BoolKind:
+		return v.Bool(), true
+	case protoreflect.EnumKind:
+		if w.native {
+			return int64(v.Enum()), true
+		}
+		return float64(v.Enum()), true
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind,
+		protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		if w.native {
+			return v.Int(), true
+		}
+		return float64(v.Int()), true
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind,
+		protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		if w.native {
+			return v.Uint(), true
+		}
+		return float64(v.Uint()), true
+	case protoreflect.FloatKind, protoreflect.DoubleKind:
+		f := v.Float()
+		if math.IsNaN(f) || math.IsInf(f, 0) {
+			if math.IsInf(f, -1) && strings.HasSuffix(name, "_dbm") && !strings.HasSuffix(name, "_threshold_dbm") {
+				return float64(-40), true
+			}
+			return nil, false
+		}
+		if fd.Kind() == protoreflect.FloatKind {
+			// Same precision as encoding/json, so 0.1 stays 0.1
+			f, _ = strconv.ParseFloat(strconv.FormatFloat(f, 'g', -1, 32), 64)
+		}
+		return f, true
+	}
+	return nil, false
+}
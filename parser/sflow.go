@@ -0,0 +1,369 @@
+/*
+ * skogul, sflow v5 parser
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+
+	"github.com/telenornms/skogul"
+)
+
+/*
+SFlow parses sFlow version 5 datagrams, as specified at
+https://sflow.org/sflow_version_5.txt. It is meant to be used with the UDP
+receiver.
+
+Each flow sample and counter sample becomes a single metric. All metrics
+get the agentAddress, subAgentId, sourceIdType and sourceIdIndex metadata
+fields, and sampleType set to either "flow" or "counter".
+
+For flow samples, the raw packet header is decoded to the Ethernet, IPv4
+or IPv6, and TCP, UDP or ICMP fields, which are stored as metadata along
+with the input and output interface. The sampling rate, sample pool,
+drops and frame length are stored as data.
+
+For counter samples, generic interface counters and Ethernet interface
+counters are stored as data, with ifIndex and ifType as metadata.
+
+Unknown sample and record types are ignored. sFlow datagrams carry no
+absolute timestamp, so the time of parsing is used.
+*/
+type SFlow struct{}
+
+// sflowReader reads XDR-encoded data, which is big endian and 4-byte
+// aligned. Reading past the end sets err, and subsequent reads return 0.
+type sflowReader struct {
+	b   []byte
+	err error
+}
+
+func (r *sflowReader) bytes(n int) []byte {
+	padded := (n + 3) &^ 3
+	if r.err != nil {
+		return nil
+	}
+	if n < 0 || padded > len(r.b) {
+		r.err = fmt.Errorf("truncated sFlow data: wanted %d bytes, %d left", padded, len(r.b))
+		return nil
+	}
+	ret := r.b[:n]
+	r.b = r.b[padded:]
+	return ret
+}
+
+func (r *sflowReader) u32() uint32 {
+	b := r.bytes(4)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint32(b)
+}
+
+func (r *sflowReader) u64() uint64 {
+	b := r.bytes(8)
+	if b == nil {
+		return 0
+	}
+	return binary.BigEndian.Uint64(b)
+}
+
+// sub returns a reader for the next opaque structure, prefixed by its
+// length.
+func (r *sflowReader) sub() *sflowReader {
+	n := r.u32()
+	return &sflowReader{b: r.bytes(int(n)), err: r.err}
+}
+
+func (r *sflowReader) address() string {
+	switch r.u32() {
+	case 1:
+		return net.IP(r.bytes(4)).String()
+	case 2:
+		return net.IP(r.bytes(16)).String()
+	}
+	return ""
+}
+
+// Parse parses a single sFlow datagram.
+func (s SFlow) Parse(b []byte) (*skogul.Container, error) {
+	r := &sflowReader{b: b}
+	if v := r.u32(); v != 5 {
+		if r.err != nil {
+			return nil, r.err
+		}
+		return nil, fmt.Errorf("unsupported sFlow version %d", v)
+	}
+	now := skogul.Now()
+	agent := r.address()
+	subAgent := r.u32()
+	r.u32() // sequence number
+	uptime := r.u32()
+	count := r.u32()
+	if r.err != nil {
+		return nil, r.err
+	}
+	// The count comes straight from the wire, so don't trust it for
+	// allocation. Every sample needs at least a format and a length.
+	capacity := count
+	if max := uint32(len(r.b) / 8); capacity > max {
+		capacity = max
+	}
+	c := skogul.Container{Metrics: make([]*skogul.Metric, 0, capacity)}
+	for i := uint32(0); i < count; i++ {
+		format := r.u32()
+		sample := r.sub()
+		if r.err != nil {
+			return nil, r.err
+		}
+		m := skogul.Metric{
+			Time: &now,
+			Metadata: map[string]interface{}{
+				"agentAddress": agent,
+				"subAgentId":   subAgent,
+			},
+			Data: map[string]interface{}{
+				"agentUptime": uptime,
+			},
+		}
+		var ok bool
+		switch format {
+		case 1:
+			ok = sflowFlowSample(sample, &m, false)
+		case 2:
+			ok = sflowCounterSample(sample, &m, false)
+		case 3:
+			ok = sflowFlowSample(sample, &m, true)
+		case 4:
+			ok = sflowCounterSample(sample, &m, true)
+		}
+		if sample.err != nil {
+			return nil, fmt.Errorf("unable to parse sFlow sample %d: %w", i, sample.err)
+		}
+		if ok {
+			c.Metrics = append(c.Metrics, &m)
+		}
+	}
+	return &c, nil
+}
+
+// sflowSourceID reads the source ID, which is a single packed value for
+// the compact sample formats, and two values for the expanded ones.
+func sflowSourceID(r *sflowReader, m *skogul.Metric, expanded bool) {
+	if expanded {
+		m.Metadata["sourceIdType"] = r.u32()
+		m.Metadata["sourceIdIndex"] = r.u32()
+		return
+	}
+	id := r.u32()
+	m.Metadata["sourceIdType"] = id >> 24
+	m.Metadata["sourceIdIndex"] = id & 0xffffff
+}
+
+// sflowInterface reads an interface, which is packed for the compact
+// format, and just returns the index.
+func sflowInterface(r *sflowReader, expanded bool) uint32 {
+	if expanded {
+		r.u32() // format
+		return r.u32()
+	}
+	return r.u32() & 0x3fffffff
+}
+
+func sflowFlowSample(r *sflowReader, m *skogul.Metric, expanded bool) bool {
+	m.Metadata["sampleType"] = "flow"
+	m.Data["sequenceNumber"] = r.u32()
+	sflowSourceID(r, m, expanded)
+	m.Data["samplingRate"] = r.u32()
+	m.Data["samplePool"] = r.u32()
+	m.Data["drops"] = r.u32()
+	m.Metadata["inputInterface"] = sflowInterface(r, expanded)
+	m.Metadata["outputInterface"] = sflowInterface(r, expanded)
+	records := r.u32()
+	for i := uint32(0); i < records && r.err == nil; i++ {
+		format := r.u32()
+		rec := r.sub()
+		switch format {
+		case 1:
+			sflowRawHeader(rec, m)
+		case 2:
+			m.Data["frameLength"] = rec.u32()
+			m.Metadata["sourceMacAddress"] = net.HardwareAddr(rec.bytes(6)).String()
+			m.Metadata["destinationMacAddress"] = net.HardwareAddr(rec.bytes(6)).String()
+			m.Metadata["etherType"] = rec.u32()
+		case 1001:
+			m.Metadata["sourceVlan"] = rec.u32()
+			m.Metadata["sourcePriority"] = rec.u32()
+			m.Metadata["destinationVlan"] = rec.u32()
+			m.Metadata["destinationPriority"] = rec.u32()
+		}
+		if rec.err != nil {
+			r.err = rec.err
+		}
+	}
+	return r.err == nil
+}
+
+func sflowCounterSample(r *sflowReader, m *skogul.Metric, expanded bool) bool {
+	m.Metadata["sampleType"] = "counter"
+	m.Data["sequenceNumber"] = r.u32()
+	sflowSourceID(r, m, expanded)
+	records := r.u32()
+	found := false
+	for i := uint32(0); i < records && r.err == nil; i++ {
+		format := r.u32()
+		rec := r.sub()
+		switch format {
+		case 1:
+			found = true
+			m.Metadata["ifIndex"] = rec.u32()
+			m.Metadata["ifType"] = rec.u32()
+			m.Data["ifSpeed"] = rec.u64()
+			m.Data["ifDirection"] = rec.u32()
+			m.Data["ifStatus"] = rec.u32()
+			m.Data["ifInOctets"] = rec.u64()
+			for _, name := range []string{"ifInUcastPkts", "ifInMulticastPkts", "ifInBroadcastPkts", "ifInDiscards", "ifInErrors", "ifInUnknownProtos"} {
+				m.Data[name] = rec.u32()
+			}
+			m.Data["ifOutOctets"] = rec.u64()
+			for _, name := range []string{"ifOutUcastPkts", "ifOutMulticastPkts", "ifOutBroadcastPkts", "ifOutDiscards", "ifOutErrors", "ifPromiscuousMode"} {
+				m.Data[name] = rec.u32()
+			}
+		case 2:
+			found = true
+			for _, name := range []string{
+				"dot3StatsAlignmentErrors", "dot3StatsFCSErrors", "dot3StatsSingleCollisionFrames",
+				"dot3StatsMultipleCollisionFrames", "dot3StatsSQETestErrors", "dot3StatsDeferredTransmissions",
+				"dot3StatsLateCollisions", "dot3StatsExcessiveCollisions", "dot3StatsInternalMacTransmitErrors",
+				"dot3StatsCarrierSenseErrors", "dot3StatsFrameTooLongs", "dot3StatsInternalMacReceiveErrors",
+				"dot3StatsSymbolErrors"} {
+				m.Data[name] = rec.u32()
+			}
+		}
+		if rec.err != nil {
+			r.err = rec.err
+		}
+	}
+	return r.err == nil && found
+}
+
+// sflowRawHeader decodes the sampled packet header. Truncated headers
+// are decoded as far as possible.
+func sflowRawHeader(r *sflowReader, m *skogul.Metric) {
+	proto := r.u32()
+	m.Data["frameLength"] = r.u32()
+	r.u32() // stripped
+	h := r.bytes(int(r.u32()))
+	if r.err != nil {
+		return
+	}
+	switch proto {
+	case 1:
+		sflowEthernet(h, m)
+	case 11:
+		sflowIPv4(h, m)
+	case 12:
+		sflowIPv6(h, m)
+	}
+}
+
+func sflowEthernet(h []byte, m *skogul.Metric) {
+	if len(h) < 14 {
+		return
+	}
+	m.Metadata["destinationMacAddress"] = net.HardwareAddr(h[0:6]).String()
+	m.Metadata["sourceMacAddress"] = net.HardwareAddr(h[6:12]).String()
+	etype := binary.BigEndian.Uint16(h[12:])
+	h = h[14:]
+	for (etype == 0x8100 || etype == 0x88a8) && len(h) >= 4 {
+		if _, ok := m.Metadata["vlan"]; !ok {
+			m.Metadata["vlan"] = binary.BigEndian.Uint16(h) & 0x0fff
+		}
+		etype = binary.BigEndian.Uint16(h[2:])
+		h = h[4:]
+	}
+	m.Metadata["etherType"] = etype
+	switch etype {
+	case 0x0800:
+		sflowIPv4(h, m)
+	case 0x86dd:
+		sflowIPv6(h, m)
+	}
+}
+
+func sflowIPv4(h []byte, m *skogul.Metric) {
+	if len(h) < 20 || h[0]>>4 != 4 {
+		return
+	}
+	ihl := int(h[0]&0x0f) * 4
+	m.Metadata["ipVersion"] = 4
+	m.Metadata["ipTos"] = h[1]
+	m.Metadata["ipTtl"] = h[8]
+	m.Metadata["ipProtocol"] = h[9]
+	m.Metadata["sourceIPAddress"] = net.IP(h[12:16]).String()
+	m.Metadata["destinationIPAddress"] = net.IP(h[16:20]).String()
+	// Only the first fragment carries the transport header
+	if binary.BigEndian.Uint16(h[6:])&0x1fff != 0 || ihl < 20 || len(h) < ihl {
+		return
+	}
+	sflowTransport(h[9], h[ihl:], m)
+}
+
+func sflowIPv6(h []byte, m *skogul.Metric) {
+	if len(h) < 40 || h[0]>>4 != 6 {
+		return
+	}
+	m.Metadata["ipVersion"] = 6
+	m.Metadata["ipTos"] = uint8(binary.BigEndian.Uint16(h) >> 4)
+	m.Metadata["ipTtl"] = h[7]
+	m.Metadata["ipProtocol"] = h[6]
+	m.Metadata["sourceIPAddress"] = net.IP(h[8:24]).String()
+	m.Metadata["destinationIPAddress"] = net.IP(h[24:40]).String()
+	sflowTransport(h[6], h[40:], m)
+}
+
+func sflowTransport(proto uint8, h []byte, m *skogul.Metric) {
+	switch proto {
+	case 6:
+		if len(h) < 14 {
+			return
+		}
+		m.Metadata["sourcePort"] = binary.BigEndian.Uint16(h)
+		m.Metadata["destinationPort"] = binary.BigEndian.Uint16(h[2:])
+		m.Metadata["tcpFlags"] = h[13]
+	case 17:
+		if len(h) < 4 {
+			return
+		}
+		m.Metadata["sourcePort"] = binary.BigEndian.Uint16(h)
+		m.Metadata["destinationPort"] = binary.BigEndian.Uint16(h[2:])
+	case 1, 58:
+		if len(h) < 2 {
+			return
+		}
+		m.Metadata["icmpType"] = h[0]
+		m.Metadata["icmpCode"] = h[1]
+	}
+}
@@ -0,0 +1,148 @@
+/*
+ * skogul, sflow parser tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package parser_test
+
+import (
+	"encoding/binary"
+	"testing"
+
+	"github.com/telenornms/skogul/parser"
+)
+
+type xdr []byte
+
+func (x xdr) u32(v ...uint32) xdr {
+	for _, i := range v {
+		x = binary.BigEndian.AppendUint32(x, i)
+	}
+	return x
+}
+
+func (x xdr) u64(v uint64) xdr { return binary.BigEndian.AppendUint64(x, v) }
+
+// opaque appends length-prefixed, padded data.
+func (x xdr) opaque(b []byte) xdr {
+	x = x.u32(uint32(len(b)))
+	x = append(x, b...)
+	for len(x)%4 != 0 {
+		x = append(x, 0)
+	}
+	return x
+}
+
+func sflowDatagram() []byte {
+	// Ethernet + 802.1q + IPv4 + TCP
+	pkt := []byte{
+		0x00, 0x11, 0x22, 0x33, 0x44, 0x55, // dst
+		0x66, 0x77, 0x88, 0x99, 0xaa, 0xbb, // src
+		0x81, 0x00, 0x00, 0x64, // vlan 100
+		0x08, 0x00, // IPv4
+		0x45, 0x10, 0x05, 0xdc, 0x00, 0x00, 0x40, 0x00, 0x3f, 0x06, 0x00, 0x00,
+		192, 0, 2, 1,
+		198, 51, 100, 2,
+		0x04, 0xd2, 0x01, 0xbb, // 1234 -> 443
+		0, 0, 0, 0, 0, 0, 0, 0,
+		0x50, 0x12, // data offset, flags SYN+ACK
+	}
+	header := xdr{}.u32(1, 1518, 4, uint32(len(pkt)))
+	header = append(header, pkt...)
+	for len(header)%4 != 0 {
+		header = append(header, 0)
+	}
+	flow := xdr{}.u32(7, 3<<24|17, 1024, 4096, 0, 17, 18, 1)
+	flow = flow.u32(1).opaque(header)
+
+	generic := xdr{}.u32(17, 6).u64(10000000000).u32(1, 3).u64(123456)
+	generic = generic.u32(1, 2, 3, 4, 5, 6).u64(654321).u32(7, 8, 9, 10, 11, 0)
+	ether := xdr{}.u32(1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13)
+	counters := xdr{}.u32(8, 17, 2).u32(1).opaque(generic).u32(2).opaque(ether)
+
+	d := xdr{}.u32(5, 1) // version, IPv4 agent
+	d = append(d, 10, 0, 0, 1)
+	d = d.u32(0, 42, 3600000, 3)
+	d = d.u32(1).opaque(flow)
+	d = d.u32(2).opaque(counters)
+	d = d.u32(99).opaque([]byte{1, 2, 3, 4}) // unknown sample type
+	return d
+}
+
+func TestSFlow(t *testing.T) {
+	c, err := parser.SFlow{}.Parse(sflowDatagram())
+	if err != nil {
+		t.Fatalf("failed to parse sFlow datagram: %v", err)
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(c.Metrics))
+	}
+	f := c.Metrics[0]
+	expected := map[string]interface{}{
+		"agentAddress":          "10.0.0.1",
+		"sampleType":            "flow",
+		"sourceIdType":          uint32(3),
+		"sourceIdIndex":         uint32(17),
+		"inputInterface":        uint32(17),
+		"outputInterface":       uint32(18),
+		"sourceMacAddress":      "66:77:88:99:aa:bb",
+		"destinationMacAddress": "00:11:22:33:44:55",
+		"vlan":                  uint16(100),
+		"sourceIPAddress":       "192.0.2.1",
+		"destinationIPAddress":  "198.51.100.2",
+		"ipProtocol":            uint8(6),
+		"sourcePort":            uint16(1234),
+		"destinationPort":       uint16(443),
+		"tcpFlags":              uint8(0x12),
+	}
+	for k, v := range expected {
+		if f.Metadata[k] != v {
+			t.Errorf("flow sample: expected metadata %s to be %v (%T), got %v (%T)", k, v, v, f.Metadata[k], f.Metadata[k])
+		}
+	}
+	if f.Data["samplingRate"] != uint32(1024) || f.Data["frameLength"] != uint32(1518) {
+		t.Errorf("flow sample: unexpected data %v", f.Data)
+	}
+
+	cs := c.Metrics[1]
+	if cs.Metadata["sampleType"] != "counter" || cs.Metadata["ifIndex"] != uint32(17) || cs.Metadata["ifType"] != uint32(6) {
+		t.Errorf("counter sample: unexpected metadata %v", cs.Metadata)
+	}
+	if cs.Data["ifSpeed"] != uint64(10000000000) || cs.Data["ifInOctets"] != uint64(123456) || cs.Data["ifOutOctets"] != uint64(654321) {
+		t.Errorf("counter sample: unexpected data %v", cs.Data)
+	}
+	if cs.Data["ifInErrors"] != uint32(5) || cs.Data["ifOutErrors"] != uint32(11) || cs.Data["dot3StatsSymbolErrors"] != uint32(13) {
+		t.Errorf("counter sample: unexpected data %v", cs.Data)
+	}
+}
+
+func TestSFlow_bad(t *testing.T) {
+	d := sflowDatagram()
+	// A header claiming billions of samples with none following must
+	// fail without trying to allocate room for them.
+	huge := append(xdr{}.u32(5, 1), 10, 0, 0, 1)
+	huge = xdr(huge).u32(0, 42, 3600000, 0xffffffff)
+	for _, b := range [][]byte{nil, d[:3], d[:40], d[:len(d)-6], xdr{}.u32(4), huge} {
+		if _, err := (parser.SFlow{}).Parse(b); err == nil {
+			t.Errorf("expected error parsing %d bytes", len(b))
+		}
+	}
+}
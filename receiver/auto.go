@@ -64,6 +64,11 @@ func init() {
 		Alloc:   func() interface{} { return &LineFileAdvanced{} },
 		Help:    "Reads a file line by line. Assumes one collection per line. Renames the file after reading it, and optionally executes a shell command (e.g.: reload a service or send SIGHUP).",
 	})
+	Auto.Add(skogul.Module{
+		Name:  "tail",
+		Alloc: func() interface{} { return &Tail{} },
+		Help:  "Follows files matching glob patterns as they are written to, like tail -F. Detects rotation and truncation by inode and size, saves read offsets in a state file so restarts resume without duplicates, and can join multi-line records such as stack traces.",
+	})
 	Auto.Add(skogul.Module{
 		Name:    "logrus",
 		Aliases: []string{"log"},
@@ -73,12 +78,12 @@ func init() {
 	Auto.Add(skogul.Module{
 		Name:  "mqtt",
 		Alloc: func() interface{} { return &MQTT{} },
-		Help:  "Listen for Skogul-formatted JSON on a MQTT endpoint.",
+		Help:  "Subscribe to topics on a MQTT broker, supporting MQTT 3.1.1 and 5. The topic is added as metadata, and topic patterns can map parts of it to metadata fields.",
 	})
 	Auto.Add(skogul.Module{
 		Name:  "nats",
 		Alloc: func() interface{} { return &Nats{} },
-		Help:  "Connect to a Nats.io server/cluster and subscribe to a subject.",
+		Help:  "Connect to a Nats.io server/cluster and subscribe to a subject, or consume from a durable JetStream consumer.",
 	})
 	Auto.Add(skogul.Module{
 		Name:  "stats",
@@ -118,6 +123,42 @@ func init() {
 	Auto.Add(skogul.Module{
 		Name:  "rabbitmq",
 		Alloc: func() interface{} { return &Rabbitmq{} },
-		Help:  "Connect to a Rabbitmq topic and consume messages.",
+		Help:  "Consume messages from a RabbitMQ queue, optionally bound to an exchange. Messages are acked after they are handled, and rejected or requeued on failure.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "prometheus-scrape",
+		Aliases: []string{"prometheus_scrape", "promscrape"},
+		Alloc:   func() interface{} { return &PrometheusScrape{} },
+		Help:    "Periodically scrape Prometheus /metrics endpoints over HTTP(S) and pass the result to a handler, typically using the prometheus parser. Adds an up/scrape_duration_seconds metric for each target.",
+		Extras:  []interface{}{PrometheusTargetGroup{}},
+	})
+	Auto.Add(skogul.Module{
+		Name:   "snmp",
+		Alloc:  func() interface{} { return &SNMP{} },
+		Help:   "Poll SNMP agents (v1, v2c or v3) for scalars and tables at a regular interval. Each table row becomes a metric, with the index and label columns as metadata.",
+		Extras: []interface{}{SNMPTable{}},
+	})
+	Auto.Add(skogul.Module{
+		Name:    "netflow",
+		Aliases: []string{"ipfix"},
+		Alloc:   func() interface{} { return &NetFlow{} },
+		Help:    "Accept NetFlow v5, NetFlow v9 and IPFIX over UDP, emitting one metric per flow record. Templates are cached per exporter.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "otlp",
+		Aliases: []string{"opentelemetry"},
+		Alloc:   func() interface{} { return &OTLP{} },
+		Help:    "Accept OpenTelemetry metrics over OTLP/gRPC and/or OTLP/HTTP. Each gauge, sum or histogram data point becomes a metric with the metric name as the data key, and resource, scope and data point attributes as metadata.",
+	})
+	Auto.Add(skogul.Module{
+		Name:  "statsd",
+		Alloc: func() interface{} { return &StatsD{} },
+		Help:  "Receive StatsD metrics over UDP, with DogStatsD tags, and send counters, gauges, timers and sets aggregated over a flush interval.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "usp",
+		Aliases: []string{"tr369"},
+		Alloc:   func() interface{} { return &USP{} },
+		Help:    "Act as a USP (TR-369) controller, accepting records from agents over the MQTT and/or WebSocket MTP. Notify requests and Get responses become metrics with the endpoint ID and path as metadata, and Notify requests are acknowledged when the agent asks for it.",
 	})
 }
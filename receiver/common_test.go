@@ -138,3 +138,39 @@ func UpdateTcpConfigWithUsablePorts(config *config.Config) {
 		}
 	}
 }
+
+// chanSender passes every container it receives on to a channel, so
+// tests can inspect what a receiver produced.
+type chanSender struct {
+	ch chan *skogul.Container
+}
+
+func newChanSender() *chanSender {
+	return &chanSender{ch: make(chan *skogul.Container, 100)}
+}
+
+func (cs *chanSender) Send(c *skogul.Container) error {
+	cs.ch <- c
+	return nil
+}
+
+// failSender is a chanSender that fails every send, after passing the
+// container on.
+type failSender struct {
+	*chanSender
+}
+
+func (fs failSender) Send(c *skogul.Container) error {
+	fs.chanSender.Send(c)
+	return fmt.Errorf("failSender always fails")
+}
+
+// wait returns the next container, or nil after a timeout.
+func (cs *chanSender) wait(timeout time.Duration) *skogul.Container {
+	select {
+	case c := <-cs.ch:
+		return c
+	case <-time.After(timeout):
+		return nil
+	}
+}
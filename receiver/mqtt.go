@@ -30,47 +30,68 @@ import (
 
 	"github.com/telenornms/skogul"
 	skmqtt "github.com/telenornms/skogul/internal/mqtt"
-
-	mqtt "github.com/eclipse/paho.mqtt.golang"
 )
 
 var mqttLog = skogul.Logger("receiver", "mqtt")
 
 /*
 MQTT connects to a MQTT broker and listens for messages on a topic.
+
+The topic a message was received on is added as the _mqtt_topic metadata
+field. With TopicPatterns, named wildcards in the topic are also mapped to
+metadata.
 */
 type MQTT struct {
-	Broker          string             `doc:"Address of broker to connect to." example:"[::1]:8888"`
-	Topics          []string           `doc:"List of topics to subscribe to"`
+	Broker          string             `doc:"Address of broker to connect to. Use a mqtts:// or ssl:// URL for TLS, and ws:// or wss:// for WebSockets." example:"[::1]:8888"`
+	Topics          []string           `doc:"List of topics to subscribe to. Defaults to the topic patterns with the names removed, if TopicPatterns is set."`
+	TopicPatterns   []string           `doc:"Topic patterns used to map parts of the topic to metadata. Named wildcards, e.g. +site or #rest, set the metadata field of that name. The first matching pattern is used." example:"[\"sites/+site/devices/+device/#\"]"`
 	Handler         *skogul.HandlerRef `doc:"Handler used to parse, transform and send data."`
 	Password        string             `doc:"Username for authenticating to the broker."`
 	Username        string             `doc:"Password for authenticating."`
 	ClientID        string             `doc:"Custom client id to use (default: random)"`
 	RenewClientID   bool               `doc:"Renew the client ID on reconnects ([MQTT-3.1.4-2] @ https://docs.oasis-open.org/mqtt/mqtt/v3.1.1/os/mqtt-v3.1.1-os.html#_Toc384800405)"`
+	QoS             byte               `doc:"QoS level for the subscriptions: 0, 1 or 2."`
+	Version         int                `doc:"MQTT protocol version, 3 (for 3.1.1) or 5. Default is 3."`
+	ConnectTimeout  skogul.Duration    `doc:"How long to wait for the broker when connecting. Default is 10s."`
+	CA              string             `doc:"Path to a CA certificate for verifying the broker. Default is the system pool."`
+	Certfile        string             `doc:"Path to a client certificate, for authenticating with TLS."`
+	Keyfile         string             `doc:"Path to the key of the client certificate."`
+	Insecure        bool               `doc:"Disable verification of the broker certificate. Dangerous."`
 	DisplayMQTTLogs bool
 
-	mc skmqtt.MQTT
+	mc       skmqtt.MQTT
+	patterns []skmqtt.Pattern
 }
 
-func appendTopic(container *skogul.Container, topic string) {
+func appendTopic(container *skogul.Container, topic string, fields map[string]string) {
 	for _, metric := range container.Metrics {
 		if metric.Metadata == nil {
 			metric.Metadata = make(map[string]interface{})
 		}
 		metric.Metadata["_mqtt_topic"] = topic
+		for key, value := range fields {
+			metric.Metadata[key] = value
+		}
 	}
 }
 
 // Handle a received message.
-func (handler *MQTT) receiver(msg mqtt.Message) {
-	container, err := handler.Handler.H.Parse(msg.Payload())
+func (handler *MQTT) receiver(msg skmqtt.Message) {
+	container, err := handler.Handler.H.Parse(msg.Payload)
 
 	if err != nil {
 		mqttLog.WithError(err).Error("Failed to parse payload from MQTT message")
 		return
 	}
 
-	appendTopic(container, msg.Topic())
+	var fields map[string]string
+	for _, pattern := range handler.patterns {
+		if f, ok := pattern.Match(msg.Topic); ok {
+			fields = f
+			break
+		}
+	}
+	appendTopic(container, msg.Topic, fields)
 
 	err = handler.Handler.H.TransformAndSend(container)
 	if err != nil {
@@ -80,14 +101,39 @@ func (handler *MQTT) receiver(msg mqtt.Message) {
 
 // Start MQTT receiver.
 func (handler *MQTT) Start() error {
+	tlsConf, err := skmqtt.TLSConfig(handler.CA, handler.Certfile, handler.Keyfile, handler.Insecure)
+	if err != nil {
+		return err
+	}
 	handler.mc.MQTTLogs = handler.DisplayMQTTLogs
 	handler.mc.RenewClientID = handler.RenewClientID
-	handler.mc.Init(handler.Broker, handler.Username, handler.Password, handler.ClientID)
-	for _, topic := range handler.Topics {
+	handler.mc.QoS = handler.QoS
+	handler.mc.Version = handler.Version
+	handler.mc.TLS = tlsConf
+	handler.mc.ConnectTimeout = handler.ConnectTimeout.Duration
+	if err := handler.mc.Init(handler.Broker, handler.Username, handler.Password, handler.ClientID); err != nil {
+		return err
+	}
+	topics := handler.Topics
+	for _, p := range handler.TopicPatterns {
+		pattern := skmqtt.NewPattern(p)
+		handler.patterns = append(handler.patterns, pattern)
+		if len(handler.Topics) == 0 {
+			topics = append(topics, pattern.Filter())
+		}
+	}
+	for _, topic := range topics {
 		handler.mc.Subscribe(topic, handler.receiver)
 	}
 	mqttLog.WithField("address", handler.Broker).Debug("Starting MQTT receiver")
-	handler.mc.Connect()
+	for {
+		err := handler.mc.Connect()
+		if err == nil || handler.Version == 5 {
+			break
+		}
+		mqttLog.WithError(err).Warn("Failed to connect to MQTT broker. Retrying in 5 seconds")
+		time.Sleep(5 * time.Second)
+	}
 	// Note that handler.listen() DOES return, because it only sets up
 	// subscriptions. This sillyness is to satisfy the requirement that
 	// Start() never returns. It should PROBABLY be more sensible.
@@ -102,9 +148,18 @@ func (handler *MQTT) Verify() error {
 	if handler.Broker == "" {
 		return skogul.MissingArgument("Broker")
 	}
-	if handler.Topics == nil {
+	if handler.Topics == nil && handler.TopicPatterns == nil {
 		return skogul.MissingArgument("Topics")
 	}
+	if handler.QoS > 2 {
+		return fmt.Errorf("invalid QoS %d, must be 0, 1 or 2", handler.QoS)
+	}
+	if handler.Version != 0 && handler.Version != 3 && handler.Version != 5 {
+		return fmt.Errorf("unsupported MQTT version %d, must be 3 or 5", handler.Version)
+	}
+	if (handler.Certfile == "") != (handler.Keyfile == "") {
+		return fmt.Errorf("both Certfile and Keyfile must be set for client certificates")
+	}
 	if handler.RenewClientID && handler.ClientID != "" {
 		mqttLog.Warning("RenewClientID AND ClientID is set - ClientID will change!")
 	}
@@ -23,11 +23,14 @@
 package receiver
 
 import (
+	"context"
 	"crypto/tls"
 	"fmt"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/telenornms/skogul"
 	"sync"
+	"time"
 )
 
 var natsLog = skogul.Logger("receiver", "nats")
@@ -35,6 +38,12 @@ var natsLog = skogul.Logger("receiver", "nats")
 /*
 Nats basic pub/sub receiver implementing all Authentication & Authorization
 features in the nats golang client. Basic queue groups is also supported.
+
+With JetStream enabled, a durable pull consumer is used instead, so
+messages published while skogul is down are kept by the server. Messages
+are acked after they are handled, and negatively acked for redelivery if
+handling fails, up to MaxDeliver times. Multiple skogul instances using
+the same Durable share the messages between them, like a queue group.
 */
 type Nats struct {
 	Handler       skogul.HandlerRef `doc:"Handler used to parse, transform and send data."`
@@ -50,6 +59,12 @@ type Nats struct {
 	UserCreds     string            `doc:"Nats credentials file path"`
 	NKeyFile      string            `doc:"Nats nkey file path"`
 	Insecure      bool              `doc:"TLS InsecureSkipVerify"`
+	JetStream     bool              `doc:"Use a durable JetStream pull consumer instead of a core NATS subscription."`
+	Stream        string            `doc:"JetStream stream to consume from. Fallback is the stream listening on Subject."`
+	Durable       string            `doc:"Name of the durable JetStream consumer. Fallback is the client name."`
+	MaxInFlight   int               `doc:"Maximum number of JetStream messages delivered but not yet acked. Fallback is 1000."`
+	MaxDeliver    int               `doc:"Maximum number of times a JetStream message is delivered before it is given up on. Fallback is unlimited."`
+	AckWait       skogul.Duration   `doc:"How long JetStream waits for an ack before redelivering a message. Fallback is 30s."`
 	conOpts       *[]nats.Option
 	natsCon       *nats.Conn
 	wg            sync.WaitGroup
@@ -72,6 +87,14 @@ func (n *Nats) Verify() error {
 		return fmt.Errorf("Please configure usercreds or nkeyfile.")
 	}
 
+	if n.JetStream && n.Queue != "" {
+		return fmt.Errorf("Queue can not be used with JetStream, use the same Durable instead.")
+	}
+
+	if n.MaxInFlight < 0 || n.MaxDeliver < 0 {
+		return fmt.Errorf("MaxInFlight and MaxDeliver can not be negative.")
+	}
+
 	return nil
 }
 
@@ -148,8 +171,12 @@ func (n *Nats) Start() error {
 		return
 	}
 
+	if n.JetStream {
+		return n.startJetStream()
+	}
+
 	n.wg.Add(1)
-	if n.Queue == "" {
+	if n.Queue != "" {
 		n.natsCon.QueueSubscribe(n.Subject, n.Queue, cb)
 	} else {
 		n.natsCon.Subscribe(n.Subject, cb)
@@ -157,3 +184,78 @@ func (n *Nats) Start() error {
 	n.wg.Wait()
 	return n.natsCon.LastError()
 }
+
+// startJetStream creates or updates the durable consumer, retrying until
+// the server is reachable, and consumes messages from it.
+func (n *Nats) startJetStream() error {
+	js, err := jetstream.New(n.natsCon)
+	if err != nil {
+		return err
+	}
+	if n.Durable == "" {
+		n.Durable = n.Name
+	}
+	if n.MaxInFlight == 0 {
+		n.MaxInFlight = 1000
+	}
+	conf := jetstream.ConsumerConfig{
+		Durable:       n.Durable,
+		FilterSubject: n.Subject,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		AckWait:       n.AckWait.Duration,
+		MaxAckPending: n.MaxInFlight,
+		MaxDeliver:    n.MaxDeliver,
+	}
+	if conf.MaxDeliver == 0 {
+		conf.MaxDeliver = -1
+	}
+	var consumer jetstream.Consumer
+	for {
+		consumer, err = n.consumer(js, conf)
+		if err == nil {
+			break
+		}
+		natsLog.WithError(err).Warn("Unable to set up JetStream consumer, retrying in 5 seconds")
+		time.Sleep(5 * time.Second)
+	}
+
+	cb := func(msg jetstream.Msg) {
+		natsLog.Debugf("Received message on %v", msg.Subject())
+		if err := n.Handler.H.Handle(msg.Data()); err != nil {
+			natsLog.WithError(err).Warn("Unable to handle Nats message")
+			if err := msg.Nak(); err != nil {
+				natsLog.WithError(err).Error("Unable to nak message")
+			}
+			return
+		}
+		if err := msg.Ack(); err != nil {
+			natsLog.WithError(err).Error("Unable to ack message")
+		}
+	}
+	errh := jetstream.ConsumeErrHandler(func(_ jetstream.ConsumeContext, err error) {
+		natsLog.WithError(err).Warn("JetStream consumer error")
+	})
+
+	n.wg.Add(1)
+	cc, err := consumer.Consume(cb, jetstream.PullMaxMessages(n.MaxInFlight), errh)
+	if err != nil {
+		return err
+	}
+	defer cc.Stop()
+	n.wg.Wait()
+	return n.natsCon.LastError()
+}
+
+func (n *Nats) consumer(js jetstream.JetStream, conf jetstream.ConsumerConfig) (jetstream.Consumer, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	stream := n.Stream
+	if stream == "" {
+		var err error
+		stream, err = js.StreamNameBySubject(ctx, n.Subject)
+		if err != nil {
+			return nil, fmt.Errorf("unable to find stream for subject %s: %w", n.Subject, err)
+		}
+	}
+	return js.CreateOrUpdateConsumer(ctx, stream, conf)
+}
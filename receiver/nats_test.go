@@ -1,9 +1,16 @@
 package receiver_test
 
 import (
+	"bufio"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/config"
 	"github.com/telenornms/skogul/receiver"
-	"testing"
 )
 
 func TestNats(t *testing.T) {
@@ -42,3 +49,109 @@ func TestNats(t *testing.T) {
 		t.Error("Bad config")
 	}
 }
+
+func TestNatsJetStream(t *testing.T) {
+	conf := `
+	{
+	  "receivers": {
+	    "nats_r": {
+	      "type": "nats",
+	      "servers": "nats://0.0.0.0:4222",
+	      %s
+	      "subject": "test.subject",
+	      "jetstream": true,
+	      "durable": "skogul",
+	      "maxinflight": 100,
+	      "maxdeliver": 5,
+	      "handler": "test_h"
+	    }
+	  },
+	  "handlers": {
+	    "test_h": {
+	      "parser": "skogul",
+	      "transformers": [],
+	      "sender": "test"
+	    }
+	  },
+	  "senders": {
+	    "test": {
+	      "type": "test"
+	    }
+	  }
+	}`
+	c, err := config.Bytes([]byte(fmt.Sprintf(conf, "")))
+
+	if err != nil {
+		t.Fatalf("Failed to load config: %s", err)
+	}
+
+	nr := c.Receivers["nats_r"].Receiver.(*receiver.Nats)
+
+	if !nr.JetStream || nr.MaxInFlight != 100 || nr.MaxDeliver != 5 {
+		t.Errorf("Bad config: %v", nr)
+	}
+
+	_, err = config.Bytes([]byte(fmt.Sprintf(conf, `"queue": "skogul_queue",`)))
+	if err == nil {
+		t.Errorf("Queue with JetStream did not fail")
+	}
+}
+
+// natsSubscriptions runs just enough of a NATS server to accept a client,
+// and returns the SUB lines it sends.
+func natsSubscriptions(t *testing.T, ln net.Listener) <-chan string {
+	t.Helper()
+	subs := make(chan string, 10)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(conn, "INFO {\"server_id\":\"test\",\"version\":\"2.10.0\",\"proto\":1,\"max_payload\":1048576}\r\n")
+		r := bufio.NewReader(conn)
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimSpace(line)
+			switch {
+			case line == "PING":
+				fmt.Fprintf(conn, "PONG\r\n")
+			case strings.HasPrefix(line, "SUB "):
+				subs <- strings.Join(strings.Fields(line), " ")
+			}
+		}
+	}()
+	return subs
+}
+
+func TestNatsQueue(t *testing.T) {
+	for queue, want := range map[string]string{
+		"":        "SUB test.subject 1",
+		"workers": "SUB test.subject workers 1",
+	} {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unable to listen: %v", err)
+		}
+		defer ln.Close()
+		subs := natsSubscriptions(t, ln)
+		n := receiver.Nats{
+			Servers: "nats://" + ln.Addr().String(),
+			Subject: "test.subject",
+			Queue:   queue,
+			Handler: skogul.HandlerRef{Name: "h"},
+		}
+		go n.Start()
+		select {
+		case got := <-subs:
+			if got != want {
+				t.Errorf("Queue %q: expected %q, got %q", queue, want, got)
+			}
+		case <-time.After(2 * time.Second):
+			t.Errorf("Queue %q: no subscription received", queue)
+		}
+	}
+}
@@ -0,0 +1,590 @@
+/*
+ * skogul, netflow v5/v9 and ipfix receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var flowLog = skogul.Logger("receiver", "netflow")
+
+/*
+NetFlow receives NetFlow v5, NetFlow v9 and IPFIX over UDP, and emits
+one metric per flow record.
+
+Unlike most receivers, NetFlow does not use the parser of the handler.
+NetFlow v9 and IPFIX are template based, and the templates are scoped to
+the exporter that sent them, which is only known to the receiver. The
+templates are cached per exporter, observation domain (source ID for v9)
+and template ID. Data records that arrive before their template are
+dropped and counted as template misses.
+
+Field names use the IANA IPFIX information element names for all
+versions, e.g. sourceIPv4Address, destinationTransportPort and
+octetDeltaCount. Addresses, ports, protocol, interfaces and similar keys
+are stored as metadata, while counters and timestamps are stored as data.
+In addition, the "exporter", "flowVersion" and "observationDomainId"
+metadata fields are set. Unknown information elements are stored as data
+fields named "ie<id>" or "ie<enterprise>.<id>".
+
+Decoding happens in the read loop, which keeps template handling
+ordered, while the handler is run in a pool of worker go routines.
+*/
+type NetFlow struct {
+	Address     string            `doc:"Address and port to listen to." example:"[::1]:2055"`
+	Handler     skogul.HandlerRef `doc:"Handler used to transform and send data. The parser of the handler is not used."`
+	Backlog     int               `doc:"Number of decoded containers that are not yet handled before the receiver starts blocking. Defaults to 100."`
+	Threads     int               `doc:"Number of worker go routines running the handler. Defaults to number of CPU threads, with a minimum of 20."`
+	PacketSize  int               `doc:"Maximum UDP packet size. Default: 9000"`
+	Buffer      int               `doc:"Set kernel read buffer. Default is kernel-specific."`
+	TemplateTTL skogul.Duration   `doc:"How long a template is kept without being refreshed by the exporter. Default: 1h"`
+	ch          chan *skogul.Container
+	decoder     *flowDecoder
+	stats       flowStats
+}
+
+type flowStats struct {
+	Received         uint64 // Number of UDP packets received.
+	DecodeErrors     uint64 // Packets or sets that could not be decoded.
+	TemplateMisses   uint64 // Data sets referencing an unknown template.
+	Templates        uint64 // Template (re)definitions received.
+	Records          uint64 // Flow records decoded.
+	OptionRecords    uint64 // Options data records decoded and discarded.
+	HandlerErrors    uint64 // Errors from the handler.
+	Sent             uint64 // Containers successfully handled.
+	CurrentTemplates int64  // Number of templates currently cached.
+}
+
+// flowTemplateField is a single field of a template.
+type flowTemplateField struct {
+	id         uint16
+	enterprise uint32
+	length     uint16 // 65535 means variable length (IPFIX only)
+}
+
+// flowTemplate is a cached NetFlow v9 or IPFIX template.
+type flowTemplate struct {
+	fields  []flowTemplateField
+	options bool
+	updated time.Time
+}
+
+// minLength returns the minimum length of a record using the template.
+func (t *flowTemplate) minLength() int {
+	l := 0
+	for _, f := range t.fields {
+		if f.length == 65535 {
+			l++
+		} else {
+			l += int(f.length)
+		}
+	}
+	return l
+}
+
+// flowDecoder holds the decoding state, i.e. the template cache. It is
+// not concurrency safe.
+type flowDecoder struct {
+	templates map[string]*flowTemplate
+	ttl       time.Duration
+	stats     *flowStats
+}
+
+// flowHeader holds the parts of the packet header needed to decode
+// records.
+type flowHeader struct {
+	exporter string
+	version  uint16
+	domain   uint32
+	export   time.Time
+	uptime   uint32 // milliseconds, v5 and v9 only
+}
+
+func (h *flowHeader) templateKey(id uint16) string {
+	return fmt.Sprintf("%s/%d/%d/%d", h.exporter, h.version, h.domain, id)
+}
+
+// newMetric creates a metric with the common metadata set.
+func (h *flowHeader) newMetric() *skogul.Metric {
+	t := h.export
+	return &skogul.Metric{
+		Time: &t,
+		Metadata: map[string]interface{}{
+			"exporter":            h.exporter,
+			"flowVersion":         h.version,
+			"observationDomainId": h.domain,
+		},
+		Data: make(map[string]interface{}),
+	}
+}
+
+// sysUpTimeToEpoch adds absolute flowStart/EndMilliseconds for the
+// sysUpTime-relative fields found in v5 and v9.
+func (h *flowHeader) sysUpTimeToEpoch(m *skogul.Metric) {
+	base := h.export.UnixMilli() - int64(h.uptime)
+	if v, ok := m.Data["flowStartSysUpTime"].(uint64); ok {
+		m.Data["flowStartMilliseconds"] = uint64(base + int64(v))
+	}
+	if v, ok := m.Data["flowEndSysUpTime"].(uint64); ok {
+		m.Data["flowEndMilliseconds"] = uint64(base + int64(v))
+	}
+}
+
+func newFlowDecoder(ttl time.Duration, stats *flowStats) *flowDecoder {
+	return &flowDecoder{
+		templates: make(map[string]*flowTemplate),
+		ttl:       ttl,
+		stats:     stats,
+	}
+}
+
+// decode decodes a single packet from the exporter. Partial results are
+// returned along with an error if parts of the packet could not be
+// decoded.
+func (d *flowDecoder) decode(exporter string, b []byte) ([]*skogul.Metric, error) {
+	if len(b) < 2 {
+		return nil, fmt.Errorf("short packet, %d bytes", len(b))
+	}
+	switch v := binary.BigEndian.Uint16(b); v {
+	case 5:
+		return d.decodeV5(exporter, b)
+	case 9:
+		return d.decodeV9(exporter, b)
+	case 10:
+		return d.decodeIPFIX(exporter, b)
+	default:
+		return nil, fmt.Errorf("unsupported flow version %d", v)
+	}
+}
+
+func (d *flowDecoder) decodeV5(exporter string, b []byte) ([]*skogul.Metric, error) {
+	const hlen, rlen = 24, 48
+	if len(b) < hlen {
+		return nil, fmt.Errorf("short NetFlow v5 header, %d bytes", len(b))
+	}
+	count := int(binary.BigEndian.Uint16(b[2:]))
+	h := flowHeader{
+		exporter: exporter,
+		version:  5,
+		uptime:   binary.BigEndian.Uint32(b[4:]),
+		export:   time.Unix(int64(binary.BigEndian.Uint32(b[8:])), int64(binary.BigEndian.Uint32(b[12:]))),
+		domain:   uint32(b[20])<<8 | uint32(b[21]),
+	}
+	sampling := binary.BigEndian.Uint16(b[22:]) & 0x3fff
+	if len(b) < hlen+count*rlen {
+		return nil, fmt.Errorf("NetFlow v5 packet claims %d records, but is only %d bytes", count, len(b))
+	}
+	ret := make([]*skogul.Metric, 0, count)
+	for i := 0; i < count; i++ {
+		r := b[hlen+i*rlen:]
+		m := h.newMetric()
+		m.Metadata["sourceIPv4Address"] = net.IP(r[0:4]).String()
+		m.Metadata["destinationIPv4Address"] = net.IP(r[4:8]).String()
+		m.Metadata["ipNextHopIPv4Address"] = net.IP(r[8:12]).String()
+		m.Metadata["ingressInterface"] = uint64(binary.BigEndian.Uint16(r[12:]))
+		m.Metadata["egressInterface"] = uint64(binary.BigEndian.Uint16(r[14:]))
+		m.Data["packetDeltaCount"] = uint64(binary.BigEndian.Uint32(r[16:]))
+		m.Data["octetDeltaCount"] = uint64(binary.BigEndian.Uint32(r[20:]))
+		m.Data["flowStartSysUpTime"] = uint64(binary.BigEndian.Uint32(r[24:]))
+		m.Data["flowEndSysUpTime"] = uint64(binary.BigEndian.Uint32(r[28:]))
+		m.Metadata["sourceTransportPort"] = uint64(binary.BigEndian.Uint16(r[32:]))
+		m.Metadata["destinationTransportPort"] = uint64(binary.BigEndian.Uint16(r[34:]))
+		m.Metadata["tcpControlBits"] = uint64(r[37])
+		m.Metadata["protocolIdentifier"] = uint64(r[38])
+		m.Metadata["ipClassOfService"] = uint64(r[39])
+		m.Metadata["bgpSourceAsNumber"] = uint64(binary.BigEndian.Uint16(r[40:]))
+		m.Metadata["bgpDestinationAsNumber"] = uint64(binary.BigEndian.Uint16(r[42:]))
+		m.Metadata["sourceIPv4PrefixLength"] = uint64(r[44])
+		m.Metadata["destinationIPv4PrefixLength"] = uint64(r[45])
+		if sampling > 0 {
+			m.Metadata["samplingInterval"] = uint64(sampling)
+		}
+		h.sysUpTimeToEpoch(m)
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+func (d *flowDecoder) decodeV9(exporter string, b []byte) ([]*skogul.Metric, error) {
+	const hlen = 20
+	if len(b) < hlen {
+		return nil, fmt.Errorf("short NetFlow v9 header, %d bytes", len(b))
+	}
+	h := flowHeader{
+		exporter: exporter,
+		version:  9,
+		uptime:   binary.BigEndian.Uint32(b[4:]),
+		export:   time.Unix(int64(binary.BigEndian.Uint32(b[8:])), 0),
+		domain:   binary.BigEndian.Uint32(b[16:]),
+	}
+	return d.decodeSets(&h, b[hlen:], 0, 1)
+}
+
+func (d *flowDecoder) decodeIPFIX(exporter string, b []byte) ([]*skogul.Metric, error) {
+	const hlen = 16
+	if len(b) < hlen {
+		return nil, fmt.Errorf("short IPFIX header, %d bytes", len(b))
+	}
+	length := int(binary.BigEndian.Uint16(b[2:]))
+	if length < hlen || length > len(b) {
+		return nil, fmt.Errorf("invalid IPFIX message length %d for %d byte packet", length, len(b))
+	}
+	h := flowHeader{
+		exporter: exporter,
+		version:  10,
+		export:   time.Unix(int64(binary.BigEndian.Uint32(b[4:])), 0),
+		domain:   binary.BigEndian.Uint32(b[12:]),
+	}
+	return d.decodeSets(&h, b[hlen:length], 2, 3)
+}
+
+// decodeSets decodes the flowsets (v9) or sets (IPFIX) of a packet. The
+// two are identical except for the set IDs used for templates.
+func (d *flowDecoder) decodeSets(h *flowHeader, b []byte, templateID, optionsID uint16) ([]*skogul.Metric, error) {
+	ret := make([]*skogul.Metric, 0)
+	var errs []string
+	for len(b) >= 4 {
+		id := binary.BigEndian.Uint16(b)
+		length := int(binary.BigEndian.Uint16(b[2:]))
+		if length < 4 || length > len(b) {
+			errs = append(errs, fmt.Sprintf("invalid set length %d with %d bytes left", length, len(b)))
+			break
+		}
+		set := b[4:length]
+		b = b[length:]
+		var err error
+		switch {
+		case id == templateID:
+			err = d.decodeTemplates(h, set, false)
+		case id == optionsID:
+			err = d.decodeTemplates(h, set, true)
+		case id >= 256:
+			var ms []*skogul.Metric
+			ms, err = d.decodeData(h, id, set)
+			ret = append(ret, ms...)
+		}
+		if err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return ret, fmt.Errorf("%d decode errors, first: %s", len(errs), errs[0])
+	}
+	return ret, nil
+}
+
+// decodeTemplates decodes a template or options template set and caches
+// the templates.
+func (d *flowDecoder) decodeTemplates(h *flowHeader, b []byte, options bool) error {
+	ipfix := h.version == 10
+	for len(b) >= 4 {
+		id := binary.BigEndian.Uint16(b)
+		count := int(binary.BigEndian.Uint16(b[2:]))
+		b = b[4:]
+		if id < 256 {
+			// Padding, or garbage.
+			return nil
+		}
+		if ipfix && count == 0 {
+			// Template withdrawal. For options templates too, it
+			// has no scope field count.
+			if _, ok := d.templates[h.templateKey(id)]; ok {
+				delete(d.templates, h.templateKey(id))
+				atomic.AddInt64(&d.stats.CurrentTemplates, -1)
+			}
+			continue
+		}
+		if options && !ipfix {
+			// v9: scope length and option length are in bytes,
+			// not number of fields.
+			if len(b) < 2 {
+				return fmt.Errorf("short v9 options template %d", id)
+			}
+			optLen := int(binary.BigEndian.Uint16(b))
+			b = b[2:]
+			count = (count + optLen) / 4
+		} else if options {
+			// IPFIX: count is the total number of fields, and
+			// the scope field count follows, which we do not
+			// need.
+			if len(b) < 2 {
+				return fmt.Errorf("short IPFIX options template %d", id)
+			}
+			b = b[2:]
+		}
+		t := flowTemplate{options: options, updated: skogul.Now(), fields: make([]flowTemplateField, 0, count)}
+		for i := 0; i < count; i++ {
+			if len(b) < 4 {
+				return fmt.Errorf("truncated template %d", id)
+			}
+			f := flowTemplateField{id: binary.BigEndian.Uint16(b), length: binary.BigEndian.Uint16(b[2:])}
+			b = b[4:]
+			if ipfix && f.id&0x8000 != 0 {
+				if len(b) < 4 {
+					return fmt.Errorf("truncated template %d", id)
+				}
+				f.id &= 0x7fff
+				f.enterprise = binary.BigEndian.Uint32(b)
+				b = b[4:]
+			}
+			t.fields = append(t.fields, f)
+		}
+		if t.minLength() == 0 {
+			return fmt.Errorf("template %d has zero length records", id)
+		}
+		key := h.templateKey(id)
+		if _, ok := d.templates[key]; !ok {
+			atomic.AddInt64(&d.stats.CurrentTemplates, 1)
+		}
+		d.templates[key] = &t
+		atomic.AddUint64(&d.stats.Templates, 1)
+	}
+	return nil
+}
+
+// decodeData decodes a data set using a cached template.
+func (d *flowDecoder) decodeData(h *flowHeader, id uint16, b []byte) ([]*skogul.Metric, error) {
+	key := h.templateKey(id)
+	t := d.templates[key]
+	if t != nil && d.ttl > 0 && skogul.Now().Sub(t.updated) > d.ttl {
+		delete(d.templates, key)
+		atomic.AddInt64(&d.stats.CurrentTemplates, -1)
+		t = nil
+	}
+	if t == nil {
+		atomic.AddUint64(&d.stats.TemplateMisses, 1)
+		return nil, nil
+	}
+	min := t.minLength()
+	ret := make([]*skogul.Metric, 0, len(b)/min)
+	for len(b) >= min {
+		m := h.newMetric()
+		for _, f := range t.fields {
+			l := int(f.length)
+			if f.length == 65535 {
+				if len(b) < 1 {
+					return ret, fmt.Errorf("truncated variable length field in template %d", id)
+				}
+				l = int(b[0])
+				b = b[1:]
+				if l == 255 {
+					if len(b) < 2 {
+						return ret, fmt.Errorf("truncated variable length field in template %d", id)
+					}
+					l = int(binary.BigEndian.Uint16(b))
+					b = b[2:]
+				}
+			}
+			if len(b) < l {
+				return ret, fmt.Errorf("truncated record in template %d", id)
+			}
+			flowSetField(m, f, b[:l])
+			b = b[l:]
+		}
+		if t.options {
+			atomic.AddUint64(&d.stats.OptionRecords, 1)
+			continue
+		}
+		if h.version == 9 {
+			h.sysUpTimeToEpoch(m)
+		}
+		ret = append(ret, m)
+	}
+	return ret, nil
+}
+
+// flowSetField decodes a single field and stores it in the metric.
+func flowSetField(m *skogul.Metric, f flowTemplateField, b []byte) {
+	def, known := flowFields[f.id]
+	if f.enterprise != 0 || !known {
+		name := fmt.Sprintf("ie%d", f.id)
+		if f.enterprise != 0 {
+			name = fmt.Sprintf("ie%d.%d", f.enterprise, f.id)
+		}
+		if len(b) <= 8 {
+			m.Data[name] = flowUintValue(b)
+		} else {
+			m.Data[name] = hex.EncodeToString(b)
+		}
+		return
+	}
+	var v interface{}
+	switch def.kind {
+	case flowAddr:
+		if len(b) != 4 && len(b) != 16 {
+			v = hex.EncodeToString(b)
+		} else {
+			v = net.IP(b).String()
+		}
+	case flowMAC:
+		v = net.HardwareAddr(b).String()
+	case flowString:
+		v = strings.TrimRight(string(b), "\x00")
+	default:
+		if len(b) > 8 {
+			v = hex.EncodeToString(b)
+		} else {
+			v = flowUintValue(b)
+		}
+	}
+	if def.data {
+		m.Data[def.name] = v
+	} else {
+		m.Metadata[def.name] = v
+	}
+}
+
+// flowUintValue decodes a big endian unsigned integer of up to 8 bytes,
+// which covers reduced-size encoding.
+func flowUintValue(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// Verify the configuration.
+func (nf *NetFlow) Verify() error {
+	if nf.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if nf.Address == "" {
+		return skogul.MissingArgument("Address")
+	}
+	if nf.PacketSize < 0 || nf.PacketSize > UDP_MAX_READ_SIZE {
+		return fmt.Errorf("invalid udp packet size, maximum udp read size is between 0 and %d", UDP_MAX_READ_SIZE)
+	}
+	return nil
+}
+
+// process runs the handler on decoded containers.
+func (nf *NetFlow) process() {
+	for c := range nf.ch {
+		if err := nf.Handler.H.TransformAndSend(c); err != nil {
+			atomic.AddUint64(&nf.stats.HandlerErrors, 1)
+			flowLog.WithError(err).Error("Unable to handle flow records")
+		} else {
+			atomic.AddUint64(&nf.stats.Sent, 1)
+		}
+	}
+}
+
+// Start listens for flow packets. Never returns, unless the address
+// cannot be bound.
+func (nf *NetFlow) Start() error {
+	if nf.PacketSize == 0 {
+		nf.PacketSize = 9000
+	}
+	if nf.Backlog == 0 {
+		nf.Backlog = 100
+	}
+	if nf.Threads == 0 {
+		nf.Threads = runtime.NumCPU()
+		if nf.Threads < 20 {
+			nf.Threads = 20
+		}
+	}
+	if nf.TemplateTTL.Duration == 0 {
+		nf.TemplateTTL.Duration = time.Hour
+	}
+	nf.decoder = newFlowDecoder(nf.TemplateTTL.Duration, &nf.stats)
+	nf.ch = make(chan *skogul.Container, nf.Backlog)
+	var wg sync.WaitGroup
+	for i := 0; i < nf.Threads; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			nf.process()
+		}()
+	}
+	defer func() {
+		close(nf.ch)
+		wg.Wait()
+	}()
+
+	udpip, err := net.ResolveUDPAddr("udp", nf.Address)
+	if err != nil {
+		return fmt.Errorf("unable to resolve address %s: %w", nf.Address, err)
+	}
+	ln, err := net.ListenUDP("udp", udpip)
+	if err != nil {
+		return err
+	}
+	if nf.Buffer > 0 {
+		ln.SetReadBuffer(nf.Buffer)
+	}
+	buf := make([]byte, nf.PacketSize)
+	for {
+		n, addr, err := ln.ReadFromUDP(buf)
+		if err != nil || n == 0 {
+			flowLog.WithError(err).WithField("bytes", n).Error("Unable to read UDP message")
+			continue
+		}
+		atomic.AddUint64(&nf.stats.Received, 1)
+		metrics, err := nf.decoder.decode(addr.IP.String(), buf[:n])
+		if err != nil {
+			atomic.AddUint64(&nf.stats.DecodeErrors, 1)
+			flowLog.WithError(err).WithField("exporter", addr.IP.String()).Debug("Unable to decode flow packet")
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		atomic.AddUint64(&nf.stats.Records, uint64(len(metrics)))
+		nf.ch <- &skogul.Container{Metrics: metrics}
+	}
+}
+
+// GetStats exposes decode statistics.
+func (nf *NetFlow) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "netflow"
+	metric.Metadata["identity"] = skogul.Identity[nf]
+	metric.Data["received"] = atomic.LoadUint64(&nf.stats.Received)
+	metric.Data["decode_errors"] = atomic.LoadUint64(&nf.stats.DecodeErrors)
+	metric.Data["template_misses"] = atomic.LoadUint64(&nf.stats.TemplateMisses)
+	metric.Data["templates_received"] = atomic.LoadUint64(&nf.stats.Templates)
+	metric.Data["templates"] = atomic.LoadInt64(&nf.stats.CurrentTemplates)
+	metric.Data["records"] = atomic.LoadUint64(&nf.stats.Records)
+	metric.Data["option_records"] = atomic.LoadUint64(&nf.stats.OptionRecords)
+	metric.Data["handler_errors"] = atomic.LoadUint64(&nf.stats.HandlerErrors)
+	metric.Data["sent"] = atomic.LoadUint64(&nf.stats.Sent)
+	return &metric
+}
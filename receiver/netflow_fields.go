@@ -0,0 +1,154 @@
+/*
+ * skogul, netflow/ipfix information elements
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+// flowFieldKind determines how the raw bytes of a flow field are
+// interpreted.
+type flowFieldKind int
+
+const (
+	flowUint flowFieldKind = iota
+	flowAddr
+	flowMAC
+	flowString
+)
+
+// flowField describes a NetFlow v9/IPFIX information element. Data fields
+// are stored in the data section of the metric, everything else is
+// considered metadata.
+type flowField struct {
+	name string
+	kind flowFieldKind
+	data bool
+}
+
+// flowFields maps information element IDs to names, using the IANA IPFIX
+// names. NetFlow v9 uses the same numbering for the elements below, so the
+// same table is used for both, and also for the fixed NetFlow v5 format.
+//
+// See https://www.iana.org/assignments/ipfix/ipfix.xhtml
+var flowFields = map[uint16]flowField{
+	1:   {"octetDeltaCount", flowUint, true},
+	2:   {"packetDeltaCount", flowUint, true},
+	3:   {"deltaFlowCount", flowUint, true},
+	4:   {"protocolIdentifier", flowUint, false},
+	5:   {"ipClassOfService", flowUint, false},
+	6:   {"tcpControlBits", flowUint, false},
+	7:   {"sourceTransportPort", flowUint, false},
+	8:   {"sourceIPv4Address", flowAddr, false},
+	9:   {"sourceIPv4PrefixLength", flowUint, false},
+	10:  {"ingressInterface", flowUint, false},
+	11:  {"destinationTransportPort", flowUint, false},
+	12:  {"destinationIPv4Address", flowAddr, false},
+	13:  {"destinationIPv4PrefixLength", flowUint, false},
+	14:  {"egressInterface", flowUint, false},
+	15:  {"ipNextHopIPv4Address", flowAddr, false},
+	16:  {"bgpSourceAsNumber", flowUint, false},
+	17:  {"bgpDestinationAsNumber", flowUint, false},
+	18:  {"bgpNextHopIPv4Address", flowAddr, false},
+	19:  {"postMCastPacketDeltaCount", flowUint, true},
+	20:  {"postMCastOctetDeltaCount", flowUint, true},
+	21:  {"flowEndSysUpTime", flowUint, true},
+	22:  {"flowStartSysUpTime", flowUint, true},
+	23:  {"postOctetDeltaCount", flowUint, true},
+	24:  {"postPacketDeltaCount", flowUint, true},
+	25:  {"minimumIpTotalLength", flowUint, true},
+	26:  {"maximumIpTotalLength", flowUint, true},
+	27:  {"sourceIPv6Address", flowAddr, false},
+	28:  {"destinationIPv6Address", flowAddr, false},
+	29:  {"sourceIPv6PrefixLength", flowUint, false},
+	30:  {"destinationIPv6PrefixLength", flowUint, false},
+	31:  {"flowLabelIPv6", flowUint, false},
+	32:  {"icmpTypeCodeIPv4", flowUint, false},
+	33:  {"igmpType", flowUint, false},
+	34:  {"samplingInterval", flowUint, false},
+	35:  {"samplingAlgorithm", flowUint, false},
+	36:  {"flowActiveTimeout", flowUint, false},
+	37:  {"flowIdleTimeout", flowUint, false},
+	38:  {"engineType", flowUint, false},
+	39:  {"engineId", flowUint, false},
+	40:  {"exportedOctetTotalCount", flowUint, true},
+	41:  {"exportedMessageTotalCount", flowUint, true},
+	42:  {"exportedFlowRecordTotalCount", flowUint, true},
+	44:  {"sourceIPv4Prefix", flowAddr, false},
+	45:  {"destinationIPv4Prefix", flowAddr, false},
+	46:  {"mplsTopLabelType", flowUint, false},
+	47:  {"mplsTopLabelIPv4Address", flowAddr, false},
+	48:  {"samplerId", flowUint, false},
+	49:  {"samplerMode", flowUint, false},
+	50:  {"samplerRandomInterval", flowUint, false},
+	52:  {"minimumTTL", flowUint, true},
+	53:  {"maximumTTL", flowUint, true},
+	54:  {"fragmentIdentification", flowUint, false},
+	55:  {"postIpClassOfService", flowUint, false},
+	56:  {"sourceMacAddress", flowMAC, false},
+	57:  {"postDestinationMacAddress", flowMAC, false},
+	58:  {"vlanId", flowUint, false},
+	59:  {"postVlanId", flowUint, false},
+	60:  {"ipVersion", flowUint, false},
+	61:  {"flowDirection", flowUint, false},
+	62:  {"ipNextHopIPv6Address", flowAddr, false},
+	63:  {"bgpNextHopIPv6Address", flowAddr, false},
+	64:  {"ipv6ExtensionHeaders", flowUint, false},
+	70:  {"mplsTopLabelStackSection", flowUint, false},
+	80:  {"destinationMacAddress", flowMAC, false},
+	81:  {"postSourceMacAddress", flowMAC, false},
+	82:  {"interfaceName", flowString, false},
+	83:  {"interfaceDescription", flowString, false},
+	85:  {"octetTotalCount", flowUint, true},
+	86:  {"packetTotalCount", flowUint, true},
+	88:  {"fragmentOffset", flowUint, false},
+	89:  {"forwardingStatus", flowUint, false},
+	128: {"bgpNextAdjacentAsNumber", flowUint, false},
+	129: {"bgpPrevAdjacentAsNumber", flowUint, false},
+	130: {"exporterIPv4Address", flowAddr, false},
+	131: {"exporterIPv6Address", flowAddr, false},
+	136: {"flowEndReason", flowUint, false},
+	139: {"icmpTypeCodeIPv6", flowUint, false},
+	148: {"flowId", flowUint, false},
+	150: {"flowStartSeconds", flowUint, true},
+	151: {"flowEndSeconds", flowUint, true},
+	152: {"flowStartMilliseconds", flowUint, true},
+	153: {"flowEndMilliseconds", flowUint, true},
+	154: {"flowStartMicroseconds", flowUint, true},
+	155: {"flowEndMicroseconds", flowUint, true},
+	160: {"systemInitTimeMilliseconds", flowUint, true},
+	176: {"icmpTypeIPv4", flowUint, false},
+	177: {"icmpCodeIPv4", flowUint, false},
+	178: {"icmpTypeIPv6", flowUint, false},
+	179: {"icmpCodeIPv6", flowUint, false},
+	225: {"postNATSourceIPv4Address", flowAddr, false},
+	226: {"postNATDestinationIPv4Address", flowAddr, false},
+	227: {"postNAPTSourceTransportPort", flowUint, false},
+	228: {"postNAPTDestinationTransportPort", flowUint, false},
+	234: {"ingressVRFID", flowUint, false},
+	235: {"egressVRFID", flowUint, false},
+	243: {"dot1qVlanId", flowUint, false},
+	252: {"ingressPhysicalInterface", flowUint, false},
+	253: {"egressPhysicalInterface", flowUint, false},
+	281: {"postNATSourceIPv6Address", flowAddr, false},
+	282: {"postNATDestinationIPv6Address", flowAddr, false},
+	305: {"samplingPacketInterval", flowUint, false},
+	306: {"samplingPacketSpace", flowUint, false},
+}
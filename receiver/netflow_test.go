@@ -0,0 +1,214 @@
+/*
+ * skogul, netflow receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+)
+
+// flowPacket is a tiny helper to build big endian packets.
+type flowPacket []byte
+
+func (p flowPacket) u8(v uint8) flowPacket   { return append(p, v) }
+func (p flowPacket) u16(v uint16) flowPacket { return binary.BigEndian.AppendUint16(p, v) }
+func (p flowPacket) u32(v uint32) flowPacket { return binary.BigEndian.AppendUint32(p, v) }
+func (p flowPacket) ip(s string) flowPacket {
+	ip := net.ParseIP(s)
+	if v4 := ip.To4(); v4 != nil {
+		return append(p, v4...)
+	}
+	return append(p, ip...)
+}
+
+func netflowV5() []byte {
+	p := flowPacket{}.u16(5).u16(1).u32(100000).u32(1700000000).u32(0).u32(1).u8(0).u8(0).u16(0)
+	p = p.ip("10.0.0.1").ip("10.0.0.2").ip("10.0.0.254")
+	p = p.u16(3).u16(4)              // input, output
+	p = p.u32(10).u32(1500)          // packets, octets
+	p = p.u32(90000).u32(99000)      // first, last
+	p = p.u16(1234).u16(443)         // ports
+	p = p.u8(0).u8(0x18).u8(6).u8(0) // pad, flags, proto, tos
+	p = p.u16(64512).u16(64513)      // ASes
+	p = p.u8(24).u8(16).u16(0)       // masks, pad
+	return p
+}
+
+func netflowV9(withTemplate bool) []byte {
+	p := flowPacket{}.u16(9).u16(2).u32(100000).u32(1700000000).u32(1).u32(42)
+	if withTemplate {
+		p = p.u16(0).u16(4 + 4 + 5*4)
+		p = p.u16(256).u16(5)
+		p = p.u16(8).u16(4)  // sourceIPv4Address
+		p = p.u16(12).u16(4) // destinationIPv4Address
+		p = p.u16(1).u16(4)  // octetDeltaCount
+		p = p.u16(2).u16(4)  // packetDeltaCount
+		p = p.u16(22).u16(4) // flowStartSysUpTime
+	}
+	// two records, 20 bytes each, plus padding
+	p = p.u16(256).u16(4 + 40 + 2)
+	p = p.ip("192.0.2.1").ip("192.0.2.2").u32(100).u32(1).u32(99000)
+	p = p.ip("192.0.2.3").ip("192.0.2.4").u32(200).u32(2).u32(99500)
+	p = p.u16(0)
+	return p
+}
+
+func ipfix() []byte {
+	p := flowPacket{}.u16(10).u16(0).u32(1700000000).u32(1).u32(7)
+	// template set with an IPv6 address, a variable length string
+	// and an enterprise specific field
+	p = p.u16(2).u16(4 + 4 + 4 + 4 + 8 + 4)
+	p = p.u16(300).u16(4)
+	p = p.u16(27).u16(16)                      // sourceIPv6Address
+	p = p.u16(82).u16(65535)                   // interfaceName, variable
+	p = p.u16(0x8000 | 1).u16(4).u32(2636)     // enterprise field
+	p = p.u16(1).u16(8)                        // octetDeltaCount
+	p = p.u16(300).u16(4 + 16 + 1 + 8 + 4 + 8) // data set
+	p = p.ip("2001:db8::1").u8(8).append("ge-0/0/0")
+	p = p.u32(1337).u32(0).u32(9000)
+	binary.BigEndian.PutUint16(p[2:], uint16(len(p)))
+	return p
+}
+
+// ipfixOptions defines an options template, or withdraws it and defines
+// another one in the same set.
+func ipfixOptions(withdraw bool) []byte {
+	p := flowPacket{}.u16(10).u16(0).u32(1700000000).u32(2).u32(7)
+	id := uint16(400)
+	if withdraw {
+		p = p.u16(3).u16(4 + 4 + 14)
+		p = p.u16(400).u16(0)
+		id = 401
+	} else {
+		p = p.u16(3).u16(4 + 14)
+	}
+	p = p.u16(id).u16(2).u16(1)
+	p = p.u16(149).u16(4) // observationDomainId, scope
+	p = p.u16(41).u16(8)  // exportedMessageTotalCount
+	binary.BigEndian.PutUint16(p[2:], uint16(len(p)))
+	return p
+}
+
+func (p flowPacket) append(s string) flowPacket { return append(p, s...) }
+
+func TestNetFlow(t *testing.T) {
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.NetFlow{
+		Address: "127.0.0.1:12055",
+		Threads: 1,
+		Handler: skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	go rcv.Start()
+	time.Sleep(50 * time.Millisecond)
+	conn, err := net.Dial("udp", rcv.Address)
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	defer conn.Close()
+
+	// v5
+	conn.Write(netflowV5())
+	c := cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 1 {
+		t.Fatalf("expected 1 v5 record, got %v", c)
+	}
+	m := c.Metrics[0]
+	if m.Metadata["sourceIPv4Address"] != "10.0.0.1" || m.Metadata["destinationTransportPort"] != uint64(443) || m.Metadata["protocolIdentifier"] != uint64(6) {
+		t.Errorf("unexpected v5 metadata: %v", m.Metadata)
+	}
+	if m.Metadata["exporter"] != "127.0.0.1" || m.Metadata["ingressInterface"] != uint64(3) {
+		t.Errorf("unexpected v5 metadata: %v", m.Metadata)
+	}
+	if m.Data["octetDeltaCount"] != uint64(1500) || m.Data["packetDeltaCount"] != uint64(10) {
+		t.Errorf("unexpected v5 data: %v", m.Data)
+	}
+	if m.Data["flowStartMilliseconds"] != uint64(1700000000000-10000) {
+		t.Errorf("unexpected flowStartMilliseconds %v", m.Data["flowStartMilliseconds"])
+	}
+
+	// v9, data before template is a miss
+	conn.Write(netflowV9(false))
+	conn.Write(netflowV9(true))
+	c = cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 v9 records, got %v", c)
+	}
+	m = c.Metrics[1]
+	if m.Metadata["sourceIPv4Address"] != "192.0.2.3" || m.Metadata["observationDomainId"] != uint32(42) || m.Metadata["flowVersion"] != uint16(9) {
+		t.Errorf("unexpected v9 metadata: %v", m.Metadata)
+	}
+	if m.Data["octetDeltaCount"] != uint64(200) || m.Data["packetDeltaCount"] != uint64(2) {
+		t.Errorf("unexpected v9 data: %v", m.Data)
+	}
+	// And now the template is cached
+	conn.Write(netflowV9(false))
+	c = cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 v9 records using cached template, got %v", c)
+	}
+
+	// IPFIX
+	conn.Write(ipfix())
+	c = cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 1 {
+		t.Fatalf("expected 1 IPFIX record, got %v", c)
+	}
+	m = c.Metrics[0]
+	if m.Metadata["sourceIPv6Address"] != "2001:db8::1" || m.Metadata["interfaceName"] != "ge-0/0/0" {
+		t.Errorf("unexpected IPFIX metadata: %v", m.Metadata)
+	}
+	if m.Data["octetDeltaCount"] != uint64(9000) || m.Data["ie2636.1"] != uint64(1337) {
+		t.Errorf("unexpected IPFIX data: %v", m.Data)
+	}
+
+	// An options template withdrawal has no scope field count, so the
+	// template after it is still found.
+	conn.Write(ipfixOptions(false))
+	conn.Write(ipfixOptions(true))
+
+	// Garbage
+	conn.Write([]byte{0, 11, 0, 0})
+	time.Sleep(50 * time.Millisecond)
+	stats := rcv.GetStats()
+	if stats.Data["template_misses"] != uint64(1) {
+		t.Errorf("expected 1 template miss, got %v", stats.Data["template_misses"])
+	}
+	if stats.Data["decode_errors"] != uint64(1) {
+		t.Errorf("expected 1 decode error, got %v", stats.Data["decode_errors"])
+	}
+	if stats.Data["templates"] != int64(3) {
+		t.Errorf("expected 3 cached templates, got %v", stats.Data["templates"])
+	}
+}
@@ -0,0 +1,450 @@
+/*
+ * skogul, OpenTelemetry OTLP metrics receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"math"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+	colmetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+var otlpLog = skogul.Logger("receiver", "otlp")
+
+// Metadata keys used for OTLP information that isn't an attribute. The
+// names follow the OpenTelemetry conventions for instrumentation scope.
+const (
+	OTLPScopeNameKey    = "otel.scope.name"
+	OTLPScopeVersionKey = "otel.scope.version"
+	OTLPTypeKey         = "otel.type"
+	OTLPUnitKey         = "otel.unit"
+	OTLPTemporalityKey  = "otel.temporality"
+	OTLPMonotonicKey    = "otel.monotonic"
+)
+
+// otlpMaxBodySize is the default limit of HTTP request bodies, after
+// decompression.
+const otlpMaxBodySize = 32 << 20
+
+/*
+OTLP accepts OpenTelemetry metrics over gRPC and HTTP, as sent by the
+OpenTelemetry collector and SDKs.
+
+Each data point becomes a metric with the metric name as the data key,
+similar to the prometheus parser. Resource, scope and data point
+attributes are stored as metadata, along with the scope name and version,
+the metric type and the unit (see OTLPTypeKey and friends). Sums and
+histograms also have their aggregation temporality, "delta" or
+"cumulative", and sums whether they are monotonic. Histograms are
+expanded into one metric per bucket, with the upper bound as the "le"
+metadata field and the cumulative count as <name>_bucket, and one metric
+holding <name>_sum, <name>_count, and <name>_min and <name>_max if set.
+
+Exponential histograms and summaries are not supported, and are counted
+and skipped.
+
+The data is transformed and sent directly, the parser of the handler is
+not used.
+*/
+type OTLP struct {
+	Address     string             `doc:"Address to listen for gRPC on. Typically :4317. If blank, gRPC is not used."`
+	HTTPAddress string             `doc:"Address to listen for HTTP on, accepting protobuf or JSON posted to /v1/metrics. Typically :4318. If blank, HTTP is not used."`
+	Certfile    string             `doc:"Path to certificate file for TLS, used for both gRPC and HTTP. If left blank, un-encrypted transport is used."`
+	Keyfile     string             `doc:"Path to key file for TLS."`
+	MaxBodySize int64              `doc:"Maximum size of a HTTP request body in bytes, after decompression. Defaults to 32MiB."`
+	Handler     *skogul.HandlerRef `doc:"Handler used to transform and send data."`
+	stats       otlpStats
+	colmetrics.UnimplementedMetricsServiceServer
+}
+
+type otlpStats struct {
+	Requests    uint64 // Export requests received.
+	Metrics     uint64 // Skogul metrics produced.
+	Unsupported uint64 // Metrics of unsupported types, skipped.
+	Errors      uint64 // Requests that failed to decode or send.
+}
+
+// Start the gRPC and/or HTTP listeners. Returns when one of them fails.
+func (o *OTLP) Start() error {
+	errs := make(chan error, 2)
+	if o.Address != "" {
+		go func() { errs <- o.startGRPC() }()
+	}
+	if o.HTTPAddress != "" {
+		go func() { errs <- o.startHTTP() }()
+	}
+	return <-errs
+}
+
+func (o *OTLP) startGRPC() error {
+	var opts []grpc.ServerOption
+	if o.Certfile != "" {
+		creds, err := credentials.NewServerTLSFromFile(o.Certfile, o.Keyfile)
+		if err != nil {
+			return fmt.Errorf("unable to load TLS certificate: %w", err)
+		}
+		opts = append(opts, grpc.Creds(creds))
+	}
+	ln, err := net.Listen("tcp", o.Address)
+	if err != nil {
+		return err
+	}
+	server := grpc.NewServer(opts...)
+	colmetrics.RegisterMetricsServiceServer(server, o)
+	otlpLog.WithField("address", o.Address).Info("Starting OTLP gRPC receiver")
+	return server.Serve(ln)
+}
+
+func (o *OTLP) startHTTP() error {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/metrics", o.serveHTTP)
+	server := http.Server{Addr: o.HTTPAddress, Handler: mux}
+	otlpLog.WithField("address", o.HTTPAddress).Info("Starting OTLP HTTP receiver")
+	if o.Certfile != "" {
+		return server.ListenAndServeTLS(o.Certfile, o.Keyfile)
+	}
+	return server.ListenAndServe()
+}
+
+// Export implements the OTLP gRPC metrics service.
+func (o *OTLP) Export(ctx context.Context, req *colmetrics.ExportMetricsServiceRequest) (*colmetrics.ExportMetricsServiceResponse, error) {
+	if err := o.handle(req); err != nil {
+		return nil, status.Error(codes.Unavailable, err.Error())
+	}
+	return &colmetrics.ExportMetricsServiceResponse{}, nil
+}
+
+// serveHTTP handles an OTLP/HTTP request. Both the binary protobuf and
+// the JSON encoding are accepted, optionally gzipped.
+func (o *OTLP) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "only POST is supported", http.StatusMethodNotAllowed)
+		return
+	}
+	isJSON := false
+	if ct := r.Header.Get("Content-Type"); ct != "" {
+		mt, _, err := mime.ParseMediaType(ct)
+		if err != nil {
+			atomic.AddUint64(&o.stats.Errors, 1)
+			http.Error(w, err.Error(), http.StatusUnsupportedMediaType)
+			return
+		}
+		switch mt {
+		case "application/json":
+			isJSON = true
+		case "application/x-protobuf", "application/protobuf":
+		default:
+			atomic.AddUint64(&o.stats.Errors, 1)
+			http.Error(w, "unsupported content type "+mt, http.StatusUnsupportedMediaType)
+			return
+		}
+	}
+	var body io.Reader = r.Body
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gz, err := gzip.NewReader(r.Body)
+		if err != nil {
+			atomic.AddUint64(&o.stats.Errors, 1)
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		defer gz.Close()
+		body = gz
+	}
+	max := o.MaxBodySize
+	if max == 0 {
+		max = otlpMaxBodySize
+	}
+	b, err := io.ReadAll(io.LimitReader(body, max+1))
+	if err != nil {
+		atomic.AddUint64(&o.stats.Errors, 1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if int64(len(b)) > max {
+		atomic.AddUint64(&o.stats.Errors, 1)
+		http.Error(w, fmt.Sprintf("request body larger than %d bytes", max), http.StatusRequestEntityTooLarge)
+		return
+	}
+	req := colmetrics.ExportMetricsServiceRequest{}
+	if isJSON {
+		err = protojson.Unmarshal(b, &req)
+	} else {
+		err = proto.Unmarshal(b, &req)
+	}
+	if err != nil {
+		atomic.AddUint64(&o.stats.Errors, 1)
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err := o.handle(&req); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+	var resp []byte
+	if isJSON {
+		w.Header().Set("Content-Type", "application/json")
+		resp, _ = protojson.Marshal(&colmetrics.ExportMetricsServiceResponse{})
+	} else {
+		w.Header().Set("Content-Type", "application/x-protobuf")
+		resp, _ = proto.Marshal(&colmetrics.ExportMetricsServiceResponse{})
+	}
+	w.Write(resp)
+}
+
+// handle converts and sends the metrics of an export request.
+func (o *OTLP) handle(req *colmetrics.ExportMetricsServiceRequest) error {
+	atomic.AddUint64(&o.stats.Requests, 1)
+	c := o.convert(req)
+	if len(c.Metrics) == 0 {
+		return nil
+	}
+	atomic.AddUint64(&o.stats.Metrics, uint64(len(c.Metrics)))
+	if err := o.Handler.H.TransformAndSend(c); err != nil {
+		atomic.AddUint64(&o.stats.Errors, 1)
+		otlpLog.WithError(err).Error("Unable to transform and send OTLP metrics")
+		return err
+	}
+	return nil
+}
+
+// convert an export request to a container, one metric per data point
+// (or histogram bucket).
+func (o *OTLP) convert(req *colmetrics.ExportMetricsServiceRequest) *skogul.Container {
+	c := skogul.Container{}
+	now := skogul.Now()
+	for _, rm := range req.GetResourceMetrics() {
+		resource := make(map[string]interface{})
+		otlpAttributes(resource, rm.GetResource().GetAttributes())
+		for _, sm := range rm.GetScopeMetrics() {
+			scope := make(map[string]interface{}, len(resource)+2)
+			for k, v := range resource {
+				scope[k] = v
+			}
+			otlpAttributes(scope, sm.GetScope().GetAttributes())
+			if sm.GetScope().GetName() != "" {
+				scope[OTLPScopeNameKey] = sm.GetScope().GetName()
+			}
+			if sm.GetScope().GetVersion() != "" {
+				scope[OTLPScopeVersionKey] = sm.GetScope().GetVersion()
+			}
+			for _, m := range sm.GetMetrics() {
+				name := m.GetName()
+				base := func(mtype string, ts uint64, attrs []*commonpb.KeyValue) *skogul.Metric {
+					t := now
+					if ts != 0 && ts <= math.MaxInt64 {
+						t = time.Unix(0, int64(ts))
+					}
+					metric := skogul.Metric{
+						Time:     &t,
+						Metadata: make(map[string]interface{}, len(scope)+len(attrs)+2),
+						Data:     make(map[string]interface{}),
+					}
+					for k, v := range scope {
+						metric.Metadata[k] = v
+					}
+					otlpAttributes(metric.Metadata, attrs)
+					metric.Metadata[OTLPTypeKey] = mtype
+					if m.GetUnit() != "" {
+						metric.Metadata[OTLPUnitKey] = m.GetUnit()
+					}
+					return &metric
+				}
+				switch data := m.GetData().(type) {
+				case *metricspb.Metric_Gauge:
+					for _, dp := range data.Gauge.GetDataPoints() {
+						v, ok := otlpNumber(dp)
+						if !ok {
+							continue
+						}
+						metric := base("gauge", dp.GetTimeUnixNano(), dp.GetAttributes())
+						metric.Data[name] = v
+						c.Metrics = append(c.Metrics, metric)
+					}
+				case *metricspb.Metric_Sum:
+					for _, dp := range data.Sum.GetDataPoints() {
+						v, ok := otlpNumber(dp)
+						if !ok {
+							continue
+						}
+						metric := base("sum", dp.GetTimeUnixNano(), dp.GetAttributes())
+						otlpTemporality(metric.Metadata, data.Sum.GetAggregationTemporality())
+						metric.Metadata[OTLPMonotonicKey] = data.Sum.GetIsMonotonic()
+						metric.Data[name] = v
+						c.Metrics = append(c.Metrics, metric)
+					}
+				case *metricspb.Metric_Histogram:
+					for _, dp := range data.Histogram.GetDataPoints() {
+						var cumulative uint64
+						counts := dp.GetBucketCounts()
+						for i, count := range counts {
+							cumulative += count
+							le := "+Inf"
+							if i < len(dp.GetExplicitBounds()) {
+								le = strconv.FormatFloat(dp.GetExplicitBounds()[i], 'g', -1, 64)
+							}
+							metric := base("histogram", dp.GetTimeUnixNano(), dp.GetAttributes())
+							otlpTemporality(metric.Metadata, data.Histogram.GetAggregationTemporality())
+							metric.Metadata["le"] = le
+							metric.Data[name+"_bucket"] = cumulative
+							c.Metrics = append(c.Metrics, metric)
+						}
+						metric := base("histogram", dp.GetTimeUnixNano(), dp.GetAttributes())
+						otlpTemporality(metric.Metadata, data.Histogram.GetAggregationTemporality())
+						metric.Data[name+"_count"] = dp.GetCount()
+						if dp.Sum != nil {
+							metric.Data[name+"_sum"] = dp.GetSum()
+						}
+						if dp.Min != nil {
+							metric.Data[name+"_min"] = dp.GetMin()
+						}
+						if dp.Max != nil {
+							metric.Data[name+"_max"] = dp.GetMax()
+						}
+						c.Metrics = append(c.Metrics, metric)
+					}
+				default:
+					atomic.AddUint64(&o.stats.Unsupported, 1)
+				}
+			}
+		}
+	}
+	return &c
+}
+
+// otlpTemporality stores the aggregation temporality as metadata, if it
+// is known.
+func otlpTemporality(md map[string]interface{}, t metricspb.AggregationTemporality) {
+	switch t {
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA:
+		md[OTLPTemporalityKey] = "delta"
+	case metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_CUMULATIVE:
+		md[OTLPTemporalityKey] = "cumulative"
+	}
+}
+
+// otlpNumber returns the value of a gauge or sum data point. Returns
+// false for missing and non-finite values, which can't be represented in
+// JSON, so the data point is skipped.
+func otlpNumber(dp *metricspb.NumberDataPoint) (interface{}, bool) {
+	switch v := dp.GetValue().(type) {
+	case *metricspb.NumberDataPoint_AsInt:
+		return v.AsInt, true
+	case *metricspb.NumberDataPoint_AsDouble:
+		if math.IsNaN(v.AsDouble) || math.IsInf(v.AsDouble, 0) {
+			return nil, false
+		}
+		return v.AsDouble, true
+	}
+	return nil, false
+}
+
+// otlpAttributes adds attributes to a metadata map.
+func otlpAttributes(md map[string]interface{}, attrs []*commonpb.KeyValue) {
+	for _, kv := range attrs {
+		if v := otlpValue(kv.GetValue()); v != nil {
+			md[kv.GetKey()] = v
+		}
+	}
+}
+
+// otlpValue converts an attribute value to a native type.
+func otlpValue(v *commonpb.AnyValue) interface{} {
+	switch x := v.GetValue().(type) {
+	case *commonpb.AnyValue_StringValue:
+		return x.StringValue
+	case *commonpb.AnyValue_BoolValue:
+		return x.BoolValue
+	case *commonpb.AnyValue_IntValue:
+		return x.IntValue
+	case *commonpb.AnyValue_DoubleValue:
+		return x.DoubleValue
+	case *commonpb.AnyValue_BytesValue:
+		return base64.StdEncoding.EncodeToString(x.BytesValue)
+	case *commonpb.AnyValue_ArrayValue:
+		ret := make([]interface{}, 0, len(x.ArrayValue.GetValues()))
+		for _, e := range x.ArrayValue.GetValues() {
+			ret = append(ret, otlpValue(e))
+		}
+		return ret
+	case *commonpb.AnyValue_KvlistValue:
+		ret := make(map[string]interface{})
+		otlpAttributes(ret, x.KvlistValue.GetValues())
+		return ret
+	}
+	return nil
+}
+
+// Verify checks that the configuration is usable.
+func (o *OTLP) Verify() error {
+	if o.Address == "" && o.HTTPAddress == "" {
+		return skogul.MissingArgument("Address")
+	}
+	if o.Handler == nil || o.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if (o.Certfile == "") != (o.Keyfile == "") {
+		return fmt.Errorf("specify both Certfile AND Keyfile or none at all")
+	}
+	if o.MaxBodySize < 0 {
+		return fmt.Errorf("MaxBodySize can not be negative")
+	}
+	return nil
+}
+
+// GetStats returns the stats of the receiver.
+func (o *OTLP) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "otlp"
+	metric.Metadata["identity"] = skogul.Identity[o]
+	metric.Data["requests"] = atomic.LoadUint64(&o.stats.Requests)
+	metric.Data["metrics"] = atomic.LoadUint64(&o.stats.Metrics)
+	metric.Data["unsupported"] = atomic.LoadUint64(&o.stats.Unsupported)
+	metric.Data["errors"] = atomic.LoadUint64(&o.stats.Errors)
+	return &metric
+}
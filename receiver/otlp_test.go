@@ -0,0 +1,295 @@
+/*
+ * skogul, test OTLP receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+	"github.com/telenornms/skogul/sender"
+	colmetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+func otlpContainer() *skogul.Container {
+	now := time.Unix(1700000000, 0)
+	return &skogul.Container{Metrics: []*skogul.Metric{
+		{
+			Time: &now,
+			Metadata: map[string]interface{}{
+				"service.name": "router",
+				"interface":    "ge-0/0/0",
+				"vlan":         10,
+			},
+			Data: map[string]interface{}{
+				"in_octets": 1234,
+				"util":      0.5,
+				"descr":     "not a number",
+			},
+		},
+	}}
+}
+
+// otlpCheck verifies a container received after a round trip through the
+// OTLP sender and receiver.
+func otlpCheck(t *testing.T, c *skogul.Container) {
+	t.Helper()
+	if c == nil {
+		t.Fatalf("no container received")
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics, got %d", len(c.Metrics))
+	}
+	for _, m := range c.Metrics {
+		if m.Time.Unix() != 1700000000 {
+			t.Errorf("unexpected time %v", m.Time)
+		}
+		if m.Metadata["service.name"] != "router" || m.Metadata["interface"] != "ge-0/0/0" || m.Metadata["vlan"] != int64(10) {
+			t.Errorf("unexpected metadata %v", m.Metadata)
+		}
+		if m.Metadata[receiver.OTLPScopeNameKey] != "skogul" || m.Metadata[receiver.OTLPTypeKey] != "gauge" {
+			t.Errorf("unexpected metadata %v", m.Metadata)
+		}
+	}
+	if c.Metrics[0].Data["in_octets"] != int64(1234) {
+		t.Errorf("unexpected data %v", c.Metrics[0].Data)
+	}
+	if c.Metrics[1].Data["util"] != 0.5 {
+		t.Errorf("unexpected data %v", c.Metrics[1].Data)
+	}
+}
+
+func TestOTLP(t *testing.T) {
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.OTLP{
+		Address:     "127.0.0.1:12057",
+		HTTPAddress: "127.0.0.1:12058",
+		Handler:     &skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	go rcv.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	grpcSender := sender.OTLP{
+		Address:          "127.0.0.1:12057",
+		Plaintext:        true,
+		ResourceMetadata: []string{"service.name"},
+	}
+	if err := grpcSender.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	if err := grpcSender.Send(otlpContainer()); err != nil {
+		t.Fatalf("gRPC send failed: %v", err)
+	}
+	otlpCheck(t, cs.wait(time.Second))
+
+	httpSender := sender.OTLP{
+		URL:  "http://127.0.0.1:12058/v1/metrics",
+		HTTP: &sender.HTTP{},
+	}
+	if err := httpSender.Send(otlpContainer()); err != nil {
+		t.Fatalf("HTTP send failed: %v", err)
+	}
+	otlpCheck(t, cs.wait(time.Second))
+
+	stats := rcv.GetStats()
+	if stats.Data["requests"] != uint64(2) || stats.Data["metrics"] != uint64(4) {
+		t.Errorf("unexpected stats %v", stats.Data)
+	}
+}
+
+func TestOTLPHistogram(t *testing.T) {
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.OTLP{
+		HTTPAddress: "127.0.0.1:12059",
+		Handler:     &skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	go rcv.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	sum := 12.5
+	req := colmetrics.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: "latency",
+					Unit: "ms",
+					Data: &metricspb.Metric_Histogram{Histogram: &metricspb.Histogram{
+						DataPoints: []*metricspb.HistogramDataPoint{{
+							TimeUnixNano:   1700000000000000000,
+							Count:          6,
+							Sum:            &sum,
+							BucketCounts:   []uint64{1, 2, 3},
+							ExplicitBounds: []float64{1, 5},
+						}},
+					}},
+				}},
+			}},
+		}},
+	}
+	b, _ := proto.Marshal(&req)
+	resp, err := http.Post("http://127.0.0.1:12059/v1/metrics", "application/x-protobuf", bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 {
+		t.Fatalf("unexpected status %d", resp.StatusCode)
+	}
+	c := cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 4 {
+		t.Fatalf("expected 4 metrics, got %v", c)
+	}
+	les := []string{"1", "5", "+Inf"}
+	counts := []uint64{1, 3, 6}
+	for i := 0; i < 3; i++ {
+		m := c.Metrics[i]
+		if m.Metadata["le"] != les[i] || m.Data["latency_bucket"] != counts[i] || m.Metadata[receiver.OTLPUnitKey] != "ms" {
+			t.Errorf("unexpected bucket %d: %v %v", i, m.Metadata, m.Data)
+		}
+	}
+	if c.Metrics[3].Data["latency_sum"] != 12.5 || c.Metrics[3].Data["latency_count"] != uint64(6) {
+		t.Errorf("unexpected sum/count %v", c.Metrics[3].Data)
+	}
+}
+
+func TestOTLPHTTP(t *testing.T) {
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.OTLP{
+		HTTPAddress: "127.0.0.1:12062",
+		MaxBodySize: 4096,
+		Handler:     &skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	go rcv.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	req := colmetrics.ExportMetricsServiceRequest{
+		ResourceMetrics: []*metricspb.ResourceMetrics{{
+			ScopeMetrics: []*metricspb.ScopeMetrics{{
+				Metrics: []*metricspb.Metric{{
+					Name: "requests",
+					Data: &metricspb.Metric_Sum{Sum: &metricspb.Sum{
+						AggregationTemporality: metricspb.AggregationTemporality_AGGREGATION_TEMPORALITY_DELTA,
+						IsMonotonic:            true,
+						DataPoints: []*metricspb.NumberDataPoint{{
+							TimeUnixNano: 1700000000000000000,
+							Value:        &metricspb.NumberDataPoint_AsInt{AsInt: 42},
+						}},
+					}},
+				}},
+			}},
+		}},
+	}
+	b, _ := protojson.Marshal(&req)
+	resp, err := http.Post("http://127.0.0.1:12062/v1/metrics", "application/json; charset=utf-8", bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != 200 || resp.Header.Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %d %s", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	c := cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 1 {
+		t.Fatalf("expected 1 metric, got %v", c)
+	}
+	m := c.Metrics[0]
+	if m.Metadata[receiver.OTLPTemporalityKey] != "delta" || m.Metadata[receiver.OTLPMonotonicKey] != true || m.Data["requests"] != int64(42) {
+		t.Errorf("unexpected sum %v %v", m.Metadata, m.Data)
+	}
+
+	resp, err = http.Post("http://127.0.0.1:12062/v1/metrics", "text/plain", bytes.NewReader(b))
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusUnsupportedMediaType {
+		t.Errorf("expected 415 for text/plain, got %d", resp.StatusCode)
+	}
+
+	// A small gzip body that expands beyond MaxBodySize
+	var gz bytes.Buffer
+	w := gzip.NewWriter(&gz)
+	w.Write(make([]byte, 1<<20))
+	w.Close()
+	hreq, _ := http.NewRequest("POST", "http://127.0.0.1:12062/v1/metrics", &gz)
+	hreq.Header.Set("Content-Type", "application/x-protobuf")
+	hreq.Header.Set("Content-Encoding", "gzip")
+	resp, err = http.DefaultClient.Do(hreq)
+	if err != nil {
+		t.Fatalf("POST failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Errorf("expected 413 for oversized body, got %d", resp.StatusCode)
+	}
+}
+
+func TestOTLPLargeUnsigned(t *testing.T) {
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.OTLP{
+		HTTPAddress: "127.0.0.1:12063",
+		Handler:     &skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	go rcv.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	now := time.Unix(1700000000, 0)
+	s := sender.OTLP{
+		URL:  "http://127.0.0.1:12063/v1/metrics",
+		HTTP: &sender.HTTP{},
+	}
+	err := s.Send(&skogul.Container{Metrics: []*skogul.Metric{{
+		Time: &now,
+		Data: map[string]interface{}{"octets": uint64(1 << 63)},
+	}}})
+	if err != nil {
+		t.Fatalf("HTTP send failed: %v", err)
+	}
+	c := cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 1 {
+		t.Fatalf("expected 1 metric, got %v", c)
+	}
+	if c.Metrics[0].Data["octets"] != float64(1<<63) {
+		t.Errorf("expected 2^63 as a double, got %T(%v)", c.Metrics[0].Data["octets"], c.Metrics[0].Data["octets"])
+	}
+}
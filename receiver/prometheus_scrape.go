@@ -0,0 +1,359 @@
+/*
+ * skogul, prometheus scrape receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var promLog = skogul.Logger("receiver", "prometheus-scrape")
+
+// PrometheusTargetGroup is a list of targets sharing the same labels. It
+// uses the same format as Prometheus' file based service discovery, so
+// existing target files can be re-used with TargetFile.
+type PrometheusTargetGroup struct {
+	Targets []string          `json:"targets" doc:"List of targets. Either a full URL, or host:port, in which case Scheme and Path is used to build the URL."`
+	Labels  map[string]string `json:"labels" doc:"Labels added as metadata to all metrics scraped from these targets."`
+}
+
+/*
+PrometheusScrape periodically fetches metrics from a list of HTTP(S)
+targets, typically /metrics endpoints, and passes the body to the parser
+of the Handler - typically the prometheus parser.
+
+In addition to the scraped metrics, a metric with "up",
+"scrape_duration_seconds" and "scrape_samples_scraped" is emitted for
+each target on each scrape, similar to what Prometheus itself does. This
+is emitted even if the scrape fails, with "up" set to 0.
+
+All metrics get the "instance" metadata field set to host:port of the
+target, the "job" metadata field set to Job if provided, and any labels
+of the target group. As in Prometheus, scraped labels that collide with
+these are kept as exported_<label>, e.g. exported_instance.
+*/
+type PrometheusScrape struct {
+	Targets     []PrometheusTargetGroup `doc:"Groups of targets to scrape, each with an optional set of labels."`
+	TargetFile  string                  `doc:"Path to a JSON file with additional target groups, in the same format as Targets (which matches the Prometheus file_sd format). The file is re-read when it changes."`
+	Scheme      string                  `doc:"Scheme used for targets given as host:port. Default: http"`
+	Path        string                  `doc:"Path used for targets given as host:port. Default: /metrics"`
+	Job         string                  `doc:"Job name, added as the job metadata field if set."`
+	Interval    skogul.Duration         `doc:"How often to scrape. Default: 60s"`
+	Timeout     skogul.Duration         `doc:"Timeout for each scrape. Default: 10s"`
+	Username    string                  `doc:"Username for basic authentication."`
+	Password    skogul.Secret           `doc:"Password for basic authentication."`
+	BearerToken skogul.Secret           `doc:"Bearer token sent in the Authorization header. Mutually exclusive with Username/Password."`
+	Insecure    bool                    `doc:"Disable TLS certificate validation."`
+	RootCA      string                  `doc:"Path to an alternate root CA used to verify server certificates. Leave blank to use system defaults."`
+	Certfile    string                  `doc:"Path to certificate file for TLS Client Certificate."`
+	Keyfile     string                  `doc:"Path to key file for TLS Client Certificate."`
+	Handler     skogul.HandlerRef       `doc:"Handler used to parse, transform and send data. Typically uses the prometheus parser."`
+	client      *http.Client
+	fileTargets []PrometheusTargetGroup
+	fileMod     time.Time
+	stats       promScrapeStats
+}
+
+type promScrapeStats struct {
+	Scrapes       uint64 // Number of scrapes attempted, across all targets.
+	ScrapeErrors  uint64 // Scrapes that failed, either due to network/HTTP issues or parsing.
+	HandlerErrors uint64 // Errors from the handler after successful parsing.
+	FileErrors    uint64 // Failures to read the TargetFile.
+}
+
+// promTarget is a single, resolved target.
+type promTarget struct {
+	url      string
+	instance string
+	labels   map[string]string
+}
+
+// Verify checks the configuration for obvious mistakes.
+func (ps *PrometheusScrape) Verify() error {
+	if ps.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if len(ps.Targets) == 0 && ps.TargetFile == "" {
+		return fmt.Errorf("need at least one of Targets or TargetFile")
+	}
+	if ps.BearerToken != "" && ps.Username != "" {
+		return fmt.Errorf("use either BearerToken or Username/Password, not both")
+	}
+	if (ps.Certfile != "" && ps.Keyfile == "") || (ps.Certfile == "" && ps.Keyfile != "") {
+		return fmt.Errorf("either provide BOTH Certfile AND Keyfile, or neither")
+	}
+	if _, err := skogul.GetCertPool(ps.RootCA); err != nil {
+		return fmt.Errorf("failed to read custom root CA (RootCA: %s): %w", ps.RootCA, err)
+	}
+	for _, g := range ps.Targets {
+		for _, t := range g.Targets {
+			if _, err := ps.resolve(t, g.Labels); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resolve turns a target string into a promTarget.
+func (ps *PrometheusScrape) resolve(target string, labels map[string]string) (*promTarget, error) {
+	raw := target
+	if !strings.Contains(target, "://") {
+		scheme := ps.Scheme
+		if scheme == "" {
+			scheme = "http"
+		}
+		path := ps.Path
+		if path == "" {
+			path = "/metrics"
+		}
+		raw = scheme + "://" + target + path
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid target %s: %w", target, err)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid target %s: missing host", target)
+	}
+	return &promTarget{url: u.String(), instance: u.Host, labels: labels}, nil
+}
+
+// readTargetFile re-reads TargetFile if it has changed since the last
+// read. If reading fails, the previous targets are kept.
+func (ps *PrometheusScrape) readTargetFile() {
+	if ps.TargetFile == "" {
+		return
+	}
+	st, err := os.Stat(ps.TargetFile)
+	if err != nil {
+		atomic.AddUint64(&ps.stats.FileErrors, 1)
+		promLog.WithError(err).Warnf("Unable to stat target file, keeping %d old target groups", len(ps.fileTargets))
+		return
+	}
+	if st.ModTime().Equal(ps.fileMod) {
+		return
+	}
+	b, err := os.ReadFile(ps.TargetFile)
+	if err != nil {
+		atomic.AddUint64(&ps.stats.FileErrors, 1)
+		promLog.WithError(err).Warnf("Unable to read target file, keeping %d old target groups", len(ps.fileTargets))
+		return
+	}
+	var groups []PrometheusTargetGroup
+	if err := json.Unmarshal(b, &groups); err != nil {
+		atomic.AddUint64(&ps.stats.FileErrors, 1)
+		promLog.WithError(err).Warnf("Unable to parse target file, keeping %d old target groups", len(ps.fileTargets))
+		return
+	}
+	promLog.WithField("file", ps.TargetFile).Infof("Loaded %d target groups", len(groups))
+	ps.fileTargets = groups
+	ps.fileMod = st.ModTime()
+}
+
+// targets returns the current list of targets, from both the
+// configuration and the target file.
+func (ps *PrometheusScrape) targets() []*promTarget {
+	ps.readTargetFile()
+	ret := make([]*promTarget, 0, len(ps.Targets))
+	for _, groups := range [][]PrometheusTargetGroup{ps.Targets, ps.fileTargets} {
+		for _, g := range groups {
+			for _, t := range g.Targets {
+				pt, err := ps.resolve(t, g.Labels)
+				if err != nil {
+					promLog.WithError(err).Warn("Skipping invalid target")
+					continue
+				}
+				ret = append(ret, pt)
+			}
+		}
+	}
+	return ret
+}
+
+// fetch GETs the target and returns the body.
+func (ps *PrometheusScrape) fetch(t *promTarget) ([]byte, error) {
+	req, err := http.NewRequest("GET", t.url, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/openmetrics-text;version=1.0.0;q=0.5,text/plain;version=0.0.4;q=0.4,*/*;q=0.1")
+	if ps.Username != "" {
+		req.SetBasicAuth(ps.Username, ps.Password.Expose())
+	} else if ps.BearerToken != "" {
+		req.Header.Set("Authorization", "Bearer "+ps.BearerToken.Expose())
+	}
+	resp, err := ps.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		io.Copy(io.Discard, resp.Body)
+		return nil, fmt.Errorf("non-OK status code from target: %s", resp.Status)
+	}
+	return io.ReadAll(resp.Body)
+}
+
+// label sets the target-specific metadata on a metric.
+func (ps *PrometheusScrape) label(t *promTarget, m *skogul.Metric) {
+	if m.Metadata == nil {
+		m.Metadata = make(map[string]interface{})
+	}
+	labels := make(map[string]string, len(t.labels)+2)
+	for k, v := range t.labels {
+		labels[k] = v
+	}
+	labels["instance"] = t.instance
+	if ps.Job != "" {
+		labels["job"] = ps.Job
+	}
+	for k, v := range labels {
+		// Like Prometheus without honor_labels, keep scraped labels
+		// that collide with target labels as exported_<name>.
+		if old, ok := m.Metadata[k]; ok {
+			m.Metadata["exported_"+k] = old
+		}
+		m.Metadata[k] = v
+	}
+}
+
+// scrape scrapes a single target and sends the result, including the up
+// metric, on to the handler.
+func (ps *PrometheusScrape) scrape(t *promTarget) {
+	atomic.AddUint64(&ps.stats.Scrapes, 1)
+	start := time.Now()
+	up := 1
+	var c *skogul.Container
+	b, err := ps.fetch(t)
+	if err == nil {
+		c, err = ps.Handler.H.Parse(b)
+	}
+	if err != nil {
+		atomic.AddUint64(&ps.stats.ScrapeErrors, 1)
+		promLog.WithError(err).WithField("target", t.url).Warn("Scrape failed")
+		up = 0
+		c = &skogul.Container{}
+	}
+	samples := len(c.Metrics)
+	for _, m := range c.Metrics {
+		ps.label(t, m)
+	}
+	now := skogul.Now()
+	upm := skogul.Metric{
+		Time: &now,
+		Data: map[string]interface{}{
+			"up":                      up,
+			"scrape_duration_seconds": time.Since(start).Seconds(),
+			"scrape_samples_scraped":  samples,
+		},
+	}
+	ps.label(t, &upm)
+	c.Metrics = append(c.Metrics, &upm)
+	if err := ps.Handler.H.TransformAndSend(c); err != nil {
+		atomic.AddUint64(&ps.stats.HandlerErrors, 1)
+		promLog.WithError(err).WithField("target", t.url).Error("Unable to send scraped metrics")
+	}
+}
+
+// init sets defaults and sets up the HTTP client.
+func (ps *PrometheusScrape) init() error {
+	if ps.Interval.Duration == 0 {
+		ps.Interval.Duration = 60 * time.Second
+	}
+	if ps.Timeout.Duration == 0 {
+		ps.Timeout.Duration = 10 * time.Second
+	}
+	if ps.Insecure {
+		promLog.Warning("Disabling certificate validation for prometheus scraper - vulnerable to man-in-the-middle")
+	}
+	cp, err := skogul.GetCertPool(ps.RootCA)
+	if err != nil {
+		return fmt.Errorf("failed to initialize root CA pool: %w", err)
+	}
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: ps.Insecure,
+		RootCAs:            cp,
+	}
+	if ps.Certfile != "" && ps.Keyfile != "" {
+		cert, err := tls.LoadX509KeyPair(ps.Certfile, ps.Keyfile)
+		if err != nil {
+			return fmt.Errorf("failed to load client certificate: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	ps.client = &http.Client{
+		Transport: &http.Transport{TLSClientConfig: tlsConfig},
+		Timeout:   ps.Timeout.Duration,
+	}
+	return nil
+}
+
+// Start scrapes all targets in parallel every Interval. Never returns,
+// unless initialization fails.
+func (ps *PrometheusScrape) Start() error {
+	if err := ps.init(); err != nil {
+		return err
+	}
+	for ; ; time.Sleep(ps.Interval.Duration) {
+		var wg sync.WaitGroup
+		for _, t := range ps.targets() {
+			wg.Add(1)
+			go func(t *promTarget) {
+				defer wg.Done()
+				ps.scrape(t)
+			}(t)
+		}
+		wg.Wait()
+	}
+}
+
+// GetStats exposes stats about the scraper.
+func (ps *PrometheusScrape) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "prometheus-scrape"
+	metric.Metadata["identity"] = skogul.Identity[ps]
+	metric.Data["scrapes"] = atomic.LoadUint64(&ps.stats.Scrapes)
+	metric.Data["scrape_errors"] = atomic.LoadUint64(&ps.stats.ScrapeErrors)
+	metric.Data["handler_errors"] = atomic.LoadUint64(&ps.stats.HandlerErrors)
+	metric.Data["file_errors"] = atomic.LoadUint64(&ps.stats.FileErrors)
+	return &metric
+}
@@ -0,0 +1,150 @@
+/*
+ * skogul, prometheus scrape receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+)
+
+func findUp(c *skogul.Container) *skogul.Metric {
+	for _, m := range c.Metrics {
+		if m.Data["up"] != nil {
+			return m
+		}
+	}
+	return nil
+}
+
+func TestPrometheusScrape(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		fmt.Fprintf(w, "# TYPE temp gauge\ntemp{sensor=\"cpu\",job=\"exporter\"} 42\n")
+	}))
+	defer srv.Close()
+
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs}
+	h.SetParser(parser.Prometheus{})
+
+	file := fmt.Sprintf("%s/skogul-promscrape-%d.json", os.TempDir(), os.Getpid())
+	defer os.Remove(file)
+	if err := os.WriteFile(file, []byte(`[]`), 0600); err != nil {
+		t.Fatalf("unable to write target file: %v", err)
+	}
+
+	rcv := receiver.PrometheusScrape{
+		Targets: []receiver.PrometheusTargetGroup{
+			{
+				Targets: []string{srv.URL + "/metrics"},
+				Labels:  map[string]string{"site": "oslo"},
+			},
+		},
+		TargetFile:  file,
+		Job:         "node",
+		BearerToken: "s3cret",
+		Interval:    skogul.Duration{Duration: 50 * time.Millisecond},
+		Handler:     skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	go rcv.Start()
+
+	c := cs.wait(time.Second)
+	if c == nil {
+		t.Fatalf("no data received from scraper")
+	}
+	if len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics (temp + up), got %d", len(c.Metrics))
+	}
+	up := findUp(c)
+	if up == nil || up.Data["up"] != 1 {
+		t.Errorf("expected up=1, got %v", up)
+	}
+	for _, m := range c.Metrics {
+		if m.Metadata["site"] != "oslo" || m.Metadata["job"] != "node" || m.Metadata["instance"] != strings.TrimPrefix(srv.URL, "http://") {
+			t.Errorf("missing target labels on metric: %v", m.Metadata)
+		}
+		if m.Data["temp"] != nil && (m.Metadata["exported_job"] != "exporter" || m.Metadata["exported_instance"] != nil) {
+			t.Errorf("scraped job label not kept as exported_job: %v", m.Metadata)
+		}
+	}
+
+	// Add a target to the file that fails, and expect up=0 for it
+	if err := os.WriteFile(file, []byte(`[{"targets": ["127.0.0.1:1"], "labels": {"site": "bergen"}}]`), 0600); err != nil {
+		t.Fatalf("unable to write target file: %v", err)
+	}
+	now := time.Now().Add(time.Second)
+	os.Chtimes(file, now, now)
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		c = cs.wait(time.Second)
+		if c == nil {
+			break
+		}
+		up = findUp(c)
+		if up != nil && up.Metadata["site"] == "bergen" {
+			if up.Data["up"] != 0 {
+				t.Errorf("expected up=0 for unreachable target, got %v", up.Data["up"])
+			}
+			if up.Metadata["instance"] != "127.0.0.1:1" {
+				t.Errorf("expected instance 127.0.0.1:1, got %v", up.Metadata["instance"])
+			}
+			return
+		}
+	}
+	t.Errorf("never saw a scrape of the target from the target file")
+}
+
+func TestPrometheusScrape_verify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	bad := []receiver.PrometheusScrape{
+		{Handler: h},
+		{Targets: []receiver.PrometheusTargetGroup{{Targets: []string{"localhost:9100"}}}},
+		{Handler: h, Targets: []receiver.PrometheusTargetGroup{{Targets: []string{"http://"}}}},
+		{Handler: h, TargetFile: "x", Username: "foo", BearerToken: "bar"},
+	}
+	for i, ps := range bad {
+		if err := ps.Verify(); err == nil {
+			t.Errorf("expected Verify() to fail for case %d", i)
+		}
+	}
+	ok := receiver.PrometheusScrape{Handler: h, Targets: []receiver.PrometheusTargetGroup{{Targets: []string{"localhost:9100"}}}}
+	if err := ok.Verify(); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+}
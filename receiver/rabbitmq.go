@@ -25,81 +25,162 @@ package receiver
 
 import (
 	"fmt"
+	"sync"
+	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/internal/rabbitmq"
 )
 
+var rabbitmqLog = skogul.Logger("receiver", "rabbitmq")
+
+/*
+Rabbitmq consumes messages from a RabbitMQ queue. Messages are acked
+only after they have been parsed, transformed and sent. Failed messages
+are rejected, which dead-letters them if the queue has a dead letter
+exchange, or requeued if Requeue is set.
+
+If Exchange is set, the queue is bound to it with each of the
+BindingKeys. The connection is re-established automatically.
+*/
 type Rabbitmq struct {
-	Username skogul.Secret      `doc:"Username for rabbitmq instance"`
-	Password skogul.Secret      `doc:"Password for rabbitmq instance"`
-	Host     string             `doc:"Hostname for rabbitmq instance. Fallback is localhost"`
-	Port     string             `doc:"Port for rabbitmq instance. Fallback is 5672"`
-	Queue    string             `doc:"Queue to read from"`
-	Handler  *skogul.HandlerRef `doc:"Handler used to parse, transform and send data. Default skogul."`
+	Username             skogul.Secret      `doc:"Username for rabbitmq instance"`
+	Password             skogul.Secret      `doc:"Password for rabbitmq instance"`
+	Host                 string             `doc:"Hostname for rabbitmq instance. Fallback is localhost"`
+	Port                 string             `doc:"Port for rabbitmq instance. Fallback is 5672, or 5671 with TLS"`
+	Vhost                string             `doc:"Virtual host to use. Fallback is the default vhost."`
+	TLS                  bool               `doc:"Connect with TLS (amqps)."`
+	CA                   string             `doc:"Path to a CA certificate for verifying the broker. Default is the system pool."`
+	Insecure             bool               `doc:"Disable verification of the broker certificate. Dangerous."`
+	Queue                string             `doc:"Queue to read from"`
+	Durable              bool               `doc:"Declare the queue, and the exchange, as durable. Must match an existing queue or exchange."`
+	Exchange             string             `doc:"Exchange to bind the queue to. The exchange is declared if it does not exist."`
+	ExchangeType         string             `doc:"Type of exchange: direct, fanout, topic or headers. Fallback is topic."`
+	BindingKeys          []string           `doc:"Routing keys to bind the queue to the exchange with. Fallback is # (everything)." example:"[\"metrics.#\"]"`
+	DeadLetterExchange   string             `doc:"Exchange failed messages are dead-lettered to. Set as an argument when declaring the queue, so it must match an existing queue."`
+	DeadLetterRoutingKey string             `doc:"Routing key to use when dead-lettering. Fallback is the original routing key."`
+	Requeue              bool               `doc:"Requeue failed messages instead of rejecting them. Beware that a message that always fails will be redelivered forever."`
+	Prefetch             int                `doc:"Number of unacknowledged messages the broker will deliver at a time. 0 means no limit."`
+	Concurrency          int                `doc:"Number of messages handled in parallel. Fallback is 1."`
+	Handler              *skogul.HandlerRef `doc:"Handler used to parse, transform and send data. Default skogul."`
+
+	conn rabbitmq.Conn
 }
 
-func (r *Rabbitmq) Start() error {
-	if r.Port == "" {
-		r.Port = "5672"
+// setup declares the queue, exchange and bindings on a new channel.
+func (r *Rabbitmq) setup(ch *amqp.Channel) error {
+	if err := ch.Qos(r.Prefetch, 0, false); err != nil {
+		return fmt.Errorf("unable to set prefetch: %w", err)
 	}
-
-	if r.Host == "" {
-		r.Host = "localhost"
-	}
-
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%s/", r.Username.Expose(), r.Password.Expose(), r.Host, r.Port))
-	if err != nil {
-		return err
-	}
-
-	ch, err := conn.Channel()
-
-	if err != nil {
-		return err
+	var args amqp.Table
+	if r.DeadLetterExchange != "" {
+		args = amqp.Table{"x-dead-letter-exchange": r.DeadLetterExchange}
+		if r.DeadLetterRoutingKey != "" {
+			args["x-dead-letter-routing-key"] = r.DeadLetterRoutingKey
+		}
 	}
-
-	_, err = ch.QueueDeclare(
+	_, err := ch.QueueDeclare(
 		r.Queue,
+		r.Durable,
 		false,
 		false,
 		false,
-		false,
-		nil,
+		args,
 	)
-
 	if err != nil {
-		return err
+		return fmt.Errorf("unable to declare queue: %w", err)
 	}
-
-	msgs, err := ch.Consume(
-		r.Queue,
-		"",
-		true,
-		false,
-		false,
-		false,
-		nil,
-	)
-
-	if err != nil {
-		return err
+	if r.Exchange == "" {
+		return nil
+	}
+	kind := r.ExchangeType
+	if kind == "" {
+		kind = amqp.ExchangeTopic
+	}
+	if err := ch.ExchangeDeclare(r.Exchange, kind, r.Durable, false, false, false, nil); err != nil {
+		return fmt.Errorf("unable to declare exchange: %w", err)
+	}
+	keys := r.BindingKeys
+	if len(keys) == 0 {
+		keys = []string{"#"}
+	}
+	for _, key := range keys {
+		if err := ch.QueueBind(r.Queue, key, r.Exchange, false, nil); err != nil {
+			return fmt.Errorf("unable to bind queue to exchange: %w", err)
+		}
 	}
+	return nil
+}
 
+// consume handles deliveries until the channel is closed.
+func (r *Rabbitmq) consume(msgs <-chan amqp.Delivery) {
 	for message := range msgs {
-		container, err := r.Handler.H.Parse(message.Body)
-
-		if err != nil {
-			return err
+		if err := r.Handler.H.Handle(message.Body); err != nil {
+			rabbitmqLog.WithError(err).Warn("Failed to handle message, rejecting it")
+			if err := message.Nack(false, r.Requeue); err != nil {
+				rabbitmqLog.WithError(err).Error("Failed to reject message")
+			}
+			continue
 		}
-
-		err = r.Handler.H.TransformAndSend(container)
-		if err != nil {
-			return err
+		if err := message.Ack(false); err != nil {
+			rabbitmqLog.WithError(err).Error("Failed to ack message")
 		}
 	}
+}
 
-	return nil
+// Start consuming. Returns an error if the initial connection fails,
+// otherwise it reconnects if the connection is lost, and never returns.
+func (r *Rabbitmq) Start() error {
+	r.conn = rabbitmq.Conn{
+		Username: r.Username.Expose(),
+		Password: r.Password.Expose(),
+		Host:     r.Host,
+		Port:     r.Port,
+		Vhost:    r.Vhost,
+		TLS:      r.TLS,
+		CA:       r.CA,
+		Insecure: r.Insecure,
+		Setup:    r.setup,
+	}
+	workers := r.Concurrency
+	if workers < 1 {
+		workers = 1
+	}
+	if _, err := r.conn.Channel(); err != nil {
+		return err
+	}
+	for {
+		ch, err := r.conn.Channel()
+		if err == nil {
+			var msgs <-chan amqp.Delivery
+			msgs, err = ch.Consume(
+				r.Queue,
+				"",
+				false,
+				false,
+				false,
+				false,
+				nil,
+			)
+			if err == nil {
+				rabbitmqLog.WithField("queue", r.Queue).Debug("Consuming from queue")
+				var wg sync.WaitGroup
+				for i := 0; i < workers; i++ {
+					wg.Add(1)
+					go func() {
+						defer wg.Done()
+						r.consume(msgs)
+					}()
+				}
+				wg.Wait()
+				err = fmt.Errorf("delivery channel closed")
+			}
+		}
+		rabbitmqLog.WithError(err).Warn("Lost connection to broker, reconnecting in 5 seconds")
+		r.conn.Close()
+		time.Sleep(5 * time.Second)
+	}
 }
 
 func (r *Rabbitmq) Verify() error {
@@ -119,5 +200,13 @@ func (r *Rabbitmq) Verify() error {
 		return skogul.MissingArgument("Queue")
 	}
 
+	if r.Prefetch < 0 {
+		return fmt.Errorf("Prefetch can not be negative")
+	}
+
+	if r.BindingKeys != nil && r.Exchange == "" {
+		return fmt.Errorf("BindingKeys set without an Exchange")
+	}
+
 	return nil
 }
@@ -0,0 +1,452 @@
+/*
+ * skogul, snmp polling receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/telenornms/skogul"
+)
+
+var snmpLog = skogul.Logger("receiver", "snmp")
+
+// SNMPTable describes a single SNMP table to walk, e.g. ifXTable.
+type SNMPTable struct {
+	Name      string            `doc:"Name of the table, stored in the 'table' metadata field if set."`
+	Columns   map[string]string `doc:"Map of data field name to column OID. Each column is walked, and the values become data fields." example:"{\"ifHCInOctets\": \".1.3.6.1.2.1.31.1.1.1.6\"}"`
+	Labels    map[string]string `doc:"Map of metadata field name to column OID. Each column is walked, and the values become metadata fields." example:"{\"ifName\": \".1.3.6.1.2.1.31.1.1.1.1\", \"ifAlias\": \".1.3.6.1.2.1.31.1.1.1.18\"}"`
+	IndexName string            `doc:"Metadata field to store the row index in. Default: index"`
+}
+
+/*
+SNMP polls a list of SNMP agents at a regular interval.
+
+Scalar OIDs are fetched with GET, and are all put in a single metric per
+target. Tables are walked with BULKWALK (GETNEXT for v1), and each row
+becomes its own metric, with the row index and any label columns as
+metadata. All metrics get the "target" metadata field.
+
+Each target is polled in its own go routine, and each poll of a target
+results in a single container passed to the handler.
+*/
+type SNMP struct {
+	Targets        []string          `doc:"List of SNMP agents to poll, as host or host:port."`
+	Port           uint16            `doc:"Default port for targets that do not specify one. Default: 161"`
+	Version        string            `doc:"SNMP version: 1, 2c or 3. Default: 2c"`
+	Community      skogul.Secret     `doc:"Community for version 1 and 2c. Default: public"`
+	Username       string            `doc:"SNMPv3 user name."`
+	SecurityLevel  string            `doc:"SNMPv3 security level: noAuthNoPriv, authNoPriv or authPriv. Default: authPriv if PrivPassphrase is set, authNoPriv if AuthPassphrase is set, otherwise noAuthNoPriv."`
+	AuthProtocol   string            `doc:"SNMPv3 authentication protocol: MD5, SHA, SHA224, SHA256, SHA384 or SHA512. Default: SHA"`
+	AuthPassphrase skogul.Secret     `doc:"SNMPv3 authentication passphrase."`
+	PrivProtocol   string            `doc:"SNMPv3 privacy protocol: DES, AES, AES192, AES256, AES192C or AES256C. Default: AES"`
+	PrivPassphrase skogul.Secret     `doc:"SNMPv3 privacy passphrase."`
+	ContextName    string            `doc:"SNMPv3 context name."`
+	Scalars        map[string]string `doc:"Map of data field name to scalar OID, fetched with GET." example:"{\"sysUpTime\": \".1.3.6.1.2.1.1.3.0\"}"`
+	Tables         []SNMPTable       `doc:"Tables to walk."`
+	Interval       skogul.Duration   `doc:"How often to poll. Default: 60s"`
+	Timeout        skogul.Duration   `doc:"Timeout for each SNMP request. Default: 5s"`
+	Retries        *int              `doc:"Number of retries for each SNMP request. 0 disables retries. Default: 1"`
+	MaxRepetitions uint32            `doc:"Max repetitions for BULKWALK. Default: 10"`
+	Handler        skogul.HandlerRef `doc:"Handler used to transform and send data. The parser of the handler is not used."`
+	stats          snmpStats
+	retries        int
+}
+
+type snmpStats struct {
+	Polls         uint64 // Number of target polls attempted.
+	PollErrors    uint64 // Polls that failed, at least partially.
+	HandlerErrors uint64 // Errors from the handler.
+}
+
+var snmpAuthProtocols = map[string]gosnmp.SnmpV3AuthProtocol{
+	"md5":    gosnmp.MD5,
+	"sha":    gosnmp.SHA,
+	"sha224": gosnmp.SHA224,
+	"sha256": gosnmp.SHA256,
+	"sha384": gosnmp.SHA384,
+	"sha512": gosnmp.SHA512,
+}
+
+var snmpPrivProtocols = map[string]gosnmp.SnmpV3PrivProtocol{
+	"des":     gosnmp.DES,
+	"aes":     gosnmp.AES,
+	"aes192":  gosnmp.AES192,
+	"aes256":  gosnmp.AES256,
+	"aes192c": gosnmp.AES192C,
+	"aes256c": gosnmp.AES256C,
+}
+
+var snmpSecurityLevels = map[string]gosnmp.SnmpV3MsgFlags{
+	"noauthnopriv": gosnmp.NoAuthNoPriv,
+	"authnopriv":   gosnmp.AuthNoPriv,
+	"authpriv":     gosnmp.AuthPriv,
+}
+
+// Verify checks the configuration.
+func (s *SNMP) Verify() error {
+	if s.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if len(s.Targets) == 0 {
+		return skogul.MissingArgument("Targets")
+	}
+	if len(s.Scalars) == 0 && len(s.Tables) == 0 {
+		return fmt.Errorf("need at least one of Scalars or Tables")
+	}
+	if s.Retries != nil && *s.Retries < 0 {
+		return fmt.Errorf("Retries can not be negative")
+	}
+	for _, t := range s.Tables {
+		if len(t.Columns) == 0 {
+			return fmt.Errorf("table %s has no columns", t.Name)
+		}
+	}
+	switch s.Version {
+	case "", "1", "2c":
+	case "3":
+		if s.Username == "" {
+			return skogul.MissingArgument("Username")
+		}
+		if _, ok := snmpSecurityLevels[strings.ToLower(s.SecurityLevel)]; s.SecurityLevel != "" && !ok {
+			return fmt.Errorf("unknown SecurityLevel %s", s.SecurityLevel)
+		}
+		if _, ok := snmpAuthProtocols[strings.ToLower(s.AuthProtocol)]; s.AuthProtocol != "" && !ok {
+			return fmt.Errorf("unknown AuthProtocol %s", s.AuthProtocol)
+		}
+		if _, ok := snmpPrivProtocols[strings.ToLower(s.PrivProtocol)]; s.PrivProtocol != "" && !ok {
+			return fmt.Errorf("unknown PrivProtocol %s", s.PrivProtocol)
+		}
+	default:
+		return fmt.Errorf("unknown SNMP version %s, must be 1, 2c or 3", s.Version)
+	}
+	return nil
+}
+
+// client creates a new, unconnected, gosnmp client for the target.
+func (s *SNMP) client(target string) (*gosnmp.GoSNMP, error) {
+	host := target
+	port := s.Port
+	if h, p, err := net.SplitHostPort(target); err == nil {
+		n, err := strconv.ParseUint(p, 10, 16)
+		if err != nil {
+			return nil, fmt.Errorf("invalid port in target %s: %w", target, err)
+		}
+		host = h
+		port = uint16(n)
+	}
+	g := &gosnmp.GoSNMP{
+		Target:             host,
+		Port:               port,
+		Transport:          "udp",
+		Community:          s.Community.Expose(),
+		Version:            gosnmp.Version2c,
+		Timeout:            s.Timeout.Duration,
+		Retries:            s.retries,
+		ExponentialTimeout: true,
+		MaxOids:            gosnmp.MaxOids,
+		MaxRepetitions:     s.MaxRepetitions,
+	}
+	switch s.Version {
+	case "1":
+		g.Version = gosnmp.Version1
+	case "3":
+		g.Version = gosnmp.Version3
+		g.SecurityModel = gosnmp.UserSecurityModel
+		g.ContextName = s.ContextName
+		level := strings.ToLower(s.SecurityLevel)
+		if level == "" {
+			level = "noauthnopriv"
+			if s.PrivPassphrase != "" {
+				level = "authpriv"
+			} else if s.AuthPassphrase != "" {
+				level = "authnopriv"
+			}
+		}
+		g.MsgFlags = snmpSecurityLevels[level]
+		usm := &gosnmp.UsmSecurityParameters{
+			UserName:                 s.Username,
+			AuthenticationProtocol:   gosnmp.NoAuth,
+			PrivacyProtocol:          gosnmp.NoPriv,
+			AuthenticationPassphrase: s.AuthPassphrase.Expose(),
+			PrivacyPassphrase:        s.PrivPassphrase.Expose(),
+		}
+		if g.MsgFlags&gosnmp.AuthNoPriv != 0 {
+			usm.AuthenticationProtocol = gosnmp.SHA
+			if p, ok := snmpAuthProtocols[strings.ToLower(s.AuthProtocol)]; ok {
+				usm.AuthenticationProtocol = p
+			}
+		}
+		if g.MsgFlags&gosnmp.AuthPriv == gosnmp.AuthPriv {
+			usm.PrivacyProtocol = gosnmp.AES
+			if p, ok := snmpPrivProtocols[strings.ToLower(s.PrivProtocol)]; ok {
+				usm.PrivacyProtocol = p
+			}
+		}
+		g.SecurityParameters = usm
+	}
+	return g, nil
+}
+
+// snmpValue converts a PDU to a sensible skogul value. Returns false if
+// the PDU carries no value, e.g. noSuchObject.
+func snmpValue(pdu gosnmp.SnmpPDU) (interface{}, bool) {
+	switch pdu.Type {
+	case gosnmp.NoSuchObject, gosnmp.NoSuchInstance, gosnmp.EndOfMibView, gosnmp.Null:
+		return nil, false
+	case gosnmp.OctetString, gosnmp.BitString, gosnmp.Opaque:
+		if b, ok := pdu.Value.([]byte); ok {
+			return string(b), true
+		}
+	case gosnmp.Counter32, gosnmp.Gauge32, gosnmp.TimeTicks, gosnmp.Uinteger32:
+		return gosnmp.ToBigInt(pdu.Value).Uint64(), true
+	case gosnmp.Counter64:
+		if v, ok := pdu.Value.(uint64); ok {
+			return v, true
+		}
+	case gosnmp.Integer:
+		if v, ok := pdu.Value.(int); ok {
+			return int64(v), true
+		}
+	}
+	return pdu.Value, pdu.Value != nil
+}
+
+// normalizeOID makes sure an OID has a leading dot, which is how gosnmp
+// returns them.
+func normalizeOID(oid string) string {
+	if strings.HasPrefix(oid, ".") {
+		return oid
+	}
+	return "." + oid
+}
+
+// walk walks a single column, calling fn with the row index and value.
+func (s *SNMP) walk(g *gosnmp.GoSNMP, column string, fn func(index string, value interface{})) error {
+	column = normalizeOID(column)
+	walkFn := func(pdu gosnmp.SnmpPDU) error {
+		index := strings.TrimPrefix(pdu.Name, column+".")
+		if index == pdu.Name {
+			return nil
+		}
+		if v, ok := snmpValue(pdu); ok {
+			fn(index, v)
+		}
+		return nil
+	}
+	if g.Version == gosnmp.Version1 {
+		return g.Walk(column, walkFn)
+	}
+	return g.BulkWalk(column, walkFn)
+}
+
+// pollTable walks all columns of a table and returns one metric per row.
+// Rows without any data columns are dropped.
+func (s *SNMP) pollTable(g *gosnmp.GoSNMP, target string, now *time.Time, t *SNMPTable) ([]*skogul.Metric, error) {
+	rows := make(map[string]*skogul.Metric)
+	order := make([]string, 0)
+	indexName := t.IndexName
+	if indexName == "" {
+		indexName = "index"
+	}
+	row := func(index string) *skogul.Metric {
+		m := rows[index]
+		if m == nil {
+			m = &skogul.Metric{
+				Time:     now,
+				Metadata: map[string]interface{}{"target": target, indexName: index},
+				Data:     make(map[string]interface{}),
+			}
+			if t.Name != "" {
+				m.Metadata["table"] = t.Name
+			}
+			rows[index] = m
+			order = append(order, index)
+		}
+		return m
+	}
+	for name, oid := range t.Columns {
+		err := s.walk(g, oid, func(index string, v interface{}) {
+			row(index).Data[name] = v
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walking %s (%s) failed: %w", name, oid, err)
+		}
+	}
+	for name, oid := range t.Labels {
+		err := s.walk(g, oid, func(index string, v interface{}) {
+			if m := rows[index]; m != nil {
+				m.Metadata[name] = v
+			}
+		})
+		if err != nil {
+			return nil, fmt.Errorf("walking %s (%s) failed: %w", name, oid, err)
+		}
+	}
+	ret := make([]*skogul.Metric, 0, len(rows))
+	for _, idx := range order {
+		ret = append(ret, rows[idx])
+	}
+	return ret, nil
+}
+
+// pollScalars GETs all scalars and returns them in a single metric.
+func (s *SNMP) pollScalars(g *gosnmp.GoSNMP, target string, now *time.Time) (*skogul.Metric, error) {
+	names := make(map[string]string, len(s.Scalars))
+	oids := make([]string, 0, len(s.Scalars))
+	for name, oid := range s.Scalars {
+		oid = normalizeOID(oid)
+		names[oid] = name
+		oids = append(oids, oid)
+	}
+	m := skogul.Metric{
+		Time:     now,
+		Metadata: map[string]interface{}{"target": target},
+		Data:     make(map[string]interface{}),
+	}
+	for start := 0; start < len(oids); start += g.MaxOids {
+		end := start + g.MaxOids
+		if end > len(oids) {
+			end = len(oids)
+		}
+		res, err := g.Get(oids[start:end])
+		if err != nil {
+			return nil, err
+		}
+		if res.Error != gosnmp.NoError {
+			return nil, fmt.Errorf("agent returned error %s", res.Error)
+		}
+		for _, pdu := range res.Variables {
+			if v, ok := snmpValue(pdu); ok && names[pdu.Name] != "" {
+				m.Data[names[pdu.Name]] = v
+			}
+		}
+	}
+	return &m, nil
+}
+
+// poll polls a single target and passes the result to the handler.
+func (s *SNMP) poll(target string) {
+	atomic.AddUint64(&s.stats.Polls, 1)
+	log := snmpLog.WithField("target", target)
+	g, err := s.client(target)
+	if err == nil {
+		err = g.Connect()
+	}
+	if err != nil {
+		atomic.AddUint64(&s.stats.PollErrors, 1)
+		log.WithError(err).Warn("Unable to connect to SNMP agent")
+		return
+	}
+	defer g.Conn.Close()
+
+	now := skogul.Now()
+	c := skogul.Container{Metrics: make([]*skogul.Metric, 0)}
+	failed := false
+	if len(s.Scalars) > 0 {
+		m, err := s.pollScalars(g, target, &now)
+		if err != nil {
+			failed = true
+			log.WithError(err).Warn("Unable to GET scalars")
+		} else if len(m.Data) > 0 {
+			c.Metrics = append(c.Metrics, m)
+		}
+	}
+	for i := range s.Tables {
+		ms, err := s.pollTable(g, target, &now, &s.Tables[i])
+		if err != nil {
+			failed = true
+			log.WithError(err).Warnf("Unable to walk table %s", s.Tables[i].Name)
+			continue
+		}
+		c.Metrics = append(c.Metrics, ms...)
+	}
+	if failed {
+		atomic.AddUint64(&s.stats.PollErrors, 1)
+	}
+	if len(c.Metrics) == 0 {
+		return
+	}
+	if err := s.Handler.H.TransformAndSend(&c); err != nil {
+		atomic.AddUint64(&s.stats.HandlerErrors, 1)
+		log.WithError(err).Error("Unable to send SNMP data")
+	}
+}
+
+// Start polls all targets in parallel every Interval. Never returns.
+func (s *SNMP) Start() error {
+	if s.Port == 0 {
+		s.Port = 161
+	}
+	if s.Community == "" {
+		s.Community = "public"
+	}
+	if s.Interval.Duration == 0 {
+		s.Interval.Duration = 60 * time.Second
+	}
+	if s.Timeout.Duration == 0 {
+		s.Timeout.Duration = 5 * time.Second
+	}
+	s.retries = 1
+	if s.Retries != nil {
+		s.retries = *s.Retries
+	}
+	if s.MaxRepetitions == 0 {
+		s.MaxRepetitions = 10
+	}
+	for ; ; time.Sleep(s.Interval.Duration) {
+		var wg sync.WaitGroup
+		for _, t := range s.Targets {
+			wg.Add(1)
+			go func(t string) {
+				defer wg.Done()
+				s.poll(t)
+			}(t)
+		}
+		wg.Wait()
+	}
+}
+
+// GetStats exposes stats about the SNMP poller.
+func (s *SNMP) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "snmp"
+	metric.Metadata["identity"] = skogul.Identity[s]
+	metric.Data["polls"] = atomic.LoadUint64(&s.stats.Polls)
+	metric.Data["poll_errors"] = atomic.LoadUint64(&s.stats.PollErrors)
+	metric.Data["handler_errors"] = atomic.LoadUint64(&s.stats.HandlerErrors)
+	return &metric
+}
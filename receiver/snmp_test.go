@@ -0,0 +1,247 @@
+/*
+ * skogul, snmp polling receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gosnmp/gosnmp"
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+)
+
+// snmpAgent is a minimal, in-process SNMPv2c agent serving a static MIB.
+// It supports GET, GETNEXT and GETBULK, which is what the receiver uses.
+type snmpAgent struct {
+	conn *net.UDPConn
+	mib  map[string]gosnmp.SnmpPDU
+	oids []string
+}
+
+// oidLess compares two OIDs numerically, sub-identifier by
+// sub-identifier.
+func oidLess(a, b string) bool {
+	as := strings.Split(strings.Trim(a, "."), ".")
+	bs := strings.Split(strings.Trim(b, "."), ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		ai, _ := strconv.Atoi(as[i])
+		bi, _ := strconv.Atoi(bs[i])
+		if ai != bi {
+			return ai < bi
+		}
+	}
+	return len(as) < len(bs)
+}
+
+func newSNMPAgent(t *testing.T, pdus []gosnmp.SnmpPDU) *snmpAgent {
+	t.Helper()
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	a := &snmpAgent{conn: conn, mib: make(map[string]gosnmp.SnmpPDU)}
+	for _, p := range pdus {
+		a.mib[p.Name] = p
+		a.oids = append(a.oids, p.Name)
+	}
+	sort.Slice(a.oids, func(i, j int) bool { return oidLess(a.oids[i], a.oids[j]) })
+	go a.serve()
+	return a
+}
+
+// next returns the PDU following oid, or endOfMibView.
+func (a *snmpAgent) next(oid string) gosnmp.SnmpPDU {
+	i := sort.Search(len(a.oids), func(i int) bool { return oidLess(oid, a.oids[i]) })
+	if i >= len(a.oids) {
+		return gosnmp.SnmpPDU{Name: oid, Type: gosnmp.EndOfMibView}
+	}
+	return a.mib[a.oids[i]]
+}
+
+func (a *snmpAgent) serve() {
+	dec := &gosnmp.GoSNMP{Version: gosnmp.Version2c}
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := a.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		req, err := dec.SnmpDecodePacket(buf[:n])
+		if err != nil {
+			continue
+		}
+		vars := make([]gosnmp.SnmpPDU, 0)
+		switch req.PDUType {
+		case gosnmp.GetRequest:
+			for _, v := range req.Variables {
+				p, ok := a.mib[v.Name]
+				if !ok {
+					p = gosnmp.SnmpPDU{Name: v.Name, Type: gosnmp.NoSuchObject}
+				}
+				vars = append(vars, p)
+			}
+		case gosnmp.GetNextRequest:
+			for _, v := range req.Variables {
+				vars = append(vars, a.next(v.Name))
+			}
+		case gosnmp.GetBulkRequest:
+			for _, v := range req.Variables {
+				oid := v.Name
+				for i := uint32(0); i < req.MaxRepetitions; i++ {
+					p := a.next(oid)
+					vars = append(vars, p)
+					if p.Type == gosnmp.EndOfMibView {
+						break
+					}
+					oid = p.Name
+				}
+			}
+		}
+		resp := gosnmp.SnmpPacket{
+			Version:   gosnmp.Version2c,
+			Community: req.Community,
+			PDUType:   gosnmp.GetResponse,
+			RequestID: req.RequestID,
+			Variables: vars,
+		}
+		b, err := resp.MarshalMsg()
+		if err != nil {
+			continue
+		}
+		a.conn.WriteToUDP(b, addr)
+	}
+}
+
+func TestSNMP(t *testing.T) {
+	agent := newSNMPAgent(t, []gosnmp.SnmpPDU{
+		{Name: ".1.3.6.1.2.1.1.3.0", Type: gosnmp.TimeTicks, Value: uint32(4242)},
+		{Name: ".1.3.6.1.2.1.1.5.0", Type: gosnmp.OctetString, Value: []byte("router1")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.1.1", Type: gosnmp.OctetString, Value: []byte("ge-0/0/0")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.1.2", Type: gosnmp.OctetString, Value: []byte("ge-0/0/1")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.1", Type: gosnmp.Counter64, Value: uint64(1000)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.6.2", Type: gosnmp.Counter64, Value: uint64(2000)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.10.1", Type: gosnmp.Counter64, Value: uint64(10)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.10.2", Type: gosnmp.Counter64, Value: uint64(20)},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.18.1", Type: gosnmp.OctetString, Value: []byte("uplink")},
+		{Name: ".1.3.6.1.2.1.31.1.1.1.18.2", Type: gosnmp.OctetString, Value: []byte("customer")},
+	})
+	defer agent.conn.Close()
+
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.SNMP{
+		Targets: []string{agent.conn.LocalAddr().String()},
+		Scalars: map[string]string{
+			"sysUpTime": "1.3.6.1.2.1.1.3.0",
+			"sysName":   ".1.3.6.1.2.1.1.5.0",
+			"missing":   ".1.3.6.1.2.1.1.99.0",
+		},
+		Tables: []receiver.SNMPTable{
+			{
+				Name: "ifXTable",
+				Columns: map[string]string{
+					"ifHCInOctets":  ".1.3.6.1.2.1.31.1.1.1.6",
+					"ifHCOutOctets": ".1.3.6.1.2.1.31.1.1.1.10",
+				},
+				Labels: map[string]string{
+					"ifName":  ".1.3.6.1.2.1.31.1.1.1.1",
+					"ifAlias": ".1.3.6.1.2.1.31.1.1.1.18",
+				},
+				IndexName: "ifIndex",
+			},
+		},
+		MaxRepetitions: 3,
+		Timeout:        skogul.Duration{Duration: time.Second},
+		Interval:       skogul.Duration{Duration: time.Hour},
+		Handler:        skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	go rcv.Start()
+
+	c := cs.wait(5 * time.Second)
+	if c == nil {
+		t.Fatalf("no data received from SNMP poller")
+	}
+	if len(c.Metrics) != 3 {
+		t.Fatalf("expected 3 metrics, got %d: %s", len(c.Metrics), c)
+	}
+	scalars := c.Metrics[0]
+	if scalars.Data["sysUpTime"] != uint64(4242) || scalars.Data["sysName"] != "router1" {
+		t.Errorf("unexpected scalars: %v", scalars.Data)
+	}
+	if _, ok := scalars.Data["missing"]; ok {
+		t.Errorf("noSuchObject should not be stored")
+	}
+	rows := make(map[string]*skogul.Metric)
+	for _, m := range c.Metrics[1:] {
+		rows[m.Metadata["ifName"].(string)] = m
+	}
+	r := rows["ge-0/0/1"]
+	if r == nil {
+		t.Fatalf("missing row for ge-0/0/1")
+	}
+	if r.Metadata["ifIndex"] != "2" || r.Metadata["ifAlias"] != "customer" || r.Metadata["table"] != "ifXTable" {
+		t.Errorf("unexpected metadata: %v", r.Metadata)
+	}
+	if r.Data["ifHCInOctets"] != uint64(2000) || r.Data["ifHCOutOctets"] != uint64(20) {
+		t.Errorf("unexpected data: %v", r.Data)
+	}
+	if r.Metadata["target"] != agent.conn.LocalAddr().String() {
+		t.Errorf("unexpected target: %v", r.Metadata["target"])
+	}
+}
+
+func TestSNMP_verify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	scalars := map[string]string{"sysUpTime": ".1.3.6.1.2.1.1.3.0"}
+	negative, zero := -1, 0
+	bad := []receiver.SNMP{
+		{Targets: []string{"localhost"}, Scalars: scalars},
+		{Handler: h, Scalars: scalars},
+		{Handler: h, Targets: []string{"localhost"}},
+		{Handler: h, Targets: []string{"localhost"}, Scalars: scalars, Version: "4"},
+		{Handler: h, Targets: []string{"localhost"}, Scalars: scalars, Version: "3"},
+		{Handler: h, Targets: []string{"localhost"}, Scalars: scalars, Version: "3", Username: "u", AuthProtocol: "rot13"},
+		{Handler: h, Targets: []string{"localhost"}, Tables: []receiver.SNMPTable{{Name: "empty"}}},
+		{Handler: h, Targets: []string{"localhost"}, Scalars: scalars, Retries: &negative},
+	}
+	for i, s := range bad {
+		if err := s.Verify(); err == nil {
+			t.Errorf("expected Verify() to fail for case %d", i)
+		}
+	}
+	ok := receiver.SNMP{Handler: h, Targets: []string{"localhost"}, Scalars: scalars, Version: "3", Username: "u", AuthProtocol: "SHA256", PrivProtocol: "aes", Retries: &zero}
+	if err := ok.Verify(); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+}
@@ -0,0 +1,368 @@
+/*
+ * skogul, statsd receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var statsdLog = skogul.Logger("receiver", "statsd")
+
+/*
+StatsD receives StatsD metrics over UDP, aggregates them and sends the
+result every FlushInterval. DogStatsD tags, e.g. "|#site:oslo,env:prod",
+are supported and stored as metadata, as is sampling, e.g. "|@0.1".
+
+One metric is sent per name, type and set of tags, with the name in the
+"measurement" metadata field and the type in the "statsd_type" metadata
+field. Data depends on the type:
+
+Counters (c) have "count", adjusted for sampling, and "rate" per second.
+
+Gauges (g) have "value". A value prefixed with + or - modifies the
+current value. Gauges are sent on every flush until DeleteGauges is set.
+
+Timers and histograms (ms, h, d) have "count", "sum", "min", "max",
+"mean", "median", and "p<N>" for each of Percentiles.
+
+Sets (s) have "count", the number of unique values.
+
+Counters, timers and sets are reset after each flush, and only sent if
+they were updated. The parser of the handler is not used.
+*/
+type StatsD struct {
+	Address       string            `doc:"Address and port to listen to." example:"[::1]:8125"`
+	Handler       skogul.HandlerRef `doc:"Handler used to transform and send data."`
+	FlushInterval skogul.Duration   `doc:"How often to send aggregated metrics. Defaults to 10s."`
+	Percentiles   []float64         `doc:"Percentiles to calculate for timers. Defaults to 90." example:"[50, 90, 99]"`
+	DeleteGauges  bool              `doc:"Only send gauges that were updated since the previous flush."`
+	PacketSize    int               `doc:"Maximum UDP packet size. Defaults to 9000."`
+	lock          sync.Mutex
+	buckets       map[string]*statsdBucket
+	lastFlush     time.Time
+	stats         statsdStats
+}
+
+type statsdStats struct {
+	Packets uint64 // UDP packets received.
+	Lines   uint64 // StatsD lines parsed.
+	Errors  uint64 // Invalid lines and failures to send.
+	Flushes uint64 // Containers sent.
+}
+
+// statsdBucket is the aggregated state of a name/type/tags combination.
+type statsdBucket struct {
+	name    string
+	kind    string
+	tags    map[string]string
+	updated bool
+	count   float64
+	value   float64
+	timings []float64
+	set     map[string]bool
+}
+
+var statsdTypes = map[string]string{
+	"c":  "counter",
+	"g":  "gauge",
+	"ms": "timer",
+	"h":  "timer",
+	"d":  "timer",
+	"s":  "set",
+}
+
+// parseLine parses a single StatsD line into the buckets.
+func (s *StatsD) parseLine(line string) error {
+	name, rest, ok := strings.Cut(line, ":")
+	if !ok || name == "" {
+		return fmt.Errorf("missing name in %q", line)
+	}
+	fields := strings.Split(rest, "|")
+	if len(fields) < 2 {
+		return fmt.Errorf("missing type in %q", line)
+	}
+	kind, ok := statsdTypes[fields[1]]
+	if !ok {
+		return fmt.Errorf("unknown type %q in %q", fields[1], line)
+	}
+	raw := fields[0]
+	rate := 1.0
+	tags := make(map[string]string)
+	for _, f := range fields[2:] {
+		switch {
+		case strings.HasPrefix(f, "@"):
+			r, err := strconv.ParseFloat(f[1:], 64)
+			if err != nil || r <= 0 || r > 1 {
+				return fmt.Errorf("invalid sample rate in %q", line)
+			}
+			rate = r
+		case strings.HasPrefix(f, "#"):
+			for _, tag := range strings.Split(f[1:], ",") {
+				if tag == "" {
+					continue
+				}
+				k, v, _ := strings.Cut(tag, ":")
+				tags[k] = v
+			}
+		}
+	}
+
+	var value float64
+	if kind != "set" {
+		var err error
+		value, err = strconv.ParseFloat(raw, 64)
+		if err != nil || math.IsNaN(value) || math.IsInf(value, 0) {
+			return fmt.Errorf("invalid value in %q", line)
+		}
+	}
+
+	keys := make([]string, 0, len(tags))
+	for k := range tags {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	var id strings.Builder
+	id.WriteString(kind)
+	id.WriteByte(0)
+	id.WriteString(name)
+	for _, k := range keys {
+		id.WriteByte(0)
+		id.WriteString(k)
+		id.WriteByte('=')
+		id.WriteString(tags[k])
+	}
+
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	b := s.buckets[id.String()]
+	if b == nil {
+		b = &statsdBucket{name: name, kind: kind, tags: tags}
+		s.buckets[id.String()] = b
+	}
+	b.updated = true
+	switch kind {
+	case "counter":
+		b.count += value / rate
+	case "gauge":
+		if raw[0] == '+' || raw[0] == '-' {
+			b.value += value
+		} else {
+			b.value = value
+		}
+	case "timer":
+		b.timings = append(b.timings, value)
+		b.count += 1 / rate
+	case "set":
+		if b.set == nil {
+			b.set = make(map[string]bool)
+		}
+		b.set[raw] = true
+	}
+	return nil
+}
+
+// flush builds a container of the aggregated buckets and resets them.
+func (s *StatsD) flush(now time.Time) *skogul.Container {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	interval := now.Sub(s.lastFlush).Seconds()
+	s.lastFlush = now
+	c := skogul.Container{Metrics: make([]*skogul.Metric, 0, len(s.buckets))}
+	for id, b := range s.buckets {
+		if !b.updated && (b.kind != "gauge" || s.DeleteGauges) {
+			delete(s.buckets, id)
+			continue
+		}
+		t := now
+		m := skogul.Metric{
+			Time:     &t,
+			Metadata: make(map[string]interface{}, len(b.tags)+2),
+			Data:     make(map[string]interface{}),
+		}
+		for k, v := range b.tags {
+			m.Metadata[k] = v
+		}
+		m.Metadata["measurement"] = b.name
+		m.Metadata["statsd_type"] = b.kind
+		switch b.kind {
+		case "counter":
+			m.Data["count"] = b.count
+			if interval > 0 {
+				m.Data["rate"] = b.count / interval
+			}
+		case "gauge":
+			m.Data["value"] = b.value
+		case "timer":
+			s.timerData(m.Data, b)
+		case "set":
+			m.Data["count"] = len(b.set)
+		}
+		c.Metrics = append(c.Metrics, &m)
+		b.updated = false
+		b.count = 0
+		b.timings = b.timings[:0]
+		b.set = nil
+	}
+	return &c
+}
+
+func (s *StatsD) timerData(data map[string]interface{}, b *statsdBucket) {
+	t := b.timings
+	sort.Float64s(t)
+	sum := 0.0
+	for _, v := range t {
+		sum += v
+	}
+	data["count"] = b.count
+	data["sum"] = sum
+	data["min"] = t[0]
+	data["max"] = t[len(t)-1]
+	data["mean"] = sum / float64(len(t))
+	data["median"] = statsdPercentile(t, 50)
+	for _, p := range s.Percentiles {
+		key := "p" + strings.ReplaceAll(strconv.FormatFloat(p, 'f', -1, 64), ".", "_")
+		data[key] = statsdPercentile(t, p)
+	}
+}
+
+// statsdPercentile returns the nearest-rank percentile of sorted values.
+func statsdPercentile(sorted []float64, p float64) float64 {
+	rank := int(math.Ceil(p / 100 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	if rank > len(sorted) {
+		rank = len(sorted)
+	}
+	return sorted[rank-1]
+}
+
+func (s *StatsD) flusher() {
+	ticker := time.NewTicker(s.FlushInterval.Duration)
+	for now := range ticker.C {
+		c := s.flush(now)
+		if len(c.Metrics) == 0 {
+			continue
+		}
+		atomic.AddUint64(&s.stats.Flushes, 1)
+		if err := s.Handler.H.TransformAndSend(c); err != nil {
+			atomic.AddUint64(&s.stats.Errors, 1)
+			statsdLog.WithError(err).Error("Unable to transform and send statsd metrics")
+		}
+	}
+}
+
+// Start listening for StatsD packets. Never returns unless the socket
+// can't be opened.
+func (s *StatsD) Start() error {
+	if s.FlushInterval.Duration == 0 {
+		s.FlushInterval.Duration = 10 * time.Second
+	}
+	if s.Percentiles == nil {
+		s.Percentiles = []float64{90}
+	}
+	if s.PacketSize == 0 {
+		s.PacketSize = 9000
+	}
+	s.buckets = make(map[string]*statsdBucket)
+	s.lastFlush = time.Now()
+
+	udpip, err := net.ResolveUDPAddr("udp", s.Address)
+	if err != nil {
+		return fmt.Errorf("unable to resolve address %s: %w", s.Address, err)
+	}
+	ln, err := net.ListenUDP("udp", udpip)
+	if err != nil {
+		return err
+	}
+	go s.flusher()
+	buf := make([]byte, s.PacketSize)
+	for {
+		n, err := ln.Read(buf)
+		if err != nil || n == 0 {
+			statsdLog.WithError(err).WithField("bytes", n).Error("Unable to read UDP message")
+			continue
+		}
+		atomic.AddUint64(&s.stats.Packets, 1)
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			line = strings.TrimSpace(line)
+			if line == "" {
+				continue
+			}
+			if err := s.parseLine(line); err != nil {
+				atomic.AddUint64(&s.stats.Errors, 1)
+				statsdLog.WithError(err).Debug("Invalid statsd line")
+				continue
+			}
+			atomic.AddUint64(&s.stats.Lines, 1)
+		}
+	}
+}
+
+// Verify checks that the configuration is usable.
+func (s *StatsD) Verify() error {
+	if s.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if s.Address == "" {
+		return skogul.MissingArgument("Address")
+	}
+	for _, p := range s.Percentiles {
+		if p <= 0 || p > 100 {
+			return fmt.Errorf("invalid percentile %v, must be between 0 and 100", p)
+		}
+	}
+	if s.PacketSize < 0 || s.PacketSize > UDP_MAX_READ_SIZE {
+		return fmt.Errorf("invalid udp packet size, maximum udp read size is between 0 and %d", UDP_MAX_READ_SIZE)
+	}
+	return nil
+}
+
+// GetStats returns the stats of the receiver.
+func (s *StatsD) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "statsd"
+	metric.Metadata["identity"] = skogul.Identity[s]
+	metric.Data["packets"] = atomic.LoadUint64(&s.stats.Packets)
+	metric.Data["lines"] = atomic.LoadUint64(&s.stats.Lines)
+	metric.Data["errors"] = atomic.LoadUint64(&s.stats.Errors)
+	metric.Data["flushes"] = atomic.LoadUint64(&s.stats.Flushes)
+	return &metric
+}
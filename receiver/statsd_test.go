@@ -0,0 +1,99 @@
+/*
+ * skogul, test statsd receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+)
+
+func TestStatsD(t *testing.T) {
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.StatsD{
+		Address:       "127.0.0.1:12060",
+		Handler:       skogul.HandlerRef{H: &h, Name: "h"},
+		FlushInterval: skogul.Duration{Duration: 200 * time.Millisecond},
+		Percentiles:   []float64{50, 99.9},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	go rcv.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	conn, err := net.Dial("udp", "127.0.0.1:12060")
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+	defer conn.Close()
+	conn.Write([]byte("hits:1|c|#site:oslo\nhits:2|c|@0.5|#site:oslo\nhits:1|c|#site:bergen\ntemp:20|g\ntemp:+5|g\nlatency:10|ms\nlatency:30|ms\nlatency:20|ms\nusers:alice|s\nusers:bob|s\nusers:alice|s\nbroken"))
+
+	c := cs.wait(time.Second)
+	if c == nil {
+		t.Fatalf("no container received")
+	}
+	byName := make(map[string]*skogul.Metric)
+	for _, m := range c.Metrics {
+		key := m.Metadata["measurement"].(string)
+		if site, ok := m.Metadata["site"]; ok {
+			key += "/" + site.(string)
+		}
+		byName[key] = m
+	}
+	if len(byName) != 5 {
+		t.Fatalf("expected 5 metrics, got %d: %v", len(byName), byName)
+	}
+	if m := byName["hits/oslo"]; m.Data["count"] != 5.0 || m.Metadata["statsd_type"] != "counter" {
+		t.Errorf("unexpected counter %v %v", m.Metadata, m.Data)
+	}
+	if m := byName["hits/bergen"]; m.Data["count"] != 1.0 {
+		t.Errorf("unexpected counter %v", m.Data)
+	}
+	if m := byName["temp"]; m.Data["value"] != 25.0 {
+		t.Errorf("unexpected gauge %v", m.Data)
+	}
+	if m := byName["latency"]; m.Data["count"] != 3.0 || m.Data["min"] != 10.0 || m.Data["max"] != 30.0 || m.Data["mean"] != 20.0 || m.Data["p50"] != 20.0 || m.Data["p99_9"] != 30.0 {
+		t.Errorf("unexpected timer %v", m.Data)
+	}
+	if m := byName["users"]; m.Data["count"] != 2 {
+		t.Errorf("unexpected set %v", m.Data)
+	}
+
+	// Only the gauge is kept until the next flush.
+	c = cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 1 || c.Metrics[0].Data["value"] != 25.0 {
+		t.Errorf("expected only the gauge on the next flush, got %v", c)
+	}
+	stats := rcv.GetStats()
+	if stats.Data["errors"] != uint64(1) || stats.Data["lines"] != uint64(11) {
+		t.Errorf("unexpected stats %v", stats.Data)
+	}
+}
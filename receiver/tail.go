@@ -0,0 +1,500 @@
+/*
+ * skogul, tail receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var tailLog = skogul.Logger("receiver", "tail")
+
+/*
+Tail follows files matching a set of glob patterns, like tail -F, passing
+each new line (or multi-line record) to the handler.
+
+Files are identified by device and inode, not by name, so a file that is
+rotated by renaming it is read to the end under its new name, while the
+new file at the old path is read from the start. On platforms without
+inodes, files are identified by name, and a rotated file is only read to
+the end if it is still matched under its old name. If the new name also
+matches one of the patterns it is not read twice. A file that shrinks is
+assumed to be truncated, e.g. by copytruncate, and is read from the
+start. Files that no longer match any pattern are closed once nothing
+more is written to them.
+
+With a StateFile, the offset of the last line handled in each file is
+saved, along with a hash of the start of the file, so a restart resumes
+where it left off in files that are still matched by the patterns, also
+if they were renamed in the meantime. Data written to a file after it is
+rotated to a name that doesn't match is lost if Skogul isn't running. If
+the hash doesn't match, the inode has been reused by a new file, which
+is read like any other new file. Lines that are not terminated by a
+newline are not handled until they are, except for files that are
+closed.
+*/
+type Tail struct {
+	Files       []string          `doc:"Glob patterns of files to follow."`
+	Handler     skogul.HandlerRef `doc:"Handler used to parse, transform and send data."`
+	StateFile   string            `doc:"Path to a file where read offsets are saved, so restarts resume without duplicates or gaps. Without it, all files are read from the start, or end, on start."`
+	FromEnd     bool              `doc:"Skip existing data in files found on start that have no saved offset. Files created later are always read from the start."`
+	Interval    skogul.Duration   `doc:"How often to look for new data, new files, rotation and truncation. Default: 1s"`
+	MaxLineSize int               `doc:"Lines longer than this many bytes are split. Default: 1MiB"`
+	Multiline   *TailMultiline    `doc:"Join lines into multi-line records, e.g. stack traces."`
+	files       map[tailID]*tailFile
+	state       map[tailID]tailState
+	multiline   *regexp.Regexp
+	stats       tailStats
+}
+
+// TailMultiline decides how lines are joined into records.
+type TailMultiline struct {
+	Pattern   string          `doc:"Regular expression matched against each line."`
+	Match     string          `doc:"Either start: a line matching Pattern starts a new record and other lines are added to the current one, or continue: a line matching Pattern is added to the current record and other lines start a new one. Default: start"`
+	Separator string          `doc:"Separator between the joined lines. Default: newline"`
+	MaxLines  int             `doc:"Maximum number of lines in a record. Default: 500"`
+	Timeout   skogul.Duration `doc:"Handle a record if no lines have been added to it for this long. Default: 5s"`
+}
+
+type tailStats struct {
+	Lines         uint64 // Lines read.
+	Records       uint64 // Records (lines or joined lines) passed to the handler.
+	HandlerErrors uint64 // Records the handler failed.
+	ReadErrors    uint64 // Failures to open, stat or read files, or save state.
+	Rotations     uint64 // New files found at a path that was already followed.
+	Truncations   uint64 // Files that shrank and were read from the start.
+}
+
+// tailID identifies a file independent of its name.
+type tailID struct {
+	Dev uint64
+	Ino uint64
+}
+
+// tailFingerprintSize is how much of the start of a file is hashed to
+// tell it apart from a later file with the same inode.
+const tailFingerprintSize = 1024
+
+// tailState is the saved state of a file. Fingerprint is the hash of the
+// first Offset bytes, up to tailFingerprintSize. It is empty in state
+// files from older versions, and then not checked.
+type tailState struct {
+	Path        string
+	Dev         uint64
+	Ino         uint64
+	Offset      int64
+	Fingerprint string `json:",omitempty"`
+}
+
+// tailFile is a file being followed.
+type tailFile struct {
+	path      string
+	f         *os.File
+	pos       int64    // Position read up to
+	committed int64    // End of the last handled record
+	partial   []byte   // Data after the last newline
+	record    [][]byte // Lines of the current multi-line record
+	recordEnd int64    // End of the last line of the current record
+	lastLine  time.Time
+	fp        string // Fingerprint of the first fpSize bytes
+	fpSize    int64
+}
+
+// Verify checks that the patterns and multi-line options are valid.
+func (t *Tail) Verify() error {
+	if t.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if len(t.Files) == 0 {
+		return skogul.MissingArgument("Files")
+	}
+	for _, pattern := range t.Files {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	if t.MaxLineSize < 0 {
+		return fmt.Errorf("MaxLineSize can't be negative")
+	}
+	if t.Multiline != nil {
+		if t.Multiline.Pattern == "" {
+			return skogul.MissingArgument("Multiline.Pattern")
+		}
+		if _, err := regexp.Compile(t.Multiline.Pattern); err != nil {
+			return fmt.Errorf("invalid multi-line pattern: %w", err)
+		}
+		switch t.Multiline.Match {
+		case "", "start", "continue":
+		default:
+			return fmt.Errorf("invalid multi-line Match %q, must be start or continue", t.Multiline.Match)
+		}
+	}
+	return nil
+}
+
+// fingerprint hashes the first size bytes of f.
+func fingerprint(f *os.File, size int64) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, io.NewSectionReader(f, 0, size)); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// fingerprint returns the fingerprint of a followed file, updating it
+// if more of the start of the file has been handled since last time.
+func (tf *tailFile) fingerprint() (string, error) {
+	size := tf.committed
+	if size > tailFingerprintSize {
+		size = tailFingerprintSize
+	}
+	if size != tf.fpSize {
+		fp, err := fingerprint(tf.f, size)
+		if err != nil {
+			return "", err
+		}
+		tf.fp, tf.fpSize = fp, size
+	}
+	return tf.fp, nil
+}
+
+// loadState reads the state file, if there is one.
+func (t *Tail) loadState() error {
+	t.state = make(map[tailID]tailState)
+	if t.StateFile == "" {
+		return nil
+	}
+	b, err := os.ReadFile(t.StateFile)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("unable to read state file: %w", err)
+	}
+	var states []tailState
+	if err := json.Unmarshal(b, &states); err != nil {
+		return fmt.Errorf("unable to parse state file %s: %w", t.StateFile, err)
+	}
+	for _, s := range states {
+		t.state[tailID{s.Dev, s.Ino}] = s
+	}
+	return nil
+}
+
+// saveState writes the offsets of all open files to the state file, by
+// writing a temporary file and renaming it, so it is never left half
+// written.
+func (t *Tail) saveState() error {
+	if t.StateFile == "" {
+		return nil
+	}
+	states := make([]tailState, 0, len(t.files))
+	for id, tf := range t.files {
+		fp, err := tf.fingerprint()
+		if err != nil {
+			return err
+		}
+		states = append(states, tailState{Path: tf.path, Dev: id.Dev, Ino: id.Ino, Offset: tf.committed, Fingerprint: fp})
+	}
+	sort.Slice(states, func(i, j int) bool { return states[i].Path < states[j].Path })
+	b, err := json.MarshalIndent(states, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp := t.StateFile + ".tmp"
+	if err := os.WriteFile(tmp, b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.StateFile)
+}
+
+// handle passes a record to the handler.
+func (t *Tail) handle(b []byte) {
+	atomic.AddUint64(&t.stats.Records, 1)
+	if err := t.Handler.H.Handle(b); err != nil {
+		atomic.AddUint64(&t.stats.HandlerErrors, 1)
+		tailLog.WithError(err).Error("Failed to send metric")
+	}
+}
+
+// flush handles the current multi-line record, if any.
+func (t *Tail) flush(tf *tailFile) {
+	if len(tf.record) == 0 {
+		return
+	}
+	t.handle(bytes.Join(tf.record, []byte(t.Multiline.Separator)))
+	tf.record = tf.record[:0]
+	tf.committed = tf.recordEnd
+}
+
+// line handles a single line, ending at offset end in the file.
+func (t *Tail) line(tf *tailFile, line []byte, end int64) {
+	atomic.AddUint64(&t.stats.Lines, 1)
+	tf.lastLine = time.Now()
+	if t.multiline == nil {
+		// Parsers may keep the data, and the read buffer is reused.
+		t.handle(append([]byte(nil), line...))
+		tf.committed = end
+		return
+	}
+	match := t.multiline.Match(line)
+	startsRecord := match
+	if t.Multiline.Match == "continue" {
+		startsRecord = !match
+	}
+	if startsRecord || len(tf.record) >= t.Multiline.MaxLines {
+		t.flush(tf)
+	}
+	tf.record = append(tf.record, append([]byte(nil), line...))
+	tf.recordEnd = end
+}
+
+// read reads everything new in a file and handles complete lines.
+func (t *Tail) read(tf *tailFile, buf []byte) (int, error) {
+	total := 0
+	for {
+		n, err := tf.f.Read(buf)
+		total += n
+		data := buf[:n]
+		for len(data) > 0 {
+			i := bytes.IndexByte(data, '\n')
+			if i < 0 {
+				tf.partial = append(tf.partial, data...)
+				break
+			}
+			tf.pos += int64(i + 1)
+			if len(tf.partial) > 0 {
+				tf.partial = append(tf.partial, data[:i]...)
+				t.line(tf, tf.partial, tf.pos)
+				tf.partial = tf.partial[:0]
+			} else {
+				t.line(tf, data[:i], tf.pos)
+			}
+			data = data[i+1:]
+		}
+		tf.pos += int64(len(data))
+		if len(tf.partial) >= t.MaxLineSize {
+			tailLog.WithField("path", tf.path).Warnf("Line longer than %d bytes, splitting it", t.MaxLineSize)
+			t.line(tf, tf.partial, tf.pos)
+			tf.partial = tf.partial[:0]
+		}
+		if err == io.EOF || n == 0 {
+			return total, nil
+		}
+		if err != nil {
+			return total, err
+		}
+	}
+}
+
+// resume checks if a saved offset belongs to the file, and not to an
+// earlier file with the same inode.
+func resume(f *os.File, s tailState, size int64) bool {
+	if s.Offset > size {
+		return false
+	}
+	if s.Fingerprint == "" {
+		return true
+	}
+	n := s.Offset
+	if n > tailFingerprintSize {
+		n = tailFingerprintSize
+	}
+	fp, err := fingerprint(f, n)
+	return err == nil && fp == s.Fingerprint
+}
+
+// open starts following a file, resuming from a saved offset if there is
+// one for the same file.
+func (t *Tail) open(path string, id tailID, size int64, fromEnd bool) (*tailFile, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	tf := &tailFile{path: path, f: f}
+	if s, ok := t.state[id]; ok && resume(f, s, size) {
+		tf.pos = s.Offset
+	} else if ok {
+		tailLog.WithField("path", path).Info("Saved offset is for an earlier file, reading from the start")
+	} else if fromEnd {
+		tf.pos = size
+	}
+	delete(t.state, id)
+	tf.committed = tf.pos
+	if _, err := f.Seek(tf.pos, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	tailLog.WithField("path", path).WithField("offset", tf.pos).Debug("Following file")
+	return tf, nil
+}
+
+// close stops following a file. A final line without a newline and an
+// incomplete multi-line record are handled, since nothing more will be
+// added to them.
+func (t *Tail) close(id tailID, tf *tailFile) {
+	if len(tf.partial) > 0 {
+		t.line(tf, tf.partial, tf.pos)
+		tf.partial = nil
+	}
+	if t.multiline != nil {
+		t.flush(tf)
+	}
+	tf.f.Close()
+	delete(t.files, id)
+	tailLog.WithField("path", tf.path).Debug("Stopped following file")
+}
+
+// poll looks for new files, rotation and truncation, and reads new data.
+func (t *Tail) poll(first bool, buf []byte) {
+	seen := make(map[tailID]bool)
+	paths := make(map[string]tailID)
+	for id, tf := range t.files {
+		paths[tf.path] = id
+	}
+	for _, pattern := range t.Files {
+		matches, _ := filepath.Glob(pattern)
+		for _, path := range matches {
+			info, err := os.Stat(path)
+			if err != nil || !info.Mode().IsRegular() {
+				continue
+			}
+			id, err := fileID(path, info)
+			if err != nil || seen[id] {
+				continue
+			}
+			seen[id] = true
+			tf, ok := t.files[id]
+			if !ok {
+				if old, ok := paths[path]; ok && old != id {
+					atomic.AddUint64(&t.stats.Rotations, 1)
+					tailLog.WithField("path", path).Info("File rotated, following the new file")
+				}
+				if tf, err = t.open(path, id, info.Size(), first && t.FromEnd); err != nil {
+					atomic.AddUint64(&t.stats.ReadErrors, 1)
+					tailLog.WithField("path", path).WithError(err).Error("Unable to open file")
+					continue
+				}
+				t.files[id] = tf
+			}
+			tf.path = path
+			if info.Size() < tf.pos {
+				atomic.AddUint64(&t.stats.Truncations, 1)
+				tailLog.WithField("path", path).Info("File truncated, reading from the start")
+				tf.f.Seek(0, io.SeekStart)
+				tf.pos, tf.committed, tf.recordEnd = 0, 0, 0
+				tf.fp, tf.fpSize = "", 0
+				tf.partial = tf.partial[:0]
+				tf.record = tf.record[:0]
+			}
+		}
+	}
+	for id, tf := range t.files {
+		n, err := t.read(tf, buf)
+		if err != nil {
+			atomic.AddUint64(&t.stats.ReadErrors, 1)
+			tailLog.WithField("path", tf.path).WithError(err).Error("Unable to read file")
+		}
+		if t.multiline != nil && len(tf.record) > 0 && time.Since(tf.lastLine) >= t.Multiline.Timeout.Duration {
+			t.flush(tf)
+		}
+		if !seen[id] && n == 0 {
+			t.close(id, tf)
+		}
+	}
+	if err := t.saveState(); err != nil {
+		atomic.AddUint64(&t.stats.ReadErrors, 1)
+		tailLog.WithError(err).Error("Unable to save state")
+	}
+}
+
+func (t *Tail) init() error {
+	if t.Interval.Duration == 0 {
+		t.Interval.Duration = time.Second
+	}
+	if t.MaxLineSize == 0 {
+		t.MaxLineSize = 1024 * 1024
+	}
+	if t.Multiline != nil {
+		var err error
+		if t.multiline, err = regexp.Compile(t.Multiline.Pattern); err != nil {
+			return fmt.Errorf("invalid multi-line pattern: %w", err)
+		}
+		if t.Multiline.Separator == "" {
+			t.Multiline.Separator = "\n"
+		}
+		if t.Multiline.MaxLines == 0 {
+			t.Multiline.MaxLines = 500
+		}
+		if t.Multiline.Timeout.Duration == 0 {
+			t.Multiline.Timeout.Duration = 5 * time.Second
+		}
+	}
+	t.files = make(map[tailID]*tailFile)
+	return t.loadState()
+}
+
+// Start follows the files forever. Only returns if the state file can't
+// be read.
+func (t *Tail) Start() error {
+	if err := t.init(); err != nil {
+		return err
+	}
+	buf := make([]byte, 64*1024)
+	for first := true; ; first = false {
+		t.poll(first, buf)
+		time.Sleep(t.Interval.Duration)
+	}
+}
+
+// GetStats exposes stats about the tail receiver.
+func (t *Tail) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "tail"
+	metric.Metadata["identity"] = skogul.Identity[t]
+	metric.Data["lines"] = atomic.LoadUint64(&t.stats.Lines)
+	metric.Data["records"] = atomic.LoadUint64(&t.stats.Records)
+	metric.Data["handler_errors"] = atomic.LoadUint64(&t.stats.HandlerErrors)
+	metric.Data["read_errors"] = atomic.LoadUint64(&t.stats.ReadErrors)
+	metric.Data["rotations"] = atomic.LoadUint64(&t.stats.Rotations)
+	metric.Data["truncations"] = atomic.LoadUint64(&t.stats.Truncations)
+	return &metric
+}
@@ -0,0 +1,40 @@
+//go:build !unix
+
+/*
+ * skogul, tail receiver, file identity without inodes
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"hash/fnv"
+	"os"
+)
+
+// fileID identifies a file by a hash of its path, since there are no
+// inodes. A file renamed by rotation is then a new file, and the new file
+// at the old path looks like a truncation of the old one.
+func fileID(path string, info os.FileInfo) (tailID, error) {
+	h := fnv.New64a()
+	h.Write([]byte(path))
+	return tailID{Ino: h.Sum64()}, nil
+}
@@ -0,0 +1,220 @@
+//go:build unix
+
+/*
+ * skogul, tail receiver tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+	"github.com/telenornms/skogul/transformer"
+)
+
+// tailExpect checks that the next records received are want, in any
+// order, and that nothing else arrives shortly after.
+func tailExpect(t *testing.T, cs *chanSender, want ...string) {
+	t.Helper()
+	missing := make(map[string]int)
+	for _, w := range want {
+		missing[w]++
+	}
+	for range want {
+		c := cs.wait(2 * time.Second)
+		if c == nil {
+			t.Fatalf("timed out waiting for %v", missing)
+		}
+		got := string(c.Metrics[0].Data["data"].([]byte))
+		if missing[got] == 0 {
+			t.Fatalf("unexpected record %q, waiting for %v", got, missing)
+		}
+		missing[got]--
+	}
+	if c := cs.wait(100 * time.Millisecond); c != nil {
+		t.Fatalf("unexpected extra record %q", c.Metrics[0].Data["data"])
+	}
+}
+
+func tailAppend(t *testing.T, file string, data string) {
+	t.Helper()
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatalf("unable to open %s: %v", file, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(data); err != nil {
+		t.Fatalf("unable to write to %s: %v", file, err)
+	}
+}
+
+func tailStart(t *testing.T, rcv *receiver.Tail) *chanSender {
+	t.Helper()
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs, Transformers: []skogul.Transformer{&transformer.DummyTimestamp{}}}
+	h.SetParser(parser.Blob{})
+	rcv.Handler = skogul.HandlerRef{H: &h, Name: "h"}
+	rcv.Interval = skogul.Duration{Duration: 10 * time.Millisecond}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	go rcv.Start()
+	return cs
+}
+
+func TestTail(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "app.log")
+	tailAppend(t, file, "a\nb\npar")
+
+	cs := tailStart(t, &receiver.Tail{Files: []string{filepath.Join(dir, "app.log*")}, StateFile: filepath.Join(dir, "state.json")})
+	tailExpect(t, cs, "a", "b")
+	tailAppend(t, file, "tial\n")
+	tailExpect(t, cs, "partial")
+
+	// Rotation, with a final write to the old file after the new one
+	// is created. The rotated file matches the pattern too, but is not
+	// read twice.
+	if err := os.Rename(file, file+".1"); err != nil {
+		t.Fatalf("rename failed: %v", err)
+	}
+	tailAppend(t, file, "new\n")
+	tailAppend(t, file+".1", "old\n")
+	tailExpect(t, cs, "new", "old")
+
+	// Truncation
+	if err := os.Truncate(file, 0); err != nil {
+		t.Fatalf("truncate failed: %v", err)
+	}
+	time.Sleep(50 * time.Millisecond)
+	tailAppend(t, file, "x\n")
+	tailExpect(t, cs, "x")
+
+	b, err := os.ReadFile(filepath.Join(dir, "state.json"))
+	if err != nil {
+		t.Fatalf("unable to read state file: %v", err)
+	}
+	var state []map[string]interface{}
+	if err := json.Unmarshal(b, &state); err != nil || len(state) != 2 {
+		t.Fatalf("unexpected state %s (%v)", b, err)
+	}
+	if state[0]["Path"] != file || state[0]["Offset"] != 2.0 || state[1]["Offset"] != 16.0 {
+		t.Errorf("unexpected state %s", b)
+	}
+}
+
+func TestTail_resume(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "resume.log")
+	other := filepath.Join(dir, "other.log")
+	tailAppend(t, file, "1\n2\n")
+	tailAppend(t, other, "skipped\n")
+	info, _ := os.Stat(file)
+	st := info.Sys().(*syscall.Stat_t)
+	stateFile := filepath.Join(dir, "state.json")
+	state := fmt.Sprintf(`[{"Path": %q, "Dev": %d, "Ino": %d, "Offset": 2}]`, file, st.Dev, st.Ino)
+	if err := os.WriteFile(stateFile, []byte(state), 0644); err != nil {
+		t.Fatalf("unable to write state: %v", err)
+	}
+
+	cs := tailStart(t, &receiver.Tail{Files: []string{filepath.Join(dir, "*.log")}, StateFile: stateFile, FromEnd: true})
+	tailExpect(t, cs, "2")
+	tailAppend(t, other, "y\n")
+	tailExpect(t, cs, "y")
+	tailAppend(t, filepath.Join(dir, "late.log"), "z\n")
+	tailExpect(t, cs, "z")
+}
+
+// TestTail_reused checks that a saved offset isn't used for a new file
+// that got the inode of the file it was saved for.
+func TestTail_reused(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "reused.log")
+	tailAppend(t, file, "1\n2\n")
+	info, _ := os.Stat(file)
+	st := info.Sys().(*syscall.Stat_t)
+	stateFile := filepath.Join(dir, "state.json")
+	state := fmt.Sprintf(`[{"Path": %q, "Dev": %d, "Ino": %d, "Offset": 2, "Fingerprint": "%x"}]`, file, st.Dev, st.Ino, sha256.Sum256([]byte("0\n")))
+	if err := os.WriteFile(stateFile, []byte(state), 0644); err != nil {
+		t.Fatalf("unable to write state: %v", err)
+	}
+
+	cs := tailStart(t, &receiver.Tail{Files: []string{file}, StateFile: stateFile})
+	tailExpect(t, cs, "1", "2")
+	b, err := os.ReadFile(stateFile)
+	if err != nil {
+		t.Fatalf("unable to read state file: %v", err)
+	}
+	if want := fmt.Sprintf("%x", sha256.Sum256([]byte("1\n2\n"))); !strings.Contains(string(b), want) {
+		t.Errorf("state %s doesn't have fingerprint %s", b, want)
+	}
+}
+
+func TestTail_multiline(t *testing.T) {
+	dir := t.TempDir()
+	file := filepath.Join(dir, "trace.log")
+	tailAppend(t, file, "Exception: boom\n  at a\n  at b\nnext\n")
+
+	cs := tailStart(t, &receiver.Tail{
+		Files:     []string{file},
+		Multiline: &receiver.TailMultiline{Pattern: `^\S`, Timeout: skogul.Duration{Duration: 300 * time.Millisecond}},
+	})
+	tailExpect(t, cs, "Exception: boom\n  at a\n  at b")
+	// The last record is handled on timeout
+	tailExpect(t, cs, "next")
+
+	dir = t.TempDir()
+	file = filepath.Join(dir, "continue.log")
+	tailAppend(t, file, "E1\n  at a\n  at b\nE2\n")
+	cs = tailStart(t, &receiver.Tail{
+		Files:     []string{file},
+		Multiline: &receiver.TailMultiline{Pattern: `^\s+at `, Match: "continue", Separator: "|", MaxLines: 2, Timeout: skogul.Duration{Duration: 50 * time.Millisecond}},
+	})
+	tailExpect(t, cs, "E1|  at a", "  at b", "E2")
+}
+
+func TestTail_verify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	for _, rcv := range []*receiver.Tail{
+		{Handler: h},
+		{Files: []string{"/tmp/x"}},
+		{Handler: h, Files: []string{"/tmp/["}},
+		{Handler: h, Files: []string{"/tmp/x"}, Multiline: &receiver.TailMultiline{}},
+		{Handler: h, Files: []string{"/tmp/x"}, Multiline: &receiver.TailMultiline{Pattern: "("}},
+		{Handler: h, Files: []string{"/tmp/x"}, Multiline: &receiver.TailMultiline{Pattern: "x", Match: "end"}},
+	} {
+		if err := rcv.Verify(); err == nil {
+			t.Errorf("Verify() of %+v did not fail", rcv)
+		}
+	}
+}
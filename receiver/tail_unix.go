@@ -0,0 +1,41 @@
+//go:build unix
+
+/*
+ * skogul, tail receiver, file identity on unix
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fileID identifies a file by device and inode.
+func fileID(path string, info os.FileInfo) (tailID, error) {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return tailID{}, fmt.Errorf("unable to get inode of %s", path)
+	}
+	return tailID{uint64(st.Dev), uint64(st.Ino)}, nil
+}
@@ -0,0 +1,513 @@
+/*
+ * skogul, USP controller receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/gen/usp"
+	skmqtt "github.com/telenornms/skogul/internal/mqtt"
+)
+
+var uspLog = skogul.Logger("receiver", "usp")
+
+/*
+USP is the controller side of the User Services Platform (TR-369). It
+receives USP Records from agents over the MQTT MTP, the WebSocket MTP or
+both, and turns Notify requests and Get responses into metrics.
+
+Each metric has the endpoint ID of the agent and the affected path as
+metadata. Notify messages with send_resp set are acknowledged with a
+NotifyResp, sent back over the MTP the Notify arrived on, once the
+handler has accepted the metrics. If the handler fails, no NotifyResp is
+sent, so the agent retransmits the Notify.
+
+Parameter values are strings in USP, and are kept as strings unless
+their data model type is configured in Types. Guessing the type from the
+value isn't safe: a serial number of "007" or a name of "NaN" would be
+mangled. Types are the TR-106 data types used by TR-181, and are looked
+up by the full path of the parameter, the path in data model notation
+with instance numbers replaced by {i}, or just the parameter name, in
+that order. Values that don't match their type are kept as strings.
+*/
+type USP struct {
+	EndpointID    string            `doc:"USP endpoint ID of this controller, used as the sender of responses. Records addressed to other endpoints are ignored." example:"self::skogul"`
+	Broker        string            `doc:"Address of the MQTT broker. Enables the MQTT MTP." example:"tcp://localhost:1883"`
+	Topics        []string          `doc:"MQTT topics to subscribe to." example:"[\"usp/controller/#\"]"`
+	Username      string            `doc:"Username for authenticating to the broker."`
+	Password      skogul.Secret     `doc:"Password for authenticating to the broker."`
+	ClientID      string            `doc:"MQTT client id to use (default: random)"`
+	ResponseTopic string            `doc:"MQTT topic to send responses to if the agent didn't include a reply-to topic. ${endpoint} is replaced with the endpoint ID of the agent." example:"usp/agent/${endpoint}"`
+	Address       string            `doc:"Address to accept WebSocket MTP connections on. Enables the WebSocket MTP." example:"[::1]:8080"`
+	Path          string            `doc:"HTTP path for WebSocket MTP connections. Defaults to /usp."`
+	Certfile      string            `doc:"Path to certificate file for TLS on the WebSocket MTP. If left blank, un-encrypted HTTP is used."`
+	Keyfile       string            `doc:"Path to key file for TLS."`
+	Types         map[string]string `doc:"Data model types of parameters. Keys are full paths, paths with {i} for instance numbers, or parameter names. Supported types are int, long, unsignedInt, unsignedLong, decimal, boolean and string. Parameters without a type are kept as strings." example:"{\"Device.WiFi.Radio.{i}.Stats.BytesSent\": \"unsignedLong\", \"Enable\": \"boolean\"}"`
+	Origins       []string          `doc:"Allowed Origin headers for WebSocket MTP connections, or * for any. Connections without an Origin header, which is what agents normally send, are always accepted. By default, only an Origin matching the Host header is accepted."`
+	Handler       skogul.HandlerRef `doc:"Handler used to transform and send data. The parser is not used."`
+
+	mc       skmqtt.MQTT
+	upgrader websocket.Upgrader
+	stats    uspStats
+}
+
+type uspStats struct {
+	Records       uint64 // Received USP records
+	Ignored       uint64 // Records addressed to others, or message types we don't use
+	DecodeErrors  uint64 // Invalid records or messages
+	Notifies      uint64 // Notify requests
+	GetResponses  uint64 // GetResp responses
+	Responses     uint64 // NotifyResp sent
+	SendErrors    uint64 // Failure to send NotifyResp
+	HandlerErrors uint64 // Errors from TransformAndSend
+}
+
+// uspReply sends a response record back to the agent
+type uspReply func(record []byte) error
+
+// uspWS is the subprotocol of the WebSocket MTP
+const uspWS = "v1.usp"
+
+// Start the MTPs. Never returns.
+func (u *USP) Start() error {
+	if u.Broker != "" {
+		u.mc.QoS = 1
+		if err := u.mc.Init(u.Broker, u.Username, u.Password.Expose(), u.ClientID); err != nil {
+			return err
+		}
+		for _, topic := range u.Topics {
+			u.mc.Subscribe(topic, u.mqttReceive)
+		}
+		uspLog.WithField("address", u.Broker).Debug("Starting USP MQTT MTP")
+		if err := u.mc.Connect(); err != nil {
+			uspLog.WithError(err).Error("Initial connection to MQTT broker failed")
+		}
+	}
+	if u.Address == "" {
+		select {}
+	}
+	u.upgrader = websocket.Upgrader{Subprotocols: []string{uspWS}}
+	if len(u.Origins) > 0 {
+		u.upgrader.CheckOrigin = u.checkOrigin
+	}
+	path := u.Path
+	if path == "" {
+		path = "/usp"
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(path, u.wsHandle)
+	server := http.Server{Addr: u.Address, Handler: mux}
+	uspLog.WithField("address", u.Address).Debug("Starting USP WebSocket MTP")
+	if u.Certfile != "" {
+		return server.ListenAndServeTLS(u.Certfile, u.Keyfile)
+	}
+	return server.ListenAndServe()
+}
+
+// mqttReceive handles a record received over MQTT. The reply topic is
+// taken from the "/reply-to=" suffix of the topic if present, as
+// specified by the MQTT MTP for MQTT 3.1.1, or the response topic property
+// with MQTT 5, otherwise from ResponseTopic.
+func (u *USP) mqttReceive(msg skmqtt.Message) {
+	replyTo := msg.ResponseTopic
+	if i := strings.Index(msg.Topic, "/reply-to="); i >= 0 && replyTo == "" {
+		replyTo, _ = url.PathUnescape(msg.Topic[i+len("/reply-to="):])
+	}
+	u.handle(msg.Payload, func(from string) uspReply {
+		topic := replyTo
+		if topic == "" {
+			topic = strings.ReplaceAll(u.ResponseTopic, "${endpoint}", from)
+		}
+		if topic == "" {
+			return nil
+		}
+		return func(record []byte) error {
+			return u.mc.Publish(topic, false, record)
+		}
+	})
+}
+
+// checkOrigin accepts WebSocket connections without an Origin header,
+// or with one of the configured Origins.
+func (u *USP) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+	for _, o := range u.Origins {
+		if o == "*" || strings.EqualFold(o, origin) {
+			return true
+		}
+	}
+	return false
+}
+
+// wsHandle accepts a WebSocket MTP connection and handles records until
+// the agent disconnects.
+func (u *USP) wsHandle(w http.ResponseWriter, r *http.Request) {
+	conn, err := u.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		uspLog.WithError(err).Warn("WebSocket upgrade failed")
+		return
+	}
+	defer conn.Close()
+	var wlock sync.Mutex
+	reply := func(record []byte) error {
+		wlock.Lock()
+		defer wlock.Unlock()
+		return conn.WriteMessage(websocket.BinaryMessage, record)
+	}
+	for {
+		typ, b, err := conn.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				uspLog.WithError(err).WithField("remote", r.RemoteAddr).Debug("WebSocket connection closed")
+			}
+			return
+		}
+		if typ != websocket.BinaryMessage {
+			continue
+		}
+		u.handle(b, func(string) uspReply { return reply })
+	}
+}
+
+// handle decodes a record, sends the metrics and acknowledges it if
+// needed. replyFor returns how to reply to the agent, or nil if that isn't
+// possible.
+func (u *USP) handle(b []byte, replyFor func(from string) uspReply) {
+	atomic.AddUint64(&u.stats.Records, 1)
+	c, resp, from, err := u.decode(b)
+	if err != nil {
+		atomic.AddUint64(&u.stats.DecodeErrors, 1)
+		uspLog.WithError(err).Warn("Unable to decode USP record")
+		return
+	}
+	if c == nil {
+		atomic.AddUint64(&u.stats.Ignored, 1)
+		return
+	}
+	if len(c.Metrics) > 0 {
+		if err := u.Handler.H.TransformAndSend(c); err != nil {
+			// No NotifyResp, so the agent sends the Notify again.
+			atomic.AddUint64(&u.stats.HandlerErrors, 1)
+			uspLog.WithError(err).Error("Error during transform or send container")
+			return
+		}
+	}
+	if resp == nil {
+		return
+	}
+	reply := replyFor(from)
+	if reply == nil {
+		atomic.AddUint64(&u.stats.SendErrors, 1)
+		uspLog.WithField("endpoint", from).Warn("Notify requires a response, but there is no topic to send it to")
+		return
+	}
+	if err := reply(resp); err != nil {
+		atomic.AddUint64(&u.stats.SendErrors, 1)
+		uspLog.WithError(err).WithField("endpoint", from).Warn("Unable to send NotifyResp")
+		return
+	}
+	atomic.AddUint64(&u.stats.Responses, 1)
+}
+
+// decode turns a record into a container, and a response record if one
+// is required. Returns a nil container for records that are ignored.
+func (u *USP) decode(b []byte) (*skogul.Container, []byte, string, error) {
+	record := usp.Record{}
+	if err := proto.Unmarshal(b, &record); err != nil {
+		return nil, nil, "", fmt.Errorf("invalid record: %w", err)
+	}
+	from := record.GetFromId()
+	if u.EndpointID != "" && record.GetToId() != u.EndpointID {
+		return nil, nil, from, nil
+	}
+	var payload []byte
+	switch rt := record.GetRecordType().(type) {
+	case *usp.Record_NoSessionContext:
+		payload = rt.NoSessionContext.GetPayload()
+	case *usp.Record_SessionContext:
+		sc := rt.SessionContext
+		if sc.GetPayloadSarState() != usp.SessionContextRecord_NONE {
+			return nil, nil, from, fmt.Errorf("segmented payloads are not supported")
+		}
+		for _, p := range sc.GetPayload() {
+			payload = append(payload, p...)
+		}
+	default:
+		return nil, nil, from, nil
+	}
+	msg := usp.Msg{}
+	if err := proto.Unmarshal(payload, &msg); err != nil {
+		return nil, nil, from, fmt.Errorf("invalid message from %s: %w", from, err)
+	}
+
+	now := skogul.Now()
+	meta := func(path string) map[string]interface{} {
+		return map[string]interface{}{
+			"endpoint_id": from,
+			"to_id":       record.GetToId(),
+			"msg_id":      msg.GetHeader().GetMsgId(),
+			"msg_type":    msg.GetHeader().GetMsgType().String(),
+			"path":        path,
+		}
+	}
+	c := skogul.Container{}
+	add := func(m map[string]interface{}, d map[string]interface{}) {
+		c.Metrics = append(c.Metrics, &skogul.Metric{Time: &now, Metadata: m, Data: d})
+	}
+
+	if notify := msg.GetBody().GetRequest().GetNotify(); notify != nil {
+		atomic.AddUint64(&u.stats.Notifies, 1)
+		var m, d map[string]interface{}
+		switch n := notify.GetNotification().(type) {
+		case *usp.Notify_Event_:
+			m = meta(n.Event.GetObjPath())
+			m["event_name"] = n.Event.GetEventName()
+			d = u.params(n.Event.GetObjPath(), n.Event.GetParams())
+		case *usp.Notify_ValueChange_:
+			path := n.ValueChange.GetParamPath()
+			param := path
+			if i := strings.LastIndex(path, "."); i >= 0 {
+				param = path[i+1:]
+				path = path[:i+1]
+			}
+			m = meta(path)
+			d = u.params(strings.TrimSuffix(n.ValueChange.GetParamPath(), param), map[string]string{param: n.ValueChange.GetParamValue()})
+		case *usp.Notify_ObjCreation:
+			m = meta(n.ObjCreation.GetObjPath())
+			d = u.params(n.ObjCreation.GetObjPath(), n.ObjCreation.GetUniqueKeys())
+			d["created"] = true
+		case *usp.Notify_ObjDeletion:
+			m = meta(n.ObjDeletion.GetObjPath())
+			d = map[string]interface{}{"deleted": true}
+		case *usp.Notify_OperComplete:
+			oc := n.OperComplete
+			m = meta(oc.GetObjPath())
+			m["command_name"] = oc.GetCommandName()
+			m["command_key"] = oc.GetCommandKey()
+			if f := oc.GetCmdFailure(); f != nil {
+				d = map[string]interface{}{"err_code": f.GetErrCode(), "err_msg": f.GetErrMsg()}
+			} else {
+				d = u.params(oc.GetObjPath()+oc.GetCommandName()+".", oc.GetReqOutputArgs().GetOutputArgs())
+			}
+		case *usp.Notify_OnBoardReq:
+			ob := n.OnBoardReq
+			m = meta("")
+			d = map[string]interface{}{
+				"oui":                               ob.GetOui(),
+				"product_class":                     ob.GetProductClass(),
+				"serial_number":                     ob.GetSerialNumber(),
+				"agent_supported_protocol_versions": ob.GetAgentSupportedProtocolVersions(),
+			}
+		}
+		if m != nil {
+			m["subscription_id"] = notify.GetSubscriptionId()
+			add(m, d)
+		}
+		if !notify.GetSendResp() {
+			return &c, nil, from, nil
+		}
+		resp, err := u.notifyResp(&record, &msg, notify)
+		return &c, resp, from, err
+	}
+
+	if getResp := msg.GetBody().GetResponse().GetGetResp(); getResp != nil {
+		atomic.AddUint64(&u.stats.GetResponses, 1)
+		for _, req := range getResp.GetReqPathResults() {
+			if req.GetErrCode() != 0 {
+				uspLog.WithFields(map[string]interface{}{"endpoint": from, "path": req.GetRequestedPath(), "err_code": req.GetErrCode()}).Warnf("Get failed: %s", req.GetErrMsg())
+				continue
+			}
+			for _, res := range req.GetResolvedPathResults() {
+				m := meta(res.GetResolvedPath())
+				m["requested_path"] = req.GetRequestedPath()
+				add(m, u.params(res.GetResolvedPath(), res.GetResultParams()))
+			}
+		}
+		return &c, nil, from, nil
+	}
+	return nil, nil, from, nil
+}
+
+// notifyResp builds the record acknowledging a Notify
+func (u *USP) notifyResp(record *usp.Record, msg *usp.Msg, notify *usp.Notify) ([]byte, error) {
+	resp := usp.Msg{
+		Header: &usp.Header{
+			MsgId:   msg.GetHeader().GetMsgId(),
+			MsgType: usp.Header_NOTIFY_RESP,
+		},
+		Body: &usp.Body{
+			MsgBody: &usp.Body_Response{
+				Response: &usp.Response{
+					RespType: &usp.Response_NotifyResp{
+						NotifyResp: &usp.NotifyResp{SubscriptionId: notify.GetSubscriptionId()},
+					},
+				},
+			},
+		},
+	}
+	payload, err := proto.Marshal(&resp)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode NotifyResp: %w", err)
+	}
+	from := u.EndpointID
+	if from == "" {
+		from = record.GetToId()
+	}
+	out := usp.Record{
+		Version: record.GetVersion(),
+		ToId:    record.GetFromId(),
+		FromId:  from,
+		RecordType: &usp.Record_NoSessionContext{
+			NoSessionContext: &usp.NoSessionContextRecord{Payload: payload},
+		},
+	}
+	return proto.Marshal(&out)
+}
+
+// uspTypes are the supported data model types, see USP.
+var uspTypes = map[string]bool{
+	"int":          true,
+	"long":         true,
+	"unsignedInt":  true,
+	"unsignedLong": true,
+	"decimal":      true,
+	"boolean":      true,
+	"string":       true,
+}
+
+// params converts USP parameters of the object at path to data, see USP.
+func (u *USP) params(path string, p map[string]string) map[string]interface{} {
+	d := make(map[string]interface{}, len(p))
+	for k, v := range p {
+		d[k] = uspValue(u.paramType(path, k), v)
+	}
+	return d
+}
+
+// paramType finds the configured type of the parameter name of the
+// object at path, or "" if there is none.
+func (u *USP) paramType(path string, name string) string {
+	if len(u.Types) == 0 {
+		return ""
+	}
+	full := path + name
+	if t, ok := u.Types[full]; ok {
+		return t
+	}
+	elems := strings.Split(full, ".")
+	for i, e := range elems {
+		if _, err := strconv.ParseUint(e, 10, 32); err == nil {
+			elems[i] = "{i}"
+		}
+	}
+	if t, ok := u.Types[strings.Join(elems, ".")]; ok {
+		return t
+	}
+	return u.Types[name]
+}
+
+// uspValue converts v to the data model type typ. Values that aren't
+// valid for the type are kept as strings.
+func uspValue(typ string, v string) interface{} {
+	switch typ {
+	case "int", "long":
+		if i, err := strconv.ParseInt(v, 10, 64); err == nil {
+			return i
+		}
+	case "unsignedInt", "unsignedLong":
+		if i, err := strconv.ParseUint(v, 10, 64); err == nil {
+			return i
+		}
+	case "decimal":
+		if f, err := strconv.ParseFloat(v, 64); err == nil && !math.IsNaN(f) && !math.IsInf(f, 0) {
+			return f
+		}
+	case "boolean":
+		switch v {
+		case "true", "1":
+			return true
+		case "false", "0":
+			return false
+		}
+	}
+	return v
+}
+
+// Verify checks that at least one MTP is configured, and that the types
+// are known
+func (u *USP) Verify() error {
+	if u.Handler.Name == "" {
+		return skogul.MissingArgument("Handler")
+	}
+	if u.Broker == "" && u.Address == "" {
+		return skogul.MissingArgument("Broker or Address")
+	}
+	if u.Broker != "" && len(u.Topics) == 0 {
+		return skogul.MissingArgument("Topics")
+	}
+	if u.Certfile != "" && u.Keyfile == "" {
+		return skogul.MissingArgument("Keyfile")
+	}
+	for k, t := range u.Types {
+		if !uspTypes[t] {
+			return fmt.Errorf("unknown type %s for %s", t, k)
+		}
+	}
+	return nil
+}
+
+// GetStats exposes stats about the USP receiver.
+func (u *USP) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "receiver"
+	metric.Metadata["type"] = "usp"
+	metric.Metadata["identity"] = skogul.Identity[u]
+	metric.Data["records"] = atomic.LoadUint64(&u.stats.Records)
+	metric.Data["ignored"] = atomic.LoadUint64(&u.stats.Ignored)
+	metric.Data["decode_errors"] = atomic.LoadUint64(&u.stats.DecodeErrors)
+	metric.Data["notifies"] = atomic.LoadUint64(&u.stats.Notifies)
+	metric.Data["get_responses"] = atomic.LoadUint64(&u.stats.GetResponses)
+	metric.Data["responses"] = atomic.LoadUint64(&u.stats.Responses)
+	metric.Data["send_errors"] = atomic.LoadUint64(&u.stats.SendErrors)
+	metric.Data["handler_errors"] = atomic.LoadUint64(&u.stats.HandlerErrors)
+	return &metric
+}
@@ -0,0 +1,250 @@
+/*
+ * skogul, test USP controller receiver
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package receiver_test
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gogo/protobuf/proto"
+	"github.com/gorilla/websocket"
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/gen/usp"
+	"github.com/telenornms/skogul/parser"
+	"github.com/telenornms/skogul/receiver"
+)
+
+// uspRecord wraps a message in a record from an agent
+func uspRecord(t *testing.T, msg *usp.Msg) []byte {
+	t.Helper()
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("unable to marshal message: %v", err)
+	}
+	b, err := proto.Marshal(&usp.Record{
+		Version: "1.1",
+		ToId:    "self::skogul",
+		FromId:  "os::012345-CPE-1",
+		RecordType: &usp.Record_NoSessionContext{
+			NoSessionContext: &usp.NoSessionContextRecord{Payload: payload},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unable to marshal record: %v", err)
+	}
+	return b
+}
+
+func TestUSP(t *testing.T) {
+	cs := newChanSender()
+	h := skogul.Handler{Sender: cs}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.USP{
+		EndpointID: "self::skogul",
+		Address:    "127.0.0.1:12056",
+		Types: map[string]string{
+			"Device.WiFi.Radio.{i}.Stats.BytesSent": "unsignedLong",
+			"Enable":                                "boolean",
+		},
+		Handler: skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	if err := rcv.Verify(); err != nil {
+		t.Fatalf("Verify failed: %v", err)
+	}
+	go rcv.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"v1.usp"}}
+	conn, _, err := dialer.Dial("ws://127.0.0.1:12056/usp", nil)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	defer conn.Close()
+
+	notify := &usp.Msg{
+		Header: &usp.Header{MsgId: "42", MsgType: usp.Header_NOTIFY},
+		Body: &usp.Body{MsgBody: &usp.Body_Request{Request: &usp.Request{ReqType: &usp.Request_Notify{Notify: &usp.Notify{
+			SubscriptionId: "sub-1",
+			SendResp:       true,
+			Notification: &usp.Notify_ValueChange_{ValueChange: &usp.Notify_ValueChange{
+				ParamPath:  "Device.WiFi.Radio.1.Stats.BytesSent",
+				ParamValue: "12345",
+			}},
+		}}}}},
+	}
+	conn.WriteMessage(websocket.BinaryMessage, uspRecord(t, notify))
+	c := cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 1 {
+		t.Fatalf("expected 1 metric from Notify, got %v", c)
+	}
+	m := c.Metrics[0]
+	if m.Metadata["endpoint_id"] != "os::012345-CPE-1" || m.Metadata["path"] != "Device.WiFi.Radio.1.Stats." || m.Metadata["subscription_id"] != "sub-1" {
+		t.Errorf("unexpected Notify metadata: %v", m.Metadata)
+	}
+	if m.Data["BytesSent"] != uint64(12345) {
+		t.Errorf("unexpected Notify data: %v", m.Data)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	_, b, err := conn.ReadMessage()
+	if err != nil {
+		t.Fatalf("no NotifyResp received: %v", err)
+	}
+	record := usp.Record{}
+	if err := proto.Unmarshal(b, &record); err != nil {
+		t.Fatalf("invalid response record: %v", err)
+	}
+	if record.GetToId() != "os::012345-CPE-1" || record.GetFromId() != "self::skogul" {
+		t.Errorf("unexpected response record addressing: to %s from %s", record.GetToId(), record.GetFromId())
+	}
+	resp := usp.Msg{}
+	if err := proto.Unmarshal(record.GetNoSessionContext().GetPayload(), &resp); err != nil {
+		t.Fatalf("invalid response message: %v", err)
+	}
+	if resp.GetHeader().GetMsgId() != "42" || resp.GetHeader().GetMsgType() != usp.Header_NOTIFY_RESP || resp.GetBody().GetResponse().GetNotifyResp().GetSubscriptionId() != "sub-1" {
+		t.Errorf("unexpected NotifyResp: %v", resp)
+	}
+
+	getResp := &usp.Msg{
+		Header: &usp.Header{MsgId: "43", MsgType: usp.Header_GET_RESP},
+		Body: &usp.Body{MsgBody: &usp.Body_Response{Response: &usp.Response{RespType: &usp.Response_GetResp{GetResp: &usp.GetResp{
+			ReqPathResults: []*usp.GetResp_RequestedPathResult{{
+				RequestedPath: "Device.Ethernet.Interface.",
+				ResolvedPathResults: []*usp.GetResp_ResolvedPathResult{
+					{ResolvedPath: "Device.Ethernet.Interface.1.", ResultParams: map[string]string{"Status": "Up", "MaxBitRate": "1000", "Alias": "007"}},
+					{ResolvedPath: "Device.Ethernet.Interface.2.", ResultParams: map[string]string{"Status": "Down", "Enable": "false"}},
+				},
+			}},
+		}}}}},
+	}
+	conn.WriteMessage(websocket.BinaryMessage, uspRecord(t, getResp))
+	c = cs.wait(time.Second)
+	if c == nil || len(c.Metrics) != 2 {
+		t.Fatalf("expected 2 metrics from GetResp, got %v", c)
+	}
+	if m := c.Metrics[0]; m.Data["MaxBitRate"] != "1000" || m.Data["Alias"] != "007" {
+		t.Errorf("expected untyped values to be kept as strings: %v", m.Data)
+	}
+	m = c.Metrics[1]
+	if m.Metadata["path"] != "Device.Ethernet.Interface.2." || m.Metadata["requested_path"] != "Device.Ethernet.Interface." {
+		t.Errorf("unexpected GetResp metadata: %v", m.Metadata)
+	}
+	if m.Data["Status"] != "Down" || m.Data["Enable"] != false {
+		t.Errorf("unexpected GetResp data: %v", m.Data)
+	}
+}
+
+// TestUSP_handlerError checks that a Notify isn't acknowledged if the
+// handler fails, so the agent sends it again.
+func TestUSP_handlerError(t *testing.T) {
+	fs := failSender{newChanSender()}
+	h := skogul.Handler{Sender: fs}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.USP{
+		EndpointID: "self::skogul",
+		Address:    "127.0.0.1:12064",
+		Handler:    skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	go rcv.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"v1.usp"}}
+	conn, _, err := dialer.Dial("ws://127.0.0.1:12064/usp", nil)
+	if err != nil {
+		t.Fatalf("unable to connect: %v", err)
+	}
+	defer conn.Close()
+	notify := &usp.Msg{
+		Header: &usp.Header{MsgId: "42", MsgType: usp.Header_NOTIFY},
+		Body: &usp.Body{MsgBody: &usp.Body_Request{Request: &usp.Request{ReqType: &usp.Request_Notify{Notify: &usp.Notify{
+			SubscriptionId: "sub-1",
+			SendResp:       true,
+			Notification: &usp.Notify_ValueChange_{ValueChange: &usp.Notify_ValueChange{
+				ParamPath:  "Device.WiFi.Radio.1.Stats.BytesSent",
+				ParamValue: "12345",
+			}},
+		}}}}},
+	}
+	conn.WriteMessage(websocket.BinaryMessage, uspRecord(t, notify))
+	if c := fs.wait(time.Second); c == nil {
+		t.Fatalf("Notify not passed to the handler")
+	}
+	conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, _, err := conn.ReadMessage(); err == nil {
+		t.Errorf("NotifyResp sent even though the handler failed")
+	}
+	stats := rcv.GetStats()
+	if stats.Data["handler_errors"] != uint64(1) || stats.Data["responses"] != uint64(0) {
+		t.Errorf("unexpected stats %v", stats.Data)
+	}
+}
+
+func TestUSP_verify(t *testing.T) {
+	h := skogul.HandlerRef{Name: "h"}
+	bad := []*receiver.USP{
+		{Handler: h},
+		{Broker: "tcp://localhost:1883", Handler: h},
+		{Address: ":8080"},
+		{Address: ":8080", Certfile: "cert.pem", Handler: h},
+		{Address: ":8080", Handler: h, Types: map[string]string{"Enable": "bool"}},
+	}
+	for i, r := range bad {
+		if err := r.Verify(); err == nil {
+			t.Errorf("Verify() of bad config %d succeeded", i)
+		}
+	}
+}
+
+func TestUSP_origin(t *testing.T) {
+	h := skogul.Handler{Sender: newChanSender()}
+	h.SetParser(parser.SkogulJSON{})
+	rcv := receiver.USP{
+		Address: "127.0.0.1:12061",
+		Origins: []string{"https://controller.example.com"},
+		Handler: skogul.HandlerRef{H: &h, Name: "h"},
+	}
+	go rcv.Start()
+	time.Sleep(50 * time.Millisecond)
+
+	dialer := websocket.Dialer{Subprotocols: []string{"v1.usp"}}
+	for origin, ok := range map[string]bool{
+		"":                               true,
+		"https://controller.example.com": true,
+		"https://evil.example.com":       false,
+		"http://127.0.0.1:12061":         false,
+	} {
+		header := http.Header{}
+		if origin != "" {
+			header.Set("Origin", origin)
+		}
+		conn, _, err := dialer.Dial("ws://127.0.0.1:12061/usp", header)
+		if (err == nil) != ok {
+			t.Errorf("connecting with Origin %q: expected success %v, got error %v", origin, ok, err)
+		}
+		if conn != nil {
+			conn.Close()
+		}
+	}
+}
@@ -88,7 +88,7 @@ func init() {
 	Auto.Add(skogul.Module{
 		Name:  "file",
 		Alloc: func() interface{} { return &File{} },
-		Help:  "Writes metrics to a file.",
+		Help:  "Writes metrics to a file, optionally with the path templated from metadata and metric time, size- and time-based rotation with compression and retention, and buffered writes flushed on an interval.",
 	})
 	Auto.Add(skogul.Module{
 		Name:  "forwardfail",
@@ -121,12 +121,12 @@ func init() {
 	Auto.Add(skogul.Module{
 		Name:  "mqtt",
 		Alloc: func() interface{} { return &MQTT{} },
-		Help:  "Publishes received metrics to an MQTT broker/topic.",
+		Help:  "Publishes received metrics to an MQTT broker/topic, supporting MQTT 3.1.1 and 5. The topic can be built from metadata.",
 	})
 	Auto.Add(skogul.Module{
 		Name:  "nats",
 		Alloc: func() interface{} { return &Nats{} },
-		Help:  "Publishes received metrics to a NATS server/cluster.",
+		Help:  "Publishes received metrics to a NATS server/cluster, optionally to JetStream with acks.",
 	})
 	Auto.Add(skogul.Module{
 		Name:  "sql",
@@ -150,6 +150,18 @@ func init() {
 		Alloc:   func() interface{} { return &Splunk{HTTP: &HTTP{}} },
 		Help:    "A sender to Splunk HEC",
 	})
+	Auto.Add(skogul.Module{
+		Name:    "elasticsearch",
+		Aliases: []string{"opensearch", "es"},
+		Alloc:   func() interface{} { return &Elasticsearch{HTTP: &HTTP{}} },
+		Help:    "Index metrics as documents in Elasticsearch or OpenSearch using the _bulk API, with the index name built from metadata and time. Documents rejected with temporary errors are retried individually.",
+	})
+	Auto.Add(skogul.Module{
+		Name:    "otlp",
+		Aliases: []string{"opentelemetry"},
+		Alloc:   func() interface{} { return &OTLP{HTTP: &HTTP{}} },
+		Help:    "Send metrics to an OpenTelemetry collector over OTLP/gRPC or OTLP/HTTP, as gauges named after the data fields with metadata as attributes.",
+	})
 	Auto.Add(skogul.Module{
 		Name:  "net",
 		Alloc: func() interface{} { return &Net{} },
@@ -180,7 +192,7 @@ func init() {
 	Auto.Add(skogul.Module{
 		Name:  "rabbitmq",
 		Alloc: func() interface{} { return &Rabbitmq{} },
-		Help:  "Rabbitmq sender",
+		Help:  "Publish metrics to a RabbitMQ queue or exchange, with the routing key optionally built from metadata.",
 	})
 	Auto.Add(skogul.Module{
 		Name:  "snmp",
@@ -0,0 +1,364 @@
+/*
+ * skogul, elasticsearch/opensearch bulk sender
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+var esLog = skogul.Logger("sender", "elasticsearch")
+
+/*
+Elasticsearch indexes metrics as documents using the _bulk API of
+Elasticsearch or OpenSearch.
+
+The index name is a template, where text inside {{ }} is a Go template
+executed with the metric, e.g. {{.Metadata.site}}, while the rest is a Go
+time layout formatted with the time of the metric, in UTC. E.g.:
+net-{{.Metadata.site}}-2006.01.02 gives net-oslo-2024.05.17.
+
+Documents rejected by the server because it is overloaded (429) or has a
+temporary failure (5xx) are retried on their own, the rest of the bulk
+request is not sent again. If the bulk request as a whole fails with 429
+or 5xx, e.g. from a proxy or a cluster without a master, all documents
+are retried. Documents rejected for other reasons, e.g. mapping errors,
+are dropped and reported as an error.
+*/
+type Elasticsearch struct {
+	URL            string          `doc:"Base URL of the cluster. /_bulk is appended." example:"https://localhost:9200"`
+	Index          string          `doc:"Index to write to. Text within {{ }} is a template with the metric as context, the rest is a Go time layout for the metric time." example:"net-{{.Metadata.site}}-2006.01.02"`
+	Action         string          `doc:"Bulk action to use, index or create. Data streams require create. Defaults to index."`
+	TimestampField string          `doc:"Document field for the metric time. Defaults to @timestamp."`
+	MetadataField  string          `doc:"Document field to put metadata in. Defaults to empty, which puts metadata at the top level of the document."`
+	DataField      string          `doc:"Document field to put data in. Defaults to empty, which puts data at the top level of the document, overwriting metadata with the same name."`
+	IDField        string          `doc:"Metadata field to use as document _id. Defaults to letting the server pick one."`
+	APIKey         skogul.Secret   `doc:"Base64-encoded API key, used for the Authorization header."`
+	Username       string          `doc:"Username for basic authentication."`
+	Password       skogul.Secret   `doc:"Password for basic authentication."`
+	MaxRetries     int             `doc:"Maximum number of times to retry documents rejected with a temporary error. Defaults to 3."`
+	RetryDelay     skogul.Duration `doc:"Delay before the first retry, doubled for each retry. Defaults to 1s."`
+	HTTP           *HTTP           `doc:"HTTP sender options. URL is overwritten from this config, the rest will be HTTP sender defaults unless overridden."`
+	index          *template.Template
+	ok             bool
+	once           sync.Once
+	stats          esStats
+}
+
+type esStats struct {
+	Received uint64 // Metrics received.
+	Sent     uint64 // Documents successfully indexed.
+	Retried  uint64 // Documents retried after a temporary error.
+	Rejected uint64 // Documents dropped, either after a permanent error or after the last retry.
+	Errors   uint64 // Failed bulk requests and metrics that could not be converted.
+}
+
+// esItem is a document ready for the bulk request.
+type esItem struct {
+	action []byte
+	doc    []byte
+}
+
+// esBulkResponse is the relevant part of the response to a bulk request.
+type esBulkResponse struct {
+	Errors bool                         `json:"errors"`
+	Items  []map[string]esBulkItemReply `json:"items"`
+}
+
+type esBulkItemReply struct {
+	Status int             `json:"status"`
+	Error  json.RawMessage `json:"error"`
+}
+
+// esTemplate converts an index name to a Go template, where the literal
+// text outside of actions is formatted as a time layout.
+func esTemplate(index string) (*template.Template, error) {
+	var b strings.Builder
+	for index != "" {
+		start := strings.Index(index, "{{")
+		if start < 0 {
+			start = len(index)
+		}
+		if start > 0 {
+			fmt.Fprintf(&b, "{{.Time.Format %s}}", strconv.Quote(index[:start]))
+		}
+		index = index[start:]
+		if index == "" {
+			break
+		}
+		end := strings.Index(index, "}}")
+		if end < 0 {
+			return nil, fmt.Errorf("unterminated action in index template")
+		}
+		b.WriteString(index[:end+2])
+		index = index[end+2:]
+	}
+	return template.New("index").Option("missingkey=error").Parse(b.String())
+}
+
+func (e *Elasticsearch) init() {
+	var err error
+	e.index, err = esTemplate(e.Index)
+	if err != nil {
+		esLog.WithError(err).Error("Invalid index template")
+		return
+	}
+	if e.Action == "" {
+		e.Action = "index"
+	}
+	if e.TimestampField == "" {
+		e.TimestampField = "@timestamp"
+	}
+	if e.MaxRetries == 0 {
+		e.MaxRetries = 3
+	}
+	if e.RetryDelay.Duration == 0 {
+		e.RetryDelay.Duration = time.Second
+	}
+	e.HTTP.URL = strings.TrimSuffix(e.URL, "/") + "/_bulk"
+	e.HTTP.once.Do(e.HTTP.init)
+	e.HTTP.Headers["Content-Type"] = "application/x-ndjson"
+	if e.APIKey.Expose() != "" {
+		e.HTTP.Headers["Authorization"] = "ApiKey " + e.APIKey.Expose()
+	} else if e.Username != "" {
+		auth := base64.StdEncoding.EncodeToString([]byte(e.Username + ":" + e.Password.Expose()))
+		e.HTTP.Headers["Authorization"] = "Basic " + auth
+	}
+	e.ok = e.HTTP.ok
+}
+
+// prepare converts a metric to a bulk item.
+func (e *Elasticsearch) prepare(c *skogul.Container, m *skogul.Metric) (esItem, error) {
+	t := skogul.Now()
+	if m.Time != nil {
+		t = *m.Time
+	} else if c.Template != nil && c.Template.Time != nil {
+		t = *c.Template.Time
+	}
+	t = t.UTC()
+
+	var index bytes.Buffer
+	err := e.index.Execute(&index, struct {
+		Metadata map[string]interface{}
+		Data     map[string]interface{}
+		Time     time.Time
+	}{m.Metadata, m.Data, t})
+	if err != nil {
+		return esItem{}, fmt.Errorf("unable to build index name: %w", err)
+	}
+
+	meta := map[string]interface{}{"_index": index.String()}
+	if e.IDField != "" {
+		if id, ok := m.Metadata[e.IDField]; ok {
+			meta["_id"] = fmt.Sprint(id)
+		}
+	}
+	action, err := json.Marshal(map[string]interface{}{e.Action: meta})
+	if err != nil {
+		return esItem{}, err
+	}
+
+	doc := make(map[string]interface{})
+	if e.MetadataField == "" {
+		for k, v := range m.Metadata {
+			doc[k] = v
+		}
+	} else if len(m.Metadata) > 0 {
+		doc[e.MetadataField] = m.Metadata
+	}
+	if e.DataField == "" {
+		for k, v := range m.Data {
+			doc[k] = v
+		}
+	} else {
+		doc[e.DataField] = m.Data
+	}
+	doc[e.TimestampField] = t.Format(time.RFC3339Nano)
+	b, err := json.Marshal(doc)
+	if err != nil {
+		return esItem{}, fmt.Errorf("unable to marshal document: %w", err)
+	}
+	return esItem{action: action, doc: b}, nil
+}
+
+// bulk sends the items and returns the ones rejected with a temporary
+// error, and the number of documents rejected for good.
+func (e *Elasticsearch) bulk(items []esItem) ([]esItem, int, error) {
+	var buffer bytes.Buffer
+	for _, item := range items {
+		buffer.Write(item.action)
+		buffer.WriteByte('\n')
+		buffer.Write(item.doc)
+		buffer.WriteByte('\n')
+	}
+	body, err := e.HTTP.sendBytesResponse(buffer.Bytes())
+	if err != nil {
+		return nil, 0, fmt.Errorf("bulk request failed: %w", err)
+	}
+	var resp esBulkResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, 0, fmt.Errorf("unable to parse bulk response: %w", err)
+	}
+	if !resp.Errors {
+		atomic.AddUint64(&e.stats.Sent, uint64(len(items)))
+		return nil, 0, nil
+	}
+	if len(resp.Items) != len(items) {
+		return nil, 0, fmt.Errorf("bulk response has %d items, expected %d", len(resp.Items), len(items))
+	}
+	var retry []esItem
+	rejected := 0
+	for i, reply := range resp.Items {
+		for _, r := range reply {
+			switch {
+			case r.Status >= 200 && r.Status <= 299:
+				atomic.AddUint64(&e.stats.Sent, 1)
+			case r.Status == 429 || r.Status >= 500:
+				retry = append(retry, items[i])
+			default:
+				rejected++
+				esLog.WithField("status", r.Status).WithField("error", string(r.Error)).Warn("Document rejected")
+			}
+		}
+	}
+	return retry, rejected, nil
+}
+
+// Send indexes the metrics of the container, retrying documents that are
+// rejected with temporary errors.
+func (e *Elasticsearch) Send(c *skogul.Container) error {
+	e.once.Do(func() {
+		e.init()
+	})
+	if !e.ok {
+		return fmt.Errorf("elasticsearch sender not in OK state")
+	}
+	atomic.AddUint64(&e.stats.Received, uint64(len(c.Metrics)))
+
+	items := make([]esItem, 0, len(c.Metrics))
+	for _, m := range c.Metrics {
+		item, err := e.prepare(c, m)
+		if err != nil {
+			atomic.AddUint64(&e.stats.Errors, 1)
+			esLog.WithError(err).Warn("Dropping metric")
+			continue
+		}
+		items = append(items, item)
+	}
+
+	total := len(items)
+	rejected := 0
+	delay := e.RetryDelay.Duration
+	for attempt := 0; len(items) > 0; attempt++ {
+		retry, r, err := e.bulk(items)
+		var status *httpStatusError
+		if err != nil && errors.As(err, &status) && status.temporary() && attempt < e.MaxRetries {
+			esLog.WithError(err).Debug("Retrying bulk request")
+			retry, r, err = items, 0, nil
+		}
+		if err != nil {
+			atomic.AddUint64(&e.stats.Errors, 1)
+			return err
+		}
+		rejected += r
+		if len(retry) > 0 && attempt >= e.MaxRetries {
+			rejected += len(retry)
+			break
+		}
+		if len(retry) > 0 {
+			atomic.AddUint64(&e.stats.Retried, uint64(len(retry)))
+			time.Sleep(delay)
+			delay *= 2
+		}
+		items = retry
+	}
+	if rejected > 0 {
+		atomic.AddUint64(&e.stats.Rejected, uint64(rejected))
+		return fmt.Errorf("%d of %d documents rejected", rejected, total)
+	}
+	return nil
+}
+
+// Verify checks that the configuration is sensible
+func (e *Elasticsearch) Verify() error {
+	if e.URL == "" {
+		return skogul.MissingArgument("URL")
+	}
+	if e.Index == "" {
+		return skogul.MissingArgument("Index")
+	}
+	if _, err := esTemplate(e.Index); err != nil {
+		return fmt.Errorf("invalid index template: %w", err)
+	}
+	if e.Action != "" && e.Action != "index" && e.Action != "create" {
+		return fmt.Errorf("invalid action %s, must be index or create", e.Action)
+	}
+	if e.APIKey.Expose() != "" && e.Username != "" {
+		return fmt.Errorf("use either APIKey or Username/Password, not both")
+	}
+	if e.MaxRetries < 0 {
+		return fmt.Errorf("MaxRetries can not be negative")
+	}
+	if e.HTTP == nil {
+		return skogul.MissingArgument("HTTP")
+	}
+	if err := e.HTTP.Verify(); err != nil {
+		// URL is set from our own config during init.
+		if !strings.Contains(err.Error(), "missing required configuration option `URL'") {
+			return fmt.Errorf("failed to verify HTTP sender for Elasticsearch: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the Elasticsearch
+// sender.
+func (e *Elasticsearch) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "sender"
+	metric.Metadata["type"] = "elasticsearch"
+	metric.Metadata["identity"] = skogul.Identity[e]
+	metric.Data["received"] = atomic.LoadUint64(&e.stats.Received)
+	metric.Data["sent"] = atomic.LoadUint64(&e.stats.Sent)
+	metric.Data["retried"] = atomic.LoadUint64(&e.stats.Retried)
+	metric.Data["rejected"] = atomic.LoadUint64(&e.stats.Rejected)
+	metric.Data["errors"] = atomic.LoadUint64(&e.stats.Errors)
+	return &metric
+}
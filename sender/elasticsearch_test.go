@@ -0,0 +1,234 @@
+/*
+ * skogul, elasticsearch sender tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+)
+
+// esServer is a fake _bulk endpoint. Documents with "reject" set are
+// rejected with a mapping error, and documents with "busy" set are
+// rejected with 429 the first time they are seen. The first unavailable
+// requests are rejected as a whole with 503.
+type esServer struct {
+	lock        sync.Mutex
+	requests    int
+	unavailable int
+	indexed     []map[string]interface{}
+	indices     []string
+	seen        map[string]bool
+	auth        string
+}
+
+func (s *esServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.requests++
+	s.auth = r.Header.Get("Authorization")
+	if r.URL.Path != "/_bulk" {
+		w.WriteHeader(404)
+		return
+	}
+	if s.requests <= s.unavailable {
+		w.WriteHeader(503)
+		return
+	}
+	scanner := bufio.NewScanner(r.Body)
+	items := []string{}
+	hasErrors := false
+	for scanner.Scan() {
+		var action map[string]map[string]interface{}
+		json.Unmarshal(scanner.Bytes(), &action)
+		scanner.Scan()
+		var doc map[string]interface{}
+		json.Unmarshal(scanner.Bytes(), &doc)
+		id := fmt.Sprint(doc["id"])
+		switch {
+		case doc["reject"] == true:
+			hasErrors = true
+			items = append(items, `{"index":{"status":400,"error":{"type":"mapper_parsing_exception"}}}`)
+		case doc["busy"] == true && !s.seen[id]:
+			s.seen[id] = true
+			hasErrors = true
+			items = append(items, `{"index":{"status":429,"error":{"type":"es_rejected_execution_exception"}}}`)
+		default:
+			s.indexed = append(s.indexed, doc)
+			s.indices = append(s.indices, action["index"]["_index"].(string))
+			items = append(items, `{"index":{"status":201}}`)
+		}
+	}
+	fmt.Fprintf(w, `{"took":1,"errors":%v,"items":[%s]}`, hasErrors, strings.Join(items, ","))
+}
+
+func esMetric(id string, data map[string]interface{}) *skogul.Metric {
+	t := time.Date(2024, 5, 17, 12, 0, 0, 0, time.UTC)
+	data["id"] = id
+	return &skogul.Metric{
+		Time:     &t,
+		Metadata: map[string]interface{}{"site": "oslo"},
+		Data:     data,
+	}
+}
+
+func TestElasticsearch(t *testing.T) {
+	srv := &esServer{seen: make(map[string]bool)}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	es := Elasticsearch{
+		URL:        ts.URL,
+		Index:      "net-{{.Metadata.site}}-2006.01.02",
+		APIKey:     "secret",
+		RetryDelay: skogul.Duration{Duration: time.Millisecond},
+		HTTP:       &HTTP{},
+	}
+	if err := es.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	c := skogul.Container{Metrics: []*skogul.Metric{
+		esMetric("a", map[string]interface{}{"value": 1}),
+		esMetric("b", map[string]interface{}{"busy": true}),
+		esMetric("c", map[string]interface{}{"value": 3}),
+	}}
+	if err := es.Send(&c); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if srv.requests != 2 {
+		t.Errorf("expected 2 requests, got %d", srv.requests)
+	}
+	if len(srv.indexed) != 3 {
+		t.Fatalf("expected 3 documents indexed, got %d", len(srv.indexed))
+	}
+	if srv.indexed[2]["id"] != "b" {
+		t.Errorf("expected only b to be retried, got %v", srv.indexed[2])
+	}
+	if srv.indices[0] != "net-oslo-2024.05.17" {
+		t.Errorf("unexpected index %s", srv.indices[0])
+	}
+	if srv.indexed[0]["@timestamp"] != "2024-05-17T12:00:00Z" || srv.indexed[0]["site"] != "oslo" {
+		t.Errorf("unexpected document %v", srv.indexed[0])
+	}
+	if srv.auth != "ApiKey secret" {
+		t.Errorf("unexpected Authorization header %s", srv.auth)
+	}
+
+	c = skogul.Container{Metrics: []*skogul.Metric{
+		esMetric("d", map[string]interface{}{"reject": true}),
+		esMetric("e", map[string]interface{}{"value": 5}),
+	}}
+	if err := es.Send(&c); err == nil {
+		t.Errorf("Send() with rejected document did not fail")
+	}
+	if len(srv.indexed) != 4 {
+		t.Errorf("expected 4 documents indexed, got %d", len(srv.indexed))
+	}
+	stats := es.GetStats()
+	if stats.Data["retried"] != uint64(1) || stats.Data["rejected"] != uint64(1) || stats.Data["sent"] != uint64(4) {
+		t.Errorf("unexpected stats %v", stats.Data)
+	}
+}
+
+func TestElasticsearchUnavailable(t *testing.T) {
+	srv := &esServer{seen: make(map[string]bool), unavailable: 2}
+	ts := httptest.NewServer(srv)
+	defer ts.Close()
+
+	es := Elasticsearch{
+		URL:        ts.URL,
+		Index:      "net-2006.01.02",
+		RetryDelay: skogul.Duration{Duration: time.Millisecond},
+		HTTP:       &HTTP{},
+	}
+	if err := es.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	c := skogul.Container{Metrics: []*skogul.Metric{
+		esMetric("a", map[string]interface{}{"value": 1}),
+		esMetric("b", map[string]interface{}{"value": 2}),
+	}}
+	if err := es.Send(&c); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if srv.requests != 3 || len(srv.indexed) != 2 {
+		t.Errorf("expected 2 documents indexed after 3 requests, got %d after %d", len(srv.indexed), srv.requests)
+	}
+	if stats := es.GetStats(); stats.Data["retried"] != uint64(4) || stats.Data["sent"] != uint64(2) {
+		t.Errorf("unexpected stats %v", stats.Data)
+	}
+
+	srv.requests = 0
+	srv.unavailable = 10
+	if err := es.Send(&c); err == nil {
+		t.Errorf("Send() to an unavailable server did not fail")
+	}
+	if srv.requests != 4 {
+		t.Errorf("expected 4 requests, got %d", srv.requests)
+	}
+
+	es = Elasticsearch{URL: ts.URL, Index: "net"}
+	if err := es.Verify(); err == nil {
+		t.Errorf("Verify() without HTTP did not fail")
+	}
+}
+
+func TestElasticsearchLayout(t *testing.T) {
+	es := Elasticsearch{
+		Index:          "{{.Metadata.site}}",
+		TimestampField: "time",
+		MetadataField:  "meta",
+		DataField:      "data",
+		IDField:        "site",
+	}
+	var err error
+	es.index, err = esTemplate(es.Index)
+	if err != nil {
+		t.Fatalf("esTemplate() failed: %v", err)
+	}
+	es.Action = "create"
+	item, err := es.prepare(&skogul.Container{}, esMetric("a", map[string]interface{}{}))
+	if err != nil {
+		t.Fatalf("prepare() failed: %v", err)
+	}
+	if string(item.action) != `{"create":{"_id":"oslo","_index":"oslo"}}` {
+		t.Errorf("unexpected action %s", item.action)
+	}
+	if string(item.doc) != `{"data":{"id":"a"},"meta":{"site":"oslo"},"time":"2024-05-17T12:00:00Z"}` {
+		t.Errorf("unexpected document %s", item.doc)
+	}
+	m := esMetric("b", map[string]interface{}{})
+	delete(m.Metadata, "site")
+	if _, err := es.prepare(&skogul.Container{}, m); err == nil {
+		t.Errorf("prepare() with missing metadata did not fail")
+	}
+}
@@ -24,20 +24,37 @@
 package sender
 
 import (
+	"bufio"
+	"compress/gzip"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"syscall"
+	"text/template"
+	"text/template/parse"
+	"time"
 
+	"github.com/klauspost/compress/zstd"
 	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/encoder"
 )
 
 var fileLog = skogul.Logger("sender", "file")
 
+var errFileClosed = errors.New("file sender is closed")
+
 const newLineChar byte = 10
 
+// rotateLayout is the time stamp appended to rotated files.
+const rotateLayout = "20060102T150405.000"
+
 /*
 File sender writes data to a file in various different fashions. Typical
 use will be debugging (write to disk) and writing to a FIFO for example.
@@ -49,24 +66,106 @@ In case SIGHUP is received and the file doesn't exists the file will be created.
 
 When Append option is supplied, and this sender receives a SIGHUP data will
 be appended to file, if the file exists.
+
+If File contains {{ }} it is a template, executed for each metric with
+.Metadata, .Data and .Time (the metric time) to find the file to write
+it to. The literal text of the file name of a templated path is also a
+Go time layout, formatted with the metric time, so
+/data/{{.Metadata.site}}/2006-01-02.ndjson gives a file per site and day.
+Text that shouldn't be treated as a time layout can be quoted, as in
+/data/{{"metrics1"}}.ndjson, and directories can use .Time.Format, as in
+/data/{{.Time.Format "2006"}}/01-02.ndjson. Missing directories are
+created, and templated files are closed when idle. Since they are
+reopened as needed, templated files are always appended to if they
+exist, and Append only decides what happens on SIGHUP. Values from the
+metric can't add ".." to the path, or make it absolute if the template
+starts with {{, so metrics can't be written outside of the directory
+the template starts with.
+
+Encoders with a header, like csv, have it written at the start of every
+file that is empty when it is first written to.
+
+Files are rotated when they would grow beyond MaxSize or have been open
+for RotateInterval, by renaming them with a time stamp suffix, e.g.
+metrics.json.20240501T120000.000, optionally compressed. Only the
+Retention most recent rotated files of each path are kept.
 */
 type File struct {
-	Path    string            `doc:"Absolute path to file to write. DEPRECATED - replaced by option File (to keep options more consistent across modules)."`
-	File    string            `doc:"Absolute path to file to write to."`
-	Append  bool              `doc:"When sighup is received data will be appended to file. When this option is false, the file will be truncated before writing."`
-	Encoder skogul.EncoderRef `doc:"Which encoder to use. Defaults to JSON."`
-	ok      bool
-	once    sync.Once
+	Path           string            `doc:"Absolute path to file to write. DEPRECATED - replaced by option File (to keep options more consistent across modules)."`
+	File           string            `doc:"Absolute path to file to write to. Can be a template, see the module documentation."`
+	Append         bool              `doc:"When sighup is received data will be appended to file. When this option is false, the file will be truncated before writing."`
+	Encoder        skogul.EncoderRef `doc:"Which encoder to use. Defaults to JSON."`
+	MaxSize        int64             `doc:"Rotate a file before it grows beyond this many bytes. 0 disables size-based rotation."`
+	RotateInterval skogul.Duration   `doc:"Rotate a file when it has been open this long. 0 disables time-based rotation."`
+	Retention      int               `doc:"Number of rotated files to keep for each path, the oldest are removed. 0 keeps all."`
+	Compress       string            `doc:"Compress rotated files with gzip or zstd. Default is no compression."`
+	FlushInterval  skogul.Duration   `doc:"Buffer writes and flush them this often, and at shutdown. Default is to write and sync each container immediately."`
+	IdleTimeout    skogul.Duration   `doc:"Close templated files that haven't been written to for this long. Default: 5m"`
+	ok             bool
+	once           sync.Once
+	tmpl           *template.Template
+	prefix         string
+	header         skogul.HeaderEncoder
+	files          map[string]*openFile
+	c              chan fileWrite
+	closing        chan chan error
+	sighup         chan os.Signal
+	rotations      sync.WaitGroup
+	rotateLock     sync.Mutex
+	closeOnce      sync.Once
+	closeErr       error
+	sendLock       sync.RWMutex
+	closed         bool
+}
+
+// openFile is a file being written to.
+type openFile struct {
 	f       *os.File
-	c       chan []byte
-	sighup  chan os.Signal
+	w       *bufio.Writer
+	size    int64
+	opened  time.Time
+	written time.Time
 }
 
-func (f *File) init() {
+// fileWrite is encoded data for the file at path.
+type fileWrite struct {
+	path string
+	b    []byte
+}
 
-	var err error
-	var file *os.File
+// timeLayoutTemplate turns the literal text of the file name part of a
+// template into calls to .Time.Format, so it is formatted with the
+// metric time. Directories are left alone, since they are much more
+// likely to contain something that looks like a time layout by accident.
+func timeLayoutTemplate(path string) (*template.Template, error) {
+	t, err := template.New("path").Option("missingkey=error").Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	base := strings.LastIndex(path, "/") + 1
+	var src strings.Builder
+	for _, n := range t.Tree.Root.Nodes {
+		text, ok := n.(*parse.TextNode)
+		if !ok {
+			src.WriteString(n.String())
+			continue
+		}
+		lit := string(text.Text)
+		if split := base - int(text.Pos); split > 0 {
+			if split > len(lit) {
+				split = len(lit)
+			}
+			src.WriteString(lit[:split])
+			lit = lit[split:]
+		}
+		if lit != "" {
+			fmt.Fprintf(&src, "{{.Time.Format %s}}", strconv.Quote(lit))
+		}
+	}
+	return template.New("path").Option("missingkey=error").Parse(src.String())
+}
 
+func (f *File) init() {
 	// To be removed
 	if f.File == "" {
 		f.File = f.Path
@@ -84,86 +183,344 @@ func (f *File) init() {
 		f.ok = false
 		return
 	}
-
-	// Open file for append-only if it already exists and config says to append
-	if finfo, statErr := os.Stat(f.File); !os.IsNotExist(statErr) && f.Append {
-		fileLog.WithField("path", f.File).Trace("File exists, let's open it for writing")
-		file, err = os.OpenFile(f.File, os.O_APPEND|os.O_WRONLY, finfo.Mode())
-	} else {
-		// Otherwise, create the file (which will truncate it if it already exists)
-		fileLog.WithField("path", f.File).Trace("Creating file since it doesn't exist or we don't want to append to it")
-		file, err = os.Create(f.File)
+	if f.IdleTimeout.Duration == 0 {
+		f.IdleTimeout.Duration = 5 * time.Minute
+	}
+	if h, ok := f.Encoder.E.(skogul.HeaderEncoder); ok {
+		h.SeparateHeader()
+		f.header = h
 	}
 
-	if err != nil {
+	f.files = make(map[string]*openFile)
+	if strings.Contains(f.File, "{{") {
+		var err error
+		static := f.File[:strings.Index(f.File, "{{")]
+		f.prefix = static[:strings.LastIndex(static, "/")+1]
+		if f.tmpl, err = timeLayoutTemplate(f.File); err != nil {
+			fileLog.WithField("path", f.File).WithError(err).Errorf("Invalid path template")
+			f.ok = false
+			return
+		}
+	} else if _, err := f.open(f.File, !f.Append); err != nil {
 		fileLog.WithField("path", f.File).WithError(err).Errorf("Failed to open '%s'", f.File)
 		f.ok = false
 		return
 	}
 
-	// startChan() handles closing the file as this function returns
-	// and consequently would close the file
-	f.f = file
-
 	f.sighup = make(chan os.Signal, 1)
+	f.closing = make(chan chan error)
 
 	// Listening to a channel is blocking so we have
 	// to start the channel listening in a goroutine
 	// so that init() doesn't block.
-	f.c = make(chan []byte, 50)
+	f.c = make(chan fileWrite, 50)
 	go f.startChan()
 
 	f.ok = true
 }
 
+// open opens path for writing, truncating it if it exists and truncate
+// is set, and appending to it otherwise.
+func (f *File) open(path string, truncate bool) (*openFile, error) {
+	var file *os.File
+	var err error
+	var size int64
+
+	if err = os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, err
+	}
+	// Open file for append-only if it already exists and config says to append
+	if finfo, statErr := os.Stat(path); !os.IsNotExist(statErr) && !truncate {
+		fileLog.WithField("path", path).Trace("File exists, let's open it for writing")
+		file, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, finfo.Mode())
+		size = finfo.Size()
+	} else {
+		// Otherwise, create the file (which will truncate it if it already exists)
+		fileLog.WithField("path", path).Trace("Creating file since it doesn't exist or we don't want to append to it")
+		file, err = os.Create(path)
+	}
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	of := &openFile{f: file, w: bufio.NewWriter(file), size: size, opened: now, written: now}
+	f.files[path] = of
+	return of, nil
+}
+
+// closeFile flushes and closes the file at path.
+func (f *File) closeFile(path string) error {
+	of := f.files[path]
+	delete(f.files, path)
+	err := of.w.Flush()
+	if cerr := of.f.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+func (f *File) write(w fileWrite) error {
+	of, ok := f.files[w.path]
+	if ok && f.MaxSize > 0 && of.size > 0 && of.size+int64(len(w.b))+1 > f.MaxSize {
+		f.rotate(w.path)
+		ok = false
+	}
+	if !ok {
+		var err error
+		if of, err = f.open(w.path, false); err != nil {
+			return err
+		}
+	}
+	if of.size == 0 && f.header != nil {
+		if h := f.header.OutputHeader(); len(h) > 0 {
+			written, err := of.w.Write(append(h, newLineChar))
+			of.size += int64(written)
+			if err != nil {
+				return fmt.Errorf("failed to write header: %w", err)
+			}
+		}
+	}
+	written, err := of.w.Write(append(w.b, newLineChar))
+	of.size += int64(written)
+	of.written = time.Now()
+	if err != nil {
+		return fmt.Errorf("wrote %d of %d bytes: %w", written, len(w.b)+1, err)
+	}
+	if f.FlushInterval.Duration == 0 {
+		if err := of.w.Flush(); err != nil {
+			return err
+		}
+		of.f.Sync()
+	}
+	return nil
+}
+
+// rotate closes the file at path and renames it. Compression and
+// removal of old files is done in the background.
+func (f *File) rotate(path string) {
+	if err := f.closeFile(path); err != nil {
+		fileLog.WithField("path", path).WithError(err).Error("Failed to close file before rotating it")
+	}
+	rotated := rotatedName(path, time.Now())
+	if err := os.Rename(path, rotated); err != nil {
+		fileLog.WithField("path", path).WithError(err).Error("Failed to rotate file")
+		return
+	}
+	fileLog.WithField("path", rotated).Debug("Rotated file")
+	f.rotations.Add(1)
+	go func() {
+		defer f.rotations.Done()
+		f.rotateLock.Lock()
+		defer f.rotateLock.Unlock()
+		if err := compressFile(rotated, f.Compress); err != nil {
+			fileLog.WithField("path", rotated).WithError(err).Error("Failed to compress rotated file")
+		}
+		if err := expireRotated(path, f.Retention); err != nil {
+			fileLog.WithField("path", path).WithError(err).Error("Failed to remove old rotated files")
+		}
+	}()
+}
+
+// rotatedName returns the name path is rotated to at time t, moving t
+// forward if files are rotated more than once per millisecond.
+func rotatedName(path string, t time.Time) string {
+	for {
+		name := path + "." + t.Format(rotateLayout)
+		taken := false
+		for _, ext := range []string{"", ".gz", ".zst"} {
+			if _, err := os.Stat(name + ext); err == nil {
+				taken = true
+			}
+		}
+		if !taken {
+			return name
+		}
+		t = t.Add(time.Millisecond)
+	}
+}
+
+// compressFile compresses path with method, replacing it with a file
+// with the .gz or .zst extension. Files rotated in quick succession may
+// be removed by expireRotated before they are compressed, which is not
+// an error.
+func compressFile(path string, method string) (err error) {
+	var ext string
+	var newWriter func(io.Writer) (io.WriteCloser, error)
+	switch method {
+	case "":
+		return nil
+	case "gzip":
+		ext = ".gz"
+		newWriter = func(w io.Writer) (io.WriteCloser, error) { return gzip.NewWriter(w), nil }
+	case "zstd":
+		ext = ".zst"
+		newWriter = func(w io.Writer) (io.WriteCloser, error) { return zstd.NewWriter(w) }
+	default:
+		return fmt.Errorf("unknown compression %q", method)
+	}
+	in, err := os.Open(path)
+	if os.IsNotExist(err) {
+		// Already removed by expireRotated
+		return nil
+	} else if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.Create(path + ext)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			os.Remove(path + ext)
+		} else {
+			os.Remove(path)
+		}
+	}()
+	w, err := newWriter(out)
+	if err != nil {
+		return err
+	}
+	if _, err = io.Copy(w, in); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// expireRotated removes all but the keep most recent rotated versions of
+// path.
+func expireRotated(path string, keep int) error {
+	if keep <= 0 {
+		return nil
+	}
+	dir, base := filepath.Split(path)
+	entries, err := os.ReadDir(filepath.Clean(dir))
+	if err != nil {
+		return err
+	}
+	rotated := make([]string, 0)
+	for _, e := range entries {
+		name := e.Name()
+		if !strings.HasPrefix(name, base+".") {
+			continue
+		}
+		stamp := strings.TrimPrefix(name, base+".")
+		stamp = strings.TrimSuffix(strings.TrimSuffix(stamp, ".gz"), ".zst")
+		if _, err := time.Parse(rotateLayout, stamp); err != nil {
+			continue
+		}
+		rotated = append(rotated, name)
+	}
+	sort.Strings(rotated)
+	for len(rotated) > keep {
+		if err := os.Remove(filepath.Join(dir, rotated[0])); err != nil {
+			return err
+		}
+		rotated = rotated[1:]
+	}
+	return nil
+}
+
+// tick flushes buffered writes, rotates files that have been open for
+// RotateInterval and closes idle templated files.
+func (f *File) tick() {
+	now := time.Now()
+	for path, of := range f.files {
+		if f.RotateInterval.Duration > 0 && now.Sub(of.opened) >= f.RotateInterval.Duration {
+			if of.size > 0 {
+				f.rotate(path)
+				continue
+			}
+			of.opened = now
+		}
+		if f.tmpl != nil && now.Sub(of.written) >= f.IdleTimeout.Duration {
+			if err := f.closeFile(path); err != nil {
+				fileLog.WithField("path", path).WithError(err).Error("Failed to close idle file")
+			}
+			continue
+		}
+		if err := of.w.Flush(); err != nil {
+			f.ok = false
+			fileLog.WithField("path", path).WithError(err).Error("Failed to flush file")
+		}
+	}
+}
+
 func (f *File) startChan() {
 	fileLog.Trace("Starting file writer routine")
-	defer f.f.Close()
+
+	interval := f.FlushInterval.Duration
+	if interval == 0 || interval > time.Second {
+		interval = time.Second
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
 
 	signal.Notify(f.sighup, syscall.SIGHUP)
+	defer signal.Stop(f.sighup)
 	for {
 		select {
-		case b := <-f.c:
-			written, err := f.f.Write(append(b, newLineChar))
-			if err != nil {
+		case w := <-f.c:
+			if err := f.write(w); err != nil {
 				f.ok = false
-				fileLog.WithField("path", f.File).WithError(err).Errorf("Failed to write to file. Wrote %d of %d bytes", written, len(b))
+				fileLog.WithField("path", w.path).WithError(err).Errorf("Failed to write to file")
 			}
-			f.f.Sync()
+		case <-ticker.C:
+			f.tick()
 		case <-f.sighup: // Hung up
-			var file *os.File
-			var err error
-
-			if f.Append {
-				if finfo, statErr := os.Stat(f.File); !os.IsNotExist(statErr) && f.Append {
-					fileLog.WithField("path", f.File).Trace("File exists, let's open it for writing")
-					file, err = os.OpenFile(f.File, os.O_APPEND|os.O_WRONLY, finfo.Mode())
+			// Reopen everything, which truncates the files unless
+			// Append is set.
+			f.ok = true
+			paths := make([]string, 0, len(f.files)+1)
+			for path := range f.files {
+				paths = append(paths, path)
+			}
+			if f.tmpl == nil && len(paths) == 0 {
+				paths = append(paths, f.File)
+			}
+			for _, path := range paths {
+				if _, ok := f.files[path]; ok {
+					if err := f.closeFile(path); err != nil {
+						fileLog.WithField("path", path).WithError(err).Error("Error closing file")
+					}
+				}
+				if _, err := f.open(path, !f.Append); err != nil {
+					f.ok = false
+					fileLog.WithField("path", path).WithError(err).Errorf("Error with file")
 				}
-			} else {
-				file, err = os.Create(f.File)
 			}
-
-			if err != nil {
-				f.ok = false
-				// Prevent handle leak
-				f.f.Close()
-				file.Close()
-				fileLog.WithField("path", file).WithError(err).Errorf("Error with file")
-				continue
+		case reply := <-f.closing:
+			// Drain what has already been sent before closing.
+			for len(f.c) > 0 {
+				if err := f.write(<-f.c); err != nil {
+					fileLog.WithError(err).Errorf("Failed to write to file")
+				}
 			}
-			// Prevent handle leak
-			// f.f is poiting to the previous file.
-			f.f.Close()
-
-			// Override file handler
-			f.f = file
-			f.ok = true
+			var err error
+			for path := range f.files {
+				if cerr := f.closeFile(path); cerr != nil {
+					err = cerr
+				}
+			}
+			reply <- err
+			return
 		}
 	}
 }
 
 // Send receives a skogul container and writes it to file.
 func (f *File) Send(c *skogul.Container) error {
+	// Holding the read lock while sending to f.c keeps Close from
+	// stopping the writer while a send is blocked.
+	f.sendLock.RLock()
+	defer f.sendLock.RUnlock()
+	if f.closed {
+		return errFileClosed
+	}
 	f.once.Do(func() {
 		f.init()
 	})
@@ -172,17 +529,100 @@ func (f *File) Send(c *skogul.Container) error {
 		return fmt.Errorf("failed to initialize file sender, or an error occurred in runtime")
 	}
 
-	b, err := f.Encoder.E.Encode(c)
+	if f.tmpl == nil {
+		b, err := f.Encoder.E.Encode(c)
 
-	if err != nil {
-		return fmt.Errorf("file sender unable to encode: %w", err)
-	}
+		if err != nil {
+			return fmt.Errorf("file sender unable to encode: %w", err)
+		}
 
-	f.c <- b
+		f.c <- fileWrite{f.File, b}
+		return nil
+	}
 
+	// Split the container by file, keeping the order of both files
+	// and metrics.
+	paths := make([]string, 0, 1)
+	split := make(map[string]*skogul.Container)
+	var terr error
+	failed := 0
+	for _, m := range c.Metrics {
+		path, err := f.path(m)
+		if err != nil {
+			failed++
+			terr = err
+			continue
+		}
+		sub, ok := split[path]
+		if !ok {
+			sub = &skogul.Container{Template: c.Template}
+			split[path] = sub
+			paths = append(paths, path)
+		}
+		sub.Metrics = append(sub.Metrics, m)
+	}
+	for _, path := range paths {
+		b, err := f.Encoder.E.Encode(split[path])
+		if err != nil {
+			return fmt.Errorf("file sender unable to encode: %w", err)
+		}
+		f.c <- fileWrite{path, b}
+	}
+	if terr != nil {
+		return fmt.Errorf("unable to find the file for %d of %d metrics, last error: %w", failed, len(c.Metrics), terr)
+	}
 	return nil
 }
 
+// path executes the path template for a metric, and checks that the
+// result doesn't escape the directory the template starts with.
+func (f *File) path(m *skogul.Metric) (string, error) {
+	t := time.Now()
+	if m.Time != nil {
+		t = *m.Time
+	}
+	var b strings.Builder
+	err := f.tmpl.Execute(&b, struct {
+		Metadata map[string]interface{}
+		Data     map[string]interface{}
+		Time     time.Time
+	}{m.Metadata, m.Data, t})
+	if err != nil {
+		return "", err
+	}
+	path := b.String()
+	rest, ok := strings.CutPrefix(path, f.prefix)
+	if !ok || (f.prefix == "" && filepath.IsAbs(rest)) {
+		return "", fmt.Errorf("path %q is outside of %q", path, f.prefix)
+	}
+	for _, elem := range strings.Split(rest, "/") {
+		if elem == ".." {
+			return "", fmt.Errorf("path %q contains \"..\"", path)
+		}
+	}
+	return filepath.Clean(path), nil
+}
+
+// Close flushes and closes all files, and waits for rotated files to be
+// compressed. Send fails after Close, since receivers may still be
+// running while Skogul shuts down.
+func (f *File) Close() error {
+	f.closeOnce.Do(func() {
+		f.sendLock.Lock()
+		f.closed = true
+		f.sendLock.Unlock()
+		if f.closing == nil {
+			return
+		}
+		f.ok = false
+		reply := make(chan error)
+		f.closing <- reply
+		f.closeErr = <-reply
+		f.rotations.Wait()
+	})
+	return f.closeErr
+}
+
 func (f *File) Deprecated() error {
 	if f.Path != "" {
 		return fmt.Errorf("config option Path is replaced by option File, Path will be removed in future versions.")
@@ -202,5 +642,18 @@ func (f *File) Verify() error {
 	if f.File == "" && f.Path == "" {
 		return skogul.MissingArgument("File")
 	}
+	if strings.Contains(f.File, "{{") {
+		if _, err := timeLayoutTemplate(f.File); err != nil {
+			return fmt.Errorf("invalid File template: %w", err)
+		}
+	}
+	switch f.Compress {
+	case "", "gzip", "zstd":
+	default:
+		return fmt.Errorf("unknown compression %q, must be gzip or zstd", f.Compress)
+	}
+	if f.MaxSize < 0 || f.Retention < 0 {
+		return fmt.Errorf("MaxSize and Retention can't be negative")
+	}
 	return nil
 }
@@ -24,13 +24,18 @@
 package sender_test
 
 import (
+	"compress/gzip"
+	"fmt"
+	"io"
 	"os"
 	"path"
+	"strings"
 	"syscall"
 	"testing"
 	"time"
 
 	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/encoder"
 	"github.com/telenornms/skogul/sender"
 )
 
@@ -149,3 +154,185 @@ func TestAppendToFile(t *testing.T) {
 		return
 	}
 }
+
+func TestFileTemplate(t *testing.T) {
+	dir := t.TempDir()
+	f := &sender.File{
+		File:          path.Join(dir, "{{.Metadata.site}}/2006-01-02.json"),
+		FlushInterval: skogul.Duration{Duration: time.Hour},
+	}
+	if err := f.Verify(); err == nil {
+		t.Errorf("Verify() without encoder did not fail")
+	}
+	day1 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	day2 := day1.Add(24 * time.Hour)
+	c := skogul.Container{Metrics: []*skogul.Metric{
+		{Time: &day1, Metadata: map[string]interface{}{"site": "oslo"}, Data: map[string]interface{}{"n": 1}},
+		{Time: &day2, Metadata: map[string]interface{}{"site": "oslo"}, Data: map[string]interface{}{"n": 2}},
+		{Time: &day1, Metadata: map[string]interface{}{"site": "site1"}, Data: map[string]interface{}{"n": 3}},
+		{Time: &day1, Metadata: map[string]interface{}{}, Data: map[string]interface{}{"n": 4}},
+	}}
+	if err := f.Send(&c); err == nil {
+		t.Errorf("Send() with a metric missing site did not fail")
+	}
+	// Buffered, so nothing is written until flushed.
+	time.Sleep(100 * time.Millisecond)
+	if b, _ := os.ReadFile(path.Join(dir, "oslo/2024-05-01.json")); len(b) != 0 {
+		t.Errorf("buffered data written before flush: %s", b)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	for file, n := range map[string]string{"oslo/2024-05-01.json": `"n":1`, "oslo/2024-05-02.json": `"n":2`, "site1/2024-05-01.json": `"n":3`} {
+		b, err := os.ReadFile(path.Join(dir, file))
+		if err != nil || !strings.Contains(string(b), n) || strings.Count(string(b), "\n") != 1 {
+			t.Errorf("%s: unexpected content %q (%v)", file, b, err)
+		}
+	}
+}
+
+func TestFileTemplateEscape(t *testing.T) {
+	dir := t.TempDir()
+	enc := skogul.EncoderRef{Name: "json", E: encoder.JSON{}}
+	for tmpl, sites := range map[string][]string{
+		path.Join(dir, "data/{{.Metadata.site}}/x.json"): {"..", "../..", "a/../../b", "a/../.."},
+		"{{.Metadata.site}}/x.json":                      {"..", dir},
+	} {
+		f := &sender.File{File: tmpl, Encoder: enc}
+		for _, site := range sites {
+			c := skogul.Container{Metrics: []*skogul.Metric{{Metadata: map[string]interface{}{"site": site}, Data: map[string]interface{}{"n": 1}}}}
+			if err := f.Send(&c); err == nil {
+				t.Errorf("%s: Send() with site %q did not fail", tmpl, site)
+			}
+		}
+		if err := f.Close(); err != nil {
+			t.Errorf("Close() failed: %v", err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 0 {
+		t.Errorf("files written outside of the template directory: %v", entries)
+	}
+}
+
+func TestFileSendAfterClose(t *testing.T) {
+	f := &sender.File{File: path.Join(t.TempDir(), "metrics.json")}
+	if err := f.Send(createContainer()); err != nil {
+		t.Fatalf("Send() failed: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	done := make(chan error)
+	go func() {
+		for i := 0; i < 100; i++ {
+			if err := f.Send(createContainer()); err == nil {
+				done <- fmt.Errorf("Send() after Close() did not fail")
+				return
+			}
+		}
+		done <- nil
+	}()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Send() after Close() blocked")
+	}
+}
+
+func TestFileCSVHeader(t *testing.T) {
+	dir := t.TempDir()
+	csv := &encoder.CSV{}
+	f := &sender.File{
+		File:    path.Join(dir, "{{.Metadata.site}}/r.csv"),
+		Encoder: skogul.EncoderRef{Name: "csv", E: csv},
+		MaxSize: 20,
+	}
+	if err := f.Send(&skogul.Container{}); err != nil {
+		t.Fatalf("Send() of empty container failed: %v", err)
+	}
+	for i := 0; i < 3; i++ {
+		for _, site := range []string{"osl", "ber"} {
+			c := skogul.Container{Metrics: []*skogul.Metric{{Metadata: map[string]interface{}{"site": site}, Data: map[string]interface{}{"v": i}}}}
+			if err := f.Send(&c); err != nil {
+				t.Fatalf("Send() failed: %v", err)
+			}
+		}
+	}
+	if err := f.Close(); err != nil {
+		t.Fatalf("Close() failed: %v", err)
+	}
+	for _, site := range []string{"osl", "ber"} {
+		entries, _ := os.ReadDir(path.Join(dir, site))
+		if len(entries) < 2 {
+			t.Errorf("%s: expected rotated files, got %v", site, entries)
+		}
+		for _, e := range entries {
+			b, err := os.ReadFile(path.Join(dir, site, e.Name()))
+			if err != nil || !strings.HasPrefix(string(b), "time,site,v\n") || strings.Count(string(b), "time,") != 1 {
+				t.Errorf("%s: unexpected content %q (%v)", e.Name(), b, err)
+			}
+		}
+	}
+}
+
+func TestFileRotate(t *testing.T) {
+	for _, compress := range []string{"", "gzip", "zstd"} {
+		dir := t.TempDir()
+		file := path.Join(dir, "metrics.json")
+		f := &sender.File{File: file, MaxSize: 100, Retention: 2, Compress: compress}
+		for i := 0; i < 10; i++ {
+			if err := f.Send(createContainer()); err != nil {
+				t.Fatalf("Send() failed: %v", err)
+			}
+		}
+		if err := f.Close(); err != nil {
+			t.Fatalf("Close() failed: %v", err)
+		}
+		entries, _ := os.ReadDir(dir)
+		if len(entries) != 3 {
+			t.Fatalf("%s: expected the file and 2 rotated files, got %v", compress, entries)
+		}
+		ext := map[string]string{"": "", "gzip": ".gz", "zstd": ".zst"}[compress]
+		for _, e := range entries[1:] {
+			if !strings.HasPrefix(e.Name(), "metrics.json.") || !strings.HasSuffix(e.Name(), ext) {
+				t.Errorf("%s: unexpected rotated file %s", compress, e.Name())
+			}
+		}
+		if info, err := os.Stat(file); err != nil || info.Size() > 100 || info.Size() == 0 {
+			t.Errorf("%s: unexpected current file %v (%v)", compress, info, err)
+		}
+		if compress == "gzip" {
+			r, _ := os.Open(path.Join(dir, entries[1].Name()))
+			zr, err := gzip.NewReader(r)
+			if err != nil {
+				t.Fatalf("rotated file isn't gzip: %v", err)
+			}
+			b, _ := io.ReadAll(zr)
+			if !strings.Contains(string(b), `"baz":"qux"`) {
+				t.Errorf("unexpected content of rotated file: %q", b)
+			}
+			r.Close()
+		}
+	}
+}
+
+func TestFileVerify(t *testing.T) {
+	enc := skogul.EncoderRef{Name: "json", E: encoder.JSON{}}
+	for _, f := range []*sender.File{
+		{Encoder: enc, File: "/tmp/{{.Metadata.x"},
+		{Encoder: enc, File: "/tmp/x", Compress: "lz4"},
+		{Encoder: enc, File: "/tmp/x", MaxSize: -1},
+	} {
+		if err := f.Verify(); err == nil {
+			t.Errorf("Verify() of %s with compression %q did not fail", f.File, f.Compress)
+		}
+	}
+	f := &sender.File{Encoder: enc, File: "/tmp/{{.Metadata.site}}/2006.json", Compress: "zstd"}
+	if err := f.Verify(); err != nil {
+		t.Errorf("Verify() failed: %v", err)
+	}
+}
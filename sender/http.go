@@ -206,8 +206,15 @@ func (ht *HTTP) initStats() {
 // reuse the HTTP sender options without having
 // to re-implement them.
 func (ht *HTTP) sendBytes(b []byte) error {
+	_, err := ht.sendBytesResponse(b)
+	return err
+}
+
+// sendBytesResponse is sendBytes, but also returns the response body, for
+// senders that need to inspect the reply.
+func (ht *HTTP) sendBytesResponse(b []byte) ([]byte, error) {
 	if !ht.ok {
-		return fmt.Errorf("HTTP sender not in OK state")
+		return nil, fmt.Errorf("HTTP sender not in OK state")
 	}
 
 	var buffer bytes.Buffer
@@ -218,7 +225,7 @@ func (ht *HTTP) sendBytes(b []byte) error {
 	req, err := http.NewRequest("POST", ht.URL, &buffer)
 	if err != nil {
 		atomic.AddUint64(&ht.stats.Errors, 1)
-		return fmt.Errorf("Failed to create a HTTP request (we are %s). Error: %w", skogul.Identity[ht], err)
+		return nil, fmt.Errorf("Failed to create a HTTP request (we are %s). Error: %w", skogul.Identity[ht], err)
 	}
 	for header, value := range ht.Headers {
 		req.Header.Add(http.CanonicalHeaderKey(header), value)
@@ -226,23 +233,38 @@ func (ht *HTTP) sendBytes(b []byte) error {
 	resp, err := ht.client.Do(req)
 	if err != nil {
 		atomic.AddUint64(&ht.stats.RequestErrors, 1)
-		return fmt.Errorf("Unable to POST request (we are %s). Error: %w", skogul.Identity[ht], err)
-	}
-	if resp.ContentLength > 0 {
-		tmp := make([]byte, resp.ContentLength)
-		if n, err := io.ReadFull(resp.Body, tmp); err != nil {
-			atomic.AddUint64(&ht.stats.Errors, 1)
-			resp.Body.Close()
-			return fmt.Errorf("Failed to read HTTP response body, ContentLength said %d, got %d. Error: %w", resp.ContentLength, n, err)
-		}
+		return nil, fmt.Errorf("Unable to POST request (we are %s). Error: %w", skogul.Identity[ht], err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		atomic.AddUint64(&ht.stats.Errors, 1)
+		return nil, fmt.Errorf("Failed to read HTTP response body, ContentLength said %d, got %d. Error: %w", resp.ContentLength, len(body), err)
 	}
 	if resp.StatusCode < 200 || resp.StatusCode > 299 {
 		httpResponseCodeStats := ht.stats.HttpResponseError[resp.StatusCode]
 		atomic.AddUint64(&httpResponseCodeStats, 1)
-		return fmt.Errorf("non-OK status code from target: %d / %s", resp.StatusCode, resp.Status)
+		return body, &httpStatusError{code: resp.StatusCode, status: resp.Status}
 	}
 	atomic.AddUint64(&ht.stats.Sent, 1)
-	return nil
+	return body, nil
+}
+
+// httpStatusError is returned by sendBytesResponse for non-OK status
+// codes, so the caller can tell temporary failures from permanent ones.
+type httpStatusError struct {
+	code   int
+	status string
+}
+
+func (e *httpStatusError) Error() string {
+	return fmt.Sprintf("non-OK status code from target: %d / %s", e.code, e.status)
+}
+
+// temporary is true for responses worth retrying: 429 Too Many Requests
+// and server errors.
+func (e *httpStatusError) temporary() bool {
+	return e.code == http.StatusTooManyRequests || e.code >= 500
 }
 
 // Send POSTS data
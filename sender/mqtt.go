@@ -26,6 +26,8 @@ package sender
 
 import (
 	"encoding/json"
+	"fmt"
+	"strings"
 	"sync"
 
 	"github.com/telenornms/skogul"
@@ -37,37 +39,98 @@ var mqttLog = skogul.Logger("sender", "mqtt")
 /*
 MQTT Sender publishes messages on a MQTT message bus.
 
+Topics may contain ${metadata.KEY}, which is replaced by the metadata
+field KEY of each metric. Metrics are grouped by the resulting topic, and
+each group is published as a separate container. Metrics lacking a field
+used in the topic are dropped with a warning.
+
 FIXME: The MQTT-sender and receiver should be updated to not use the
 url-encoded scheme.
 */
 type MQTT struct {
-	Broker   string   `doc:"Address of broker to send to" example:"[::1]:8888"`
-	Topics   []string `doc:"Topic(s) to publish events to"`
-	Username string   `doc:"MQTT broker authorization username"`
-	Password string   `doc:"MQTT broker authorization password"`
-	ClientID string   `doc:"Custom client id to use (default: random)"`
+	Broker         string          `doc:"Address of broker to send to. Use a mqtts:// or ssl:// URL for TLS, and ws:// or wss:// for WebSockets." example:"[::1]:8888"`
+	Topics         []string        `doc:"Topic(s) to publish events to. ${metadata.KEY} is replaced by the metadata field KEY." example:"[\"sites/${metadata.site}/metrics\"]"`
+	Username       string          `doc:"MQTT broker authorization username"`
+	Password       string          `doc:"MQTT broker authorization password"`
+	ClientID       string          `doc:"Custom client id to use (default: random)"`
+	QoS            byte            `doc:"QoS level for publishing: 0, 1 or 2."`
+	Retain         bool            `doc:"Set the retain flag on published messages."`
+	Version        int             `doc:"MQTT protocol version, 3 (for 3.1.1) or 5. Default is 3."`
+	ConnectTimeout skogul.Duration `doc:"How long to wait for the broker when connecting or publishing. Default is 10s."`
+	CA             string          `doc:"Path to a CA certificate for verifying the broker. Default is the system pool."`
+	Certfile       string          `doc:"Path to a client certificate, for authenticating with TLS."`
+	Keyfile        string          `doc:"Path to the key of the client certificate."`
+	Insecure       bool            `doc:"Disable verification of the broker certificate. Dangerous."`
 
-	once sync.Once
-	mc   skmqtt.MQTT
+	once    sync.Once
+	initErr error
+	mc      skmqtt.MQTT
+}
+
+func (handler *MQTT) init() {
+	if handler.Topics == nil {
+		handler.Topics = []string{"#"}
+	}
+	tlsConf, err := skmqtt.TLSConfig(handler.CA, handler.Certfile, handler.Keyfile, handler.Insecure)
+	if err != nil {
+		handler.initErr = err
+		return
+	}
+	handler.mc.QoS = handler.QoS
+	handler.mc.Version = handler.Version
+	handler.mc.TLS = tlsConf
+	handler.mc.ConnectTimeout = handler.ConnectTimeout.Duration
+	if handler.initErr = handler.mc.Init(handler.Broker, handler.Username, handler.Password, handler.ClientID); handler.initErr != nil {
+		return
+	}
+	if err := handler.mc.Connect(); err != nil {
+		mqttLog.WithError(err).Error("Initial connection to MQTT broker failed")
+	}
 }
 
 // Send publishes the container in skogul JSON-encoded format on an MQTT
 // topic.
 func (handler *MQTT) Send(c *skogul.Container) error {
-	handler.once.Do(func() {
-		if handler.Topics == nil {
-			handler.Topics = []string{"#"}
+	handler.once.Do(handler.init)
+	if handler.initErr != nil {
+		return handler.initErr
+	}
+	for _, topic := range handler.Topics {
+		if !strings.Contains(topic, "${metadata.") {
+			if err := handler.publish(topic, c); err != nil {
+				return err
+			}
+			continue
 		}
-		handler.mc.Init(handler.Broker, handler.Username, handler.Password, handler.ClientID)
-		handler.mc.Connect()
-	})
+		groups := make(map[string]*skogul.Container)
+		for _, m := range c.Metrics {
+			t, err := skogul.ExpandMetadata(topic, m.Metadata)
+			if err != nil {
+				mqttLog.WithError(err).Warn("Dropping metric")
+				continue
+			}
+			if groups[t] == nil {
+				groups[t] = &skogul.Container{Template: c.Template}
+			}
+			groups[t].Metrics = append(groups[t].Metrics, m)
+		}
+		for t, group := range groups {
+			if err := handler.publish(t, group); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (handler *MQTT) publish(topic string, c *skogul.Container) error {
 	b, err := json.MarshalIndent(*c, "", "  ")
 	if err != nil {
 		mqttLog.WithError(err).Panic("Unable to marshal json for debug output")
 		return err
 	}
-	for _, topic := range handler.Topics {
-		handler.mc.Client.Publish(topic, 0, false, b)
+	if err := handler.mc.Publish(topic, handler.Retain, b); err != nil {
+		return fmt.Errorf("unable to publish to %s: %w", topic, err)
 	}
 	return nil
 }
@@ -77,6 +140,15 @@ func (handler *MQTT) Verify() error {
 	if handler.Broker == "" {
 		return skogul.MissingArgument("Broker")
 	}
+	if handler.QoS > 2 {
+		return fmt.Errorf("invalid QoS %d, must be 0, 1 or 2", handler.QoS)
+	}
+	if handler.Version != 0 && handler.Version != 3 && handler.Version != 5 {
+		return fmt.Errorf("unsupported MQTT version %d, must be 3 or 5", handler.Version)
+	}
+	if (handler.Certfile == "") != (handler.Keyfile == "") {
+		return fmt.Errorf("both Certfile and Keyfile must be set for client certificates")
+	}
 	if handler.Topics == nil {
 		mqttLog.Warn("MQTT topic(s) not set, sending all messages to wildcard ('#')")
 	}
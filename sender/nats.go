@@ -23,38 +23,53 @@
 package sender
 
 import (
+	"context"
+	"crypto/sha256"
 	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
 	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/encoder"
 	"strings"
 	"sync"
+	"time"
 )
 
 var natsLog = skogul.Logger("sender", "nats")
 
 /*
-Nats sender. A small Nats, non-jetstream, publisher implementing all
-Authentication & Authorization features in the nats golang client.
+Nats sender. A small Nats publisher implementing all Authentication &
+Authorization features in the nats golang client.
+
+With JetStream enabled, each message is published to a stream and Send
+waits for the server to acknowledge it, failing if it doesn't within
+AckWait. With Dedup, the Nats-Msg-Id header is set to a hash of the
+subject and message, so the stream drops identical messages published
+again within its duplicate window, e.g. when a failed Send is retried.
 */
 
 type Nats struct {
-	Servers       string   `doc:"Comma separated list of nats URLs"`
-	Subject       string   `doc:"Subject to publish messages on"`
-	SubjectAppend []string `doc:"Append theese Metadata fields to subject"`
-	Name          string   `doc:"Client name"`
-	Username      string   `doc:"Client username"`
-	Password      string   `doc:"Client password"`
-	TLSClientKey  string   `doc:"TLS client key file path"`
-	TLSClientCert string   `doc:"TLS client cert file path"`
-	TLSCACert     string   `doc:"CA cert file path"`
-	UserCreds     string   `doc:"Nats credentials file path"`
-	NKeyFile      string   `doc:"Nats nkey file path"`
-	Insecure      bool     `doc:"TLS InsecureSkipVerify"`
+	Servers       string          `doc:"Comma separated list of nats URLs"`
+	Subject       string          `doc:"Subject to publish messages on"`
+	SubjectAppend []string        `doc:"Append theese Metadata fields to subject"`
+	Name          string          `doc:"Client name"`
+	Username      string          `doc:"Client username"`
+	Password      string          `doc:"Client password"`
+	TLSClientKey  string          `doc:"TLS client key file path"`
+	TLSClientCert string          `doc:"TLS client cert file path"`
+	TLSCACert     string          `doc:"CA cert file path"`
+	UserCreds     string          `doc:"Nats credentials file path"`
+	NKeyFile      string          `doc:"Nats nkey file path"`
+	Insecure      bool            `doc:"TLS InsecureSkipVerify"`
+	JetStream     bool            `doc:"Publish to JetStream and wait for the publish to be acknowledged."`
+	AckWait       skogul.Duration `doc:"How long to wait for JetStream to acknowledge a message. Fallback is 5s."`
+	Dedup         bool            `doc:"Set the JetStream Nats-Msg-Id header to a hash of the subject and message, for deduplication."`
 	Encoder       skogul.EncoderRef
 	conOpts       *[]nats.Option
 	natsCon       *nats.Conn
+	js            jetstream.JetStream
 	once          sync.Once
 	init_error    error
 }
@@ -73,6 +88,10 @@ func (n *Nats) Verify() error {
 		return fmt.Errorf("Please configure usercreds or nkeyfile.")
 	}
 
+	if n.Dedup && !n.JetStream {
+		return fmt.Errorf("Dedup requires JetStream.")
+	}
+
 	return nil
 }
 
@@ -147,6 +166,17 @@ func (n *Nats) init() error {
 	n.natsCon, err = nats.Connect(n.Servers, *n.conOpts...)
 	if err != nil {
 		n.init_error = fmt.Errorf("Encountered an error while connecting to Nats: %v", err)
+		return err
+	}
+
+	if n.JetStream {
+		if n.AckWait.Duration == 0 {
+			n.AckWait.Duration = 5 * time.Second
+		}
+		n.js, err = jetstream.New(n.natsCon)
+		if err != nil {
+			n.init_error = fmt.Errorf("Unable to initialize JetStream: %v", err)
+		}
 	}
 	return err
 }
@@ -179,8 +209,32 @@ func (n *Nats) Send(c *skogul.Container) error {
 			natsLog.WithError(err).Debugf("Metadata of incorrect metric: %v", m.Metadata)
 			continue
 		}
+		if n.JetStream {
+			if err := n.publish(subject, b); err != nil {
+				return err
+			}
+			continue
+		}
 		n.natsCon.Publish(subject, b)
 	}
 
 	return n.natsCon.LastError()
 }
+
+// publish a message to JetStream and wait for the ack.
+func (n *Nats) publish(subject string, b []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), n.AckWait.Duration)
+	defer cancel()
+	var opts []jetstream.PublishOpt
+	if n.Dedup {
+		h := sha256.New()
+		h.Write([]byte(subject))
+		h.Write([]byte{0})
+		h.Write(b)
+		opts = append(opts, jetstream.WithMsgID(hex.EncodeToString(h.Sum(nil))))
+	}
+	if _, err := n.js.Publish(ctx, subject, b, opts...); err != nil {
+		return fmt.Errorf("JetStream publish to %s failed: %w", subject, err)
+	}
+	return nil
+}
@@ -1,9 +1,11 @@
 package sender_test
 
 import (
+	"fmt"
 	"github.com/telenornms/skogul/config"
 	"github.com/telenornms/skogul/sender"
 	"testing"
+	"time"
 )
 
 func TestNats(t *testing.T) {
@@ -44,3 +46,36 @@ func TestNats(t *testing.T) {
 		t.Error("Bad config")
 	}
 }
+
+func TestNatsJetStream(t *testing.T) {
+	conf := `
+	{
+	  "senders": {
+	    "nats_s": {
+	      "type": "nats",
+	      "servers": "nats://0.0.0.0:4222",
+	      "subject": "nats.sender",
+	      "subjectappend": ["site"],
+	      "jetstream": %s,
+	      "dedup": true,
+	      "ackwait": "2s"
+	    }
+	  }
+	}`
+	c, err := config.Bytes([]byte(fmt.Sprintf(conf, "true")))
+
+	if err != nil {
+		t.Fatalf("Failed to load config: %s", err)
+	}
+
+	ns := c.Senders["nats_s"].Sender.(*sender.Nats)
+
+	if !ns.JetStream || ns.AckWait.Duration != 2*time.Second {
+		t.Errorf("Bad config: %v", ns)
+	}
+
+	_, err = config.Bytes([]byte(fmt.Sprintf(conf, "false")))
+	if err == nil {
+		t.Errorf("Dedup without JetStream did not fail")
+	}
+}
@@ -24,24 +24,29 @@
 package sender
 
 import (
-	"encoding/json"
 	"fmt"
 	"net"
 
 	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/encoder"
 )
 
 var netLog = skogul.Logger("sender", "net")
 
 // Net sends metrics to a network address
-// FIXME: Use Encoder
 type Net struct {
-	Address string `doc:"Address to send data to" example:"192.168.1.99:1234"`
-	Network string `doc:"Network, according to net.Dial. Typically udp or tcp."`
+	Address string            `doc:"Address to send data to" example:"192.168.1.99:1234"`
+	Network string            `doc:"Network, according to net.Dial. Typically udp or tcp."`
+	Encoder skogul.EncoderRef `doc:"Encoder to use. Defaults to JSON-encoding."`
 }
 
-// Send sends metrics to a network address, json-encoded
+// Send sends metrics to a network address, encoded with the configured
+// encoder.
 func (n *Net) Send(c *skogul.Container) error {
+	enc := n.Encoder.E
+	if enc == nil {
+		enc = encoder.JSON{}
+	}
 	d, err := net.Dial(n.Network, n.Address)
 	if err != nil {
 		return fmt.Errorf("connection to %s failed: %w", n.Address, err)
@@ -50,9 +55,9 @@ func (n *Net) Send(c *skogul.Container) error {
 	// connection in the future
 	defer d.Close()
 
-	b, err := json.Marshal(c)
+	b, err := enc.Encode(c)
 	if err != nil {
-		return fmt.Errorf("unable to marshal json for sending: %w", err)
+		return fmt.Errorf("unable to encode data for sending: %w", err)
 	}
 	nbytes, err := d.Write(b)
 	if err != nil {
@@ -0,0 +1,324 @@
+/*
+ * skogul, OpenTelemetry OTLP metrics sender
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package sender
+
+import (
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/telenornms/skogul"
+	colmetrics "go.opentelemetry.io/proto/otlp/collector/metrics/v1"
+	commonpb "go.opentelemetry.io/proto/otlp/common/v1"
+	metricspb "go.opentelemetry.io/proto/otlp/metrics/v1"
+	resourcepb "go.opentelemetry.io/proto/otlp/resource/v1"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	grpcmd "google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
+)
+
+/*
+OTLP sends metrics to an OpenTelemetry collector, or anything else
+accepting OTLP, over gRPC or HTTP.
+
+Each numeric data field becomes a gauge named after the field, with the
+metadata as attributes. Metadata fields listed in ResourceMetadata are
+used as resource attributes instead, and metrics are grouped by resource.
+Non-numeric data fields are skipped.
+*/
+type OTLP struct {
+	Address          string            `doc:"Address of a gRPC endpoint, e.g. localhost:4317. Mutually exclusive with URL."`
+	URL              string            `doc:"URL of a HTTP endpoint. Mutually exclusive with Address." example:"http://localhost:4318/v1/metrics"`
+	Headers          map[string]string `doc:"Headers added to gRPC requests, e.g. for authentication. For HTTP, use the headers of the HTTP options."`
+	Plaintext        bool              `doc:"Use gRPC without TLS."`
+	Insecure         bool              `doc:"Disable TLS certificate validation for gRPC."`
+	RootCA           string            `doc:"Path to an alternate root CA used to verify the gRPC server certificate. Leave blank to use system defaults."`
+	Timeout          skogul.Duration   `doc:"Timeout for gRPC requests. Defaults to 20s."`
+	ResourceMetadata []string          `doc:"Metadata fields used as resource attributes instead of data point attributes." example:"[\"service.name\", \"host.name\"]"`
+	Scope            string            `doc:"Name of the instrumentation scope. Defaults to skogul."`
+	HTTP             *HTTP             `doc:"HTTP sender options, used with URL. URL is overwritten from this config, the rest will be HTTP sender defaults unless overridden."`
+	once             sync.Once
+	initErr          error
+	client           colmetrics.MetricsServiceClient
+	resourceKeys     map[string]bool
+}
+
+func (o *OTLP) init() {
+	if o.Timeout.Duration == 0 {
+		o.Timeout.Duration = 20 * time.Second
+	}
+	if o.Scope == "" {
+		o.Scope = "skogul"
+	}
+	o.resourceKeys = make(map[string]bool)
+	for _, k := range o.ResourceMetadata {
+		o.resourceKeys[k] = true
+	}
+	if o.URL != "" {
+		o.HTTP.URL = o.URL
+		o.HTTP.once.Do(o.HTTP.init)
+		if !o.HTTP.ok {
+			o.initErr = fmt.Errorf("HTTP sender not in OK state")
+			return
+		}
+		o.HTTP.Headers["Content-Type"] = "application/x-protobuf"
+		return
+	}
+	creds := insecure.NewCredentials()
+	if !o.Plaintext {
+		cp, err := skogul.GetCertPool(o.RootCA)
+		if err != nil {
+			o.initErr = err
+			return
+		}
+		creds = credentials.NewTLS(&tls.Config{RootCAs: cp, InsecureSkipVerify: o.Insecure})
+	}
+	conn, err := grpc.NewClient(o.Address, grpc.WithTransportCredentials(creds))
+	if err != nil {
+		o.initErr = fmt.Errorf("unable to set up gRPC client: %w", err)
+		return
+	}
+	o.client = colmetrics.NewMetricsServiceClient(conn)
+}
+
+// Send converts the container to OTLP and exports it.
+func (o *OTLP) Send(c *skogul.Container) error {
+	o.once.Do(o.init)
+	if o.initErr != nil {
+		return o.initErr
+	}
+	req := o.convert(c)
+	if len(req.ResourceMetrics) == 0 {
+		return nil
+	}
+	if o.client == nil {
+		b, err := proto.Marshal(req)
+		if err != nil {
+			return fmt.Errorf("unable to marshal OTLP request: %w", err)
+		}
+		return o.HTTP.sendBytes(b)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), o.Timeout.Duration)
+	defer cancel()
+	if len(o.Headers) > 0 {
+		ctx = grpcmd.NewOutgoingContext(ctx, grpcmd.New(o.Headers))
+	}
+	if _, err := o.client.Export(ctx, req); err != nil {
+		return fmt.Errorf("OTLP export failed: %w", err)
+	}
+	return nil
+}
+
+// convert builds an export request with one resource per distinct set
+// of resource attributes, and one gauge per data field name.
+func (o *OTLP) convert(c *skogul.Container) *colmetrics.ExportMetricsServiceRequest {
+	type resource struct {
+		attrs   []*commonpb.KeyValue
+		gauges  map[string]*metricspb.Gauge
+		ordered []string
+	}
+	resources := make(map[string]*resource)
+	var order []string
+	for _, m := range c.Metrics {
+		t := skogul.Now()
+		if m.Time != nil {
+			t = *m.Time
+		}
+		var rattrs, attrs []*commonpb.KeyValue
+		var rkeyb strings.Builder
+		for _, k := range otlpSortedKeys(m.Metadata) {
+			kv := &commonpb.KeyValue{Key: k, Value: otlpAnyValue(m.Metadata[k])}
+			if o.resourceKeys[k] {
+				rattrs = append(rattrs, kv)
+				fmt.Fprintf(&rkeyb, "%s\x00%v\x00", k, m.Metadata[k])
+			} else {
+				attrs = append(attrs, kv)
+			}
+		}
+		rkey := rkeyb.String()
+		r := resources[rkey]
+		if r == nil {
+			r = &resource{attrs: rattrs, gauges: make(map[string]*metricspb.Gauge)}
+			resources[rkey] = r
+			order = append(order, rkey)
+		}
+		for _, name := range otlpSortedKeys(m.Data) {
+			dp := &metricspb.NumberDataPoint{
+				Attributes:   attrs,
+				TimeUnixNano: uint64(t.UnixNano()),
+			}
+			if !otlpSetValue(dp, m.Data[name]) {
+				continue
+			}
+			g := r.gauges[name]
+			if g == nil {
+				g = &metricspb.Gauge{}
+				r.gauges[name] = g
+				r.ordered = append(r.ordered, name)
+			}
+			g.DataPoints = append(g.DataPoints, dp)
+		}
+	}
+	req := colmetrics.ExportMetricsServiceRequest{}
+	for _, rkey := range order {
+		r := resources[rkey]
+		if len(r.ordered) == 0 {
+			continue
+		}
+		sm := &metricspb.ScopeMetrics{Scope: &commonpb.InstrumentationScope{Name: o.Scope}}
+		for _, name := range r.ordered {
+			sm.Metrics = append(sm.Metrics, &metricspb.Metric{
+				Name: name,
+				Data: &metricspb.Metric_Gauge{Gauge: r.gauges[name]},
+			})
+		}
+		req.ResourceMetrics = append(req.ResourceMetrics, &metricspb.ResourceMetrics{
+			Resource:     &resourcepb.Resource{Attributes: r.attrs},
+			ScopeMetrics: []*metricspb.ScopeMetrics{sm},
+		})
+	}
+	return &req
+}
+
+func otlpSortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// otlpSetValue sets the value of a data point from a numeric data field.
+// Returns false if the value isn't numeric.
+func otlpSetValue(dp *metricspb.NumberDataPoint, v interface{}) bool {
+	switch x := v.(type) {
+	case int:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(x)}
+	case int8:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(x)}
+	case int16:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(x)}
+	case int32:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(x)}
+	case int64:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: x}
+	case uint:
+		return otlpSetUint(dp, uint64(x))
+	case uint8:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(x)}
+	case uint16:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(x)}
+	case uint32:
+		dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(x)}
+	case uint64:
+		return otlpSetUint(dp, x)
+	case float32:
+		dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: float64(x)}
+	case float64:
+		dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: x}
+	case json.Number:
+		if i, err := x.Int64(); err == nil {
+			dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: i}
+		} else if u, err := strconv.ParseUint(string(x), 10, 64); err == nil {
+			return otlpSetUint(dp, u)
+		} else if f, err := x.Float64(); err == nil {
+			dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: f}
+		} else {
+			return false
+		}
+	default:
+		return false
+	}
+	return true
+}
+
+// otlpSetUint sets an unsigned value. OTLP only has signed integers, so
+// values too large for int64 are sent as doubles rather than wrapping
+// around to negative numbers.
+func otlpSetUint(dp *metricspb.NumberDataPoint, x uint64) bool {
+	if x > math.MaxInt64 {
+		dp.Value = &metricspb.NumberDataPoint_AsDouble{AsDouble: float64(x)}
+		return true
+	}
+	dp.Value = &metricspb.NumberDataPoint_AsInt{AsInt: int64(x)}
+	return true
+}
+
+// otlpAnyValue converts a metadata value to an attribute value. Types
+// without an OTLP equivalent are converted to strings.
+func otlpAnyValue(v interface{}) *commonpb.AnyValue {
+	switch x := v.(type) {
+	case string:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: x}}
+	case bool:
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_BoolValue{BoolValue: x}}
+	case []interface{}:
+		arr := &commonpb.ArrayValue{}
+		for _, e := range x {
+			arr.Values = append(arr.Values, otlpAnyValue(e))
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_ArrayValue{ArrayValue: arr}}
+	case map[string]interface{}:
+		kvs := &commonpb.KeyValueList{}
+		for _, k := range otlpSortedKeys(x) {
+			kvs.Values = append(kvs.Values, &commonpb.KeyValue{Key: k, Value: otlpAnyValue(x[k])})
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_KvlistValue{KvlistValue: kvs}}
+	}
+	dp := metricspb.NumberDataPoint{}
+	if otlpSetValue(&dp, v) {
+		if i, ok := dp.Value.(*metricspb.NumberDataPoint_AsInt); ok {
+			return &commonpb.AnyValue{Value: &commonpb.AnyValue_IntValue{IntValue: i.AsInt}}
+		}
+		return &commonpb.AnyValue{Value: &commonpb.AnyValue_DoubleValue{DoubleValue: dp.GetAsDouble()}}
+	}
+	return &commonpb.AnyValue{Value: &commonpb.AnyValue_StringValue{StringValue: fmt.Sprint(v)}}
+}
+
+// Verify checks that the configuration is usable.
+func (o *OTLP) Verify() error {
+	if o.Address == "" && o.URL == "" {
+		return skogul.MissingArgument("Address")
+	}
+	if o.Address != "" && o.URL != "" {
+		return fmt.Errorf("specify either Address or URL, not both")
+	}
+	if _, err := skogul.GetCertPool(o.RootCA); err != nil {
+		return fmt.Errorf("failed to read custom root CA (RootCA: %s): %w", o.RootCA, err)
+	}
+	if o.URL != "" && o.HTTP == nil {
+		return fmt.Errorf("HTTP options missing")
+	}
+	return nil
+}
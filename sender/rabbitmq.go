@@ -26,37 +26,113 @@ package sender
 import (
 	"context"
 	"fmt"
+	"regexp"
+	"strings"
 	"sync"
 	"time"
 
 	amqp "github.com/rabbitmq/amqp091-go"
 	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/encoder"
+	"github.com/telenornms/skogul/internal/rabbitmq"
 )
 
+/*
+Rabbitmq publishes metrics to RabbitMQ, either directly to a queue or to
+an exchange with a routing key.
+
+The routing key may contain ${metadata.KEY}, which is replaced by the
+metadata field KEY of each metric. Metrics are grouped by the resulting
+routing key and each group is published as a separate message. Metrics
+lacking a field used in the routing key are dropped with a warning.
+
+If both Queue and Exchange are set, the queue is bound to the exchange
+with the routing key, so messages reach it. For a topic exchange, each
+${metadata.KEY} is bound as #, matching any value. A direct exchange
+can't be bound to a routing key with metadata, since every value would
+need its own binding.
+
+With Confirm set, each message is only considered sent once the broker
+has confirmed it, so messages the broker drops are reported as errors.
+
+The connection is re-established on the next Send if publishing fails.
+*/
 type Rabbitmq struct {
-	Username skogul.Secret     `doc:"Username for rabbitmq instance"`
-	Password skogul.Secret     `doc:"Password for rabbitmq instance"`
-	Host     string            `doc:"Hostname for rabbitmq instance. Fallback is localhost"`
-	Port     string            `doc:"Port for rabbitmq instance. Fallback is 5672"`
-	Queue    string            `doc:"Queue to write to"`
-	Encoder  skogul.EncoderRef `doc:"Encoder to use. Fallback is json"`
-	Timeout  int               `doc:"Timeout for rabbitmq instance connection. Fallback is 10 seconds."`
-	channel  *amqp.Channel
-	once     sync.Once
+	Username     skogul.Secret     `doc:"Username for rabbitmq instance"`
+	Password     skogul.Secret     `doc:"Password for rabbitmq instance"`
+	Host         string            `doc:"Hostname for rabbitmq instance. Fallback is localhost"`
+	Port         string            `doc:"Port for rabbitmq instance. Fallback is 5672, or 5671 with TLS"`
+	Vhost        string            `doc:"Virtual host to use. Fallback is the default vhost."`
+	TLS          bool              `doc:"Connect with TLS (amqps)."`
+	CA           string            `doc:"Path to a CA certificate for verifying the broker. Default is the system pool."`
+	Insecure     bool              `doc:"Disable verification of the broker certificate. Dangerous."`
+	Queue        string            `doc:"Queue to write to. Declared if set. Not needed if Exchange and RoutingKey is set."`
+	Durable      bool              `doc:"Declare the queue, and the exchange, as durable. Must match an existing queue or exchange."`
+	Exchange     string            `doc:"Exchange to publish to. The exchange is declared if it does not exist. Fallback is the default exchange, which routes directly to the queue."`
+	ExchangeType string            `doc:"Type of exchange: direct, fanout, topic or headers. Fallback is topic."`
+	RoutingKey   string            `doc:"Routing key to publish with. ${metadata.KEY} is replaced by the metadata field KEY. Fallback is the queue name." example:"metrics.${metadata.site}"`
+	Persistent   bool              `doc:"Publish messages as persistent, so they survive a broker restart if the queue is durable."`
+	Confirm      bool              `doc:"Use publisher confirms, waiting for the broker to accept each message before Send returns. Slower, but messages can't silently be lost between Skogul and the broker."`
+	Encoder      skogul.EncoderRef `doc:"Encoder to use. Fallback is json"`
+	Timeout      int               `doc:"Timeout for rabbitmq instance connection. Fallback is 10 seconds."`
+	conn         rabbitmq.Conn
+	once         sync.Once
 }
 
 var rabbitmqLog = skogul.Logger("sender", "rabbitmq")
 
-func (r *Rabbitmq) init() {
-	if r.Port == "" {
-		r.Port = "5672"
+// setup declares the queue and exchange on a new channel.
+func (r *Rabbitmq) setup(ch *amqp.Channel) error {
+	if r.Queue != "" {
+		_, err := ch.QueueDeclare(
+			r.Queue,
+			r.Durable,
+			false,
+			false,
+			false,
+			nil,
+		)
+		if err != nil {
+			return fmt.Errorf("unable to declare queue: %w", err)
+		}
 	}
+	if r.Exchange != "" {
+		kind := r.ExchangeType
+		if kind == "" {
+			kind = amqp.ExchangeTopic
+		}
+		if err := ch.ExchangeDeclare(r.Exchange, kind, r.Durable, false, false, false, nil); err != nil {
+			return fmt.Errorf("unable to declare exchange: %w", err)
+		}
+		if r.Queue != "" {
+			if err := ch.QueueBind(r.Queue, r.bindingKey(), r.Exchange, false, nil); err != nil {
+				return fmt.Errorf("unable to bind queue to exchange: %w", err)
+			}
+		}
+	}
+	if r.Confirm {
+		if err := ch.Confirm(false); err != nil {
+			return fmt.Errorf("unable to enable publisher confirms: %w", err)
+		}
+	}
+	return nil
+}
+
+// rabbitmqMetadata matches the ${metadata.KEY} references of a routing
+// key.
+var rabbitmqMetadata = regexp.MustCompile(`\$\{metadata\.[^}]*\}`)
 
-	if r.Host == "" {
-		r.Host = "localhost"
+// bindingKey is the routing key to bind the queue with, matching any
+// value of the metadata fields used in the routing key.
+func (r *Rabbitmq) bindingKey() string {
+	key := r.RoutingKey
+	if key == "" {
+		key = r.Queue
 	}
+	return rabbitmqMetadata.ReplaceAllString(key, "#")
+}
 
+func (r *Rabbitmq) init() {
 	if r.Timeout == 0 {
 		r.Timeout = 10
 	}
@@ -65,32 +141,20 @@ func (r *Rabbitmq) init() {
 		r.Encoder.E = encoder.JSON{}
 	}
 
-	conn, err := amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%s/", r.Username.Expose(), r.Password.Expose(), r.Host, r.Port))
-	if err != nil {
-		rabbitmqLog.WithError(err).Error("Failed initializing broker connection")
-		return
+	if r.RoutingKey == "" {
+		r.RoutingKey = r.Queue
 	}
 
-	ch, err := conn.Channel()
-	if err != nil {
-		rabbitmqLog.WithError(err).Error("Failed initializing channel")
-		return
-	}
-
-	r.channel = ch
-
-	_, err = ch.QueueDeclare(
-		r.Queue,
-		false,
-		false,
-		false,
-		false,
-		nil,
-	)
-
-	if err != nil {
-		rabbitmqLog.WithError(err).Error("Failed to declare a queue")
-		return
+	r.conn = rabbitmq.Conn{
+		Username: r.Username.Expose(),
+		Password: r.Password.Expose(),
+		Host:     r.Host,
+		Port:     r.Port,
+		Vhost:    r.Vhost,
+		TLS:      r.TLS,
+		CA:       r.CA,
+		Insecure: r.Insecure,
+		Setup:    r.setup,
 	}
 }
 
@@ -99,36 +163,78 @@ func (r *Rabbitmq) Send(c *skogul.Container) error {
 		r.init()
 	})
 
-	if r.channel == nil {
-		return fmt.Errorf("No active rabbitmq connections")
+	if !strings.Contains(r.RoutingKey, "${metadata.") {
+		return r.publish(r.RoutingKey, c)
 	}
 
+	groups := make(map[string]*skogul.Container)
+	for _, m := range c.Metrics {
+		key, err := skogul.ExpandMetadata(r.RoutingKey, m.Metadata)
+		if err != nil {
+			rabbitmqLog.WithError(err).Warn("Dropping metric")
+			continue
+		}
+		if groups[key] == nil {
+			groups[key] = &skogul.Container{Template: c.Template}
+		}
+		groups[key].Metrics = append(groups[key].Metrics, m)
+	}
+	for key, group := range groups {
+		if err := r.publish(key, group); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Rabbitmq) publish(key string, c *skogul.Container) error {
 	body, err := r.Encoder.E.Encode(c)
 	if err != nil {
-		r.channel.Close()
+		return err
+	}
+
+	ch, err := r.conn.Channel()
+	if err != nil {
+		rabbitmqLog.WithError(err).Error("Failed initializing broker connection")
 		return err
 	}
 
 	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(r.Timeout)*time.Second)
 	defer cancel()
 
-	err = r.channel.PublishWithContext(
+	msg := amqp.Publishing{
+		ContentType: "text/plain",
+		Body:        body,
+	}
+	if r.Persistent {
+		msg.DeliveryMode = amqp.Persistent
+	}
+
+	confirm, err := ch.PublishWithDeferredConfirmWithContext(
 		ctx,
-		"",
-		r.Queue,
+		r.Exchange,
+		key,
 		false,
 		false,
-		amqp.Publishing{
-			ContentType: "text/plain",
-			Body:        body,
-		},
+		msg,
 	)
 
 	if err != nil {
-		r.channel.Close()
+		r.conn.Close()
 		return err
 	}
 
+	if confirm == nil {
+		return nil
+	}
+	acked, err := confirm.WaitContext(ctx)
+	if err != nil {
+		r.conn.Close()
+		return fmt.Errorf("no confirmation from broker: %w", err)
+	}
+	if !acked {
+		return fmt.Errorf("broker rejected message")
+	}
 	return nil
 }
 
@@ -141,9 +247,13 @@ func (r *Rabbitmq) Verify() error {
 		return skogul.MissingArgument("Password")
 	}
 
-	if r.Queue == "" {
+	if r.Queue == "" && r.Exchange == "" {
 		return skogul.MissingArgument("Queue")
 	}
 
+	if r.Queue != "" && r.Exchange != "" && r.ExchangeType == amqp.ExchangeDirect && rabbitmqMetadata.MatchString(r.RoutingKey) {
+		return fmt.Errorf("can not bind queue %s to direct exchange %s with metadata in the routing key", r.Queue, r.Exchange)
+	}
+
 	return nil
 }
@@ -92,3 +92,51 @@ func TestRabbitmqTonsOfMessages(t *testing.T) {
 		i++
 	}
 }
+
+func TestRabbitmqRoutingKey(t *testing.T) {
+	if testing.Short() {
+		t.Skip("Short test: Not connecting to a Rabbitmq instance")
+	}
+
+	data := createContainer()
+
+	r := Rabbitmq{
+		Username:   "guest",
+		Password:   "guest",
+		Exchange:   "test-exchange",
+		RoutingKey: "metrics.${metadata.foo}",
+	}
+
+	err := r.Send(data)
+
+	if err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRabbitmqVerify(t *testing.T) {
+	r := Rabbitmq{
+		Username: "guest",
+		Password: "guest",
+	}
+	if err := r.Verify(); err == nil {
+		t.Errorf("Verify() without Queue or Exchange did not fail")
+	}
+	r.Exchange = "test-exchange"
+	if err := r.Verify(); err != nil {
+		t.Errorf("Verify() with Exchange failed: %v", err)
+	}
+	r.Queue = "test-queue"
+	r.ExchangeType = "direct"
+	r.RoutingKey = "metrics.${metadata.foo}"
+	if err := r.Verify(); err == nil {
+		t.Errorf("Verify() binding a direct exchange with metadata in the routing key did not fail")
+	}
+	r.ExchangeType = "topic"
+	if err := r.Verify(); err != nil {
+		t.Errorf("Verify() with topic exchange failed: %v", err)
+	}
+	if key := r.bindingKey(); key != "metrics.#" {
+		t.Errorf("Expected binding key metrics.#, got %s", key)
+	}
+}
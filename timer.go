@@ -24,6 +24,10 @@
 package skogul
 
 import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
 	"sync/atomic"
 	"time"
 )
@@ -72,3 +76,58 @@ need to insert time, but might get thousands of metrics per second.
 func Now() time.Time {
 	return time.Unix(ltime.sec, ltime.nsec)
 }
+
+// TimeFormat returns the layout for a named timestamp format: rfc3339
+// and iso8601 are RFC3339, anything else is assumed to be a Go time
+// layout and returned as is. The empty string is RFC3339.
+func TimeFormat(format string) string {
+	switch strings.ToLower(format) {
+	case "", "rfc3339", "iso8601":
+		return time.RFC3339
+	}
+	return format
+}
+
+// epochUnit returns the unit of the epoch formats unix, unix_ms, unix_us
+// and unix_ns, or 0 for other formats.
+func epochUnit(format string) time.Duration {
+	switch strings.ToLower(format) {
+	case "unix":
+		return time.Second
+	case "unix_ms":
+		return time.Millisecond
+	case "unix_us":
+		return time.Microsecond
+	case "unix_ns":
+		return time.Nanosecond
+	}
+	return 0
+}
+
+// ParseTime parses value according to format, which is either a format
+// understood by TimeFormat, or unix, unix_ms, unix_us or unix_ns for
+// (possibly fractional) epoch values.
+func ParseTime(format, value string) (time.Time, error) {
+	if unit := epochUnit(format); unit != 0 {
+		value = strings.TrimSpace(value)
+		if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+			perSec := int64(time.Second / unit)
+			return time.Unix(i/perSec, (i%perSec)*int64(unit)), nil
+		}
+		f, err := strconv.ParseFloat(value, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return time.Time{}, fmt.Errorf("invalid %s timestamp %q", format, value)
+		}
+		sec, frac := math.Modf(f * float64(unit) / float64(time.Second))
+		return time.Unix(int64(sec), int64(frac*1e9)), nil
+	}
+	return time.Parse(TimeFormat(format), value)
+}
+
+// FormatTime formats t according to format, the reverse of ParseTime.
+func FormatTime(format string, t time.Time) string {
+	if unit := epochUnit(format); unit != 0 {
+		return strconv.FormatInt(t.UnixNano()/int64(unit), 10)
+	}
+	return t.Format(TimeFormat(format))
+}
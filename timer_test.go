@@ -52,6 +52,37 @@ func TestNow(t *testing.T) {
 	}
 }
 
+func TestParseTime(t *testing.T) {
+	want := time.Date(2024, 5, 1, 12, 0, 0, 500000000, time.UTC)
+	cases := map[string]string{
+		"":                        "2024-05-01T12:00:00.5Z",
+		"rfc3339":                 "2024-05-01T12:00:00.5Z",
+		"2006-01-02 15:04:05.000": "2024-05-01 12:00:00.500",
+		"unix":                    "1714564800.5",
+		"unix_ms":                 "1714564800500",
+		"unix_ns":                 "1714564800500000000",
+	}
+	for format, value := range cases {
+		got, err := skogul.ParseTime(format, value)
+		if err != nil {
+			t.Errorf("ParseTime(%q, %q) failed: %v", format, value, err)
+			continue
+		}
+		if !got.Equal(want) {
+			t.Errorf("ParseTime(%q, %q) = %v, want %v", format, value, got, want)
+		}
+		if format == "" || format == "rfc3339" {
+			continue
+		}
+		if s := skogul.FormatTime(format, want); s != value && format != "unix" {
+			t.Errorf("FormatTime(%q) = %q, want %q", format, s, value)
+		}
+	}
+	if _, err := skogul.ParseTime("unix", "soon"); err == nil {
+		t.Errorf("ParseTime of invalid epoch did not fail")
+	}
+}
+
 func BenchmarkTimeNow(b *testing.B) {
 	for i := 0; i < b.N; i++ {
 		time.Now()
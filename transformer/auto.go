@@ -111,9 +111,30 @@ func init() {
 		Name:     "enrich",
 		Aliases:  []string{},
 		Alloc:    func() interface{} { return &Enrich{} },
-		Help:     "PROTOTYPE/ALPHA: Static enrichment. Use a json-structured source document to enrich incoming metrics with additional data. See the docs/examples/ directory for an actual example. This is NOT production ready, and should only be used if you are prepared to file bug reports and update your setup as the transformer matures.",
+		Help:     "Enrich metrics with additional metadata by matching metadata keys against an enrichment database. The database can be loaded from a JSON, NDJSON or CSV file, or from a SQL query, optionally reloaded on an interval, and updated on the fly with the enrichmentupdater sender. See docs/examples/enrichment for an example.",
 		AutoMake: true,
 	})
+	Auto.Add(skogul.Module{
+		Name:     "ipenrich",
+		Aliases:  []string{"ipprefix"},
+		Alloc:    func() interface{} { return &IPEnrich{} },
+		Help:     "Look up an IP address from a metadata or data field in a prefix table (CSV, JSON or MaxMind .mmdb) and add the attributes of the longest matching prefix to the metadata.",
+		AutoMake: false,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "wasm",
+		Aliases:  []string{"webassembly"},
+		Alloc:    func() interface{} { return &Wasm{} },
+		Help:     "Run each metric through a sandboxed WebAssembly module, which returns zero or more metrics to replace it. Metrics are passed as JSON or msgpack, and each call is bounded by a timeout and memory limit.",
+		AutoMake: false,
+	})
+	Auto.Add(skogul.Module{
+		Name:     "expr",
+		Aliases:  []string{"expression", "compute"},
+		Alloc:    func() interface{} { return &Expr{} },
+		Help:     "Set data or metadata fields from arithmetic expressions over other fields, e.g. util = (in_octets*8)/speed*100. Expressions are compiled when the configuration is loaded.",
+		AutoMake: false,
+	})
 	Auto.Add(skogul.Module{
 		Name:     "unflatten",
 		Aliases:  []string{},
@@ -24,9 +24,22 @@
 package transformer
 
 import (
+	"bufio"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
 	"fmt"
-	"github.com/telenornms/skogul"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql" // Imported for side effect/mysql support
+	_ "github.com/lib/pq"
+	"github.com/telenornms/skogul"
 )
 
 // keyType identifies the type the hash returns
@@ -37,13 +50,37 @@ type keyType string
 type entry map[string]interface{}
 
 /*
-Enrich transformer adds additional metadatainformation to metrics. This
-is a work in progress, see #224 for on-going discussions.
+Enrich transformer adds additional metadatainformation to metrics.
+
+The enrichment database can be loaded directly from a file (JSON, NDJSON
+or CSV) or from a SQL query, and optionally reloaded on an interval. It
+can also be updated on the fly through the enrichmentupdater sender.
+
+A reload builds a complete new database and swaps it in, so entries that
+are gone from the source are removed. Updates from the enrichmentupdater
+are merged into the current database and last until the next reload.
 */
 type Enrich struct {
-	Keys  []string `doc:"Metadatafields to match, e.g.: sysName and ifName."`
-	lock  sync.RWMutex
-	store map[keyType]*entry
+	Keys     []string        `doc:"Metadatafields to match, e.g.: sysName and ifName."`
+	Source   string          `doc:"File to load the enrichment database from. The format is determined by the Format option."`
+	Format   string          `doc:"Format of the Source file. json is a regular skogul container where metadata is matched and data is added, ndjson is one flat JSON object per line and csv is a CSV file with a header row. For ndjson and csv, the fields named in Keys are matched and the remaining fields are added. Default is guessed from the file extension, falling back to json."`
+	Driver   string          `doc:"Database driver to use for loading the enrichment database from SQL. Currently supported: mysql and postgres."`
+	ConnStr  string          `doc:"Connection string to use for the database. See the sql receiver for details." example:"mysql: 'root:lol@/mydb' postgres: 'user=pqgotest dbname=pqgotest sslmode=verify-full'"`
+	Query    string          `doc:"SQL query returning the enrichment database. Columns named in Keys are matched, the remaining columns are added."`
+	Interval skogul.Duration `doc:"How often to reload the enrichment database. For files, the database is only reloaded if the file has changed since the last load. Default is to load it only once."`
+	lock     sync.RWMutex
+	store    map[keyType]*entry
+	once     sync.Once
+	modTime  time.Time
+	size     int64
+	stats    enrichStats
+}
+
+type enrichStats struct {
+	Hits         uint64
+	Misses       uint64
+	Reloads      uint64
+	ReloadErrors uint64
 }
 
 var eLog = skogul.Logger("transformer", "enrich")
@@ -62,8 +99,11 @@ func (e *Enrich) Hash(m skogul.Metric) keyType {
 }
 
 // Update uses the provided Container to update/bootstrap the enrichment
-// database (e.store). It is used by the enrichmentupdater-sender.
+// database (e.store). It is used by the enrichmentupdater-sender. The
+// initial database is loaded first, if it hasn't been already, so it
+// doesn't replace the update.
 func (e *Enrich) Update(c *skogul.Container) {
+	e.once.Do(e.init)
 	e.lock.Lock()
 	eLog.Infof("Updating enrichment database")
 	if e.store == nil {
@@ -77,14 +117,213 @@ func (e *Enrich) Update(c *skogul.Container) {
 	e.lock.Unlock()
 }
 
+// Load (re)loads the enrichment database from the configured Source or
+// Query, replacing the current database. For files, nothing is done if
+// the file is unchanged since the last load, unless force is set.
+func (e *Enrich) Load(force bool) error {
+	var store map[keyType]*entry
+	var err error
+	if e.Query != "" {
+		store, err = e.loadSQL()
+	} else {
+		store, err = e.loadFile(force)
+	}
+	if err != nil {
+		atomic.AddUint64(&e.stats.ReloadErrors, 1)
+		return err
+	}
+	if store == nil {
+		return nil
+	}
+	atomic.AddUint64(&e.stats.Reloads, 1)
+	eLog.Infof("Loaded enrichment database with %d entries", len(store))
+	e.lock.Lock()
+	e.store = store
+	e.lock.Unlock()
+	return nil
+}
+
+// add adds a flat record to store, using e.Keys as metadata to match and
+// the rest as the entry.
+func (e *Enrich) add(store map[keyType]*entry, record map[string]interface{}) {
+	m := skogul.Metric{Metadata: make(map[string]interface{})}
+	en := entry{}
+	for k, v := range record {
+		en[k] = v
+	}
+	for _, k := range e.Keys {
+		m.Metadata[k] = record[k]
+		delete(en, k)
+	}
+	store[e.Hash(m)] = &en
+}
+
+// format returns the configured format, or guesses it from the file
+// name.
+func (e *Enrich) format() string {
+	if e.Format != "" {
+		return strings.ToLower(e.Format)
+	}
+	switch strings.ToLower(filepath.Ext(e.Source)) {
+	case ".csv":
+		return "csv"
+	case ".ndjson", ".jsonl":
+		return "ndjson"
+	}
+	return "json"
+}
+
+// loadFile reads e.Source and returns a new store, or nil if the file
+// is unchanged and force isn't set.
+func (e *Enrich) loadFile(force bool) (map[keyType]*entry, error) {
+	f, err := os.Open(e.Source)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open enrichment source: %w", err)
+	}
+	defer f.Close()
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("unable to stat enrichment source: %w", err)
+	}
+	if !force && fi.ModTime().Equal(e.modTime) && fi.Size() == e.size {
+		return nil, nil
+	}
+	store := make(map[keyType]*entry)
+	switch e.format() {
+	case "json":
+		c := skogul.Container{}
+		if err := json.NewDecoder(f).Decode(&c); err != nil {
+			return nil, fmt.Errorf("unable to parse enrichment source %s: %w", e.Source, err)
+		}
+		for _, m := range c.Metrics {
+			en := entry(m.Data)
+			store[e.Hash(*m)] = &en
+		}
+	case "ndjson":
+		scanner := bufio.NewScanner(f)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		line := 0
+		for scanner.Scan() {
+			line++
+			b := scanner.Bytes()
+			if len(strings.TrimSpace(string(b))) == 0 {
+				continue
+			}
+			record := make(map[string]interface{})
+			if err := json.Unmarshal(b, &record); err != nil {
+				return nil, fmt.Errorf("unable to parse line %d of enrichment source %s: %w", line, e.Source, err)
+			}
+			e.add(store, record)
+		}
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("unable to read enrichment source %s: %w", e.Source, err)
+		}
+	case "csv":
+		r := csv.NewReader(f)
+		header, err := r.Read()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read header of enrichment source %s: %w", e.Source, err)
+		}
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse enrichment source %s: %w", e.Source, err)
+			}
+			record := make(map[string]interface{})
+			for idx, name := range header {
+				record[name] = row[idx]
+			}
+			e.add(store, record)
+		}
+	default:
+		return nil, fmt.Errorf("unknown enrichment source format %s", e.Format)
+	}
+	e.modTime = fi.ModTime()
+	e.size = fi.Size()
+	return store, nil
+}
+
+// loadSQL runs e.Query and returns a new store.
+func (e *Enrich) loadSQL() (map[keyType]*entry, error) {
+	db, err := sql.Open(e.Driver, e.ConnStr)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't initialize SQL connection: %w", err)
+	}
+	defer db.Close()
+	rows, err := db.Query(e.Query)
+	if err != nil {
+		return nil, fmt.Errorf("couldn't run enrichment query: %w", err)
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, fmt.Errorf("unable to retrieve columns: %w", err)
+	}
+	store := make(map[keyType]*entry)
+	values := make([]interface{}, len(columns))
+	pointers := make([]interface{}, len(columns))
+	for idx := range values {
+		pointers[idx] = &values[idx]
+	}
+	for rows.Next() {
+		if err := rows.Scan(pointers...); err != nil {
+			return nil, fmt.Errorf("scan error: %w", err)
+		}
+		record := make(map[string]interface{})
+		for idx, name := range columns {
+			v := values[idx]
+			// The mysql driver returns []byte for text columns
+			if b, ok := v.([]byte); ok {
+				v = string(b)
+			}
+			record[name] = v
+		}
+		e.add(store, record)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error while reading enrichment rows: %w", err)
+	}
+	return store, nil
+}
+
+// init loads the initial database, if a source is configured, and starts
+// the reload loop if an interval is set.
+func (e *Enrich) init() {
+	if e.Source == "" && e.Query == "" {
+		return
+	}
+	if err := e.Load(true); err != nil {
+		eLog.WithError(err).Error("Failed to load enrichment database")
+	}
+	if e.Interval.Duration > 0 {
+		go e.reload()
+	}
+}
+
+// reload reloads the database every e.Interval, forever.
+func (e *Enrich) reload() {
+	for {
+		time.Sleep(e.Interval.Duration)
+		if err := e.Load(false); err != nil {
+			eLog.WithError(err).Error("Failed to reload enrichment database")
+		}
+	}
+}
+
 func (e *Enrich) find(m skogul.Metric) *entry {
 	if e.store == nil {
+		atomic.AddUint64(&e.stats.Misses, 1)
 		return nil
 	}
 	nm := e.store[e.Hash(m)]
 	if nm != nil {
+		atomic.AddUint64(&e.stats.Hits, 1)
 		eLog.Tracef("Enrichment hit")
 	} else {
+		atomic.AddUint64(&e.stats.Misses, 1)
 		eLog.Tracef("Enrichment miss")
 	}
 	return nm
@@ -93,12 +332,16 @@ func (e *Enrich) find(m skogul.Metric) *entry {
 // Transform looks up a metric in the stored enrichment database (loading
 // it on initial run) and adds metadata if a match is found.
 func (e *Enrich) Transform(c *skogul.Container) error {
+	e.once.Do(e.init)
 	e.lock.RLock()
 	for _, m := range c.Metrics {
 		hit := e.find(*m)
 		if hit == nil {
 			continue
 		}
+		if m.Metadata == nil {
+			m.Metadata = make(map[string]interface{})
+		}
 		for idx, field := range *hit {
 			m.Metadata[idx] = field
 		}
@@ -106,3 +349,57 @@ func (e *Enrich) Transform(c *skogul.Container) error {
 	e.lock.RUnlock()
 	return nil
 }
+
+// Verify checks that the configuration is sensible
+func (e *Enrich) Verify() error {
+	if len(e.Keys) == 0 {
+		return skogul.MissingArgument("Keys")
+	}
+	if e.Source != "" && e.Query != "" {
+		return fmt.Errorf("use either Source or Query, not both")
+	}
+	if e.Source != "" {
+		switch e.format() {
+		case "json", "ndjson", "csv":
+		default:
+			return fmt.Errorf("unknown format %s, must be json, ndjson or csv", e.Format)
+		}
+	}
+	if e.Query != "" {
+		if e.Driver == "" {
+			return skogul.MissingArgument("Driver")
+		}
+		if e.ConnStr == "" {
+			return skogul.MissingArgument("ConnStr")
+		}
+		if e.Driver != "mysql" && e.Driver != "postgres" {
+			return fmt.Errorf("unsupported database driver %s, must be mysql or postgres", e.Driver)
+		}
+	}
+	if e.Interval.Duration < 0 {
+		return fmt.Errorf("Interval can not be negative")
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the enrichment
+// transformer.
+func (e *Enrich) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "transformer"
+	metric.Metadata["type"] = "enrich"
+	metric.Metadata["identity"] = skogul.Identity[e]
+	metric.Data["hits"] = atomic.LoadUint64(&e.stats.Hits)
+	metric.Data["misses"] = atomic.LoadUint64(&e.stats.Misses)
+	metric.Data["reloads"] = atomic.LoadUint64(&e.stats.Reloads)
+	metric.Data["reload_errors"] = atomic.LoadUint64(&e.stats.ReloadErrors)
+	e.lock.RLock()
+	metric.Data["size"] = len(e.store)
+	e.lock.RUnlock()
+	return &metric
+}
@@ -24,7 +24,10 @@
 package transformer_test
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/telenornms/skogul"
 	"github.com/telenornms/skogul/transformer"
@@ -43,6 +46,133 @@ func TestEnrich_config1(t *testing.T) {
 	}`)
 }
 
+func TestEnrich_verify(t *testing.T) {
+	testConfBad(t, `
+	{
+		"transformers": {
+			"bad": {
+				"type": "enrich",
+				"source": "foo.json"
+			}
+		}
+	}`)
+	testConfBad(t, `
+	{
+		"transformers": {
+			"bad": {
+				"type": "enrich",
+				"keys": ["key1"],
+				"query": "SELECT * FROM enrich",
+				"connstr": "foo"
+			}
+		}
+	}`)
+	testConfBad(t, `
+	{
+		"transformers": {
+			"bad": {
+				"type": "enrich",
+				"keys": ["key1"],
+				"source": "foo.json",
+				"format": "xml"
+			}
+		}
+	}`)
+}
+
+func enrichMetric(sysName, ifName string) *skogul.Metric {
+	m := skogul.Metric{}
+	m.Metadata = map[string]interface{}{"sysName": sysName, "ifName": ifName}
+	m.Data = map[string]interface{}{"x": 1}
+	return &m
+}
+
+func TestEnrich_sources(t *testing.T) {
+	for _, source := range []string{"testdata/enrich.json", "testdata/enrich.ndjson", "testdata/enrich.csv"} {
+		e := transformer.Enrich{Keys: []string{"sysName", "ifName"}, Source: source}
+		if err := e.Verify(); err != nil {
+			t.Fatalf("Verify() failed for %s: %v", source, err)
+		}
+		c := skogul.Container{Metrics: []*skogul.Metric{enrichMetric("foobar", "eth0"), enrichMetric("foobarx", "eth2"), enrichMetric("foobar", "eth2")}}
+		if err := e.Transform(&c); err != nil {
+			t.Fatalf("Transform() failed for %s: %v", source, err)
+		}
+		if c.Metrics[0].Metadata["customer"] != "something" {
+			t.Errorf("%s: expected customer something, got %v", source, c.Metrics[0].Metadata["customer"])
+		}
+		if c.Metrics[1].Metadata["customer"] != "blatti" {
+			t.Errorf("%s: expected customer blatti, got %v", source, c.Metrics[1].Metadata["customer"])
+		}
+		if _, ok := c.Metrics[2].Metadata["customer"]; ok {
+			t.Errorf("%s: unexpected enrichment of unknown metric", source)
+		}
+		if _, ok := c.Metrics[0].Metadata["sysName"].(string); !ok {
+			t.Errorf("%s: key field was overwritten", source)
+		}
+		stats := e.GetStats()
+		if stats.Data["hits"] != uint64(2) || stats.Data["misses"] != uint64(1) || stats.Data["size"] != 2 {
+			t.Errorf("%s: unexpected stats %v", source, stats.Data)
+		}
+	}
+}
+
+func TestEnrich_updateBeforeLoad(t *testing.T) {
+	e := transformer.Enrich{Keys: []string{"sysName", "ifName"}, Source: "testdata/enrich.csv"}
+	update := enrichMetric("foobar", "eth2")
+	update.Data = map[string]interface{}{"customer": "updated"}
+	e.Update(&skogul.Container{Metrics: []*skogul.Metric{update}})
+
+	c := skogul.Container{Metrics: []*skogul.Metric{enrichMetric("foobar", "eth0"), enrichMetric("foobar", "eth2")}}
+	if err := e.Transform(&c); err != nil {
+		t.Fatalf("Transform() failed: %v", err)
+	}
+	if c.Metrics[0].Metadata["customer"] != "something" {
+		t.Errorf("expected customer something from the source, got %v", c.Metrics[0].Metadata["customer"])
+	}
+	if c.Metrics[1].Metadata["customer"] != "updated" {
+		t.Errorf("update before the initial load was lost, got %v", c.Metrics[1].Metadata["customer"])
+	}
+}
+
+func TestEnrich_reload(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "enrich.csv")
+	if err := os.WriteFile(source, []byte("sysName,ifName,customer\nfoobar,eth0,old\nfoobarx,eth2,gone\n"), 0644); err != nil {
+		t.Fatalf("unable to write source: %v", err)
+	}
+	e := transformer.Enrich{Keys: []string{"sysName", "ifName"}, Source: source}
+	e.Interval.Duration = 10 * time.Millisecond
+	c := skogul.Container{Metrics: []*skogul.Metric{enrichMetric("foobar", "eth0")}}
+	e.Transform(&c)
+	if c.Metrics[0].Metadata["customer"] != "old" {
+		t.Fatalf("expected customer old, got %v", c.Metrics[0].Metadata["customer"])
+	}
+
+	if err := os.WriteFile(source, []byte("sysName,ifName,customer\nfoobar,eth0,new\n"), 0644); err != nil {
+		t.Fatalf("unable to write source: %v", err)
+	}
+	// Make sure the modification time differs on coarse file systems
+	later := time.Now().Add(time.Second)
+	os.Chtimes(source, later, later)
+
+	for i := 0; i < 100; i++ {
+		time.Sleep(10 * time.Millisecond)
+		c = skogul.Container{Metrics: []*skogul.Metric{enrichMetric("foobar", "eth0"), enrichMetric("foobarx", "eth2")}}
+		e.Transform(&c)
+		if c.Metrics[0].Metadata["customer"] == "new" {
+			break
+		}
+	}
+	if c.Metrics[0].Metadata["customer"] != "new" {
+		t.Fatalf("expected customer new after reload, got %v", c.Metrics[0].Metadata["customer"])
+	}
+	if _, ok := c.Metrics[1].Metadata["customer"]; ok {
+		t.Errorf("stale entry survived reload")
+	}
+	if e.GetStats().Data["reloads"] != uint64(2) {
+		t.Errorf("expected 2 reloads, got %v", e.GetStats().Data["reloads"])
+	}
+}
+
 func BenchmarkHash(b *testing.B) {
 	e := transformer.Enrich{}
 	e.Keys = []string{"key1", "key2"}
@@ -0,0 +1,147 @@
+/*
+ * skogul, expression transformer
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sync"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/internal/expr"
+)
+
+var exprLog = skogul.Logger("transformer", "expr")
+
+// ExprRule sets a single data or metadata field from an expression.
+type ExprRule struct {
+	Data       string `doc:"Data field to set. Either Data or Metadata must be set."`
+	Metadata   string `doc:"Metadata field to set."`
+	Expression string `doc:"Expression to evaluate. Plain names refer to data fields, metadata fields are referenced as metadata.name. Supports + - * / %, comparisons, && || !, cond ? a : b and the functions abs, ceil, exp, floor, if, ln, log, log10, log2, max, min, pow, round and sqrt." example:"(in_octets*8)/speed*100"`
+	Missing    string `doc:"What to do if an operand is missing or has the wrong type, or the result is not a finite number: skip leaves the field untouched and continues with the next rule, fail fails the container. Default skip."`
+	expr       *expr.Expr
+}
+
+// Expr computes new data or metadata fields from expressions over other
+// fields. Rules are evaluated in order, so later rules can use fields set
+// by earlier ones.
+type Expr struct {
+	Rules []ExprRule `doc:"List of rules to evaluate, in order."`
+	once  sync.Once
+	err   error
+}
+
+func (r *ExprRule) compile() error {
+	if r.Expression == "" {
+		return skogul.MissingArgument("Expression")
+	}
+	if r.Data == "" && r.Metadata == "" {
+		return skogul.MissingArgument("Data or Metadata")
+	}
+	if r.Data != "" && r.Metadata != "" {
+		return fmt.Errorf("rule sets both Data and Metadata, only one is allowed")
+	}
+	if r.Missing != "" && r.Missing != "skip" && r.Missing != "fail" {
+		return fmt.Errorf("invalid Missing policy %s, must be skip or fail", r.Missing)
+	}
+	var err error
+	r.expr, err = expr.Compile(r.Expression)
+	return err
+}
+
+// apply evaluates the rule for a metric.
+func (r *ExprRule) apply(m *skogul.Metric) error {
+	v, err := r.expr.Eval(func(metadata bool, name string) (interface{}, bool) {
+		if metadata {
+			v, ok := m.Metadata[name]
+			return v, ok
+		}
+		v, ok := m.Data[name]
+		return v, ok
+	})
+	if f, ok := v.(float64); ok && err == nil && (math.IsNaN(f) || math.IsInf(f, 0)) {
+		err = fmt.Errorf("%w: result of `%s' is %v", expr.ErrOperand, r.Expression, f)
+	}
+	if err != nil {
+		return err
+	}
+	if r.Data != "" {
+		if m.Data == nil {
+			m.Data = make(map[string]interface{})
+		}
+		m.Data[r.Data] = v
+	} else {
+		if m.Metadata == nil {
+			m.Metadata = make(map[string]interface{})
+		}
+		m.Metadata[r.Metadata] = v
+	}
+	return nil
+}
+
+// Transform evaluates the rules for each metric
+func (e *Expr) Transform(c *skogul.Container) error {
+	e.once.Do(func() {
+		for i := range e.Rules {
+			if err := e.Rules[i].compile(); err != nil {
+				e.err = err
+				return
+			}
+		}
+	})
+	// Verify() compiles the same expressions.
+	skogul.Assert(e.err == nil)
+
+	for _, m := range c.Metrics {
+		for i := range e.Rules {
+			r := &e.Rules[i]
+			err := r.apply(m)
+			if err == nil {
+				continue
+			}
+			if r.Missing == "fail" || !errors.Is(err, expr.ErrOperand) {
+				return fmt.Errorf("expression `%s' failed: %w", r.Expression, err)
+			}
+			exprLog.WithError(err).Tracef("Skipping expression `%s'", r.Expression)
+		}
+	}
+	return nil
+}
+
+// Verify checks that all rules are complete and that their expressions
+// compile.
+func (e *Expr) Verify() error {
+	if len(e.Rules) == 0 {
+		return skogul.MissingArgument("Rules")
+	}
+	for i := range e.Rules {
+		r := e.Rules[i]
+		r.expr = nil
+		if err := r.compile(); err != nil {
+			return fmt.Errorf("rule %d: %w", i+1, err)
+		}
+	}
+	return nil
+}
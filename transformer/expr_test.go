@@ -0,0 +1,116 @@
+/*
+ * skogul, expression transformer tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer_test
+
+import (
+	"testing"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/transformer"
+)
+
+func TestExpr(t *testing.T) {
+	conf := testConfOk(t, `
+	{
+		"transformers": {
+			"util": {
+				"type": "expr",
+				"rules": [
+					{ "data": "util", "expression": "(in_octets*8)/speed*100" },
+					{ "metadata": "load", "expression": "util > 50 ? \"high\" : \"low\"" },
+					{ "data": "temp_f", "expression": "temp_c*1.8+32" }
+				]
+			},
+			"strict": {
+				"type": "expr",
+				"rules": [
+					{ "data": "temp_f", "expression": "temp_c*1.8+32", "missing": "fail" }
+				]
+			}
+		}
+	}`)
+	util := conf.Transformers["util"].Transformer.(*transformer.Expr)
+	strict := conf.Transformers["strict"].Transformer.(*transformer.Expr)
+
+	m := skogul.Metric{
+		Metadata: map[string]interface{}{},
+		Data:     map[string]interface{}{"in_octets": 750, "speed": 1000},
+	}
+	c := skogul.Container{Metrics: []*skogul.Metric{&m}}
+	if err := util.Transform(&c); err != nil {
+		t.Fatalf("Transform() failed: %v", err)
+	}
+	if m.Data["util"] != 600.0 {
+		t.Errorf("expected util 600, got %v", m.Data["util"])
+	}
+	if m.Metadata["load"] != "high" {
+		t.Errorf("expected load high, got %v", m.Metadata["load"])
+	}
+	if _, ok := m.Data["temp_f"]; ok {
+		t.Errorf("temp_f set despite missing temp_c")
+	}
+	if err := strict.Transform(&c); err == nil {
+		t.Errorf("expected strict rule to fail on missing temp_c")
+	}
+
+	// Division by zero gives a non-finite result, which is skipped
+	m.Data["speed"] = 0
+	delete(m.Data, "util")
+	if err := util.Transform(&c); err != nil {
+		t.Fatalf("Transform() failed: %v", err)
+	}
+	if _, ok := m.Data["util"]; ok {
+		t.Errorf("util set despite division by zero")
+	}
+}
+
+func TestExpr_bad(t *testing.T) {
+	testConfBad(t, `
+	{
+		"transformers": {
+			"bad": {
+				"type": "expr",
+				"rules": [ { "data": "x", "expression": "1 +" } ]
+			}
+		}
+	}`)
+	testConfBad(t, `
+	{
+		"transformers": {
+			"bad": {
+				"type": "expr",
+				"rules": [ { "data": "x", "metadata": "y", "expression": "1" } ]
+			}
+		}
+	}`)
+	testConfBad(t, `
+	{
+		"transformers": {
+			"bad": {
+				"type": "expr",
+				"rules": [ { "data": "x", "expression": "1", "missing": "ignore" } ]
+			}
+		}
+	}`)
+}
@@ -0,0 +1,487 @@
+/*
+ * skogul, IP prefix enrichment transformer
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/telenornms/skogul"
+)
+
+var ipLog = skogul.Logger("transformer", "ipenrich")
+
+// ipNode is a node in a binary radix trie of IP prefixes. Only nodes
+// with set == true represent an actual prefix.
+type ipNode struct {
+	child  [2]*ipNode
+	set    bool
+	prefix netip.Prefix
+	attrs  map[string]interface{}
+}
+
+// ipTrie holds separate tries for IPv4 and IPv6, so IPv4 lookups only
+// need to walk 32 bits.
+type ipTrie struct {
+	v4   ipNode
+	v6   ipNode
+	size int
+}
+
+// insert adds a prefix with attributes to the trie, replacing any
+// previous entry for the same prefix.
+func (t *ipTrie) insert(p netip.Prefix, attrs map[string]interface{}) {
+	p = p.Masked()
+	n := &t.v6
+	if p.Addr().Is4() {
+		n = &t.v4
+	}
+	b := p.Addr().AsSlice()
+	for i := 0; i < p.Bits(); i++ {
+		bit := (b[i/8] >> (7 - i%8)) & 1
+		if n.child[bit] == nil {
+			n.child[bit] = &ipNode{}
+		}
+		n = n.child[bit]
+	}
+	if !n.set {
+		t.size++
+	}
+	n.set = true
+	n.prefix = p
+	n.attrs = attrs
+}
+
+// lookup returns the longest prefix matching addr, or nil.
+func (t *ipTrie) lookup(addr netip.Addr) *ipNode {
+	addr = addr.Unmap()
+	n := &t.v6
+	bits := 128
+	if addr.Is4() {
+		n = &t.v4
+		bits = 32
+	}
+	var match *ipNode
+	b := addr.AsSlice()
+	for i := 0; n != nil; i++ {
+		if n.set {
+			match = n
+		}
+		if i == bits {
+			break
+		}
+		n = n.child[(b[i/8]>>(7-i%8))&1]
+	}
+	return match
+}
+
+/*
+IPEnrich looks up an IP address from a metadata or data field in a table
+of IP prefixes and adds the attributes of the longest matching prefix to
+the metadata of the metric.
+
+The prefix table is read from a CSV or JSON file and kept in a radix trie,
+or looked up directly in a MaxMind-format .mmdb file.
+*/
+type IPEnrich struct {
+	Source        string            `doc:"File to load the prefix table from."`
+	Format        string            `doc:"Format of the Source file: csv, json or mmdb. CSV files need a header row, with one column holding the prefix and the rest used as attributes. JSON files are either an object mapping prefixes to attribute objects, or an array of objects with the prefix in a field. Default is guessed from the file extension." example:"csv"`
+	PrefixColumn  string            `doc:"Name of the column or field holding the prefix in CSV and JSON array sources. Default 'prefix'."`
+	MetadataField string            `doc:"Metadata field holding the IP address to look up."`
+	DataField     string            `doc:"Data field holding the IP address to look up."`
+	Fields        map[string]string `doc:"Map of metadata keys to attributes to copy, where nested attributes use dots, e.g. country.iso_code. Mostly useful for mmdb sources. Default is to copy all top-level attributes." example:"{\"country\": \"country.iso_code\", \"asn\": \"autonomous_system_number\"}"`
+	KeyPrefix     string            `doc:"String to prepend to the metadata keys added, e.g. src_ when enriching a source address."`
+	PrefixKey     string            `doc:"If set, the matched prefix is stored in this metadata key."`
+	Interval      skogul.Duration   `doc:"How often to check if the Source has changed and reload it. Default is to load it only once."`
+	lock          sync.RWMutex
+	trie          *ipTrie
+	mmdb          *maxminddb.Reader
+	once          sync.Once
+	modTime       time.Time
+	size          int64
+	stats         ipEnrichStats
+}
+
+type ipEnrichStats struct {
+	Hits         uint64
+	Misses       uint64
+	Invalid      uint64
+	Reloads      uint64
+	ReloadErrors uint64
+}
+
+// format returns the configured format, or guesses it from the file
+// name.
+func (ipe *IPEnrich) format() string {
+	if ipe.Format != "" {
+		return strings.ToLower(ipe.Format)
+	}
+	switch strings.ToLower(filepath.Ext(ipe.Source)) {
+	case ".json":
+		return "json"
+	case ".mmdb":
+		return "mmdb"
+	}
+	return "csv"
+}
+
+// parsePrefix parses a prefix, accepting plain addresses as host
+// prefixes.
+func parsePrefix(s string) (netip.Prefix, error) {
+	s = strings.TrimSpace(s)
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return p, err
+		}
+		if p.Addr().Is4In6() {
+			if p.Bits() < 96 {
+				return netip.Prefix{}, fmt.Errorf("IPv4-mapped prefix shorter than /96")
+			}
+			p = netip.PrefixFrom(p.Addr().Unmap(), p.Bits()-96)
+		}
+		return p, nil
+	}
+	addr, err := netip.ParseAddr(s)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	addr = addr.Unmap()
+	return netip.PrefixFrom(addr, addr.BitLen()), nil
+}
+
+// Load (re)loads the prefix table from Source. Unless force is set,
+// nothing is done if the file is unchanged since the last load.
+func (ipe *IPEnrich) Load(force bool) error {
+	fi, err := os.Stat(ipe.Source)
+	if err != nil {
+		atomic.AddUint64(&ipe.stats.ReloadErrors, 1)
+		return fmt.Errorf("unable to stat prefix source: %w", err)
+	}
+	if !force && fi.ModTime().Equal(ipe.modTime) && fi.Size() == ipe.size {
+		return nil
+	}
+	var trie *ipTrie
+	var db *maxminddb.Reader
+	if ipe.format() == "mmdb" {
+		db, err = maxminddb.Open(ipe.Source)
+	} else {
+		trie, err = ipe.loadTable()
+	}
+	if err != nil {
+		atomic.AddUint64(&ipe.stats.ReloadErrors, 1)
+		return err
+	}
+	ipe.modTime = fi.ModTime()
+	ipe.size = fi.Size()
+	atomic.AddUint64(&ipe.stats.Reloads, 1)
+
+	ipe.lock.Lock()
+	old := ipe.mmdb
+	ipe.trie = trie
+	ipe.mmdb = db
+	ipe.lock.Unlock()
+	if old != nil {
+		old.Close()
+	}
+	if trie != nil {
+		ipLog.Infof("Loaded %d prefixes from %s", trie.size, ipe.Source)
+	} else {
+		ipLog.Infof("Loaded mmdb database %s", ipe.Source)
+	}
+	return nil
+}
+
+// loadTable reads a CSV or JSON prefix table into a new trie.
+func (ipe *IPEnrich) loadTable() (*ipTrie, error) {
+	f, err := os.Open(ipe.Source)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open prefix source: %w", err)
+	}
+	defer f.Close()
+
+	column := ipe.PrefixColumn
+	if column == "" {
+		column = "prefix"
+	}
+	trie := &ipTrie{}
+	add := func(prefix string, attrs map[string]interface{}) error {
+		p, err := parsePrefix(prefix)
+		if err == nil && !p.IsValid() {
+			err = fmt.Errorf("not a valid prefix")
+		}
+		if err != nil {
+			return fmt.Errorf("invalid prefix %s in %s: %w", prefix, ipe.Source, err)
+		}
+		trie.insert(p, attrs)
+		return nil
+	}
+
+	switch ipe.format() {
+	case "csv":
+		r := csv.NewReader(f)
+		header, err := r.Read()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read header of prefix source %s: %w", ipe.Source, err)
+		}
+		pidx := -1
+		for idx, name := range header {
+			if name == column {
+				pidx = idx
+			}
+		}
+		if pidx == -1 {
+			return nil, fmt.Errorf("prefix column %s not found in %s", column, ipe.Source)
+		}
+		for {
+			row, err := r.Read()
+			if err == io.EOF {
+				break
+			}
+			if err != nil {
+				return nil, fmt.Errorf("unable to parse prefix source %s: %w", ipe.Source, err)
+			}
+			attrs := make(map[string]interface{})
+			for idx, name := range header {
+				if idx != pidx {
+					attrs[name] = row[idx]
+				}
+			}
+			if err := add(row[pidx], attrs); err != nil {
+				return nil, err
+			}
+		}
+	case "json":
+		var raw interface{}
+		if err := json.NewDecoder(f).Decode(&raw); err != nil {
+			return nil, fmt.Errorf("unable to parse prefix source %s: %w", ipe.Source, err)
+		}
+		switch table := raw.(type) {
+		case map[string]interface{}:
+			for prefix, v := range table {
+				attrs, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("attributes for prefix %s in %s is not an object", prefix, ipe.Source)
+				}
+				if err := add(prefix, attrs); err != nil {
+					return nil, err
+				}
+			}
+		case []interface{}:
+			for _, v := range table {
+				attrs, ok := v.(map[string]interface{})
+				if !ok {
+					return nil, fmt.Errorf("prefix entry in %s is not an object", ipe.Source)
+				}
+				prefix, ok := attrs[column].(string)
+				if !ok {
+					return nil, fmt.Errorf("prefix entry in %s lacks a %s string", ipe.Source, column)
+				}
+				delete(attrs, column)
+				if err := add(prefix, attrs); err != nil {
+					return nil, err
+				}
+			}
+		default:
+			return nil, fmt.Errorf("prefix source %s must be a JSON object or array", ipe.Source)
+		}
+	default:
+		return nil, fmt.Errorf("unknown prefix source format %s", ipe.Format)
+	}
+	return trie, nil
+}
+
+// init loads the initial table and starts the reload loop if an
+// interval is set.
+func (ipe *IPEnrich) init() {
+	if err := ipe.Load(true); err != nil {
+		ipLog.WithError(err).Error("Failed to load prefix table")
+	}
+	if ipe.Interval.Duration > 0 {
+		go func() {
+			for {
+				time.Sleep(ipe.Interval.Duration)
+				if err := ipe.Load(false); err != nil {
+					ipLog.WithError(err).Error("Failed to reload prefix table")
+				}
+			}
+		}()
+	}
+}
+
+// address extracts the address to look up from a metric.
+func (ipe *IPEnrich) address(m *skogul.Metric) (netip.Addr, bool) {
+	var v interface{}
+	if ipe.MetadataField != "" {
+		v = m.Metadata[ipe.MetadataField]
+	} else {
+		v = m.Data[ipe.DataField]
+	}
+	switch ip := v.(type) {
+	case string:
+		addr, err := netip.ParseAddr(strings.TrimSpace(ip))
+		return addr, err == nil
+	case net.IP:
+		addr, ok := netip.AddrFromSlice(ip)
+		return addr, ok
+	case []byte:
+		addr, ok := netip.AddrFromSlice(ip)
+		return addr, ok
+	}
+	return netip.Addr{}, false
+}
+
+// lookup finds the matching prefix and attributes for addr.
+func (ipe *IPEnrich) lookup(addr netip.Addr) (string, map[string]interface{}) {
+	if ipe.trie != nil {
+		n := ipe.trie.lookup(addr)
+		if n == nil {
+			return "", nil
+		}
+		return n.prefix.String(), n.attrs
+	}
+	if ipe.mmdb != nil {
+		var attrs map[string]interface{}
+		network, ok, err := ipe.mmdb.LookupNetwork(net.IP(addr.Unmap().AsSlice()), &attrs)
+		if err != nil || !ok {
+			return "", nil
+		}
+		return network.String(), attrs
+	}
+	return "", nil
+}
+
+// attribute returns a possibly nested attribute, using dots to separate
+// levels.
+func attribute(attrs map[string]interface{}, path string) (interface{}, bool) {
+	var v interface{} = attrs
+	for _, key := range strings.Split(path, ".") {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		v, ok = m[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+// Transform looks up the configured field of each metric and adds the
+// attributes of the longest matching prefix to the metadata.
+func (ipe *IPEnrich) Transform(c *skogul.Container) error {
+	ipe.once.Do(ipe.init)
+	ipe.lock.RLock()
+	defer ipe.lock.RUnlock()
+	for _, m := range c.Metrics {
+		addr, ok := ipe.address(m)
+		if !ok {
+			atomic.AddUint64(&ipe.stats.Invalid, 1)
+			continue
+		}
+		prefix, attrs := ipe.lookup(addr)
+		if attrs == nil {
+			atomic.AddUint64(&ipe.stats.Misses, 1)
+			continue
+		}
+		atomic.AddUint64(&ipe.stats.Hits, 1)
+		if m.Metadata == nil {
+			m.Metadata = make(map[string]interface{})
+		}
+		if ipe.PrefixKey != "" {
+			m.Metadata[ipe.PrefixKey] = prefix
+		}
+		if len(ipe.Fields) == 0 {
+			for k, v := range attrs {
+				m.Metadata[ipe.KeyPrefix+k] = v
+			}
+			continue
+		}
+		for k, path := range ipe.Fields {
+			if v, ok := attribute(attrs, path); ok {
+				m.Metadata[ipe.KeyPrefix+k] = v
+			}
+		}
+	}
+	return nil
+}
+
+// Verify checks that the configuration is sensible
+func (ipe *IPEnrich) Verify() error {
+	if ipe.Source == "" {
+		return skogul.MissingArgument("Source")
+	}
+	if ipe.MetadataField == "" && ipe.DataField == "" {
+		return skogul.MissingArgument("MetadataField or DataField")
+	}
+	if ipe.MetadataField != "" && ipe.DataField != "" {
+		return fmt.Errorf("use either MetadataField or DataField, not both")
+	}
+	switch ipe.format() {
+	case "csv", "json", "mmdb":
+	default:
+		return fmt.Errorf("unknown format %s, must be csv, json or mmdb", ipe.Format)
+	}
+	if ipe.Interval.Duration < 0 {
+		return fmt.Errorf("Interval can not be negative")
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the ipenrich
+// transformer.
+func (ipe *IPEnrich) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "transformer"
+	metric.Metadata["type"] = "ipenrich"
+	metric.Metadata["identity"] = skogul.Identity[ipe]
+	metric.Data["hits"] = atomic.LoadUint64(&ipe.stats.Hits)
+	metric.Data["misses"] = atomic.LoadUint64(&ipe.stats.Misses)
+	metric.Data["invalid"] = atomic.LoadUint64(&ipe.stats.Invalid)
+	metric.Data["reloads"] = atomic.LoadUint64(&ipe.stats.Reloads)
+	metric.Data["reload_errors"] = atomic.LoadUint64(&ipe.stats.ReloadErrors)
+	ipe.lock.RLock()
+	if ipe.trie != nil {
+		metric.Data["size"] = ipe.trie.size
+	}
+	ipe.lock.RUnlock()
+	return &metric
+}
@@ -0,0 +1,142 @@
+/*
+ * skogul, ipenrich transformer tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/transformer"
+)
+
+func ipMetric(ip interface{}) *skogul.Metric {
+	m := skogul.Metric{}
+	m.Metadata = map[string]interface{}{"src": ip}
+	m.Data = map[string]interface{}{"bytes": 1}
+	return &m
+}
+
+func TestIPEnrich_csv(t *testing.T) {
+	ipe := transformer.IPEnrich{Source: "testdata/prefixes.csv", MetadataField: "src", KeyPrefix: "src_", PrefixKey: "src_prefix"}
+	if err := ipe.Verify(); err != nil {
+		t.Fatalf("Verify() failed: %v", err)
+	}
+	cases := []struct {
+		ip     interface{}
+		site   interface{}
+		prefix interface{}
+	}{
+		{"10.2.0.1", "", "10.0.0.0/8"},
+		{"10.1.200.1", "oslo", "10.1.0.0/16"},
+		{"10.1.2.3", "oslo-core", "10.1.2.3/32"},
+		{"::ffff:10.1.2.3", "oslo-core", "10.1.2.3/32"},
+		{"2001:db8:1::1", "bergen", "2001:db8::/32"},
+		{"192.0.2.1", nil, nil},
+		{"not an ip", nil, nil},
+	}
+	c := skogul.Container{}
+	for _, tc := range cases {
+		c.Metrics = append(c.Metrics, ipMetric(tc.ip))
+	}
+	if err := ipe.Transform(&c); err != nil {
+		t.Fatalf("Transform() failed: %v", err)
+	}
+	for idx, tc := range cases {
+		m := c.Metrics[idx]
+		if m.Metadata["src_site"] != tc.site {
+			t.Errorf("%v: expected site %v, got %v", tc.ip, tc.site, m.Metadata["src_site"])
+		}
+		if m.Metadata["src_prefix"] != tc.prefix {
+			t.Errorf("%v: expected prefix %v, got %v", tc.ip, tc.prefix, m.Metadata["src_prefix"])
+		}
+	}
+	stats := ipe.GetStats()
+	if stats.Data["hits"] != uint64(5) || stats.Data["misses"] != uint64(1) || stats.Data["invalid"] != uint64(1) || stats.Data["size"] != 4 {
+		t.Errorf("unexpected stats %v", stats.Data)
+	}
+}
+
+func TestIPEnrich_json(t *testing.T) {
+	ipe := transformer.IPEnrich{Source: "testdata/prefixes.json", DataField: "ip", Fields: map[string]string{"as": "asn"}}
+	m := skogul.Metric{Metadata: map[string]interface{}{}, Data: map[string]interface{}{"ip": "192.0.2.200"}}
+	c := skogul.Container{Metrics: []*skogul.Metric{&m}}
+	ipe.Transform(&c)
+	if m.Metadata["as"] != float64(64501) {
+		t.Errorf("expected as 64501, got %v", m.Metadata["as"])
+	}
+	if _, ok := m.Metadata["customer"]; ok {
+		t.Errorf("customer copied despite Fields")
+	}
+}
+
+// TestIPEnrich_mapped checks that an IPv4-mapped prefix shorter than /96
+// is rejected, instead of matching every IPv6 address.
+func TestIPEnrich_mapped(t *testing.T) {
+	source := filepath.Join(t.TempDir(), "prefixes.csv")
+	if err := os.WriteFile(source, []byte("prefix,site\n::ffff:0:0/80,everywhere\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ipe := transformer.IPEnrich{Source: source, MetadataField: "src"}
+	if err := ipe.Load(true); err == nil {
+		t.Errorf("Load() with an IPv4-mapped /80 did not fail")
+	}
+	if err := os.WriteFile(source, []byte("prefix,site\n::ffff:10.0.0.0/104,mapped\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := ipe.Load(true); err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	c := skogul.Container{Metrics: []*skogul.Metric{ipMetric("10.1.2.3"), ipMetric("2001:db8::1")}}
+	ipe.Transform(&c)
+	if c.Metrics[0].Metadata["site"] != "mapped" || c.Metrics[1].Metadata["site"] != nil {
+		t.Errorf("unexpected metadata %v %v", c.Metrics[0].Metadata, c.Metrics[1].Metadata)
+	}
+}
+
+func TestIPEnrich_config(t *testing.T) {
+	testConfOk(t, `
+	{
+		"transformers": {
+			"ok": {
+				"type": "ipenrich",
+				"source": "GeoLite2-ASN.mmdb",
+				"metadatafield": "src",
+				"fields": { "asn": "autonomous_system_number" }
+			}
+		}
+	}`)
+	testConfBad(t, `
+	{
+		"transformers": {
+			"bad": {
+				"type": "ipenrich",
+				"source": "prefixes.csv",
+				"metadatafield": "src",
+				"datafield": "src"
+			}
+		}
+	}`)
+}
@@ -0,0 +1,29 @@
+;; Source of the test modules for the wasm transformer. All modules share
+;; memory, alloc and the transform signature, and differ only in the body
+;; of transform.
+(module
+  (memory (export "memory") 1)
+  ;; Single buffer at 1025, leaving room for a prefix byte.
+  (func (export "alloc") (param i32) (result i32)
+    i32.const 1025)
+
+  ;; wrap_json.wasm: wraps the JSON input in [ ], returning it as an
+  ;; array of one metric.
+  (func (export "transform") (param $ptr i32) (param $len i32) (result i64)
+    (i32.store8 (i32.sub (local.get $ptr) (i32.const 1)) (i32.const 91))
+    (i32.store8 (i32.add (local.get $ptr) (local.get $len)) (i32.const 93))
+    (i64.or
+      (i64.shl (i64.extend_i32_u (i32.sub (local.get $ptr) (i32.const 1))) (i64.const 32))
+      (i64.extend_i32_u (i32.add (local.get $len) (i32.const 2)))))
+
+  ;; wrap_msgpack.wasm: prefixes the msgpack input with 0x91 (fixarray
+  ;; of one element).
+  ;;   (i32.store8 (i32.sub (local.get $ptr) (i32.const 1)) (i32.const 145))
+  ;;   returning ptr-1 and len+1.
+
+  ;; drop.wasm: returns an empty result, dropping the metric.
+  ;;   (i64.const 0)
+
+  ;; loop.wasm: never returns.
+  ;;   (loop (br 0)) (i64.const 0)
+)
@@ -25,7 +25,6 @@ package transformer
 
 import (
 	"fmt"
-	"strings"
 	"sync"
 	"time"
 
@@ -110,13 +109,11 @@ func (config *Timestamp) Transform(c *skogul.Container) error {
 // parseTimestamp parses a timestamp format name into a timestamp format
 // e.g. rfc3339 will be returned as "2006-01-02T15:04:05Z07:00"
 func parseTimestamp(format string) string {
-	switch strings.ToLower(format) {
-	case "rfc3339", "iso8601": // 🙈
-		return time.RFC3339
-	default:
+	layout := skogul.TimeFormat(format)
+	if layout == format {
 		timestampLogger.WithField("format", format).Debug("Could not match format to a named format, using format directly")
-		return format
 	}
+	return layout
 }
 
 // Verify will make sure the required fields are set
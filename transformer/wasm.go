@@ -0,0 +1,391 @@
+/*
+ * skogul, WebAssembly transformer
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/tetratelabs/wazero"
+	"github.com/tetratelabs/wazero/api"
+	"github.com/tetratelabs/wazero/imports/wasi_snapshot_preview1"
+	"github.com/vmihailenco/msgpack/v5"
+
+	"github.com/telenornms/skogul"
+)
+
+var wasmLog = skogul.Logger("transformer", "wasm")
+
+/*
+Wasm runs each metric through a sandboxed WebAssembly module, using the
+pure-Go wazero runtime.
+
+The module must export its memory as "memory" and the two functions:
+
+	alloc(size i32) i32
+	transform(ptr i32, len i32) i64
+
+For each metric, alloc is called to get a buffer for the encoded metric,
+which is written to the module memory before transform is called with
+the location and length of it. Transform returns the location of the
+result in the upper 32 bits and the length in the lower 32 bits. The
+result is an encoded array of zero or more metrics, which replace the
+original metric. An empty result drops the metric, and a trap fails the
+container. If the module exports "dealloc(ptr i32, len i32)", it is
+called for both buffers after each call.
+
+Metrics are encoded as objects with "timestamp", "metadata" and "data",
+the same as the skogul JSON format, using either JSON or msgpack.
+
+The runtime does not count instructions, so execution is bounded by
+wall-clock time through Timeout, and memory through MaxMemory.
+Each instance is only used by one goroutine at a time, and instances are
+reused across calls, so modules can keep state between calls, but should
+not rely on it.
+*/
+type Wasm struct {
+	Module    string          `doc:"Path to the WebAssembly module."`
+	Encoding  string          `doc:"Encoding of metrics passed to and from the module: json or msgpack. Default json."`
+	Timeout   skogul.Duration `doc:"Maximum time a single transform call may run before it is aborted. Default 100ms."`
+	MaxMemory uint32          `doc:"Maximum memory of a module instance, in 64KiB WebAssembly pages. Default 256 (16MiB)."`
+	Instances int             `doc:"Maximum number of idle module instances kept for reuse. Default is the number of CPUs."`
+	Interval  skogul.Duration `doc:"How often to check if the module file has changed and reload it. Default is to load it only once."`
+	lock      sync.RWMutex
+	mod       *wasmModule
+	once      sync.Once
+	err       error
+	modTime   time.Time
+	size      int64
+	stats     wasmStats
+}
+
+type wasmStats struct {
+	Calls    uint64
+	Errors   uint64
+	Timeouts uint64
+	Dropped  uint64
+	Reloads  uint64
+}
+
+// wasmModule is a compiled module with its runtime and a pool of idle
+// instances. A new one is created on each reload.
+type wasmModule struct {
+	runtime  wazero.Runtime
+	compiled wazero.CompiledModule
+	pool     chan api.Module
+}
+
+// get returns an idle instance, or instantiates a new one.
+func (wm *wasmModule) get(ctx context.Context) (api.Module, error) {
+	select {
+	case inst := <-wm.pool:
+		return inst, nil
+	default:
+	}
+	config := wazero.NewModuleConfig().WithName("").WithStartFunctions("_initialize")
+	inst, err := wm.runtime.InstantiateModule(ctx, wm.compiled, config)
+	if err != nil {
+		return nil, fmt.Errorf("unable to instantiate module: %w", err)
+	}
+	for _, fn := range []string{"alloc", "transform"} {
+		if inst.ExportedFunction(fn) == nil {
+			inst.Close(ctx)
+			return nil, fmt.Errorf("module does not export %s", fn)
+		}
+	}
+	if inst.Memory() == nil {
+		inst.Close(ctx)
+		return nil, fmt.Errorf("module does not export memory")
+	}
+	return inst, nil
+}
+
+// put returns an instance to the pool, closing it if the pool is full.
+func (wm *wasmModule) put(inst api.Module) {
+	select {
+	case wm.pool <- inst:
+	default:
+		inst.Close(context.Background())
+	}
+}
+
+// close closes the runtime and all instances.
+func (wm *wasmModule) close() {
+	wm.runtime.Close(context.Background())
+}
+
+// Load compiles the module from disk and swaps it in. Unless force is
+// set, nothing is done if the file is unchanged since the last load.
+func (w *Wasm) Load(force bool) error {
+	fi, err := os.Stat(w.Module)
+	if err != nil {
+		return fmt.Errorf("unable to stat wasm module: %w", err)
+	}
+	if !force && fi.ModTime().Equal(w.modTime) && fi.Size() == w.size {
+		return nil
+	}
+	b, err := os.ReadFile(w.Module)
+	if err != nil {
+		return fmt.Errorf("unable to read wasm module: %w", err)
+	}
+	ctx := context.Background()
+	config := wazero.NewRuntimeConfig().WithCloseOnContextDone(true).WithMemoryLimitPages(w.MaxMemory)
+	wm := &wasmModule{}
+	wm.runtime = wazero.NewRuntimeWithConfig(ctx, config)
+	if _, err := wasi_snapshot_preview1.Instantiate(ctx, wm.runtime); err != nil {
+		wm.close()
+		return fmt.Errorf("unable to instantiate WASI: %w", err)
+	}
+	wm.compiled, err = wm.runtime.CompileModule(ctx, b)
+	if err != nil {
+		wm.close()
+		return fmt.Errorf("unable to compile wasm module %s: %w", w.Module, err)
+	}
+	wm.pool = make(chan api.Module, w.Instances)
+	// Instantiate one to catch missing exports early.
+	inst, err := wm.get(ctx)
+	if err != nil {
+		wm.close()
+		return fmt.Errorf("invalid wasm module %s: %w", w.Module, err)
+	}
+	wm.put(inst)
+	w.modTime = fi.ModTime()
+	w.size = fi.Size()
+	atomic.AddUint64(&w.stats.Reloads, 1)
+
+	w.lock.Lock()
+	old := w.mod
+	w.mod = wm
+	w.lock.Unlock()
+	if old != nil {
+		old.close()
+	}
+	wasmLog.Infof("Loaded wasm module %s", w.Module)
+	return nil
+}
+
+func (w *Wasm) init() {
+	w.Encoding = strings.ToLower(w.Encoding)
+	if w.Encoding == "" {
+		w.Encoding = "json"
+	}
+	if w.Timeout.Duration == 0 {
+		w.Timeout.Duration = 100 * time.Millisecond
+	}
+	if w.MaxMemory == 0 {
+		w.MaxMemory = 256
+	}
+	if w.Instances == 0 {
+		w.Instances = runtime.NumCPU()
+	}
+	w.err = w.Load(true)
+	if w.err != nil {
+		wasmLog.WithError(w.err).Error("Failed to load wasm module")
+		return
+	}
+	if w.Interval.Duration > 0 {
+		go func() {
+			for {
+				time.Sleep(w.Interval.Duration)
+				if err := w.Load(false); err != nil {
+					wasmLog.WithError(err).Error("Failed to reload wasm module")
+				}
+			}
+		}()
+	}
+}
+
+// encode encodes a metric for the module.
+func (w *Wasm) encode(m *skogul.Metric) ([]byte, error) {
+	if w.Encoding == "msgpack" {
+		var buf bytes.Buffer
+		enc := msgpack.NewEncoder(&buf)
+		enc.SetCustomStructTag("json")
+		enc.SetOmitEmpty(true)
+		err := enc.Encode(m)
+		return buf.Bytes(), err
+	}
+	return json.Marshal(m)
+}
+
+// decode decodes the result of the module.
+func (w *Wasm) decode(b []byte) ([]*skogul.Metric, error) {
+	var metrics []*skogul.Metric
+	if w.Encoding == "msgpack" {
+		dec := msgpack.NewDecoder(bytes.NewReader(b))
+		dec.SetCustomStructTag("json")
+		dec.UseLooseInterfaceDecoding(true)
+		err := dec.Decode(&metrics)
+		return metrics, err
+	}
+	err := json.Unmarshal(b, &metrics)
+	return metrics, err
+}
+
+// call runs a single metric through an instance of the module.
+func (w *Wasm) call(wm *wasmModule, m *skogul.Metric) ([]*skogul.Metric, error) {
+	in, err := w.encode(m)
+	if err != nil {
+		return nil, fmt.Errorf("unable to encode metric: %w", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), w.Timeout.Duration)
+	defer cancel()
+	inst, err := wm.get(ctx)
+	if err != nil {
+		return nil, err
+	}
+	atomic.AddUint64(&w.stats.Calls, 1)
+	metrics, err := w.run(ctx, inst, in)
+	if err != nil {
+		// The instance may be in any state after a failure, and is
+		// closed by the runtime on timeouts.
+		inst.Close(context.Background())
+		if ctx.Err() != nil {
+			atomic.AddUint64(&w.stats.Timeouts, 1)
+			return nil, fmt.Errorf("wasm module timed out after %v", w.Timeout.Duration)
+		}
+		return nil, err
+	}
+	wm.put(inst)
+	return metrics, nil
+}
+
+func (w *Wasm) run(ctx context.Context, inst api.Module, in []byte) ([]*skogul.Metric, error) {
+	res, err := inst.ExportedFunction("alloc").Call(ctx, uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("alloc failed: %w", err)
+	}
+	ptr := uint32(res[0])
+	if !inst.Memory().Write(ptr, in) {
+		return nil, fmt.Errorf("alloc returned out of range buffer %d+%d", ptr, len(in))
+	}
+	res, err = inst.ExportedFunction("transform").Call(ctx, uint64(ptr), uint64(len(in)))
+	if err != nil {
+		return nil, fmt.Errorf("transform failed: %w", err)
+	}
+	outPtr := uint32(res[0] >> 32)
+	outLen := uint32(res[0])
+	var metrics []*skogul.Metric
+	if outLen > 0 {
+		out, ok := inst.Memory().Read(outPtr, outLen)
+		if !ok {
+			return nil, fmt.Errorf("transform returned out of range buffer %d+%d", outPtr, outLen)
+		}
+		metrics, err = w.decode(out)
+		if err != nil {
+			return nil, fmt.Errorf("unable to decode transform result: %w", err)
+		}
+	}
+	if dealloc := inst.ExportedFunction("dealloc"); dealloc != nil {
+		if _, err := dealloc.Call(ctx, uint64(ptr), uint64(len(in))); err != nil {
+			return nil, fmt.Errorf("dealloc failed: %w", err)
+		}
+		if outLen > 0 {
+			if _, err := dealloc.Call(ctx, uint64(outPtr), uint64(outLen)); err != nil {
+				return nil, fmt.Errorf("dealloc failed: %w", err)
+			}
+		}
+	}
+	return metrics, nil
+}
+
+// Transform replaces each metric with the metrics returned by the module.
+func (w *Wasm) Transform(c *skogul.Container) error {
+	w.once.Do(w.init)
+	w.lock.RLock()
+	defer w.lock.RUnlock()
+	if w.mod == nil {
+		return fmt.Errorf("no wasm module loaded: %w", w.err)
+	}
+	metrics := make([]*skogul.Metric, 0, len(c.Metrics))
+	for _, m := range c.Metrics {
+		res, err := w.call(w.mod, m)
+		if err != nil {
+			atomic.AddUint64(&w.stats.Errors, 1)
+			return err
+		}
+		if len(res) == 0 {
+			atomic.AddUint64(&w.stats.Dropped, 1)
+		}
+		for _, nm := range res {
+			if nm != nil {
+				metrics = append(metrics, nm)
+			}
+		}
+	}
+	c.Metrics = metrics
+	return nil
+}
+
+// Verify checks that the configuration is sensible
+func (w *Wasm) Verify() error {
+	if w.Module == "" {
+		return skogul.MissingArgument("Module")
+	}
+	switch strings.ToLower(w.Encoding) {
+	case "", "json", "msgpack":
+	default:
+		return fmt.Errorf("unknown encoding %s, must be json or msgpack", w.Encoding)
+	}
+	if w.Timeout.Duration < 0 {
+		return fmt.Errorf("Timeout can not be negative")
+	}
+	if w.MaxMemory > 65536 {
+		return fmt.Errorf("MaxMemory can not exceed 65536 pages (4GiB)")
+	}
+	if w.Instances < 0 {
+		return fmt.Errorf("Instances can not be negative")
+	}
+	if w.Interval.Duration < 0 {
+		return fmt.Errorf("Interval can not be negative")
+	}
+	return nil
+}
+
+// GetStats prepares a skogul metric with stats for the wasm transformer.
+func (w *Wasm) GetStats() *skogul.Metric {
+	now := skogul.Now()
+	metric := skogul.Metric{
+		Time:     &now,
+		Metadata: make(map[string]interface{}),
+		Data:     make(map[string]interface{}),
+	}
+	metric.Metadata["component"] = "transformer"
+	metric.Metadata["type"] = "wasm"
+	metric.Metadata["identity"] = skogul.Identity[w]
+	metric.Data["calls"] = atomic.LoadUint64(&w.stats.Calls)
+	metric.Data["errors"] = atomic.LoadUint64(&w.stats.Errors)
+	metric.Data["timeouts"] = atomic.LoadUint64(&w.stats.Timeouts)
+	metric.Data["dropped"] = atomic.LoadUint64(&w.stats.Dropped)
+	metric.Data["reloads"] = atomic.LoadUint64(&w.stats.Reloads)
+	return &metric
+}
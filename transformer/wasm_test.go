@@ -0,0 +1,129 @@
+/*
+ * skogul, wasm transformer tests
+ *
+ * Copyright (c) 2026 Telenor Norge AS
+ * Author(s):
+ *  - Kristian Lyngstøl <kly@kly.no>
+ *
+ * This library is free software; you can redistribute it and/or
+ * modify it under the terms of the GNU Lesser General Public
+ * License as published by the Free Software Foundation; either
+ * version 2.1 of the License, or (at your option) any later version.
+ *
+ * This library is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the GNU
+ * Lesser General Public License for more details.
+ *
+ * You should have received a copy of the GNU Lesser General Public
+ * License along with this library; if not, write to the Free Software
+ * Foundation, Inc., 51 Franklin Street, Fifth Floor, Boston, MA
+ * 02110-1301  USA
+ */
+
+package transformer_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/telenornms/skogul"
+	"github.com/telenornms/skogul/transformer"
+)
+
+func wasmContainer() *skogul.Container {
+	now := time.Now().Truncate(time.Second)
+	m := skogul.Metric{
+		Time:     &now,
+		Metadata: map[string]interface{}{"host": "foo"},
+		Data:     map[string]interface{}{"value": 42, "name": "bar"},
+	}
+	return &skogul.Container{Metrics: []*skogul.Metric{&m}}
+}
+
+func TestWasm(t *testing.T) {
+	for _, enc := range []string{"json", "msgpack"} {
+		w := transformer.Wasm{Module: "testdata/wasm/wrap_" + enc + ".wasm", Encoding: enc}
+		if err := w.Verify(); err != nil {
+			t.Fatalf("Verify() failed: %v", err)
+		}
+		for i := 0; i < 3; i++ {
+			c := wasmContainer()
+			if err := w.Transform(c); err != nil {
+				t.Fatalf("%s: Transform() failed: %v", enc, err)
+			}
+			if len(c.Metrics) != 1 {
+				t.Fatalf("%s: expected 1 metric, got %d", enc, len(c.Metrics))
+			}
+			m := c.Metrics[0]
+			if m.Metadata["host"] != "foo" || m.Data["name"] != "bar" {
+				t.Errorf("%s: unexpected metric %v", enc, m)
+			}
+			if v, ok := m.Data["value"]; !ok || v == nil {
+				t.Errorf("%s: value missing from %v", enc, m.Data)
+			}
+			if m.Time == nil || !m.Time.Equal(*wasmContainer().Metrics[0].Time) {
+				t.Errorf("%s: timestamp not preserved: %v", enc, m.Time)
+			}
+		}
+		if w.GetStats().Data["calls"] != uint64(3) {
+			t.Errorf("%s: expected 3 calls, got %v", enc, w.GetStats().Data["calls"])
+		}
+	}
+}
+
+func TestWasm_drop(t *testing.T) {
+	w := transformer.Wasm{Module: "testdata/wasm/drop.wasm"}
+	c := wasmContainer()
+	if err := w.Transform(c); err != nil {
+		t.Fatalf("Transform() failed: %v", err)
+	}
+	if len(c.Metrics) != 0 {
+		t.Errorf("expected metric to be dropped, got %d metrics", len(c.Metrics))
+	}
+}
+
+func TestWasm_timeout(t *testing.T) {
+	w := transformer.Wasm{Module: "testdata/wasm/loop.wasm"}
+	w.Timeout.Duration = 50 * time.Millisecond
+	start := time.Now()
+	err := w.Transform(wasmContainer())
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("timeout took too long: %v", time.Since(start))
+	}
+	// A new instance must be usable after the old one was aborted.
+	if err := w.Transform(wasmContainer()); err == nil {
+		t.Errorf("expected second timeout error")
+	}
+	if w.GetStats().Data["timeouts"] != uint64(2) {
+		t.Errorf("expected 2 timeouts, got %v", w.GetStats().Data["timeouts"])
+	}
+}
+
+func TestWasm_config(t *testing.T) {
+	testConfOk(t, `
+	{
+		"transformers": {
+			"ok": {
+				"type": "wasm",
+				"module": "fix.wasm",
+				"encoding": "msgpack",
+				"timeout": "10ms"
+			}
+		}
+	}`)
+	testConfBad(t, `
+	{
+		"transformers": {
+			"bad": {
+				"type": "wasm",
+				"module": "fix.wasm",
+				"encoding": "xml"
+			}
+		}
+	}`)
+}